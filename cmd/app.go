@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"pgpockets/internal/cardissuer"
+	"pgpockets/internal/config"
+	"pgpockets/internal/events"
+	"pgpockets/internal/jobs"
+	"pgpockets/internal/mailer"
+	"pgpockets/internal/nameenquiry"
+	"pgpockets/internal/push"
+	"pgpockets/internal/realtime"
+	"pgpockets/internal/repositories"
+	"pgpockets/internal/services"
+	"pgpockets/internal/sms"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// application holds the services shared by the HTTP server, the background worker and the admin commands
+type application struct {
+	config config.Config
+	logger *zap.Logger
+	db     *gorm.DB
+
+	// Background loops run on ctx; shutdown cancels it and waits for them on background
+	ctx        context.Context
+	cancel     context.CancelFunc
+	background sync.WaitGroup
+
+	jobRunner   *jobs.Runner
+	realtimeHub *realtime.Hub
+	eventBus    events.Bus
+	eventOutbox events.Outbox
+	cardIssuer  cardissuer.CardIssuer
+	// Transfers above this need a recent 2FA step-up
+	stepUpThreshold decimal.Decimal
+
+	userRepo   repositories.UserRepository
+	walletRepo repositories.WalletRepository
+
+	authService              services.AuthService
+	verificationService      services.VerificationService
+	loginGuard               services.LoginGuard
+	twoFactorService         services.TwoFactorService
+	notifService             services.NotificationService
+	notifDispatcher          services.NotificationDispatcher
+	webhookService           services.WebhookService
+	cardService              services.CardService
+	cardAuthService          services.CardAuthorizationService
+	cardExpiryService        services.CardExpiryService
+	walletService            services.WalletService
+	txnService               services.TransactionService
+	ledgerService            services.LedgerService
+	profileService           services.ProfileService
+	dashboardService         services.DashboardService
+	beneficiaryService       services.BeneficiaryService
+	transferService          services.TransferService
+	scheduledTransferService services.ScheduledTransferService
+	paymentRequestService    services.PaymentRequestService
+	billSplitService         services.BillSplitService
+	paymentLinkService       services.PaymentLinkService
+}
+
+func newApplication(config config.Config, appLogger *zap.Logger, db *gorm.DB) *application {
+	a := &application{config: config, logger: appLogger, db: db}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+
+	// Background jobs; features register their job types here and the runner starts with the workers
+	a.jobRunner = jobs.NewRunner(repositories.NewJobRepository(db), appLogger)
+
+	// Live updates fan out to every instance through Postgres LISTEN/NOTIFY
+	a.realtimeHub = realtime.NewHub(repositories.NewRealtimeEventRepository(db), db, config.DBSource, appLogger)
+
+	// Services publish domain events on the bus; subscribers turn them into notifications.
+	// Events recorded in the outbox reach the bus through the relay once their transaction commits.
+	outboxRepo := repositories.NewOutboxRepository(db)
+	a.eventBus = events.NewBus(appLogger, outboxRepo)
+	a.eventOutbox = events.NewOutbox(outboxRepo, a.eventBus, db, appLogger)
+	notifRepo := repositories.NewNotifRepo(db)
+	a.notifService = services.NewNotificationService(notifRepo, a.realtimeHub, appLogger)
+	// Users' own endpoints receive the events they subscribe to, signed and retried until delivered.
+	// Secrets saved before WEBHOOK_SECRET_KEY existed are still read with JWT_SECRET and moved over on use.
+	if config.WebhookSecretKey == "" {
+		appLogger.Fatal("WEBHOOK_SECRET_KEY is not set")
+	}
+	a.webhookService = services.NewWebhookService(repositories.NewWebhookRepository(db), appLogger,
+		config.WebhookSecretKey, config.JWTSecret, config.WebhookAllowPrivateURLs)
+	a.webhookService.Register(a.eventBus)
+
+	// Auth
+	a.userRepo = repositories.NewUserRepository(db)
+	a.walletRepo = repositories.NewWalletRepository(db)
+	loginThrottleRepo := repositories.NewLoginThrottleRepository(db)
+	a.twoFactorService = services.NewTwoFactorService(repositories.NewTwoFactorRepository(db), a.userRepo, loginThrottleRepo, appLogger)
+	appMailer, err := mailer.NewFromConfig(config, appLogger)
+	if err != nil {
+		appLogger.Fatal("Failed to set up mailer", zap.Error(err))
+	}
+	verificationRepo := repositories.NewVerificationTokenRepository(db)
+	a.verificationService = services.NewVerificationService(a.userRepo, verificationRepo, appMailer, appLogger, config.JWTSecret, config.AppBaseURL)
+	a.loginGuard = services.NewLoginGuard(loginThrottleRepo, a.userRepo, a.verificationService, appLogger)
+	a.authService = services.NewAuthService(a.userRepo, a.walletRepo, verificationRepo, a.twoFactorService, a.loginGuard, appLogger, config.JWTSecret, a.eventBus)
+
+	// Card payments arrive from the issuer as authorizations that hold funds until captured
+	cardRepo := repositories.NewCardRepository(db)
+	txnRepo := repositories.NewTransactionRepository(db)
+	services.NewRealtimeSubscriber(a.realtimeHub, a.walletRepo, txnRepo, appLogger).Register(a.eventBus)
+	cardAuthRepo := repositories.NewCardAuthorizationRepository(db)
+	a.cardAuthService = services.NewCardAuthorizationService(cardAuthRepo, cardRepo, a.walletRepo, txnRepo, appLogger, db, a.eventBus)
+
+	profileRepo := repositories.NewProfileRepository(db)
+
+	// Notifications go out on every channel the user has on for the event's category
+	smsSender, err := sms.NewFromConfig(config, appLogger)
+	if err != nil {
+		appLogger.Fatal("Failed to set up SMS sender", zap.Error(err))
+	}
+	pushSender, err := push.NewFromConfig(config, appLogger)
+	if err != nil {
+		appLogger.Fatal("Failed to set up push sender", zap.Error(err))
+	}
+	a.notifDispatcher = services.NewNotificationDispatcher(
+		repositories.NewNotificationPreferenceRepository(db),
+		repositories.NewNotificationDeliveryRepository(db),
+		appLogger,
+		services.NewInAppChannel(a.notifService),
+		services.NewEmailChannel(appMailer, a.userRepo),
+		services.NewSMSChannel(smsSender, profileRepo),
+		services.NewPushChannel(pushSender),
+	)
+	services.NewNotificationSubscriber(a.notifDispatcher, appLogger).Register(a.eventBus)
+	a.txnService = services.NewTransactionService(txnRepo, appLogger, a.walletRepo, profileRepo, db, a.eventOutbox)
+	a.ledgerService = services.NewLedgerService(repositories.NewLedgerRepository(db), a.walletRepo, txnRepo, db, a.eventOutbox, appLogger)
+	a.stepUpThreshold, err = decimal.NewFromString(config.StepUpTransferThreshold)
+	if err != nil {
+		appLogger.Fatal("Invalid STEP_UP_TRANSFER_THRESHOLD", zap.Error(err))
+	}
+
+	// Payment links and QR codes
+	paymentLinkRepo := repositories.NewPaymentLinkRepository(db)
+	a.paymentLinkService = services.NewPaymentLinkService(paymentLinkRepo, a.walletRepo, profileRepo, a.txnService, appLogger, config.AppBaseURL)
+
+	// Dashboard
+	dashboardRepo := repositories.NewDashboardRepository(db)
+	a.dashboardService = services.NewDashboardService(dashboardRepo, appLogger, config.ExchangeRatesAPIKey)
+
+	// Cards
+	a.cardIssuer, err = cardissuer.NewFromConfig(config, db)
+	if err != nil {
+		appLogger.Fatal("Failed to set up card issuer", zap.Error(err))
+	}
+	a.cardService = services.NewCardService(cardRepo, a.walletRepo, a.userRepo, profileRepo, a.cardIssuer, appLogger, a.eventBus, db)
+	a.cardExpiryService = services.NewCardExpiryService(cardRepo, a.userRepo, a.cardService, appMailer, appLogger)
+
+	// Wallets, profiles and beneficiaries
+	a.walletService = services.NewWalletService(a.walletRepo, appLogger, db)
+	a.profileService = services.NewProfileService(profileRepo, appLogger)
+	beneficiaryRepo := repositories.NewBeneficiaryRepository(db)
+	nameEnquiry, err := nameenquiry.NewFromConfig(config)
+	if err != nil {
+		appLogger.Fatal("Failed to set up name enquiry", zap.Error(err))
+	}
+	a.beneficiaryService = services.NewBeneficiaryService(beneficiaryRepo, a.walletRepo, profileRepo, nameEnquiry, appLogger)
+
+	// Transfers by beneficiary, email or phone, now or on a schedule
+	a.transferService = services.NewTransferService(a.txnService, a.beneficiaryService, a.walletRepo, a.userRepo, profileRepo, appLogger)
+	scheduledTransferRepo := repositories.NewScheduledTransferRepository(db)
+	a.scheduledTransferService = services.NewScheduledTransferService(scheduledTransferRepo, a.walletRepo, a.userRepo, a.txnService, a.transferService, appMailer, appLogger)
+
+	// Payment requests and bill splitting
+	paymentRequestRepo := repositories.NewPaymentRequestRepository(db)
+	a.paymentRequestService = services.NewPaymentRequestService(paymentRequestRepo, a.walletRepo, a.userRepo, profileRepo, a.txnService, appLogger, a.eventBus)
+	billSplitRepo := repositories.NewBillSplitRepository(db)
+	a.billSplitService = services.NewBillSplitService(billSplitRepo, a.walletRepo, a.userRepo, profileRepo, a.paymentRequestService, appLogger, a.eventBus)
+
+	a.registerJobs()
+	return a
+}
+
+/*
+	Periodic sweeps run as recurring jobs, so each one runs on a single instance at a time and
+	shows up in the admin job API. Retries of outgoing deliveries get their own queue so a long
+	sweep cannot hold them up.
+*/
+func (a *application) registerJobs() {
+	recurring := []struct {
+		jobType, queue string
+		interval       time.Duration
+		run            func(ctx context.Context) error
+	}{
+		{"notifications.retry_due", "deliveries", 30 * time.Second, func(context.Context) error {
+			_, err := a.notifDispatcher.ProcessDue()
+			return err
+		}},
+		{"webhooks.retry_due", "deliveries", 30 * time.Second, func(context.Context) error {
+			_, err := a.webhookService.ProcessDue()
+			return err
+		}},
+		{"scheduled_transfers.run_due", "payments", time.Minute, func(context.Context) error {
+			_, err := a.scheduledTransferService.RunDue(time.Now())
+			return err
+		}},
+		{"card_authorizations.expire_holds", "payments", 10 * time.Minute, func(context.Context) error {
+			_, err := a.cardAuthService.ExpireStaleHolds()
+			return err
+		}},
+		{"payment_requests.expire", jobs.DefaultQueue, 5 * time.Minute, func(context.Context) error {
+			a.paymentRequestService.ExpireStale(time.Now())
+			return nil
+		}},
+		{"bill_splits.remind", jobs.DefaultQueue, 15 * time.Minute, func(context.Context) error {
+			a.billSplitService.ProcessOpenSplits(time.Now())
+			return nil
+		}},
+		{"cards.process_expiring", jobs.DefaultQueue, 24 * time.Hour, func(context.Context) error {
+			return a.cardExpiryService.ProcessExpiringCards(time.Now())
+		}},
+	}
+	for _, job := range recurring {
+		if err := a.jobRunner.Every(job.jobType, job.queue, job.interval, job.run); err != nil {
+			a.logger.Fatal("Failed to register job", zap.String("job_type", job.jobType), zap.Error(err))
+		}
+	}
+}
+
+/*
+	Starts everything that runs on a timer rather than per request: the outbox relay, the
+	per-instance cleanup loops and the job runner, which runs the retry and expiry sweeps
+	registered in registerJobs. They run until shutdown, which also gives the job runner the
+	chance to let running jobs finish.
+*/
+func (a *application) startWorkers() {
+	a.goBackground(func(ctx context.Context) { a.eventOutbox.RunRelay(ctx, time.Second) })
+	a.goBackground(func(ctx context.Context) { a.realtimeHub.RunPruning(ctx, time.Hour, 24*time.Hour) })
+	a.goBackground(func(ctx context.Context) { a.notifService.RunExpiryCleanup(ctx, time.Hour) })
+	a.jobRunner.Start()
+	a.logger.Info("Background workers started")
+}
+
+// Runs fn in a goroutine that shutdown cancels and waits for
+func (a *application) goBackground(fn func(ctx context.Context)) {
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		fn(a.ctx)
+	}()
+}
+
+/*
+	shutdown stops the background loops, the job runner and in-flight first delivery
+	attempts, waiting for them until ctx ends, and then closes the database pool. Anything
+	still running past the deadline is logged and abandoned; the pool is closed regardless.
+*/
+func (a *application) shutdown(ctx context.Context) {
+	a.cancel()
+	done := make(chan struct{})
+	go func() {
+		a.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		a.logger.Warn("Background workers still running at shutdown")
+	}
+	if err := a.jobRunner.Shutdown(ctx); err != nil {
+		a.logger.Warn("Jobs still running at shutdown", zap.Error(err))
+	}
+	// First delivery attempts run outside the loops and the runner but still write their outcome
+	if err := a.webhookService.Drain(ctx); err != nil {
+		a.logger.Warn("Webhook deliveries still running at shutdown", zap.Error(err))
+	}
+	if err := a.notifDispatcher.Drain(ctx); err != nil {
+		a.logger.Warn("Notification deliveries still running at shutdown", zap.Error(err))
+	}
+
+	sqlDB, err := a.db.DB()
+	if err != nil {
+		a.logger.Error("Failed to get database pool", zap.Error(err))
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		a.logger.Error("Failed to close database", zap.Error(err))
+	}
+}
@@ -1,18 +1,40 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
 	"pgpockets/internal/config"
 	"pgpockets/internal/database"
 
-	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/helmet"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+const usage = `usage: pgpockets <command> [arguments]
+
+commands:
+  serve            run the HTTP API (default when no command is given)
+  worker           run background jobs and sweeps without the HTTP API
+  migrate          apply, revert or create schema migrations
+  seed             create demo users, wallets, transactions and invoices
+  user lock        block sign-in for an account
+  user unlock      clear an account lock
+  wallet adjust    credit or debit a wallet with an adjustment transaction
+  ledger reconcile compare wallet balances with their transactions
+
+Run "pgpockets <command> -h" for a command's options.`
+
+// Returned by commands called with the wrong arguments; main prints the usage text instead of failing loudly
+type usageError struct {
+	text string
+}
+
+func (e usageError) Error() string { return e.text }
+
 func main() {
-	app := fiber.New()
 	var err error
 	// Twelve factor apps need logs for 011y
 	// Initialize Zap logger
@@ -22,19 +44,63 @@ func main() {
 	}
 	defer appLogger.Sync()
 
-	app.Use(logger.New())
-	app.Use(helmet.New())
 	config, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("cannot load config: %v", err)
 	}
-	
-	// Connect to the database
+
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "serve":
+		err = runServe(args, config, appLogger)
+	case "worker":
+		err = runWorker(args, config, appLogger)
+	case "migrate":
+		err = runMigrate(args, config, appLogger)
+	case "seed":
+		err = runSeed(args, config, appLogger)
+	case "user":
+		err = runUser(args, config, appLogger)
+	case "wallet":
+		err = runWallet(args, config, appLogger)
+	case "ledger":
+		err = runLedger(args, config, appLogger)
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+	default:
+		err = usageError{text: usage}
+	}
+
+	var badUsage usageError
+	switch {
+	case errors.As(err, &badUsage):
+		fmt.Fprintln(os.Stderr, badUsage.text)
+		appLogger.Sync()
+		os.Exit(2)
+	case errors.Is(err, flag.ErrHelp):
+	case err != nil:
+		appLogger.Sync()
+		log.Fatal(err)
+	}
+}
+
+// Opens the database and, for long-running commands when AUTO_MIGRATE is on, brings the schema up to date
+func openDatabase(config config.Config, appLogger *zap.Logger, migrate bool) (*gorm.DB, error) {
 	db, err := database.ConnectToDatabase(config.DBSource)
 	if err != nil {
-		log.Fatalf("Cannot connect to database: %v", err)
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
+	}
+	if migrate && config.AutoMigrate {
+		migrator, err := database.NewMigrator(db, appLogger)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load migrations: %w", err)
+		}
+		if _, err := migrator.Up(0); err != nil {
+			return nil, fmt.Errorf("cannot migrate database: %w", err)
+		}
 	}
-	SetupRoutes(app, config, appLogger, db)
-	
-	log.Fatal(app.Listen(config.ServerAddr))
+	return db, nil
 }
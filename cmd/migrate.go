@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"pgpockets/internal/config"
+	"pgpockets/internal/database"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const migrateUsage = `usage: pgpockets migrate [-dir path] <command>
+
+  up [n]         apply pending migrations, all of them unless n is given
+  down [n]       revert the latest n applied migrations (default 1)
+  status         list migrations and when each was applied
+  create <name>  write an empty up/down pair for the next version`
+
+var errMigrateUsage = usageError{text: migrateUsage}
+
+func runMigrate(args []string, cfg config.Config, logger *zap.Logger) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dir := flags.String("dir", "../internal/database/migrations", "where create writes new migration files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errMigrateUsage
+		}
+		up, down, err := database.CreateMigration(*dir, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(up)
+		fmt.Println(down)
+		return nil
+	}
+
+	db, err := openDatabase(cfg, logger, false)
+	if err != nil {
+		return err
+	}
+	migrator, err := database.NewMigrator(db, logger)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		steps, err := migrateSteps(args, 0)
+		if err != nil {
+			return err
+		}
+		applied, err := migrator.Up(steps)
+		for _, migration := range applied {
+			fmt.Printf("applied  %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		steps, err := migrateSteps(args, 1)
+		if err != nil {
+			return err
+		}
+		reverted, err := migrator.Down(steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%04d_%-40s %s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	default:
+		return errMigrateUsage
+	}
+}
+
+func migrateSteps(args []string, fallback int) (int, error) {
+	if len(args) < 2 {
+		return fallback, nil
+	}
+	steps, err := strconv.Atoi(args[1])
+	if err != nil || steps < 1 {
+		return 0, fmt.Errorf("invalid number of migrations %q", args[1])
+	}
+	return steps, nil
+}
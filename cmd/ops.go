@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"pgpockets/internal/config"
+	"pgpockets/internal/models"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Operator commands for support and finance work that has no API of its own
+
+const (
+	userUsage = `usage: pgpockets user lock [-for duration] <email|user-id>
+       pgpockets user unlock <email|user-id>`
+	walletUsage = `usage: pgpockets wallet adjust -reason text <wallet-id|email> <amount>
+
+  amount is signed: 2500.00 credits the wallet, -2500.00 debits it`
+	ledgerUsage = `usage: pgpockets ledger reconcile [-json]`
+)
+
+// A lock with no duration lasts until someone unlocks the account
+const indefiniteLock = 100 * 365 * 24 * time.Hour
+
+var errLedgerMismatch = errors.New("ledger reconciliation found mismatched wallets")
+
+func runUser(args []string, config config.Config, appLogger *zap.Logger) error {
+	if len(args) == 0 {
+		return usageError{text: userUsage}
+	}
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	lockFor := flags.Duration("for", 0, "how long the lock lasts; until unlocked when not set")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || (args[0] != "lock" && args[0] != "unlock") {
+		return usageError{text: userUsage}
+	}
+
+	db, err := openDatabase(config, appLogger, false)
+	if err != nil {
+		return err
+	}
+	a := newApplication(config, appLogger, db)
+	defer a.shutdown(context.Background())
+	user, err := findUser(a, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "unlock" {
+		if err := a.loginGuard.Unlock(user.ID); err != nil {
+			return err
+		}
+		fmt.Printf("unlocked %s (%s)\n", user.Email, user.ID)
+		return nil
+	}
+	duration := *lockFor
+	if duration <= 0 {
+		duration = indefiniteLock
+	}
+	until := time.Now().Add(duration)
+	if err := a.loginGuard.Lock(user.ID, until); err != nil {
+		return err
+	}
+	if *lockFor <= 0 {
+		fmt.Printf("locked %s (%s) until unlocked\n", user.Email, user.ID)
+	} else {
+		fmt.Printf("locked %s (%s) until %s\n", user.Email, user.ID, until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func runWallet(args []string, config config.Config, appLogger *zap.Logger) error {
+	if len(args) == 0 || args[0] != "adjust" {
+		return usageError{text: walletUsage}
+	}
+	flags := flag.NewFlagSet("wallet adjust", flag.ContinueOnError)
+	reason := flags.String("reason", "", "why the balance is being corrected; shown on the transaction")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageError{text: walletUsage}
+	}
+	amount, err := decimal.NewFromString(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid amount %q", flags.Arg(1))
+	}
+
+	db, err := openDatabase(config, appLogger, false)
+	if err != nil {
+		return err
+	}
+	a := newApplication(config, appLogger, db)
+	defer a.shutdown(context.Background())
+	walletID, err := findWallet(a, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	txn, err := a.ledgerService.AdjustWallet(walletID, amount, *reason)
+	if err != nil {
+		return err
+	}
+	wallet, err := a.walletRepo.GetWalletByID(walletID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("adjusted wallet %s by %s %s, balance now %s (transaction %s)\n",
+		walletID, amount.StringFixed(2), wallet.Currency, wallet.Balance.StringFixed(2), txn.ID)
+	return nil
+}
+
+func runLedger(args []string, config config.Config, appLogger *zap.Logger) error {
+	if len(args) == 0 || args[0] != "reconcile" {
+		return usageError{text: ledgerUsage}
+	}
+	flags := flag.NewFlagSet("ledger reconcile", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(config, appLogger, false)
+	if err != nil {
+		return err
+	}
+	a := newApplication(config, appLogger, db)
+	defer a.shutdown(context.Background())
+	report, err := a.ledgerService.Reconcile()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("checked %d wallets, %d mismatched\n", report.WalletsChecked, len(report.Mismatches))
+		if len(report.Mismatches) > 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+			fmt.Fprintln(w, "WALLET\tCURRENCY\tBALANCE\tLEDGER\tHELD\tPENDING HOLDS\t")
+			for _, m := range report.Mismatches {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", m.WalletID, m.Currency,
+					m.Balance.StringFixed(2), m.LedgerBalance.StringFixed(2),
+					m.HeldBalance.StringFixed(2), m.PendingHolds.StringFixed(2))
+			}
+			w.Flush()
+		}
+	}
+	// Non-zero exit so scheduled runs can alert on it
+	if len(report.Mismatches) > 0 {
+		return errLedgerMismatch
+	}
+	return nil
+}
+
+func findUser(a *application, ref string) (*models.User, error) {
+	var user *models.User
+	var err error
+	if id, parseErr := uuid.Parse(ref); parseErr == nil {
+		user, err = a.userRepo.GetUserByID(id)
+	} else {
+		user, err = a.userRepo.GetUserByEmail(strings.TrimSpace(ref))
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("no user %q", ref)
+	}
+	return user, err
+}
+
+// A wallet ID as given, or the wallet of the user with that email
+func findWallet(a *application, ref string) (uuid.UUID, error) {
+	if id, err := uuid.Parse(ref); err == nil {
+		return id, nil
+	}
+	wallet, err := a.walletRepo.GetWalletByEmail(strings.TrimSpace(ref))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return uuid.Nil, fmt.Errorf("no wallet for %q", ref)
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return wallet.ID, nil
+}
@@ -1,64 +1,129 @@
 package main
 
 import (
-	"pgpockets/internal/config"
 	"pgpockets/internal/handlers"
 	"pgpockets/internal/middleware"
-	"pgpockets/internal/repositories"
-	"pgpockets/internal/services"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/limiter"
-	"go.uber.org/zap"
-	"gorm.io/gorm"
 	"time"
 )
 
-func SetupRoutes(app *fiber.App, config config.Config, appLogger *zap.Logger, db *gorm.DB) {
+// SetupRoutes registers every HTTP route on app. Background work is started separately by startWorkers.
+func SetupRoutes(app *fiber.App, a *application) {
+	config, appLogger := a.config, a.logger
 	apiV1 := app.Group("/api/v1")
 	appLogger.Info("Setting up routes...")
 
 	// Auth routes
 	authGroup := apiV1.Group("/auth")
-	userRepo := repositories.NewUserRepository(db)
-	walletRepo := repositories.NewWalletRepository(db)
-	authService := services.NewAuthService(userRepo, walletRepo, appLogger, config.JWTSecret)
-	authHandlers := handlers.NewAuthHandler(authService, appLogger)
-	authGroup.Post("/register", authHandlers.RegisterUser)
-	authGroup.Post("/login", authHandlers.LoginUser)
+	authHandlers := handlers.NewAuthHandler(a.authService, a.verificationService, a.loginGuard, appLogger)
+	// Coarse per-IP cap on unauthenticated auth endpoints; account lockout is handled by loginGuard
+	authRateLimiter := limiter.New(limiter.Config{
+		Max:        20,
+		Expiration: time.Minute,
+	})
+	authGroup.Post("/register", authRateLimiter, authHandlers.RegisterUser)
+	authGroup.Post("/login", authRateLimiter, authHandlers.LoginUser)
+	authGroup.Post("/login/2fa", authRateLimiter, authHandlers.CompleteTwoFactorLogin)
+	authGroup.Post("/verify-email", authRateLimiter, authHandlers.VerifyEmail)
+	authGroup.Post("/password/forgot", authRateLimiter, authHandlers.ForgotPassword)
+	authGroup.Post("/password/reset", authRateLimiter, authHandlers.ResetPassword)
+	authGroup.Post("/unlock", authRateLimiter, authHandlers.UnlockAccount)
+	authGroup.Post("/refresh", authRateLimiter, authHandlers.RefreshToken)
+
+	// Card issuer webhooks are authenticated by signature, not by user token
+	cardAuthHandlers := handlers.NewCardAuthorizationHandler(a.cardAuthService, appLogger, config.CardWebhookSecret)
+	apiV1.Post("/webhooks/card-issuer", cardAuthHandlers.HandleIssuerWebhook)
+
+	// Payment links and QR codes can be looked up without signing in; paying one needs an account
+	paymentLinkHandlers := handlers.NewPaymentLinkHandler(a.paymentLinkService, a.twoFactorService, a.stepUpThreshold, appLogger)
+	payRateLimiter := limiter.New(limiter.Config{
+		Max:        30,
+		Expiration: time.Minute,
+	})
+	payGroup := apiV1.Group("/pay")
+	payGroup.Get("/:code", payRateLimiter, paymentLinkHandlers.ResolveLink)
+	payGroup.Post("/qr/resolve", payRateLimiter, paymentLinkHandlers.ResolveQR)
 
 	// Initialize authMiddleware
-	authMiddleware := middleware.NewAuthMiddleware(config.JWTSecret, appLogger, userRepo)
+	authMiddleware := middleware.NewAuthMiddleware(config.JWTSecret, appLogger, a.userRepo)
 	// Initialize reusable rate limiter concern
 	rateLimiter := limiter.New(limiter.Config{
 		Max:        10,
 		Expiration: 2 * time.Second,
 	})
+	// Live update streams sit ahead of the global auth middleware so browsers can pass ?access_token=
+	realtimeHandlers := handlers.NewRealtimeHandler(a.realtimeHub, appLogger)
+	streamGroup := apiV1.Group("/stream", middleware.AcceptQueryToken(), authMiddleware.RequireAuth())
+	streamGroup.Get("/events", realtimeHandlers.StreamEvents)
+	streamGroup.Get("/ws", realtimeHandlers.RequireWebSocketUpgrade, realtimeHandlers.StreamWebSocket())
+
 	/* Protected routes */
 	apiV1.Use(authMiddleware.RequireAuth())
 
 	authGroup.Delete("/logout", authHandlers.LogoutUser)
+	authGroup.Post("/verify-email/resend", authHandlers.ResendEmailVerification)
+	authGroup.Post("/password/change", authHandlers.ChangePassword)
+	authGroup.Get("/sessions", authHandlers.ListSessions)
+	authGroup.Delete("/sessions", authHandlers.RevokeOtherSessions)
+	authGroup.Delete("/sessions/:sessionID", authHandlers.RevokeSession)
+	// Two-factor authentication routes
+	twoFactorHandlers := handlers.NewTwoFactorHandler(a.twoFactorService, appLogger)
+	twoFactorGroup := authGroup.Group("/2fa")
+	twoFactorGroup.Get("/", twoFactorHandlers.GetStatus)
+	twoFactorGroup.Post("/setup", twoFactorHandlers.BeginEnrollment)
+	twoFactorGroup.Post("/confirm", twoFactorHandlers.ConfirmEnrollment)
+	// Wrong codes are also counted per user by the service
+	twoFactorGroup.Post("/disable", authRateLimiter, twoFactorHandlers.Disable)
+	twoFactorGroup.Post("/recovery-codes", authRateLimiter, twoFactorHandlers.RegenerateRecoveryCodes)
+	twoFactorGroup.Post("/step-up", authRateLimiter, twoFactorHandlers.StepUp)
+
+	// Admin routes
+	adminHandlers := handlers.NewAdminHandler(a.loginGuard, a.jobRunner, appLogger)
+	adminGroup := apiV1.Group("/admin", authMiddleware.RequireAdmin())
+	adminGroup.Post("/users/:userID/unlock", adminHandlers.UnlockUser)
+	adminGroup.Get("/jobs", adminHandlers.ListJobs)
+	adminGroup.Get("/jobs/stats", adminHandlers.GetJobStats)
+	adminGroup.Get("/jobs/:jobID", adminHandlers.GetJob)
+	adminGroup.Post("/jobs/:jobID/requeue", adminHandlers.RequeueJob)
 
 	// Dashboard routes
-	dashboardRepo := repositories.NewDashboardRepository(db)
-	dashboardService := services.NewDashboardService(dashboardRepo, appLogger, config.ExchangeRatesAPIKey)
-	dashboardHandlers := handlers.NewDashboardHandler(dashboardService, appLogger)
+	dashboardHandlers := handlers.NewDashboardHandler(a.dashboardService, appLogger)
 	dashboardGroup := apiV1.Group("/dashboard")
 	dashboardGroup.Use(rateLimiter)
 	dashboardGroup.Get("/exchange-rates", dashboardHandlers.GetExchangeRates)
 	// Card routes
-	cardRepo := repositories.NewCardRepository(db)
-	cardService := services.NewCardService(cardRepo, appLogger)
-	cardHandlers := handlers.NewCardHandler(cardService, appLogger)
+	cardHandlers := handlers.NewCardHandler(a.cardService, appLogger)
 	cardGroup := apiV1.Group("/cards")
 	cardGroup.Post("/", cardHandlers.CreateCard)
 	cardGroup.Get("/cards", cardHandlers.RetrieveAllCards)
 	cardGroup.Get("/card/:cardID", cardHandlers.GetCardByID)
+	// Reveals check the password, so each card gets its own small budget of attempts
+	revealRateLimiter := limiter.New(limiter.Config{
+		Max:        5,
+		Expiration: 15 * time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return "card-reveal:" + c.Params("cardID")
+		},
+	})
+	cardGroup.Post("/card/:cardID/reveal", revealRateLimiter, cardHandlers.RevealCard)
 	cardGroup.Delete("/card/:cardID", cardHandlers.DeleteCard)
+	cardGroup.Post("/card/:cardID/freeze", cardHandlers.FreezeCard)
+	cardGroup.Post("/card/:cardID/unfreeze", cardHandlers.UnfreezeCard)
+	cardGroup.Get("/card/:cardID/controls", cardHandlers.GetControls)
+	cardGroup.Put("/card/:cardID/controls", cardHandlers.UpdateControls)
+	cardGroup.Get("/card/:cardID/authorizations", cardAuthHandlers.ListAuthorizations)
+	if a.cardIssuer.Name() == "simulator" && config.EnableCardSimulator {
+		appLogger.Warn("Card payment simulation routes are enabled; never enable them in production")
+		simulateGroup := cardGroup.Group("/card/:cardID/simulate")
+		simulateGroup.Post("/authorize", cardAuthHandlers.SimulateAuthorization)
+		simulateGroup.Post("/capture/:authID", cardAuthHandlers.SimulateCapture)
+		simulateGroup.Post("/reverse/:authID", cardAuthHandlers.SimulateReversal)
+	}
 
 	// Wallet routes
-	walletService := services.NewWalletService(walletRepo, appLogger, db)
-	walletHandlers := handlers.NewWalletHandler(walletService, appLogger, config.ExchangeRatesAPIKey)
+	walletHandlers := handlers.NewWalletHandler(a.walletService, appLogger, config.ExchangeRatesAPIKey)
 	walletGroup := apiV1.Group("/wallets")
 	// Rate limiting
 	walletGroup.Use(rateLimiter)
@@ -66,46 +131,103 @@ func SetupRoutes(app *fiber.App, config config.Config, appLogger *zap.Logger, db
 	walletGroup.Get("/balances", walletHandlers.GetBalancesForAllWallets)
 	walletGroup.Patch("/currency/:desiredCurrency", walletHandlers.ChangeWalletCurrency)
 	// Transaction routes
-	txnRepo := repositories.NewTransactionRepository(db)
-	txnService := services.NewTransactionService(txnRepo, appLogger, walletRepo, db)
-	txnHandlers := handlers.NewTransactionHandler(txnService, appLogger)
+	txnHandlers := handlers.NewTransactionHandler(a.txnService, a.twoFactorService, a.stepUpThreshold, appLogger)
 	txnGroup := apiV1.Group("/transaction")
 	txnGroup.Use(rateLimiter)
-	txnGroup.Patch("/make-transfer", txnHandlers.TransferFunds)
+	txnGroup.Patch("/make-transfer", authMiddleware.RequireVerifiedEmail(), txnHandlers.TransferFunds)
 	txnGroup.Get("/history", txnHandlers.GetUserTransactionHistory)
 	txnGroup.Get("/history/date-range", txnHandlers.GetTransactionsInDateRange)
 	txnGroup.Get("/transaction/:txnID", txnHandlers.GetTransactionByID)
 
 	// Profile routes
-	profileRepo := repositories.NewProfileRepository(db)
-	profileService := services.NewProfileService(profileRepo, appLogger)
-	profileHandlers := handlers.NewProfileHandler(profileService, appLogger)
+	profileHandlers := handlers.NewProfileHandler(a.profileService, appLogger)
 	profileGroup := apiV1.Group("/profile")
 	profileGroup.Get("/", profileHandlers.GetProfile)
 	profileGroup.Put("/", profileHandlers.UpdateProfile)
 	// Beneficiary routes
-	beneficiaryRepo := repositories.NewBeneficiaryRepository(db)
-	beneficiaryService := services.NewBeneficiaryService(beneficiaryRepo, appLogger)
-	beneficiaryHandlers := handlers.NewBeneficiaryHandler(beneficiaryService, appLogger)
+	beneficiaryHandlers := handlers.NewBeneficiaryHandler(a.beneficiaryService, a.twoFactorService, appLogger)
 	beneficiaryGroup := apiV1.Group("/beneficiaries")
 	beneficiaryGroup.Get("/", beneficiaryHandlers.GetBeneficiaries)
 	beneficiaryGroup.Post("/", beneficiaryHandlers.AddBeneficiary)
+	beneficiaryGroup.Patch("/beneficiary/:beneID", beneficiaryHandlers.UpdateBeneficiary)
 	beneficiaryGroup.Delete("/beneficiary/:beneID", beneficiaryHandlers.DeleteBeneficiary)
+	beneficiaryGroup.Post("/name-enquiry", rateLimiter, beneficiaryHandlers.NameEnquiry)
+	beneficiaryGroup.Get("/banks", beneficiaryHandlers.ListBanks)
+	// Transfers by beneficiary, email or phone
+	transferHandlers := handlers.NewTransferHandler(a.transferService, a.twoFactorService, a.stepUpThreshold, appLogger)
+	txnGroup.Post("/transfer/preview", transferHandlers.PreviewTransfer)
+	txnGroup.Post("/transfer", authMiddleware.RequireVerifiedEmail(), transferHandlers.SendTransfer)
+	// Scheduled and recurring transfers
+	scheduledTransferHandlers := handlers.NewScheduledTransferHandler(a.scheduledTransferService, a.twoFactorService, a.stepUpThreshold, appLogger)
+	scheduleGroup := apiV1.Group("/scheduled-transfers")
+	scheduleGroup.Post("/", authMiddleware.RequireVerifiedEmail(), scheduledTransferHandlers.CreateSchedule)
+	scheduleGroup.Get("/", scheduledTransferHandlers.ListSchedules)
+	scheduleGroup.Get("/:scheduleID", scheduledTransferHandlers.GetSchedule)
+	scheduleGroup.Patch("/:scheduleID", scheduledTransferHandlers.UpdateSchedule)
+	scheduleGroup.Post("/:scheduleID/pause", scheduledTransferHandlers.PauseSchedule)
+	scheduleGroup.Post("/:scheduleID/resume", scheduledTransferHandlers.ResumeSchedule)
+	scheduleGroup.Delete("/:scheduleID", scheduledTransferHandlers.CancelSchedule)
 	// Notification Routes
-	notifRepo := repositories.NewNotifRepo(db)
-	notifServices := services.NewNotificationService(notifRepo)
-	notifHandlers := handlers.NewNotificationHandlers(notifServices, appLogger)
+	notifHandlers := handlers.NewNotificationHandlers(a.notifService, a.notifDispatcher, appLogger)
 	notifGroup := apiV1.Group("/notifications")
 	notifGroup.Get("/count", notifHandlers.GetNotificationCount)
 	notifGroup.Get("/unread-count", notifHandlers.GetUnreadNotificationCount)
 	notifGroup.Get("/", notifHandlers.GetNotifications)
 	notifGroup.Get("/unread", notifHandlers.GetUnreadNotifications)
+	notifGroup.Get("/preferences", notifHandlers.GetPreferences)
+	notifGroup.Put("/preferences", notifHandlers.UpdatePreferences)
+	notifGroup.Get("/deliveries", notifHandlers.ListDeliveries)
 	notifGroup.Get("/:id", notifHandlers.GetNotification)
 	notifGroup.Patch("/:id/read", notifHandlers.MarkAsRead)
 	notifGroup.Patch("/:id/unread", notifHandlers.MarkAsUnread)
 	notifGroup.Patch("/read-all", notifHandlers.MarkAllAsRead)
+	notifGroup.Delete("/read", notifHandlers.DeleteAllReadNotifications)
 	notifGroup.Delete("/:id", notifHandlers.DeleteNotification)
 	notifGroup.Delete("/", notifHandlers.DeleteAllNotifications)
-	notifGroup.Delete("/read", notifHandlers.DeleteAllReadNotifications)
 
+	// Payment requests
+	paymentRequestHandlers := handlers.NewPaymentRequestHandler(a.paymentRequestService, a.twoFactorService, a.stepUpThreshold, appLogger)
+	paymentRequestGroup := apiV1.Group("/payment-requests")
+	paymentRequestGroup.Use(rateLimiter)
+	paymentRequestGroup.Post("/", authMiddleware.RequireVerifiedEmail(), paymentRequestHandlers.CreateRequest)
+	paymentRequestGroup.Get("/", paymentRequestHandlers.ListRequests)
+	paymentRequestGroup.Get("/:requestID", paymentRequestHandlers.GetRequest)
+	paymentRequestGroup.Post("/:requestID/approve", authMiddleware.RequireVerifiedEmail(), paymentRequestHandlers.ApproveRequest)
+	paymentRequestGroup.Post("/:requestID/decline", paymentRequestHandlers.DeclineRequest)
+	paymentRequestGroup.Delete("/:requestID", paymentRequestHandlers.CancelRequest)
+
+	// Bill splitting
+	billSplitHandlers := handlers.NewBillSplitHandler(a.billSplitService, appLogger)
+	splitGroup := apiV1.Group("/bill-splits")
+	splitGroup.Use(rateLimiter)
+	splitGroup.Post("/", authMiddleware.RequireVerifiedEmail(), billSplitHandlers.CreateSplit)
+	splitGroup.Get("/", billSplitHandlers.ListSplits)
+	splitGroup.Get("/:splitID", billSplitHandlers.GetSplit)
+	splitGroup.Post("/:splitID/remind", billSplitHandlers.RemindParticipants)
+	splitGroup.Post("/:splitID/participants/:participantID/mark-paid", billSplitHandlers.MarkParticipantPaid)
+	splitGroup.Delete("/:splitID", billSplitHandlers.CancelSplit)
+
+	// Payment links
+	linkGroup := apiV1.Group("/payment-links")
+	linkGroup.Use(rateLimiter)
+	linkGroup.Post("/", paymentLinkHandlers.CreateLink)
+	linkGroup.Get("/", paymentLinkHandlers.ListLinks)
+	linkGroup.Get("/:linkID", paymentLinkHandlers.GetLink)
+	linkGroup.Delete("/:linkID", paymentLinkHandlers.DisableLink)
+	payGroup.Post("/:code", rateLimiter, authMiddleware.RequireVerifiedEmail(), paymentLinkHandlers.PayLink)
+
+	// Outbound webhooks
+	webhookHandlers := handlers.NewWebhookHandler(a.webhookService, appLogger)
+	webhookGroup := apiV1.Group("/webhook-endpoints")
+	webhookGroup.Use(rateLimiter)
+	webhookGroup.Get("/event-types", webhookHandlers.ListEventTypes)
+	webhookGroup.Post("/", webhookHandlers.CreateEndpoint)
+	webhookGroup.Get("/", webhookHandlers.ListEndpoints)
+	webhookGroup.Get("/:endpointID", webhookHandlers.GetEndpoint)
+	webhookGroup.Patch("/:endpointID", webhookHandlers.UpdateEndpoint)
+	webhookGroup.Delete("/:endpointID", webhookHandlers.DeleteEndpoint)
+	webhookGroup.Post("/:endpointID/rotate-secret", webhookHandlers.RotateSecret)
+	webhookGroup.Get("/:endpointID/deliveries", webhookHandlers.ListDeliveries)
+	webhookGroup.Get("/:endpointID/deliveries/:deliveryID", webhookHandlers.GetDelivery)
+	webhookGroup.Post("/:endpointID/deliveries/:deliveryID/redeliver", webhookHandlers.Redeliver)
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"pgpockets/internal/config"
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const seedUsage = `usage: pgpockets seed -password text
+
+  creates demo data for local development; refuses to run unless ALLOW_SEED=true`
+
+var errSeedNotAllowed = errors.New("seed creates demo accounts with known passwords and only runs with ALLOW_SEED=true; never set it outside local development")
+
+type demoUser struct {
+	email, firstName, lastName, dob, phone, address, gender string
+	openingBalance                                          int64
+}
+
+var demoUsers = []demoUser{
+	{"ada@pgpockets.local", "Ada", "Obi", "14-03-1992", "+2348010000001", "12 Admiralty Way, Lekki, Lagos", "female", 250_000},
+	{"tunde@pgpockets.local", "Tunde", "Bakare", "02-11-1988", "+2348010000002", "4 Awolowo Road, Ikoyi, Lagos", "male", 120_000},
+	{"chioma@pgpockets.local", "Chioma", "Eze", "27-07-1995", "+2348010000003", "9 Aminu Kano Crescent, Wuse II, Abuja", "female", 80_000},
+}
+
+// Demo data for local development: verified users with funded wallets, a few transfers between them and invoices
+func runSeed(args []string, config config.Config, appLogger *zap.Logger) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	password := flags.String("password", "", "password for every demo user (required)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *password == "" || flags.NArg() != 0 {
+		return usageError{text: seedUsage}
+	}
+	if !config.AllowSeed {
+		return errSeedNotAllowed
+	}
+
+	db, err := openDatabase(config, appLogger, true)
+	if err != nil {
+		return err
+	}
+	a := newApplication(config, appLogger, db)
+	defer a.shutdown(context.Background())
+
+	if _, err := a.userRepo.GetUserByEmail(demoUsers[0].email); err == nil {
+		fmt.Println("demo data is already present")
+		return nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	users := make([]*models.User, len(demoUsers))
+	wallets := make([]*models.Wallet, len(demoUsers))
+	for i, demo := range demoUsers {
+		user, err := a.authService.Register(demo.email, *password, demo.firstName, demo.lastName, demo.dob, demo.phone, demo.address, demo.gender)
+		if err != nil {
+			return fmt.Errorf("registering %s: %w", demo.email, err)
+		}
+		if err := a.userRepo.MarkEmailVerified(user.ID); err != nil {
+			return err
+		}
+		wallet, err := a.walletRepo.GetWalletByUserID(user.ID)
+		if err != nil {
+			return fmt.Errorf("wallet for %s: %w", demo.email, err)
+		}
+		if _, err := a.ledgerService.AdjustWallet(wallet.ID, decimal.NewFromInt(demo.openingBalance), "Demo opening balance"); err != nil {
+			return err
+		}
+		users[i], wallets[i] = user, wallet
+	}
+
+	transfers := []struct {
+		from, to    int
+		amount      int64
+		description string
+	}{
+		{0, 1, 15_000, "Dinner at Yellow Chilli"},
+		{1, 2, 7_500, "Uber split"},
+		{2, 0, 3_200, "Airtime"},
+		{0, 2, 45_000, "Rent contribution"},
+		{1, 0, 12_000, "Concert tickets"},
+	}
+	for _, t := range transfers {
+		if _, err := a.txnService.TransferFunds(users[t.from].ID, wallets[t.from].ID, wallets[t.to].ID,
+			decimal.NewFromInt(t.amount), wallets[t.from].Currency, t.description); err != nil {
+			return fmt.Errorf("transfer %q: %w", t.description, err)
+		}
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	invoices := []models.Invoice{
+		{
+			InvoiceNumber: "INV-DEMO-0001",
+			SenderID:      users[0].ID,
+			ReceiverID:    users[1].ID,
+			IssueDate:     today,
+			DueDate:       today.AddDate(0, 0, 14),
+			TotalAmount:   "161250.00",
+			Currency:      models.CurrencyNGN,
+			Status:        models.InvoiceStatusSent,
+			Description:   "Website redesign, first milestone",
+			PaymentTerms:  "Net 14",
+			Items: []models.InvoiceItem{
+				{Description: "Design workshop", Quantity: "1.0000", UnitPrice: "50000.00", LineTotal: "53750.00", TaxRate: "0.0750", TaxAmount: "3750.00"},
+				{Description: "Page templates", Quantity: "4.0000", UnitPrice: "25000.00", LineTotal: "107500.00", TaxRate: "0.0750", TaxAmount: "7500.00"},
+			},
+		},
+		{
+			InvoiceNumber: "INV-DEMO-0002",
+			SenderID:      users[2].ID,
+			ReceiverID:    users[0].ID,
+			IssueDate:     today.AddDate(0, -1, 0),
+			DueDate:       today.AddDate(0, 0, -3),
+			TotalAmount:   "30000.00",
+			Currency:      models.CurrencyNGN,
+			Status:        models.InvoiceStatusOverdue,
+			Description:   "Catering for team lunch",
+			PaymentTerms:  "Due on receipt",
+			Items: []models.InvoiceItem{
+				{Description: "Jollof rice trays", Quantity: "3.0000", UnitPrice: "10000.00", LineTotal: "30000.00"},
+			},
+		},
+		{
+			InvoiceNumber: "INV-DEMO-0003",
+			SenderID:      users[1].ID,
+			ReceiverID:    users[2].ID,
+			IssueDate:     today,
+			DueDate:       today.AddDate(0, 0, 30),
+			TotalAmount:   "18000.00",
+			Currency:      models.CurrencyNGN,
+			Status:        models.InvoiceStatusDraft,
+			Description:   "Photography session",
+			Items: []models.InvoiceItem{
+				{Description: "Portrait session", Quantity: "1.0000", UnitPrice: "18000.00", LineTotal: "18000.00"},
+			},
+		},
+	}
+	for i := range invoices {
+		if err := db.Omit("Sender", "Receiver").Create(&invoices[i]).Error; err != nil {
+			return fmt.Errorf("invoice %s: %w", invoices[i].InvoiceNumber, err)
+		}
+	}
+
+	fmt.Printf("seeded %d users, %d transfers and %d invoices\n", len(users), len(transfers), len(invoices))
+	for _, demo := range demoUsers {
+		fmt.Printf("  %s / %s\n", demo.email, *password)
+	}
+	return nil
+}
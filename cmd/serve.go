@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"pgpockets/internal/config"
+	"pgpockets/internal/handlers"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/helmet"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"go.uber.org/zap"
+)
+
+func runServe(args []string, config config.Config, appLogger *zap.Logger) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	withWorkers := flags.Bool("workers", true, "also run background work; turn off when a separate worker process runs it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(config, appLogger, true)
+	if err != nil {
+		return err
+	}
+	a := newApplication(config, appLogger, db)
+
+	app := fiber.New()
+	// Probes go before the request logger so orchestrator polling does not flood the logs
+	health := handlers.NewHealthHandler(db, appLogger)
+	app.Get("/healthz", health.Live)
+	app.Get("/readyz", health.Ready)
+	app.Use(logger.New())
+	// A panicking handler answers 500 instead of taking the whole process down
+	app.Use(recover.New(recover.Config{EnableStackTrace: true}))
+	app.Use(helmet.New())
+	SetupRoutes(app, a)
+
+	a.goBackground(a.realtimeHub.Listen)
+	if *withWorkers {
+		a.startWorkers()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(config.ServerAddr)
+	}()
+
+	select {
+	case err := <-listenErr:
+		// The listener failed before any signal, e.g. the address is in use
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		a.shutdown(shutdownCtx)
+		return err
+	case <-ctx.Done():
+	}
+	// A second signal kills the process straight away
+	stop()
+
+	appLogger.Info("Shutting down", zap.Duration("drain_delay", config.ShutdownDrainDelay),
+		zap.Duration("timeout", config.ShutdownTimeout))
+	health.MarkShuttingDown()
+	time.Sleep(config.ShutdownDrainDelay)
+
+	// Streams never finish on their own, so end them before waiting for requests to drain
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	a.realtimeHub.Close()
+	if err := app.ShutdownWithContext(shutdownCtx); err != nil {
+		appLogger.Warn("Requests still in flight at shutdown", zap.Error(err))
+	}
+	if err := <-listenErr; err != nil {
+		appLogger.Error("Server stopped with error", zap.Error(err))
+	}
+	a.shutdown(shutdownCtx)
+	appLogger.Info("Shutdown complete")
+	return nil
+}
+
+// Runs the background work on its own, for deployments that scale it apart from the API
+func runWorker(args []string, config config.Config, appLogger *zap.Logger) error {
+	flags := flag.NewFlagSet("worker", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(config, appLogger, true)
+	if err != nil {
+		return err
+	}
+	a := newApplication(config, appLogger, db)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	a.startWorkers()
+	<-ctx.Done()
+	stop()
+	appLogger.Info("Stopping background workers", zap.Duration("timeout", config.ShutdownTimeout))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	a.shutdown(shutdownCtx)
+	appLogger.Info("Shutdown complete")
+	return nil
+}
@@ -0,0 +1,59 @@
+package cardissuer
+
+import (
+	"errors"
+	"pgpockets/internal/config"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownIssuer       = errors.New("unknown card issuer")
+	ErrUnsupportedCardType = errors.New("unsupported card type")
+	ErrVaultEntryNotFound  = errors.New("card not found in vault")
+)
+
+type IssueRequest struct {
+	CardType   string // Maps to models.CardType constants
+	NameOnCard string
+	Currency   string
+}
+
+// IssuedCard is what we keep about a card. The PAN and CVV stay with the issuer.
+type IssuedCard struct {
+	VaultToken     string
+	LastFourDigits string
+	ExpiryMonth    string // MM
+	ExpiryYear     string // YYYY
+	CardBrand      string
+	BankName       string
+	Issuer         string
+}
+
+// CardDetails are the sensitive details only returned on an explicit reveal
+type CardDetails struct {
+	PAN         string `json:"pan"`
+	CVV         string `json:"cvv"`
+	ExpiryMonth string `json:"expiry_month"`
+	ExpiryYear  string `json:"expiry_year"`
+}
+
+// CardIssuer issues virtual cards and holds their sensitive details in its vault
+type CardIssuer interface {
+	Name() string
+	IssueCard(req IssueRequest) (*IssuedCard, error)
+	RevealCard(vaultToken string) (*CardDetails, error)
+}
+
+// Builds the issuer selected by CARD_ISSUER. Only the local simulator exists for now.
+func NewFromConfig(cfg config.Config, db *gorm.DB) (CardIssuer, error) {
+	switch cfg.CardIssuer {
+	case "simulator", "":
+		vaultKey := cfg.CardVaultKey
+		if vaultKey == "" {
+			vaultKey = cfg.JWTSecret
+		}
+		return NewSimulator(db, vaultKey), nil
+	}
+	return nil, ErrUnknownIssuer
+}
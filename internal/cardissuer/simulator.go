@@ -0,0 +1,142 @@
+package cardissuer
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"pgpockets/internal/models"
+	"pgpockets/internal/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	simulatorName     = "simulator"
+	simulatorBankName = "PgPockets Sandbox Bank"
+	cardValidityYears = 3
+)
+
+// Test BIN ranges per card type. None of these route to a real issuer.
+var simulatorBINs = map[string]string{
+	models.CardTypeVisa:       "400000",
+	models.CardTypeMastercard: "510000",
+	models.CardTypeVerve:      "506100",
+}
+
+var panLengths = map[string]int{
+	models.CardTypeVisa:       16,
+	models.CardTypeMastercard: 16,
+	models.CardTypeVerve:      19,
+}
+
+var brandNames = map[string]string{
+	models.CardTypeVisa:       "Visa",
+	models.CardTypeMastercard: "Mastercard",
+	models.CardTypeVerve:      "Verve",
+}
+
+// simulator is a local stand-in for a card issuing partner. It generates
+// Luhn-valid PANs in test BIN ranges and keeps them encrypted in its own vault table.
+type simulator struct {
+	db       *gorm.DB
+	vaultKey string
+}
+
+func NewSimulator(db *gorm.DB, vaultKey string) CardIssuer {
+	return &simulator{
+		db:       db,
+		vaultKey: vaultKey,
+	}
+}
+
+func (s *simulator) Name() string {
+	return simulatorName
+}
+
+func (s *simulator) IssueCard(req IssueRequest) (*IssuedCard, error) {
+	bin, ok := simulatorBINs[req.CardType]
+	if !ok {
+		return nil, ErrUnsupportedCardType
+	}
+
+	pan, err := generatePAN(bin, panLengths[req.CardType])
+	if err != nil {
+		return nil, err
+	}
+	cvv, err := randomDigits(3)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedPAN, err := utils.EncryptString(pan, s.vaultKey)
+	if err != nil {
+		return nil, err
+	}
+	encryptedCVV, err := utils.EncryptString(cvv, s.vaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &models.CardVaultEntry{
+		VaultToken:   "vtok_" + uuid.NewString(),
+		EncryptedPAN: encryptedPAN,
+		EncryptedCVV: encryptedCVV,
+	}
+	if err := s.db.Create(entry).Error; err != nil {
+		return nil, err
+	}
+
+	expiry := time.Now().AddDate(cardValidityYears, 0, 0)
+	return &IssuedCard{
+		VaultToken:     entry.VaultToken,
+		LastFourDigits: pan[len(pan)-4:],
+		ExpiryMonth:    fmt.Sprintf("%02d", int(expiry.Month())),
+		ExpiryYear:     fmt.Sprintf("%04d", expiry.Year()),
+		CardBrand:      brandNames[req.CardType],
+		BankName:       simulatorBankName,
+		Issuer:         simulatorName,
+	}, nil
+}
+
+func (s *simulator) RevealCard(vaultToken string) (*CardDetails, error) {
+	var entry models.CardVaultEntry
+	if err := s.db.Where("vault_token = ?", vaultToken).First(&entry).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrVaultEntryNotFound
+		}
+		return nil, err
+	}
+
+	pan, err := utils.DecryptString(entry.EncryptedPAN, s.vaultKey)
+	if err != nil {
+		return nil, err
+	}
+	cvv, err := utils.DecryptString(entry.EncryptedCVV, s.vaultKey)
+	if err != nil {
+		return nil, err
+	}
+	return &CardDetails{PAN: pan, CVV: cvv}, nil
+}
+
+func generatePAN(bin string, length int) (string, error) {
+	body, err := randomDigits(length - len(bin) - 1)
+	if err != nil {
+		return "", err
+	}
+	partial := bin + body
+	return fmt.Sprintf("%s%d", partial, utils.LuhnCheckDigit(partial)), nil
+}
+
+func randomDigits(n int) (string, error) {
+	digits := make([]byte, n)
+	for i := range digits {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		digits[i] = byte('0' + d.Int64())
+	}
+	return string(digits), nil
+}
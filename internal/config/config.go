@@ -1,18 +1,81 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
 
 type Config struct {
 	DBSource            string `mapstructure:"DB_SOURCE"`
 	ServerAddr          string `mapstructure:"SERVER_ADDR"`
 	JWTSecret           string `mapstructure:"JWT_SECRET"`
 	ExchangeRatesAPIKey string `mapstructure:"EXCHANGE_RATES_API_KEY"`
+	// Transfers above this amount need a recent 2FA step-up when the user has 2FA enabled
+	StepUpTransferThreshold string `mapstructure:"STEP_UP_TRANSFER_THRESHOLD"`
+	// Base URL used to build links in outgoing emails
+	AppBaseURL string `mapstructure:"APP_BASE_URL"`
+	// Mail delivery: "smtp" or "log" (logs and writes .eml files to MAIL_OUTBOX_DIR)
+	MailDriver    string `mapstructure:"MAIL_DRIVER"`
+	MailFrom      string `mapstructure:"MAIL_FROM"`
+	MailOutboxDir string `mapstructure:"MAIL_OUTBOX_DIR"`
+	SMTPHost      string `mapstructure:"SMTP_HOST"`
+	SMTPPort      string `mapstructure:"SMTP_PORT"`
+	SMTPUsername  string `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword  string `mapstructure:"SMTP_PASSWORD"`
+	// SMS and push delivery; "log" only logs what would have been sent
+	SMSDriver  string `mapstructure:"SMS_DRIVER"`
+	PushDriver string `mapstructure:"PUSH_DRIVER"`
+	// Card issuing partner ("simulator" for local development) and the key its vault is encrypted with
+	CardIssuer   string `mapstructure:"CARD_ISSUER"`
+	CardVaultKey string `mapstructure:"CARD_VAULT_KEY"`
+	// Local development only: exposes /cards/card/:cardID/simulate/* when CARD_ISSUER is simulator.
+	// Those routes create holds and captures against real wallet balances, so they are off by default.
+	EnableCardSimulator bool `mapstructure:"ENABLE_CARD_SIMULATOR"`
+	// Shared secret the issuer signs authorization webhooks with
+	CardWebhookSecret string `mapstructure:"CARD_WEBHOOK_SECRET"`
+	// Resolves external bank accounts to holder names ("stub" for local development)
+	NameEnquiryProvider string `mapstructure:"NAME_ENQUIRY_PROVIDER"`
+	// Encrypts users' webhook signing secrets at rest; kept apart from JWT_SECRET so either can be rotated alone
+	WebhookSecretKey string `mapstructure:"WEBHOOK_SECRET_KEY"`
+	// Local development only: lets webhook endpoints use plain http and private or loopback addresses
+	WebhookAllowPrivateURLs bool `mapstructure:"WEBHOOK_ALLOW_PRIVATE_URLS"`
+	// Local development only: the seed command refuses to run unless this is set
+	AllowSeed bool `mapstructure:"ALLOW_SEED"`
+	// Apply pending migrations when the server starts; turn off to run "migrate up" as a deploy step instead
+	AutoMigrate bool `mapstructure:"AUTO_MIGRATE"`
+	// On SIGTERM the readiness probe fails for SHUTDOWN_DRAIN_DELAY so load balancers stop routing
+	// here, then in-flight requests and background work get SHUTDOWN_TIMEOUT to finish
+	ShutdownDrainDelay time.Duration `mapstructure:"SHUTDOWN_DRAIN_DELAY"`
+	ShutdownTimeout    time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
 }
 
 func LoadConfig() (config Config, err error) {
 	viper.AddConfigPath("../")
 	viper.SetConfigName("app")
 	viper.SetConfigType("env")
+	viper.SetDefault("STEP_UP_TRANSFER_THRESHOLD", "100000")
+	viper.SetDefault("APP_BASE_URL", "http://localhost:4000")
+	viper.SetDefault("MAIL_DRIVER", "log")
+	viper.SetDefault("MAIL_FROM", "PgPockets <no-reply@pgpockets.local>")
+	viper.SetDefault("MAIL_OUTBOX_DIR", "")
+	viper.SetDefault("SMTP_HOST", "")
+	viper.SetDefault("SMTP_PORT", "587")
+	viper.SetDefault("SMTP_USERNAME", "")
+	viper.SetDefault("SMTP_PASSWORD", "")
+	viper.SetDefault("SMS_DRIVER", "log")
+	viper.SetDefault("PUSH_DRIVER", "log")
+	viper.SetDefault("CARD_ISSUER", "simulator")
+	viper.SetDefault("CARD_VAULT_KEY", "")
+	viper.SetDefault("ENABLE_CARD_SIMULATOR", false)
+	viper.SetDefault("CARD_WEBHOOK_SECRET", "")
+	viper.SetDefault("NAME_ENQUIRY_PROVIDER", "stub")
+	viper.SetDefault("WEBHOOK_SECRET_KEY", "")
+	viper.SetDefault("WEBHOOK_ALLOW_PRIVATE_URLS", false)
+	viper.SetDefault("ALLOW_SEED", false)
+	viper.SetDefault("AUTO_MIGRATE", true)
+	viper.SetDefault("SHUTDOWN_DRAIN_DELAY", "5s")
+	viper.SetDefault("SHUTDOWN_TIMEOUT", "20s")
 	viper.AutomaticEnv()
 	err = viper.ReadInConfig()
 	if err != nil {
@@ -1,29 +1,15 @@
 package database
 
 import (
-	"pgpockets/internal/models"
-
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-
+// ConnectToDatabase opens the connection pool. The schema is managed by the migrations in this package.
 func ConnectToDatabase(dbSource string) (*gorm.DB, error) {
 	db, err := gorm.Open(postgres.Open(dbSource), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
-	
-	db.AutoMigrate(
-		&models.Card{},
-		&models.User{},
-		&models.Transaction{},
-		&models.Invoice{},
-		&models.InvoiceItem{},
-		&models.Profile{},
-		&models.Session{},
-		&models.Wallet{},
-	)
-
 	return db, nil
-}
\ No newline at end of file
+}
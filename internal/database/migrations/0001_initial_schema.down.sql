@@ -0,0 +1,34 @@
+DROP TABLE IF EXISTS
+    jobs,
+    processed_events,
+    outbox_events,
+    webhook_delivery_attempts,
+    webhook_deliveries,
+    webhook_endpoints,
+    payment_links,
+    bill_split_participants,
+    bill_splits,
+    payment_requests,
+    realtime_events,
+    notification_deliveries,
+    quiet_hours,
+    notification_preferences,
+    notifications,
+    scheduled_transfers,
+    beneficiaries,
+    known_devices,
+    login_throttles,
+    verification_tokens,
+    recovery_codes,
+    two_factor_auths,
+    sessions,
+    invoice_items,
+    invoices,
+    card_authorizations,
+    transactions,
+    card_vault_entries,
+    cards,
+    wallets,
+    kyc_details,
+    profiles,
+    users;
@@ -0,0 +1,526 @@
+-- Schema as previously created by AutoMigrate, plus kyc_details which it never created.
+-- IF NOT EXISTS lets databases that were set up by AutoMigrate adopt this migration as is.
+
+CREATE TABLE IF NOT EXISTS users (
+    id                uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    email             varchar(255) NOT NULL UNIQUE,
+    password_hash     varchar(255) NOT NULL,
+    is_email_verified boolean DEFAULT false,
+    role              varchar(16) NOT NULL DEFAULT 'user',
+    created_at        timestamptz NOT NULL DEFAULT now(),
+    updated_at        timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS profiles (
+    id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       uuid NOT NULL UNIQUE,
+    first_name    varchar(255) NOT NULL,
+    last_name     varchar(255) NOT NULL,
+    date_of_birth date,
+    phone_number  varchar(20) UNIQUE,
+    address       text,
+    gender        varchar(10),
+    created_at    timestamptz NOT NULL DEFAULT now(),
+    updated_at    timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS kyc_details (
+    id                       uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id                  uuid NOT NULL,
+    first_name               varchar(255) NOT NULL,
+    last_name                varchar(255) NOT NULL,
+    date_of_birth            date,
+    phone_number             varchar(20) UNIQUE,
+    country                  varchar(128),
+    house_number             varchar(128),
+    street                   varchar(255),
+    lga                      varchar(128),
+    state                    varchar(128),
+    bvn                      varchar(12),
+    nin                      varchar(12),
+    occupation               varchar(255),
+    estimated_monthly_salary bigint,
+    created_at               timestamptz NOT NULL DEFAULT now(),
+    updated_at               timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS wallets (
+    id           uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id      uuid NOT NULL,
+    currency     varchar(3) NOT NULL DEFAULT 'NGN',
+    balance      numeric(18,2) NOT NULL DEFAULT 0.00,
+    held_balance numeric(18,2) NOT NULL DEFAULT 0.00,
+    name         varchar(255) DEFAULT 'Naira Wallet',
+    is_active    boolean DEFAULT true,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    updated_at   timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS cards (
+    id                    uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id               uuid NOT NULL,
+    card_token            varchar(255) NOT NULL UNIQUE,
+    last_four_digits      varchar(4) NOT NULL,
+    card_type             varchar(20) NOT NULL,
+    expiry_month          varchar(2) NOT NULL,
+    expiry_year           varchar(4) NOT NULL,
+    card_brand            varchar(50),
+    bank_name             varchar(255),
+    is_active             boolean DEFAULT true,
+    status                varchar(20) NOT NULL DEFAULT 'active',
+    per_transaction_limit numeric(18,2),
+    daily_limit           numeric(18,2),
+    monthly_limit         numeric(18,2),
+    allowed_mccs          jsonb,
+    blocked_mccs          jsonb,
+    allowed_countries     jsonb,
+    blocked_countries     jsonb,
+    online_enabled        boolean NOT NULL DEFAULT true,
+    atm_enabled           boolean NOT NULL DEFAULT true,
+    wallet_id             uuid,
+    name_on_card          varchar(255),
+    issuer                varchar(50),
+    revealed_at           timestamptz,
+    expiry_notified_at    timestamptz,
+    replaced_by_card_id   uuid,
+    created_at            timestamptz NOT NULL DEFAULT now(),
+    updated_at            timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_cards_status ON cards (status);
+CREATE INDEX IF NOT EXISTS idx_cards_wallet_id ON cards (wallet_id);
+
+CREATE TABLE IF NOT EXISTS card_vault_entries (
+    vault_token   varchar(64) PRIMARY KEY,
+    encrypted_pan text NOT NULL,
+    encrypted_cvv text NOT NULL,
+    created_at    timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id                     uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    sender_wallet_id       uuid,
+    receiver_wallet_id     uuid,
+    amount                 numeric(18,2) NOT NULL,
+    currency               varchar(3) NOT NULL,
+    transaction_type       varchar(50) NOT NULL,
+    status                 varchar(20) NOT NULL DEFAULT 'pending',
+    description            text,
+    reference_id           varchar(255) UNIQUE,
+    card_id                uuid,
+    merchant_name          varchar(255),
+    merchant_category_code varchar(4),
+    merchant_city          varchar(128),
+    merchant_country       varchar(2),
+    made_at                timestamptz NOT NULL DEFAULT now(),
+    created_at             timestamptz NOT NULL DEFAULT now(),
+    updated_at             timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_transactions_card_id ON transactions (card_id);
+
+CREATE TABLE IF NOT EXISTS card_authorizations (
+    id                     uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    card_id                uuid NOT NULL,
+    wallet_id              uuid NOT NULL,
+    issuer_reference       varchar(255) NOT NULL UNIQUE,
+    amount                 numeric(18,2) NOT NULL,
+    captured_amount        numeric(18,2) NOT NULL DEFAULT 0.00,
+    currency               varchar(3) NOT NULL,
+    status                 varchar(20) NOT NULL,
+    decline_reason         varchar(64),
+    merchant_name          varchar(255),
+    merchant_category_code varchar(4),
+    merchant_city          varchar(128),
+    merchant_country       varchar(2),
+    channel                varchar(10) NOT NULL DEFAULT 'pos',
+    transaction_id         uuid,
+    expires_at             timestamptz NOT NULL,
+    created_at             timestamptz NOT NULL DEFAULT now(),
+    updated_at             timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_card_authorizations_card_id ON card_authorizations (card_id);
+CREATE INDEX IF NOT EXISTS idx_card_authorizations_wallet_id ON card_authorizations (wallet_id);
+CREATE INDEX IF NOT EXISTS idx_card_authorizations_expires_at ON card_authorizations (expires_at);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id             uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    invoice_number varchar(50) NOT NULL UNIQUE,
+    sender_id      uuid NOT NULL,
+    receiver_id    uuid NOT NULL,
+    issue_date     date NOT NULL,
+    due_date       date NOT NULL,
+    total_amount   numeric(18,2) NOT NULL,
+    currency       varchar(3) NOT NULL,
+    status         varchar(20) NOT NULL DEFAULT 'draft',
+    description    text,
+    payment_terms  varchar(255),
+    created_at     timestamptz NOT NULL DEFAULT now(),
+    updated_at     timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS invoice_items (
+    id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    invoice_id  uuid NOT NULL,
+    description text NOT NULL,
+    quantity    numeric(18,4) NOT NULL,
+    unit_price  numeric(18,2) NOT NULL,
+    line_total  numeric(18,2) NOT NULL,
+    tax_rate    numeric(5,4) DEFAULT 0.00,
+    tax_amount  numeric(18,2) DEFAULT 0.00,
+    created_at  timestamptz NOT NULL DEFAULT now(),
+    updated_at  timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id                       uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id                  uuid NOT NULL,
+    client_ip                varchar(45),
+    user_agent               text,
+    access_token             text NOT NULL,
+    access_token_expires_at  timestamptz NOT NULL,
+    refresh_token            text NOT NULL,
+    refresh_token_expires_at timestamptz NOT NULL,
+    is_active                boolean DEFAULT true,
+    family_id                uuid,
+    replaced_by_id           uuid,
+    last_seen_at             timestamptz NOT NULL DEFAULT now(),
+    step_up_at               timestamptz,
+    created_at               timestamptz NOT NULL DEFAULT now(),
+    updated_at               timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_family_id ON sessions (family_id);
+
+CREATE TABLE IF NOT EXISTS two_factor_auths (
+    id             uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id        uuid NOT NULL UNIQUE,
+    secret         varchar(64) NOT NULL,
+    is_enabled     boolean DEFAULT false,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    confirmed_at   timestamptz,
+    created_at     timestamptz NOT NULL DEFAULT now(),
+    updated_at     timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id         uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id    uuid NOT NULL,
+    code_hash  varchar(64) NOT NULL,
+    used_at    timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS verification_tokens (
+    id         uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id    uuid NOT NULL,
+    purpose    varchar(32) NOT NULL,
+    token_hash varchar(64) NOT NULL UNIQUE,
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_verification_tokens_user_id ON verification_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS login_throttles (
+    id              uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    scope           varchar(16) NOT NULL,
+    key             varchar(255) NOT NULL,
+    failed_count    bigint NOT NULL DEFAULT 0,
+    last_failed_at  timestamptz,
+    next_attempt_at timestamptz,
+    locked_until    timestamptz,
+    created_at      timestamptz NOT NULL DEFAULT now(),
+    updated_at      timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_login_throttles_scope_key ON login_throttles (scope, key);
+
+CREATE TABLE IF NOT EXISTS known_devices (
+    id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       uuid NOT NULL,
+    fingerprint   varchar(128) NOT NULL,
+    last_ip       varchar(45),
+    first_seen_at timestamptz NOT NULL DEFAULT now(),
+    last_seen_at  timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_known_devices_user_fingerprint ON known_devices (user_id, fingerprint);
+
+CREATE TABLE IF NOT EXISTS beneficiaries (
+    id                uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id           uuid NOT NULL,
+    kind              varchar(20) NOT NULL DEFAULT 'wallet',
+    description       text,
+    nickname          varchar(64),
+    is_favorite       boolean NOT NULL DEFAULT false,
+    target_key        varchar(128),
+    wallet_id         uuid,
+    recipient_user_id uuid,
+    phone_number      varchar(20),
+    account_number    varchar(10),
+    bank_code         varchar(10),
+    bank_name         varchar(255),
+    account_name      varchar(255),
+    created_at        timestamptz NOT NULL DEFAULT now(),
+    updated_at        timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_beneficiaries_is_favorite ON beneficiaries (is_favorite);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_beneficiaries_user_target ON beneficiaries (user_id, target_key) WHERE target_key <> '';
+
+CREATE TABLE IF NOT EXISTS scheduled_transfers (
+    id                  uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id             uuid NOT NULL,
+    sender_wallet_id    uuid NOT NULL,
+    receiver_wallet_id  uuid,
+    beneficiary_id      uuid,
+    amount              numeric(18,2) NOT NULL,
+    currency            varchar(3) NOT NULL,
+    description         text,
+    frequency           varchar(10) NOT NULL,
+    start_at            timestamptz NOT NULL,
+    next_run_at         timestamptz NOT NULL,
+    end_date            timestamptz,
+    status              varchar(20) NOT NULL DEFAULT 'active',
+    retry_count         bigint NOT NULL DEFAULT 0,
+    run_count           bigint NOT NULL DEFAULT 0,
+    last_run_at         timestamptz,
+    last_error          text,
+    last_transaction_id uuid,
+    created_at          timestamptz NOT NULL DEFAULT now(),
+    updated_at          timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_user_id ON scheduled_transfers (user_id);
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_next_run_at ON scheduled_transfers (next_run_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_status ON scheduled_transfers (status);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id     uuid NOT NULL,
+    type        varchar(64) NOT NULL DEFAULT '',
+    category    varchar(32) NOT NULL DEFAULT 'account',
+    priority    varchar(16) NOT NULL DEFAULT 'normal',
+    title       text NOT NULL,
+    description text NOT NULL,
+    payload     jsonb,
+    is_read     boolean DEFAULT false,
+    expires_at  timestamptz,
+    created_at  timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_category ON notifications (user_id, category);
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    id         uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id    uuid NOT NULL,
+    category   varchar(32) NOT NULL,
+    channel    varchar(16) NOT NULL,
+    enabled    boolean NOT NULL,
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_preferences_user_category_channel
+    ON notification_preferences (user_id, category, channel);
+
+CREATE TABLE IF NOT EXISTS quiet_hours (
+    user_id    uuid PRIMARY KEY,
+    enabled    boolean NOT NULL,
+    start_time varchar(5) NOT NULL,
+    end_time   varchar(5) NOT NULL,
+    timezone   varchar(64) NOT NULL,
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+    id              uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id         uuid NOT NULL,
+    event_type      varchar(64) NOT NULL,
+    category        varchar(32) NOT NULL,
+    channel         varchar(16) NOT NULL,
+    recipient       varchar(255) NOT NULL,
+    priority        varchar(16) NOT NULL DEFAULT 'normal',
+    title           text NOT NULL,
+    body            text NOT NULL,
+    payload         jsonb,
+    expires_at      timestamptz,
+    status          varchar(16) NOT NULL,
+    attempts        bigint NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL,
+    last_error      text,
+    sent_at         timestamptz,
+    created_at      timestamptz NOT NULL DEFAULT now(),
+    updated_at      timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_user_id ON notification_deliveries (user_id);
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_due ON notification_deliveries (status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS realtime_events (
+    id         bigserial PRIMARY KEY,
+    user_id    uuid NOT NULL,
+    type       varchar(64) NOT NULL,
+    payload    jsonb NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_realtime_events_user_id_id ON realtime_events (user_id, id);
+CREATE INDEX IF NOT EXISTS idx_realtime_events_created_at ON realtime_events (created_at);
+
+CREATE TABLE IF NOT EXISTS payment_requests (
+    id                  uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    requester_id        uuid NOT NULL,
+    payer_id            uuid NOT NULL,
+    requester_wallet_id uuid NOT NULL,
+    amount              numeric(18,2) NOT NULL,
+    currency            varchar(3) NOT NULL,
+    note                varchar(255),
+    status              varchar(20) NOT NULL DEFAULT 'pending',
+    expires_at          timestamptz NOT NULL,
+    responded_at        timestamptz,
+    transaction_id      uuid,
+    created_at          timestamptz NOT NULL DEFAULT now(),
+    updated_at          timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_payment_requests_requester_id ON payment_requests (requester_id);
+CREATE INDEX IF NOT EXISTS idx_payment_requests_payer_id ON payment_requests (payer_id);
+CREATE INDEX IF NOT EXISTS idx_payment_requests_status ON payment_requests (status);
+CREATE INDEX IF NOT EXISTS idx_payment_requests_expires_at ON payment_requests (expires_at);
+
+CREATE TABLE IF NOT EXISTS bill_splits (
+    id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    creator_id    uuid NOT NULL,
+    title         varchar(100) NOT NULL,
+    total_amount  numeric(18,2) NOT NULL,
+    currency      varchar(3) NOT NULL,
+    method        varchar(12) NOT NULL,
+    creator_share numeric(18,2) NOT NULL DEFAULT 0,
+    status        varchar(12) NOT NULL DEFAULT 'open',
+    settled_at    timestamptz,
+    created_at    timestamptz NOT NULL DEFAULT now(),
+    updated_at    timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_bill_splits_creator_id ON bill_splits (creator_id);
+CREATE INDEX IF NOT EXISTS idx_bill_splits_status ON bill_splits (status);
+
+CREATE TABLE IF NOT EXISTS bill_split_participants (
+    id                 uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    split_id           uuid NOT NULL,
+    user_id            uuid NOT NULL,
+    amount             numeric(18,2) NOT NULL,
+    percentage         numeric(5,2),
+    payment_request_id uuid,
+    status             varchar(20) NOT NULL DEFAULT 'pending',
+    paid_at            timestamptz,
+    last_reminded_at   timestamptz,
+    reminder_count     bigint NOT NULL DEFAULT 0,
+    created_at         timestamptz NOT NULL DEFAULT now(),
+    updated_at         timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_bill_split_participants_split_id ON bill_split_participants (split_id);
+CREATE INDEX IF NOT EXISTS idx_bill_split_participants_user_id ON bill_split_participants (user_id);
+
+CREATE TABLE IF NOT EXISTS payment_links (
+    id              uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id         uuid NOT NULL,
+    wallet_id       uuid NOT NULL,
+    code            varchar(16) NOT NULL,
+    amount          numeric(18,2),
+    currency        varchar(3) NOT NULL,
+    description     varchar(255),
+    reusable        boolean NOT NULL DEFAULT false,
+    status          varchar(12) NOT NULL DEFAULT 'active',
+    expires_at      timestamptz,
+    use_count       bigint NOT NULL DEFAULT 0,
+    total_collected numeric(18,2) NOT NULL DEFAULT 0,
+    last_paid_at    timestamptz,
+    created_at      timestamptz NOT NULL DEFAULT now(),
+    updated_at      timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_payment_links_user_id ON payment_links (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_links_code ON payment_links (code);
+
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id                   uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id              uuid NOT NULL,
+    url                  varchar(2048) NOT NULL,
+    description          varchar(255),
+    event_types          jsonb NOT NULL,
+    encrypted_secret     text NOT NULL,
+    status               varchar(16) NOT NULL DEFAULT 'active',
+    disabled_reason      varchar(255),
+    consecutive_failures bigint NOT NULL DEFAULT 0,
+    failing_since        timestamptz,
+    last_success_at      timestamptz,
+    disabled_at          timestamptz,
+    created_at           timestamptz NOT NULL DEFAULT now(),
+    updated_at           timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_user_id ON webhook_endpoints (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    endpoint_id     uuid NOT NULL,
+    event_id        uuid NOT NULL,
+    event_type      varchar(64) NOT NULL,
+    payload         text NOT NULL,
+    status          varchar(16) NOT NULL,
+    attempts        bigint NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL,
+    last_error      text,
+    delivered_at    timestamptz,
+    created_at      timestamptz NOT NULL DEFAULT now(),
+    updated_at      timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint_event ON webhook_deliveries (endpoint_id, event_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_created_at ON webhook_deliveries (created_at);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+    id              uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    delivery_id     uuid NOT NULL,
+    response_status bigint,
+    response_body   text,
+    error           text,
+    duration_ms     bigint,
+    created_at      timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts (delivery_id);
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+    sequence        bigserial PRIMARY KEY,
+    event_id        uuid NOT NULL,
+    type            varchar(64) NOT NULL,
+    user_id         uuid NOT NULL,
+    occurred_at     timestamptz NOT NULL,
+    data            jsonb NOT NULL,
+    attempts        bigint NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    last_error      text,
+    published_at    timestamptz,
+    failed_at       timestamptz,
+    created_at      timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_outbox_events_event_id ON outbox_events (event_id);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_published_at ON outbox_events (published_at);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_failed_at ON outbox_events (failed_at);
+
+CREATE TABLE IF NOT EXISTS processed_events (
+    consumer     varchar(64) NOT NULL,
+    event_id     uuid NOT NULL,
+    processed_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (consumer, event_id)
+);
+CREATE INDEX IF NOT EXISTS idx_processed_events_processed_at ON processed_events (processed_at);
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id           uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    queue        varchar(64) NOT NULL,
+    type         varchar(128) NOT NULL,
+    payload      jsonb NOT NULL,
+    priority     bigint NOT NULL DEFAULT 0,
+    status       varchar(16) NOT NULL,
+    run_at       timestamptz NOT NULL,
+    locked_until timestamptz,
+    locked_by    varchar(128),
+    attempts     bigint NOT NULL DEFAULT 0,
+    max_attempts bigint NOT NULL,
+    last_error   text,
+    completed_at timestamptz,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    updated_at   timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_jobs_claim ON jobs (queue, status, run_at);
+CREATE INDEX IF NOT EXISTS idx_jobs_type ON jobs (type);
+CREATE INDEX IF NOT EXISTS idx_jobs_created_at ON jobs (created_at);
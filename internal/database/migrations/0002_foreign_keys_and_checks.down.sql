@@ -0,0 +1,107 @@
+DROP INDEX IF EXISTS idx_notifications_created_at;
+DROP INDEX IF EXISTS idx_invoice_items_invoice_id;
+DROP INDEX IF EXISTS idx_invoices_receiver_id;
+DROP INDEX IF EXISTS idx_invoices_sender_id;
+DROP INDEX IF EXISTS idx_transactions_receiver_wallet_id;
+DROP INDEX IF EXISTS idx_transactions_sender_wallet_id;
+DROP INDEX IF EXISTS idx_sessions_user_id;
+DROP INDEX IF EXISTS idx_cards_user_id;
+DROP INDEX IF EXISTS idx_wallets_user_id;
+DROP INDEX IF EXISTS idx_kyc_details_user_id;
+DROP INDEX IF EXISTS idx_profiles_user_id;
+
+ALTER TABLE jobs
+    DROP CONSTRAINT IF EXISTS chk_jobs_status;
+ALTER TABLE webhook_endpoints
+    DROP CONSTRAINT IF EXISTS chk_webhook_endpoints_status,
+    DROP CONSTRAINT IF EXISTS fk_webhook_endpoints_user_id;
+ALTER TABLE payment_links
+    DROP CONSTRAINT IF EXISTS chk_payment_links_status,
+    DROP CONSTRAINT IF EXISTS chk_payment_links_amount_positive,
+    DROP CONSTRAINT IF EXISTS fk_payment_links_wallet_id,
+    DROP CONSTRAINT IF EXISTS fk_payment_links_user_id;
+ALTER TABLE bill_split_participants
+    DROP CONSTRAINT IF EXISTS chk_bill_split_participants_amount_non_negative,
+    DROP CONSTRAINT IF EXISTS fk_bill_split_participants_payment_request_id,
+    DROP CONSTRAINT IF EXISTS fk_bill_split_participants_user_id,
+    DROP CONSTRAINT IF EXISTS fk_bill_split_participants_split_id;
+ALTER TABLE bill_splits
+    DROP CONSTRAINT IF EXISTS chk_bill_splits_status,
+    DROP CONSTRAINT IF EXISTS chk_bill_splits_method,
+    DROP CONSTRAINT IF EXISTS chk_bill_splits_total_amount_positive,
+    DROP CONSTRAINT IF EXISTS fk_bill_splits_creator_id;
+ALTER TABLE payment_requests
+    DROP CONSTRAINT IF EXISTS chk_payment_requests_status,
+    DROP CONSTRAINT IF EXISTS chk_payment_requests_amount_positive,
+    DROP CONSTRAINT IF EXISTS fk_payment_requests_transaction_id,
+    DROP CONSTRAINT IF EXISTS fk_payment_requests_requester_wallet_id,
+    DROP CONSTRAINT IF EXISTS fk_payment_requests_payer_id,
+    DROP CONSTRAINT IF EXISTS fk_payment_requests_requester_id;
+ALTER TABLE scheduled_transfers
+    DROP CONSTRAINT IF EXISTS chk_scheduled_transfers_status,
+    DROP CONSTRAINT IF EXISTS chk_scheduled_transfers_frequency,
+    DROP CONSTRAINT IF EXISTS chk_scheduled_transfers_amount_positive,
+    DROP CONSTRAINT IF EXISTS fk_scheduled_transfers_beneficiary_id,
+    DROP CONSTRAINT IF EXISTS fk_scheduled_transfers_receiver_wallet_id,
+    DROP CONSTRAINT IF EXISTS fk_scheduled_transfers_sender_wallet_id,
+    DROP CONSTRAINT IF EXISTS fk_scheduled_transfers_user_id;
+ALTER TABLE invoices
+    DROP CONSTRAINT IF EXISTS chk_invoices_total_amount_non_negative,
+    DROP CONSTRAINT IF EXISTS fk_invoices_receiver_id,
+    DROP CONSTRAINT IF EXISTS fk_invoices_sender_id;
+ALTER TABLE transactions
+    DROP CONSTRAINT IF EXISTS chk_transactions_status,
+    DROP CONSTRAINT IF EXISTS chk_transactions_amount_positive,
+    DROP CONSTRAINT IF EXISTS fk_transactions_card_id,
+    DROP CONSTRAINT IF EXISTS fk_transactions_receiver_wallet_id,
+    DROP CONSTRAINT IF EXISTS fk_transactions_sender_wallet_id;
+ALTER TABLE card_authorizations
+    DROP CONSTRAINT IF EXISTS chk_card_authorizations_status,
+    DROP CONSTRAINT IF EXISTS chk_card_authorizations_captured_amount,
+    DROP CONSTRAINT IF EXISTS chk_card_authorizations_amount_positive,
+    DROP CONSTRAINT IF EXISTS fk_card_authorizations_transaction_id,
+    DROP CONSTRAINT IF EXISTS fk_card_authorizations_wallet_id,
+    DROP CONSTRAINT IF EXISTS fk_card_authorizations_card_id;
+ALTER TABLE cards
+    DROP CONSTRAINT IF EXISTS chk_cards_status,
+    DROP CONSTRAINT IF EXISTS fk_cards_replaced_by_card_id,
+    DROP CONSTRAINT IF EXISTS fk_cards_wallet_id,
+    DROP CONSTRAINT IF EXISTS fk_cards_user_id;
+ALTER TABLE wallets
+    DROP CONSTRAINT IF EXISTS chk_wallets_held_balance_non_negative,
+    DROP CONSTRAINT IF EXISTS chk_wallets_balance_non_negative,
+    DROP CONSTRAINT IF EXISTS fk_wallets_user_id;
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS chk_users_role;
+ALTER TABLE webhook_delivery_attempts
+    DROP CONSTRAINT IF EXISTS fk_webhook_delivery_attempts_delivery_id;
+ALTER TABLE webhook_deliveries
+    DROP CONSTRAINT IF EXISTS fk_webhook_deliveries_endpoint_id;
+ALTER TABLE beneficiaries
+    DROP CONSTRAINT IF EXISTS fk_beneficiaries_recipient_user_id,
+    DROP CONSTRAINT IF EXISTS fk_beneficiaries_wallet_id,
+    DROP CONSTRAINT IF EXISTS fk_beneficiaries_user_id;
+ALTER TABLE invoice_items
+    DROP CONSTRAINT IF EXISTS fk_invoice_items_invoice_id;
+ALTER TABLE notification_deliveries
+    DROP CONSTRAINT IF EXISTS fk_notification_deliveries_user_id;
+ALTER TABLE quiet_hours
+    DROP CONSTRAINT IF EXISTS fk_quiet_hours_user_id;
+ALTER TABLE notification_preferences
+    DROP CONSTRAINT IF EXISTS fk_notification_preferences_user_id;
+ALTER TABLE notifications
+    DROP CONSTRAINT IF EXISTS fk_notifications_user_id;
+ALTER TABLE known_devices
+    DROP CONSTRAINT IF EXISTS fk_known_devices_user_id;
+ALTER TABLE verification_tokens
+    DROP CONSTRAINT IF EXISTS fk_verification_tokens_user_id;
+ALTER TABLE recovery_codes
+    DROP CONSTRAINT IF EXISTS fk_recovery_codes_user_id;
+ALTER TABLE two_factor_auths
+    DROP CONSTRAINT IF EXISTS fk_two_factor_auths_user_id;
+ALTER TABLE sessions
+    DROP CONSTRAINT IF EXISTS fk_sessions_user_id;
+ALTER TABLE kyc_details
+    DROP CONSTRAINT IF EXISTS fk_kyc_details_user_id;
+ALTER TABLE profiles
+    DROP CONSTRAINT IF EXISTS fk_profiles_user_id;
@@ -0,0 +1,146 @@
+-- AutoMigrate named its foreign keys after the association (fk_profiles_user); those are
+-- replaced by ones named after the column so every relation is declared here once.
+ALTER TABLE profiles DROP CONSTRAINT IF EXISTS fk_profiles_user;
+ALTER TABLE wallets DROP CONSTRAINT IF EXISTS fk_wallets_user;
+ALTER TABLE cards DROP CONSTRAINT IF EXISTS fk_cards_user;
+ALTER TABLE cards DROP CONSTRAINT IF EXISTS fk_cards_wallet;
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS fk_invoices_sender;
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS fk_invoices_receiver;
+ALTER TABLE invoice_items DROP CONSTRAINT IF EXISTS fk_invoices_items;
+ALTER TABLE invoice_items DROP CONSTRAINT IF EXISTS fk_invoice_items_invoice;
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS fk_transactions_sender_wallet;
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS fk_transactions_receiver_wallet;
+ALTER TABLE sessions DROP CONSTRAINT IF EXISTS fk_sessions_user;
+ALTER TABLE two_factor_auths DROP CONSTRAINT IF EXISTS fk_two_factor_auths_user;
+ALTER TABLE beneficiaries DROP CONSTRAINT IF EXISTS fk_beneficiaries_wallet;
+ALTER TABLE bill_split_participants DROP CONSTRAINT IF EXISTS fk_bill_splits_participants;
+ALTER TABLE webhook_delivery_attempts DROP CONSTRAINT IF EXISTS fk_webhook_deliveries_attempt_log;
+
+-- Data that only makes sense for its user goes with the user; anything on the ledger
+-- (wallets, cards, transactions, invoices) blocks deleting the user instead.
+ALTER TABLE profiles
+    ADD CONSTRAINT fk_profiles_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE kyc_details
+    ADD CONSTRAINT fk_kyc_details_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE sessions
+    ADD CONSTRAINT fk_sessions_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE two_factor_auths
+    ADD CONSTRAINT fk_two_factor_auths_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE recovery_codes
+    ADD CONSTRAINT fk_recovery_codes_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE verification_tokens
+    ADD CONSTRAINT fk_verification_tokens_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE known_devices
+    ADD CONSTRAINT fk_known_devices_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE notifications
+    ADD CONSTRAINT fk_notifications_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE notification_preferences
+    ADD CONSTRAINT fk_notification_preferences_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE quiet_hours
+    ADD CONSTRAINT fk_quiet_hours_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE notification_deliveries
+    ADD CONSTRAINT fk_notification_deliveries_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE beneficiaries
+    ADD CONSTRAINT fk_beneficiaries_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE webhook_endpoints
+    ADD CONSTRAINT fk_webhook_endpoints_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+ALTER TABLE wallets
+    ADD CONSTRAINT fk_wallets_user_id FOREIGN KEY (user_id) REFERENCES users (id);
+ALTER TABLE cards
+    ADD CONSTRAINT fk_cards_user_id FOREIGN KEY (user_id) REFERENCES users (id),
+    ADD CONSTRAINT fk_cards_wallet_id FOREIGN KEY (wallet_id) REFERENCES wallets (id),
+    ADD CONSTRAINT fk_cards_replaced_by_card_id FOREIGN KEY (replaced_by_card_id) REFERENCES cards (id);
+ALTER TABLE card_authorizations
+    ADD CONSTRAINT fk_card_authorizations_card_id FOREIGN KEY (card_id) REFERENCES cards (id),
+    ADD CONSTRAINT fk_card_authorizations_wallet_id FOREIGN KEY (wallet_id) REFERENCES wallets (id),
+    ADD CONSTRAINT fk_card_authorizations_transaction_id FOREIGN KEY (transaction_id) REFERENCES transactions (id);
+ALTER TABLE transactions
+    ADD CONSTRAINT fk_transactions_sender_wallet_id FOREIGN KEY (sender_wallet_id) REFERENCES wallets (id),
+    ADD CONSTRAINT fk_transactions_receiver_wallet_id FOREIGN KEY (receiver_wallet_id) REFERENCES wallets (id),
+    ADD CONSTRAINT fk_transactions_card_id FOREIGN KEY (card_id) REFERENCES cards (id);
+ALTER TABLE invoices
+    ADD CONSTRAINT fk_invoices_sender_id FOREIGN KEY (sender_id) REFERENCES users (id),
+    ADD CONSTRAINT fk_invoices_receiver_id FOREIGN KEY (receiver_id) REFERENCES users (id);
+ALTER TABLE invoice_items
+    ADD CONSTRAINT fk_invoice_items_invoice_id FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE CASCADE;
+ALTER TABLE beneficiaries
+    ADD CONSTRAINT fk_beneficiaries_wallet_id FOREIGN KEY (wallet_id) REFERENCES wallets (id) ON DELETE SET NULL,
+    ADD CONSTRAINT fk_beneficiaries_recipient_user_id FOREIGN KEY (recipient_user_id) REFERENCES users (id) ON DELETE SET NULL;
+ALTER TABLE scheduled_transfers
+    ADD CONSTRAINT fk_scheduled_transfers_user_id FOREIGN KEY (user_id) REFERENCES users (id),
+    ADD CONSTRAINT fk_scheduled_transfers_sender_wallet_id FOREIGN KEY (sender_wallet_id) REFERENCES wallets (id),
+    ADD CONSTRAINT fk_scheduled_transfers_receiver_wallet_id FOREIGN KEY (receiver_wallet_id) REFERENCES wallets (id),
+    ADD CONSTRAINT fk_scheduled_transfers_beneficiary_id FOREIGN KEY (beneficiary_id) REFERENCES beneficiaries (id) ON DELETE SET NULL;
+ALTER TABLE payment_requests
+    ADD CONSTRAINT fk_payment_requests_requester_id FOREIGN KEY (requester_id) REFERENCES users (id),
+    ADD CONSTRAINT fk_payment_requests_payer_id FOREIGN KEY (payer_id) REFERENCES users (id),
+    ADD CONSTRAINT fk_payment_requests_requester_wallet_id FOREIGN KEY (requester_wallet_id) REFERENCES wallets (id),
+    ADD CONSTRAINT fk_payment_requests_transaction_id FOREIGN KEY (transaction_id) REFERENCES transactions (id);
+ALTER TABLE bill_splits
+    ADD CONSTRAINT fk_bill_splits_creator_id FOREIGN KEY (creator_id) REFERENCES users (id);
+ALTER TABLE bill_split_participants
+    ADD CONSTRAINT fk_bill_split_participants_split_id FOREIGN KEY (split_id) REFERENCES bill_splits (id) ON DELETE CASCADE,
+    ADD CONSTRAINT fk_bill_split_participants_user_id FOREIGN KEY (user_id) REFERENCES users (id),
+    ADD CONSTRAINT fk_bill_split_participants_payment_request_id FOREIGN KEY (payment_request_id) REFERENCES payment_requests (id);
+ALTER TABLE payment_links
+    ADD CONSTRAINT fk_payment_links_user_id FOREIGN KEY (user_id) REFERENCES users (id),
+    ADD CONSTRAINT fk_payment_links_wallet_id FOREIGN KEY (wallet_id) REFERENCES wallets (id);
+ALTER TABLE webhook_deliveries
+    ADD CONSTRAINT fk_webhook_deliveries_endpoint_id FOREIGN KEY (endpoint_id) REFERENCES webhook_endpoints (id) ON DELETE CASCADE;
+ALTER TABLE webhook_delivery_attempts
+    ADD CONSTRAINT fk_webhook_delivery_attempts_delivery_id FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries (id) ON DELETE CASCADE;
+
+-- Indexes for foreign keys that are looked up or checked on delete and had none
+CREATE INDEX IF NOT EXISTS idx_profiles_user_id ON profiles (user_id);
+CREATE INDEX IF NOT EXISTS idx_kyc_details_user_id ON kyc_details (user_id);
+CREATE INDEX IF NOT EXISTS idx_wallets_user_id ON wallets (user_id);
+CREATE INDEX IF NOT EXISTS idx_cards_user_id ON cards (user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_sender_wallet_id ON transactions (sender_wallet_id, made_at);
+CREATE INDEX IF NOT EXISTS idx_transactions_receiver_wallet_id ON transactions (receiver_wallet_id, made_at);
+CREATE INDEX IF NOT EXISTS idx_invoices_sender_id ON invoices (sender_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_receiver_id ON invoices (receiver_id);
+CREATE INDEX IF NOT EXISTS idx_invoice_items_invoice_id ON invoice_items (invoice_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_created_at ON notifications (user_id, created_at);
+
+ALTER TABLE users
+    ADD CONSTRAINT chk_users_role CHECK (role IN ('user', 'admin'));
+ALTER TABLE wallets
+    ADD CONSTRAINT chk_wallets_balance_non_negative CHECK (balance >= 0),
+    ADD CONSTRAINT chk_wallets_held_balance_non_negative CHECK (held_balance >= 0);
+ALTER TABLE cards
+    ADD CONSTRAINT chk_cards_status CHECK (status IN ('active', 'frozen', 'terminated', 'expired'));
+ALTER TABLE card_authorizations
+    ADD CONSTRAINT chk_card_authorizations_amount_positive CHECK (amount > 0),
+    ADD CONSTRAINT chk_card_authorizations_captured_amount CHECK (captured_amount >= 0),
+    ADD CONSTRAINT chk_card_authorizations_status
+        CHECK (status IN ('pending', 'captured', 'reversed', 'expired', 'declined'));
+ALTER TABLE transactions
+    ADD CONSTRAINT chk_transactions_amount_positive CHECK (amount > 0),
+    ADD CONSTRAINT chk_transactions_status
+        CHECK (status IN ('pending', 'completed', 'failed', 'reversed', 'refunded', 'cancelled'));
+ALTER TABLE invoices
+    ADD CONSTRAINT chk_invoices_total_amount_non_negative CHECK (total_amount >= 0);
+ALTER TABLE scheduled_transfers
+    ADD CONSTRAINT chk_scheduled_transfers_amount_positive CHECK (amount > 0),
+    ADD CONSTRAINT chk_scheduled_transfers_frequency CHECK (frequency IN ('once', 'daily', 'weekly', 'monthly')),
+    ADD CONSTRAINT chk_scheduled_transfers_status
+        CHECK (status IN ('active', 'paused', 'completed', 'cancelled', 'failed'));
+ALTER TABLE payment_requests
+    ADD CONSTRAINT chk_payment_requests_amount_positive CHECK (amount > 0),
+    ADD CONSTRAINT chk_payment_requests_status
+        CHECK (status IN ('pending', 'paid', 'declined', 'cancelled', 'expired'));
+ALTER TABLE bill_splits
+    ADD CONSTRAINT chk_bill_splits_total_amount_positive CHECK (total_amount > 0),
+    ADD CONSTRAINT chk_bill_splits_method CHECK (method IN ('equal', 'percentage', 'exact')),
+    ADD CONSTRAINT chk_bill_splits_status CHECK (status IN ('open', 'settled', 'cancelled'));
+ALTER TABLE bill_split_participants
+    ADD CONSTRAINT chk_bill_split_participants_amount_non_negative CHECK (amount >= 0);
+ALTER TABLE payment_links
+    ADD CONSTRAINT chk_payment_links_amount_positive CHECK (amount IS NULL OR amount > 0),
+    ADD CONSTRAINT chk_payment_links_status CHECK (status IN ('active', 'used', 'disabled', 'expired'));
+ALTER TABLE webhook_endpoints
+    ADD CONSTRAINT chk_webhook_endpoints_status CHECK (status IN ('active', 'disabled'));
+ALTER TABLE jobs
+    ADD CONSTRAINT chk_jobs_status CHECK (status IN ('queued', 'running', 'completed', 'dead'));
@@ -0,0 +1,2 @@
+-- 2FA login challenges are stored as verification tokens; each one allows a few codes before it is burned
+ALTER TABLE verification_tokens ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
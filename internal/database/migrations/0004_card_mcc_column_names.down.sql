@@ -0,0 +1 @@
+-- Nothing to undo: allowed_mccs and blocked_mccs are the names 0001 creates
@@ -0,0 +1,16 @@
+-- GORM's default naming turned AllowedMCCs into allowed_mc_cs, so databases created by the old
+-- auto-migration have those columns while 0001 creates allowed_mccs. Bring the old ones in line.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'cards' AND column_name = 'allowed_mc_cs') THEN
+        ALTER TABLE cards DROP COLUMN IF EXISTS allowed_mccs;
+        ALTER TABLE cards RENAME COLUMN allowed_mc_cs TO allowed_mccs;
+    END IF;
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'cards' AND column_name = 'blocked_mc_cs') THEN
+        ALTER TABLE cards DROP COLUMN IF EXISTS blocked_mccs;
+        ALTER TABLE cards RENAME COLUMN blocked_mc_cs TO blocked_mccs;
+    END IF;
+END
+$$;
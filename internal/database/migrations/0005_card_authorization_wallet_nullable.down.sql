@@ -0,0 +1,2 @@
+DELETE FROM card_authorizations WHERE wallet_id IS NULL;
+ALTER TABLE card_authorizations ALTER COLUMN wallet_id SET NOT NULL;
@@ -0,0 +1,2 @@
+-- Declines for cards with no funding wallet are still recorded, so they have no wallet to point at
+ALTER TABLE card_authorizations ALTER COLUMN wallet_id DROP NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_jobs_unique_key_queued;
+ALTER TABLE jobs DROP COLUMN IF EXISTS unique_key;
@@ -0,0 +1,3 @@
+-- Recurring jobs keep exactly one queued run across instances by sharing a unique key
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS unique_key varchar(255);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_unique_key_queued ON jobs (unique_key) WHERE status = 'queued';
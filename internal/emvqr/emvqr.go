@@ -0,0 +1,234 @@
+/*
+	Package emvqr encodes and decodes EMVCo-style merchant-presented QR payloads.
+
+	A payload is a run of TLV fields: a 2-digit tag, a 2-digit length and the value,
+	ending with tag 63 holding a CRC16/CCITT-FALSE checksum over everything before it
+	(including "6304"). PgPockets account details live in merchant account template 26.
+*/
+package emvqr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrMalformedPayload = errors.New("malformed QR payload")
+	ErrChecksumMismatch = errors.New("QR payload checksum does not match")
+	ErrNotPgPockets     = errors.New("QR payload is not a PgPockets payment code")
+	ErrUnknownCurrency  = errors.New("currency has no numeric code")
+	ErrFieldTooLong     = errors.New("QR field is longer than 99 characters")
+)
+
+// Globally unique identifier in the merchant account template marking our payloads
+const GloballyUniqueID = "com.pgpockets"
+
+const (
+	tagPayloadFormat     = "00"
+	tagPointOfInitiation = "01"
+	tagMerchantAccount   = "26"
+	tagCategoryCode      = "52"
+	tagCurrency          = "53"
+	tagAmount            = "54"
+	tagCountryCode       = "58"
+	tagMerchantName      = "59"
+	tagMerchantCity      = "60"
+	tagAdditionalData    = "62"
+	tagCRC               = "63"
+
+	// Sub-tags of the merchant account template
+	subTagGUID     = "00"
+	subTagLinkCode = "01"
+	subTagWalletID = "02"
+	// Sub-tag of the additional data template
+	subTagPurpose = "08"
+
+	payloadFormatVersion = "01"
+	staticInitiation     = "11" // Code can be paid more than once
+	dynamicInitiation    = "12" // Code is for a single payment
+	personToPersonMCC    = "0000"
+)
+
+// ISO 4217 numeric codes for the currencies wallets can hold
+var currencyCodes = map[string]string{
+	"NGN": "566",
+	"USD": "840",
+	"EUR": "978",
+	"GBP": "826",
+	"GHS": "936",
+	"KES": "404",
+	"ZAR": "710",
+}
+
+type Payload struct {
+	LinkCode     string
+	WalletID     string
+	SingleUse    bool
+	Currency     string // ISO 4217 alpha code, e.g. "NGN"
+	Amount       string // Empty for open-amount codes
+	CountryCode  string
+	MerchantName string
+	MerchantCity string
+	Purpose      string
+}
+
+func Encode(p Payload) (string, error) {
+	numericCurrency, ok := currencyCodes[strings.ToUpper(p.Currency)]
+	if !ok {
+		return "", ErrUnknownCurrency
+	}
+
+	account, err := tlv(
+		subTagGUID, GloballyUniqueID,
+		subTagLinkCode, p.LinkCode,
+		subTagWalletID, p.WalletID,
+	)
+	if err != nil {
+		return "", err
+	}
+	initiation := staticInitiation
+	if p.SingleUse {
+		initiation = dynamicInitiation
+	}
+	additional := ""
+	if p.Purpose != "" {
+		if additional, err = tlv(subTagPurpose, truncate(p.Purpose, 25)); err != nil {
+			return "", err
+		}
+	}
+
+	body, err := tlv(
+		tagPayloadFormat, payloadFormatVersion,
+		tagPointOfInitiation, initiation,
+		tagMerchantAccount, account,
+		tagCategoryCode, personToPersonMCC,
+		tagCurrency, numericCurrency,
+		tagAmount, p.Amount,
+		tagCountryCode, p.CountryCode,
+		tagMerchantName, truncate(p.MerchantName, 25),
+		tagMerchantCity, truncate(p.MerchantCity, 15),
+		tagAdditionalData, additional,
+	)
+	if err != nil {
+		return "", err
+	}
+	body += tagCRC + "04"
+	return body + fmt.Sprintf("%04X", crc16(body)), nil
+}
+
+func Decode(payload string) (*Payload, error) {
+	if len(payload) < 8 || payload[len(payload)-8:len(payload)-4] != tagCRC+"04" {
+		return nil, ErrMalformedPayload
+	}
+	expected := fmt.Sprintf("%04X", crc16(payload[:len(payload)-4]))
+	if !strings.EqualFold(expected, payload[len(payload)-4:]) {
+		return nil, ErrChecksumMismatch
+	}
+
+	fields, err := parse(payload[:len(payload)-8])
+	if err != nil {
+		return nil, err
+	}
+	if fields[tagPayloadFormat] != payloadFormatVersion {
+		return nil, ErrMalformedPayload
+	}
+	account, err := parse(fields[tagMerchantAccount])
+	if err != nil {
+		return nil, err
+	}
+	if account[subTagGUID] != GloballyUniqueID || account[subTagLinkCode] == "" {
+		return nil, ErrNotPgPockets
+	}
+
+	p := &Payload{
+		LinkCode:     account[subTagLinkCode],
+		WalletID:     account[subTagWalletID],
+		SingleUse:    fields[tagPointOfInitiation] == dynamicInitiation,
+		Amount:       fields[tagAmount],
+		CountryCode:  fields[tagCountryCode],
+		MerchantName: fields[tagMerchantName],
+		MerchantCity: fields[tagMerchantCity],
+	}
+	for alpha, numeric := range currencyCodes {
+		if numeric == fields[tagCurrency] {
+			p.Currency = alpha
+		}
+	}
+	if p.Currency == "" {
+		return nil, ErrUnknownCurrency
+	}
+	if additional := fields[tagAdditionalData]; additional != "" {
+		data, err := parse(additional)
+		if err != nil {
+			return nil, err
+		}
+		p.Purpose = data[subTagPurpose]
+	}
+	return p, nil
+}
+
+// Builds TLV fields from tag/value pairs, skipping empty values
+func tlv(pairs ...string) (string, error) {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := pairs[i+1]
+		if value == "" {
+			continue
+		}
+		if len(value) > 99 {
+			return "", ErrFieldTooLong
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(fmt.Sprintf("%02d", len(value)))
+		b.WriteString(value)
+	}
+	return b.String(), nil
+}
+
+func parse(data string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for i := 0; i < len(data); {
+		if i+4 > len(data) {
+			return nil, ErrMalformedPayload
+		}
+		tag := data[i : i+2]
+		// Lengths are exactly two ASCII digits; Atoi would also take signs like "-1"
+		tens, units := data[i+2], data[i+3]
+		if tens < '0' || tens > '9' || units < '0' || units > '9' {
+			return nil, ErrMalformedPayload
+		}
+		length := int(tens-'0')*10 + int(units-'0')
+		if i+4+length > len(data) {
+			return nil, ErrMalformedPayload
+		}
+		fields[tag] = data[i+4 : i+4+length]
+		i += 4 + length
+	}
+	return fields, nil
+}
+
+// CRC16/CCITT-FALSE: polynomial 0x1021, initial value 0xFFFF
+func crc16(data string) uint16 {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) > max {
+		return string(runes[:max])
+	}
+	return s
+}
+
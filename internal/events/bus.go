@@ -0,0 +1,145 @@
+/*
+Package events is an in-process domain-event bus. Services publish what happened
+(a transfer completed, a card was frozen) and subscribers such as the notification
+subscriber react to it, so services do not need to know who is listening. Events that must
+not be lost with a crash are recorded in the Outbox inside the business transaction instead.
+*/
+package events
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type Event struct {
+	ID         uuid.UUID
+	Type       string    // One of the event type constants
+	UserID     uuid.UUID // The user the event is about and who should hear of it
+	OccurredAt time.Time
+	// Pre-formatted values for subscribers and notification templates, e.g. "amount": "NGN 1500.00"
+	Data map[string]string
+}
+
+type Handler func(Event) error
+
+// ConsumerLog remembers which consumers have handled which events
+type ConsumerLog interface {
+	HasProcessed(consumer string, eventID uuid.UUID) (bool, error)
+	MarkProcessed(consumer string, eventID uuid.UUID) error
+}
+
+type Bus interface {
+	// Publish delivers the event to every matching subscriber before returning.
+	// Publish after the database transaction that made the change has committed.
+	Publish(event Event)
+	/*
+		Deliver is Publish for events that may be delivered more than once, such as those
+		relayed from the outbox. Subscribers that already handled the event are skipped, and
+		the error reports the subscribers that failed so the caller can try again later.
+	*/
+	Deliver(event Event) error
+	// Subscribe registers a handler for the given event types, or for every event when none are given.
+	// consumer names the subscriber in the consumer log and must not change between releases.
+	Subscribe(consumer string, handler Handler, types ...string)
+}
+
+type subscription struct {
+	consumer string
+	handler  Handler
+	types    map[string]bool
+}
+
+type bus struct {
+	mu            sync.RWMutex
+	subscriptions []subscription
+	consumers     ConsumerLog
+	logger        *zap.Logger
+}
+
+func NewBus(logger *zap.Logger, consumers ConsumerLog) Bus {
+	return &bus{logger: logger, consumers: consumers}
+}
+
+func (b *bus) Subscribe(consumer string, handler Handler, types ...string) {
+	sub := subscription{consumer: consumer, handler: handler}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	b.mu.Lock()
+	b.subscriptions = append(b.subscriptions, sub)
+	b.mu.Unlock()
+}
+
+// A failing or panicking subscriber is logged and skipped; it never fails the publisher
+func (b *bus) Publish(event Event) {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	for _, sub := range b.matching(event) {
+		if err := b.deliver(sub.handler, event); err != nil {
+			b.logger.Error("Event subscriber failed",
+				zap.String("consumer", sub.consumer),
+				zap.String("event_type", event.Type),
+				zap.String("event_id", event.ID.String()),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (b *bus) Deliver(event Event) error {
+	var errs []error
+	for _, sub := range b.matching(event) {
+		processed, err := b.consumers.HasProcessed(sub.consumer, event.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sub.consumer, err))
+			continue
+		}
+		if processed {
+			continue
+		}
+		if err := b.deliver(sub.handler, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sub.consumer, err))
+			continue
+		}
+		if err := b.consumers.MarkProcessed(sub.consumer, event.ID); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sub.consumer, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *bus) matching(event Event) []subscription {
+	b.mu.RLock()
+	subscriptions := b.subscriptions
+	b.mu.RUnlock()
+
+	var matched []subscription
+	for _, sub := range subscriptions {
+		if sub.types == nil || sub.types[event.Type] {
+			matched = append(matched, sub)
+		}
+	}
+	return matched
+}
+
+func (b *bus) deliver(handler Handler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panicked: %v", r)
+		}
+	}()
+	return handler(event)
+}
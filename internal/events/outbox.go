@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	outboxBatchSize   = 100
+	maxOutboxAttempts = 10
+	// Wait before retrying an event a subscriber failed on, doubled per attempt up to the limit
+	outboxBackoffBase  = time.Second
+	outboxBackoffLimit = 5 * time.Minute
+	// Published events and the consumer log are kept this long so late redeliveries are still recognised
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+/*
+Outbox makes publishing part of the database transaction that made the change: Record
+writes the event with the change, and the relay hands it to the bus only after commit.
+A crash between the two just means the relay publishes it when the process comes back.
+*/
+type Outbox interface {
+	// Record stores the event in tx. Nothing is published if tx rolls back.
+	Record(tx *gorm.DB, event Event) error
+	// Flush asks the relay to publish now rather than at its next tick. Call it after commit.
+	Flush()
+	// ProcessPending publishes pending events in order until one fails or none are left in the batch
+	ProcessPending() (int, error)
+	RunRelay(ctx context.Context, interval time.Duration)
+}
+
+type outbox struct {
+	repo   repositories.OutboxRepository
+	bus    Bus
+	db     *gorm.DB
+	wake   chan struct{}
+	logger *zap.Logger
+}
+
+func NewOutbox(repo repositories.OutboxRepository, bus Bus, db *gorm.DB, logger *zap.Logger) Outbox {
+	return &outbox{
+		repo:   repo,
+		bus:    bus,
+		db:     db,
+		wake:   make(chan struct{}, 1),
+		logger: logger,
+	}
+}
+
+func (o *outbox) Record(tx *gorm.DB, event Event) error {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	return o.repo.WithTx(tx).Create(&models.OutboxEvent{
+		EventID:       event.ID,
+		Type:          event.Type,
+		UserID:        event.UserID,
+		OccurredAt:    event.OccurredAt,
+		Data:          event.Data,
+		NextAttemptAt: event.OccurredAt,
+	})
+}
+
+func (o *outbox) Flush() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+/*
+	Only one relay publishes at a time, across every instance, so subscribers see events in
+	the order they were recorded. An event a subscriber fails on holds back the ones after it
+	until it succeeds or runs out of attempts; subscribers that already handled it are not
+	called again.
+*/
+func (o *outbox) ProcessPending() (int, error) {
+	published := 0
+	err := o.db.Transaction(func(tx *gorm.DB) error {
+		repo := o.repo.WithTx(tx)
+		locked, err := repo.TryLockRelay()
+		if err != nil || !locked {
+			return err
+		}
+		pending, err := repo.ListPending(outboxBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range pending {
+			row := &pending[i]
+			now := time.Now()
+			if row.NextAttemptAt.After(now) {
+				return nil
+			}
+			deliverErr := o.bus.Deliver(Event{
+				ID:         row.EventID,
+				Type:       row.Type,
+				UserID:     row.UserID,
+				OccurredAt: row.OccurredAt,
+				Data:       row.Data,
+			})
+			if deliverErr == nil {
+				if err := repo.MarkPublished(row.Sequence, now); err != nil {
+					return err
+				}
+				published++
+				continue
+			}
+
+			row.Attempts++
+			row.LastError = deliverErr.Error()
+			row.NextAttemptAt = now.Add(outboxBackoff(row.Attempts))
+			if row.Attempts >= maxOutboxAttempts {
+				row.FailedAt = &now
+			}
+			o.logger.Warn("Outbox event delivery failed",
+				zap.Int64("sequence", row.Sequence),
+				zap.String("event_type", row.Type),
+				zap.Int("attempts", row.Attempts),
+				zap.Error(deliverErr))
+			if err := repo.RecordFailure(row); err != nil {
+				return err
+			}
+			if row.FailedAt == nil {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		o.logger.Error("Failed to relay outbox events", zap.Error(err))
+		return published, err
+	}
+	return published, nil
+}
+
+func (o *outbox) RunRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(time.Hour)
+	defer pruneTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.ProcessPending()
+		case <-o.wake:
+			o.ProcessPending()
+		case <-pruneTicker.C:
+			o.prune()
+		}
+	}
+}
+
+func (o *outbox) prune() {
+	cutoff := time.Now().Add(-outboxRetention)
+	if _, err := o.repo.DeletePublishedBefore(cutoff); err != nil {
+		o.logger.Error("Failed to prune outbox", zap.Error(err))
+	}
+	if _, err := o.repo.DeleteProcessedBefore(cutoff); err != nil {
+		o.logger.Error("Failed to prune consumer log", zap.Error(err))
+	}
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	wait := outboxBackoffBase
+	for i := 1; i < attempts && wait < outboxBackoffLimit; i++ {
+		wait *= 2
+	}
+	return min(wait, outboxBackoffLimit)
+}
@@ -0,0 +1,41 @@
+package events
+
+// Event types. The part before the dot is the area of the app the event comes from.
+const (
+	TransferSent     = "transfer.sent"
+	TransferReceived = "transfer.received"
+	// Published to each party of a transfer alongside transfer.sent or transfer.received, and for
+	// moves between a user's own wallets; carries the same data plus the direction from their side
+	TransactionCompleted = "transaction.completed"
+
+	// A wallet's balance or held balance changed outside a transfer, e.g. a card hold or capture
+	WalletBalanceChanged = "wallet.balance_changed"
+
+	LoginNewDevice = "security.login_new_device"
+
+	CardIssued                = "card.issued"
+	CardFrozen                = "card.frozen"
+	CardUnfrozen              = "card.unfrozen"
+	CardTerminated            = "card.terminated"
+	CardReissued              = "card.reissued"
+	CardAuthorized            = "card.authorized"
+	CardAuthorizationDeclined = "card.authorization_declined"
+
+	PaymentRequestReceived  = "payment_request.received"
+	PaymentRequestPaid      = "payment_request.paid"
+	PaymentRequestDeclined  = "payment_request.declined"
+	PaymentRequestCancelled = "payment_request.cancelled"
+	PaymentRequestExpired   = "payment_request.expired"
+
+	BillSplitReminder = "bill_split.reminder"
+	BillSplitSettled  = "bill_split.settled"
+
+	// Published by the invoicing module
+	InvoiceReceived      = "invoice.received"
+	InvoicePaid          = "invoice.paid"
+	InvoiceStatusChanged = "invoice.status_changed"
+
+	// Published when a compliance officer decides on a user's KYC submission
+	KYCApproved = "kyc.approved"
+	KYCRejected = "kyc.rejected"
+)
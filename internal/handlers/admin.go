@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"pgpockets/internal/jobs"
+	"pgpockets/internal/repositories"
+	"pgpockets/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type AdminHandler struct {
+	loginGuard services.LoginGuard
+	jobRunner  *jobs.Runner
+	logger     *zap.Logger
+}
+
+func NewAdminHandler(loginGuard services.LoginGuard, jobRunner *jobs.Runner, logger *zap.Logger) *AdminHandler {
+	return &AdminHandler{
+		loginGuard: loginGuard,
+		jobRunner:  jobRunner,
+		logger:     logger,
+	}
+}
+
+func (h *AdminHandler) UnlockUser(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("userID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID format",
+		})
+	}
+
+	if err := h.loginGuard.Unlock(userID); err != nil {
+		if err == services.ErrUserNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unlock user",
+		})
+	}
+
+	h.logger.Info("Admin unlocked user",
+		zap.String("admin_id", c.Locals("userID").(uuid.UUID).String()),
+		zap.String("user_id", userID.String()))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User unlocked successfully",
+	})
+}
+
+// Lists background jobs, filtered by queue, type and status
+func (h *AdminHandler) ListJobs(c *fiber.Ctx) error {
+	limit, offset := getPaginationParams(c)
+	filter := repositories.JobFilter{
+		Queue:  c.Query("queue"),
+		Type:   c.Query("type"),
+		Status: c.Query("status"),
+	}
+	jobList, count, err := h.jobRunner.List(filter, limit, offset)
+	if err != nil {
+		h.logger.Error("Failed to list jobs", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list jobs",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"jobs":  jobList,
+		"count": count,
+	})
+}
+
+// Number of jobs in each queue by status
+func (h *AdminHandler) GetJobStats(c *fiber.Ctx) error {
+	stats, err := h.jobRunner.Stats()
+	if err != nil {
+		h.logger.Error("Failed to get job stats", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get job stats",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"queues": stats,
+	})
+}
+
+func (h *AdminHandler) GetJob(c *fiber.Ctx) error {
+	jobID, err := uuid.Parse(c.Params("jobID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid job ID format",
+		})
+	}
+	job, err := h.jobRunner.Get(jobID)
+	if err != nil {
+		return h.jobError(c, err, "Failed to get job")
+	}
+	return c.Status(fiber.StatusOK).JSON(job)
+}
+
+func (h *AdminHandler) RequeueJob(c *fiber.Ctx) error {
+	jobID, err := uuid.Parse(c.Params("jobID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid job ID format",
+		})
+	}
+	job, err := h.jobRunner.Requeue(jobID)
+	if err != nil {
+		return h.jobError(c, err, "Failed to requeue job")
+	}
+
+	h.logger.Info("Admin requeued job",
+		zap.String("admin_id", c.Locals("userID").(uuid.UUID).String()),
+		zap.String("job_id", jobID.String()))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Job requeued",
+		"job":     job,
+	})
+}
+
+func (h *AdminHandler) jobError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case jobs.ErrJobNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	case jobs.ErrJobNotRequeueable:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	h.logger.Error(fallback, zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
 	"log"
 	"pgpockets/internal/services"
+	"pgpockets/internal/utils"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
@@ -13,16 +17,25 @@ import (
 )
 
 type AuthHandler struct {
-	authService services.AuthService
-	logger      *zap.Logger
-	validator   *validator.Validate
+	authService         services.AuthService
+	verificationService services.VerificationService
+	loginGuard          services.LoginGuard
+	logger              *zap.Logger
+	validator           *validator.Validate
 }
 
-func NewAuthHandler(authService services.AuthService, logger *zap.Logger) *AuthHandler {
+func NewAuthHandler(
+	authService services.AuthService,
+	verificationService services.VerificationService,
+	loginGuard services.LoginGuard,
+	logger *zap.Logger,
+) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
-		logger:      logger,
-		validator:   validator.New(),
+		authService:         authService,
+		verificationService: verificationService,
+		loginGuard:          loginGuard,
+		logger:              logger,
+		validator:           validator.New(),
 	}
 }
 
@@ -79,6 +92,10 @@ func (h *AuthHandler) RegisterUser(c *fiber.Ctx) error {
 		})
 	}
 	h.logger.Info("User registered successfully", zap.String("email", user.Email))
+	// A failed email shouldn't fail the registration, the user can ask for another one
+	if err := h.verificationService.SendEmailVerification(user.ID); err != nil {
+		h.logger.Error("Failed to send verification email", zap.Error(err))
+	}
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"message": "User registered successfully",
 		"user": fiber.Map{
@@ -119,8 +136,16 @@ func (h *AuthHandler) LoginUser(c *fiber.Ctx) error {
 	if len(userAgent) > 255 {
 		userAgent = userAgent[:255]
 	}
-	accessToken, refreshToken, err := h.authService.Login(req.Email, req.Password, c.IP(), userAgent)
+	result, err := h.authService.Login(req.Email, req.Password, c.IP(), userAgent)
 	if err != nil {
+		var throttled *services.LoginThrottledError
+		if errors.As(err, &throttled) {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":               "Too many failed login attempts, please try again later",
+				"retry_after_seconds": int(math.Ceil(throttled.RetryAfter.Seconds())),
+			})
+		}
 		if err == services.ErrInvalidCredentials {
 			h.logger.Warn("Login failed: invalid credentials", zap.String("email", req.Email))
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -133,17 +158,137 @@ func (h *AuthHandler) LoginUser(c *fiber.Ctx) error {
 		})
 	}
 
+	if result.TwoFactorRequired {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message":           "Two-factor authentication required",
+			"twoFactorRequired": true,
+			"challengeToken":    result.ChallengeToken,
+		})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"messsage": "User logged in successfully",
-		"accessToken":     accessToken,
-		"refreshToken":    refreshToken,
+		"accessToken":     result.AccessToken,
+		"refreshToken":    result.RefreshToken,
+	})
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required,min=6,max=11"`
+}
+
+func (h *AuthHandler) CompleteTwoFactorLogin(c *fiber.Ctx) error {
+	var req TwoFactorLoginRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		h.logger.Warn("Failed to parse request body", zap.Error(err))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("Validation failed", zap.Error(err))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	userAgent := string(c.Context().UserAgent())
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	accessToken, refreshToken, err := h.authService.CompleteTwoFactorLogin(req.ChallengeToken, req.Code, c.IP(), userAgent)
+	if err != nil {
+		var throttled *services.LoginThrottledError
+		if errors.As(err, &throttled) {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":               "Too many failed login attempts, please try again later",
+				"retry_after_seconds": int(math.Ceil(throttled.RetryAfter.Seconds())),
+			})
+		}
+		if err == services.ErrInvalidChallenge {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid, expired or used up login challenge, please log in again",
+			})
+		}
+		if err == services.ErrInvalidTwoFactorCode || err == services.ErrTwoFactorNotEnabled {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid two-factor code",
+			})
+		}
+		h.logger.Error("Failed to complete 2FA login", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to login user",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":      "User logged in successfully",
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+	})
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
+	var req RefreshTokenRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		h.logger.Warn("Failed to parse request body", zap.Error(err))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("Validation failed", zap.Error(err))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	userAgent := string(c.Context().UserAgent())
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	accessToken, refreshToken, err := h.authService.Refresh(req.RefreshToken, c.IP(), userAgent)
+	if err != nil {
+		if err == services.ErrInvalidRefreshToken {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired refresh token",
+			})
+		}
+		if err == services.ErrRefreshTokenReused {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Refresh token has already been used, please log in again",
+			})
+		}
+		h.logger.Error("Failed to refresh session", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh session",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":      "Session refreshed successfully",
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
 	})
 }
 
 func (h *AuthHandler) LogoutUser(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(uuid.UUID)
+	sessionID := c.Locals("sessionID").(uuid.UUID)
 
-	err := h.authService.Logout(userID)
+	err := h.authService.Logout(userID, sessionID)
 	if err != nil {
 		h.logger.Error("Failed to logout user", zap.Error(err))
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -156,3 +301,256 @@ func (h *AuthHandler) LogoutUser(c *fiber.Ctx) error {
 	})
 }
 
+func (h *AuthHandler) ListSessions(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	currentSessionID := c.Locals("sessionID").(uuid.UUID)
+
+	sessions, err := h.authService.ListSessions(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list sessions",
+		})
+	}
+
+	result := make([]fiber.Map, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, fiber.Map{
+			"id":           session.ID,
+			"client_ip":    session.ClientIP,
+			"user_agent":   session.UserAgent,
+			"device":       utils.ParseUserAgent(session.UserAgent),
+			"last_seen_at": session.LastSeenAt,
+			"created_at":   session.CreatedAt,
+			"current":      session.ID == currentSessionID,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Sessions retrieved successfully",
+		"sessions": result,
+	})
+}
+
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	sessionID, err := uuid.Parse(c.Params("sessionID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid session ID format",
+		})
+	}
+
+	if err := h.authService.RevokeSession(userID, sessionID); err != nil {
+		if err == services.ErrSessionNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke session",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Session revoked successfully",
+	})
+}
+
+func (h *AuthHandler) RevokeOtherSessions(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	sessionID := c.Locals("sessionID").(uuid.UUID)
+
+	revoked, err := h.authService.RevokeOtherSessions(userID, sessionID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke sessions",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Other sessions revoked successfully",
+		"revoked": revoked,
+	})
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (h *AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	var req VerifyEmailRequest
+	if !h.parseBody(c, &req) {
+		return nil
+	}
+
+	if err := h.verificationService.ConfirmEmail(req.Token); err != nil {
+		if err == services.ErrInvalidVerificationToken {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired verification link",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify email",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Email verified successfully",
+	})
+}
+
+func (h *AuthHandler) ResendEmailVerification(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	if err := h.verificationService.SendEmailVerification(userID); err != nil {
+		if err == services.ErrEmailAlreadyVerified {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Email is already verified",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send verification email",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Verification email sent",
+	})
+}
+
+type UnlockAccountRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (h *AuthHandler) UnlockAccount(c *fiber.Ctx) error {
+	var req UnlockAccountRequest
+	if !h.parseBody(c, &req) {
+		return nil
+	}
+
+	userID, err := h.verificationService.ConsumeAccountUnlock(req.Token)
+	if err != nil {
+		if err == services.ErrInvalidVerificationToken {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired unlock link",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unlock account",
+		})
+	}
+
+	if err := h.loginGuard.Unlock(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unlock account",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Account unlocked, you can log in again",
+	})
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	var req ForgotPasswordRequest
+	if !h.parseBody(c, &req) {
+		return nil
+	}
+
+	if err := h.verificationService.RequestPasswordReset(req.Email); err != nil {
+		h.logger.Error("Failed to process password reset request", zap.Error(err))
+	}
+
+	// Same response whether or not the account exists
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "If an account exists for this email, a password reset link has been sent",
+	})
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8"`
+}
+
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	var req ResetPasswordRequest
+	if !h.parseBody(c, &req) {
+		return nil
+	}
+
+	if err := h.verificationService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		if err == services.ErrInvalidVerificationToken {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired password reset link",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reset password",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Password reset successfully, please log in again",
+	})
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8"`
+}
+
+func (h *AuthHandler) ChangePassword(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	sessionID := c.Locals("sessionID").(uuid.UUID)
+
+	var req ChangePasswordRequest
+	if !h.parseBody(c, &req) {
+		return nil
+	}
+
+	if err := h.verificationService.ChangePassword(userID, sessionID, req.CurrentPassword, req.NewPassword); err != nil {
+		switch err {
+		case services.ErrIncorrectPassword:
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Current password is incorrect",
+			})
+		case services.ErrPasswordUnchanged:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to change password",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Password changed successfully, other sessions have been signed out",
+	})
+}
+
+// Parses and validates a JSON body into req. On failure the 400 response has already been written.
+func (h *AuthHandler) parseBody(c *fiber.Ctx, req interface{}) bool {
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		h.logger.Warn("Failed to parse request body", zap.Error(err))
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return false
+	}
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("Validation failed", zap.Error(err))
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return false
+	}
+	return true
+}
@@ -1,9 +1,10 @@
 package handlers
 
 import (
-	"pgpockets/internal/models"
+	"encoding/json"
+	"pgpockets/internal/nameenquiry"
+	"pgpockets/internal/repositories"
 	"pgpockets/internal/services"
-	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
@@ -13,26 +14,38 @@ import (
 
 type BeneficiaryHandler struct {
 	beneficiaryService services.BeneficiaryService
-	walletService      services.WalletService
+	twoFactorService   services.TwoFactorService
 	logger             *zap.Logger
 	validator          *validator.Validate
 }
 
-func NewBeneficiaryHandler(beneficiaryService services.BeneficiaryService, logger *zap.Logger) *BeneficiaryHandler {
+func NewBeneficiaryHandler(
+	beneficiaryService services.BeneficiaryService,
+	twoFactorService services.TwoFactorService,
+	logger *zap.Logger,
+) *BeneficiaryHandler {
 	return &BeneficiaryHandler{
 		beneficiaryService: beneficiaryService,
+		twoFactorService:   twoFactorService,
 		logger:             logger,
 		validator:          validator.New(),
 	}
 }
 
+// Lists the user's beneficiaries. Supports ?kind=, ?favorites=true and ?q= (nickname or name).
 func (h *BeneficiaryHandler) GetBeneficiaries(c *fiber.Ctx) error {
-	userID := c.Params("userID")
-	beneficiaries, err := h.beneficiaryService.GetBeneficiaries(userID)
+	userID := c.Locals("userID").(uuid.UUID)
+	filter := repositories.BeneficiaryFilter{
+		Kind:          c.Query("kind"),
+		FavoritesOnly: c.QueryBool("favorites", false),
+		Search:        c.Query("q"),
+	}
+
+	beneficiaries, err := h.beneficiaryService.GetBeneficiaries(userID, filter)
 	if err != nil {
 		h.logger.Error("Failed to get beneficiaries", zap.Error(err))
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Invalid request body",
+			"error": "Failed to get beneficiaries",
 		})
 	}
 	h.logger.Info("Successfully retrieved beneficiaries", zap.Int("count", len(beneficiaries)))
@@ -43,88 +56,188 @@ func (h *BeneficiaryHandler) GetBeneficiaries(c *fiber.Ctx) error {
 }
 
 type CreateBeneficiaryRequest struct {
-	Title          string    `json:"title"`
-	Description    string    `json:"description"`
-	RecipientEmail string    `json:"recipient_id"`
-	IsRead         bool      `json:"is_read"`
-	CreatedAt      time.Time `json:"created_at"`
+	Kind          string `json:"kind" validate:"required,oneof=wallet bank_account phone"`
+	Email         string `json:"email" validate:"omitempty,email"`
+	WalletID      string `json:"wallet_id" validate:"omitempty,uuid"`
+	PhoneNumber   string `json:"phone_number" validate:"omitempty,max=20"`
+	AccountNumber string `json:"account_number" validate:"omitempty,len=10,numeric"`
+	BankCode      string `json:"bank_code" validate:"omitempty,max=10,numeric"`
+	Nickname      string `json:"nickname" validate:"max=64"`
+	Description   string `json:"description" validate:"max=255"`
+	IsFavorite    bool   `json:"is_favorite"`
 }
 
 func (h *BeneficiaryHandler) AddBeneficiary(c *fiber.Ctx) error {
 	var req CreateBeneficiaryRequest
 	userID := c.Locals("userID").(uuid.UUID)
-	if err := c.BodyParser(&req); err != nil {
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
 		h.logger.Error("Failed to parse request body", zap.Error(err))
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request body",
 		})
 	}
+	if err := h.validator.Struct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
 
-	// Get recipient wallet by email
-	wallet, err := h.walletService.GetWalletByEmail(req.RecipientEmail)
-	if err != nil {
-		h.logger.Error("Failed to get wallet by email", zap.Error(err))
+	// Adding a payee is sensitive, so it needs a fresh 2FA verification when 2FA is on
+	sessionID := c.Locals("sessionID").(uuid.UUID)
+	if err := h.twoFactorService.RequireStepUp(userID, sessionID); err != nil {
+		if err == services.ErrStepUpRequired {
+			return stepUpRequired(c)
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to get wallet",
+			"error": "Failed to verify two-factor status",
 		})
 	}
 
-	newBeneficiary := models.Beneficiary{
-		UserID:      userID,
-		Description: req.Description,
-		WalletID:    wallet.ID,
-		Wallet:      *wallet,
+	newBeneficiary := services.NewBeneficiary{
+		Kind:          req.Kind,
+		Email:         req.Email,
+		PhoneNumber:   req.PhoneNumber,
+		AccountNumber: req.AccountNumber,
+		BankCode:      req.BankCode,
+		Nickname:      req.Nickname,
+		Description:   req.Description,
+		IsFavorite:    req.IsFavorite,
+	}
+	if req.WalletID != "" {
+		walletID := uuid.MustParse(req.WalletID)
+		newBeneficiary.WalletID = &walletID
 	}
 
-	newBen, err := h.beneficiaryService.Create(&newBeneficiary)
+	newBen, err := h.beneficiaryService.Create(userID, newBeneficiary)
 	if err != nil {
-		h.logger.Error("Failed to create beneficiary", zap.Error(err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create beneficiary",
-		})
+		return h.beneficiaryError(c, err, "Failed to create beneficiary")
 	}
 	h.logger.Info("Beneficiary added successfully", zap.String("beneficiaryID", newBen.ID.String()))
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"message":        "Beneficiary created successfully",
-		"beneficiary_id": newBen.ID.String(),
-		"user_id":        newBen.UserID.String(),
-		"description":    newBen.Description,
-		"created_at":     newBen.CreatedAt.Format(time.RFC3339),
-		"wallet_id":      newBen.WalletID.String(),
-		"wallet": fiber.Map{
-			"id":       newBen.Wallet.ID.String(),
-			"balance":  newBen.Wallet.Balance,
-			"currency": newBen.Wallet.Currency,
-		},
+		"message":     "Beneficiary created successfully",
+		"beneficiary": newBen,
 	})
+}
 
+type UpdateBeneficiaryRequest struct {
+	Nickname    *string `json:"nickname" validate:"omitempty,max=64"`
+	Description *string `json:"description" validate:"omitempty,max=255"`
+	IsFavorite  *bool   `json:"is_favorite"`
 }
 
-func (h *BeneficiaryHandler) DeleteBeneficiary(c *fiber.Ctx) error {
-	userID := c.Locals("userID").(string)
-	if userID == "" {
-		h.logger.Error("The request requires you to be authenticated")
+// Renames, re-describes or (un)favorites a beneficiary
+func (h *BeneficiaryHandler) UpdateBeneficiary(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	beneficiaryID, err := uuid.Parse(c.Params("beneID"))
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "User is not authenticated",
+			"error": "Invalid beneficiary ID format",
 		})
 	}
-	beneficiaryID := c.Params("beneID")
-	if beneficiaryID == "" {
-		h.logger.Error("The request requires beneficiary ID to be passed")
+
+	var req UpdateBeneficiaryRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.validator.Struct(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Beneficiary ID missing from request",
+			"error":   "Validation failed",
+			"details": err.Error(),
 		})
 	}
-	err := h.beneficiaryService.DeleteBeneficiary(userID, beneficiaryID)
+
+	beneficiary, err := h.beneficiaryService.UpdateBeneficiary(userID, beneficiaryID, services.BeneficiaryUpdate{
+		Nickname:    req.Nickname,
+		Description: req.Description,
+		IsFavorite:  req.IsFavorite,
+	})
 	if err != nil {
-		h.logger.Error("Failed to delete beneficiary", zap.Error(err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete beneficiary",
+		return h.beneficiaryError(c, err, "Failed to update beneficiary")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Beneficiary updated successfully",
+		"beneficiary": beneficiary,
+	})
+}
+
+func (h *BeneficiaryHandler) DeleteBeneficiary(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	beneficiaryID, err := uuid.Parse(c.Params("beneID"))
+	if err != nil {
+		h.logger.Error("The request requires a valid beneficiary ID")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid beneficiary ID format",
 		})
 	}
+	if err := h.beneficiaryService.DeleteBeneficiary(userID, beneficiaryID); err != nil {
+		return h.beneficiaryError(c, err, "Failed to delete beneficiary")
+	}
 
 	h.logger.Info("Successfully deleted beneficiary")
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Beneficiary deleted successfully",
 	})
-}
\ No newline at end of file
+}
+
+type NameEnquiryRequest struct {
+	AccountNumber string `json:"account_number" validate:"required,len=10,numeric"`
+	BankCode      string `json:"bank_code" validate:"required,max=10,numeric"`
+}
+
+// Resolves an external account to its holder's name so the user can confirm it before saving
+func (h *BeneficiaryHandler) NameEnquiry(c *fiber.Ctx) error {
+	var req NameEnquiryRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	account, err := h.beneficiaryService.ResolveAccountName(req.AccountNumber, req.BankCode)
+	if err != nil {
+		return h.beneficiaryError(c, err, "Failed to resolve account name")
+	}
+	return c.Status(fiber.StatusOK).JSON(account)
+}
+
+func (h *BeneficiaryHandler) ListBanks(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"banks": h.beneficiaryService.ListBanks(),
+	})
+}
+
+func (h *BeneficiaryHandler) beneficiaryError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case services.ErrBeneficiaryNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Beneficiary not found",
+		})
+	case services.ErrRecipientNotFound, nameenquiry.ErrAccountNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Recipient not found",
+		})
+	case services.ErrDuplicateBeneficiary:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "You have already saved this beneficiary",
+		})
+	case services.ErrCannotAddSelf, services.ErrMissingBeneficiaryField, services.ErrInvalidBeneficiaryKind,
+		nameenquiry.ErrUnknownBank, nameenquiry.ErrInvalidAccount:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	h.logger.Error(fallback, zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
+
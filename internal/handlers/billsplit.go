@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"pgpockets/internal/services"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type BillSplitHandler struct {
+	service   services.BillSplitService
+	logger    *zap.Logger
+	validator *validator.Validate
+}
+
+func NewBillSplitHandler(service services.BillSplitService, logger *zap.Logger) *BillSplitHandler {
+	return &BillSplitHandler{
+		service:   service,
+		logger:    logger,
+		validator: validator.New(),
+	}
+}
+
+type SplitParticipantRequest struct {
+	Email       string          `json:"email" validate:"required_without=PhoneNumber,omitempty,email"`
+	PhoneNumber string          `json:"phone_number" validate:"required_without=Email,omitempty,min=7,max=20"`
+	Value       decimal.Decimal `json:"value"` // Percentage or exact amount, depending on the method
+}
+
+type CreateBillSplitRequest struct {
+	Title          string                    `json:"title" validate:"required,max=100"`
+	TotalAmount    decimal.Decimal           `json:"total_amount" validate:"required"`
+	Currency       string                    `json:"currency" validate:"required,len=3"`
+	Method         string                    `json:"method" validate:"required,oneof=equal percentage exact"`
+	IncludeCreator bool                      `json:"include_creator"`
+	Participants   []SplitParticipantRequest `json:"participants" validate:"required,min=1,max=20,dive"`
+	ExpiresAt      *time.Time                `json:"expires_at"`
+}
+
+func (h *BillSplitHandler) CreateSplit(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	var req CreateBillSplitRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.validator.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	newSplit := services.NewBillSplit{
+		Title:          req.Title,
+		TotalAmount:    req.TotalAmount,
+		Currency:       req.Currency,
+		Method:         req.Method,
+		IncludeCreator: req.IncludeCreator,
+		ExpiresAt:      req.ExpiresAt,
+	}
+	for _, p := range req.Participants {
+		newSplit.Participants = append(newSplit.Participants, services.SplitParticipant{
+			Email:       p.Email,
+			PhoneNumber: p.PhoneNumber,
+			Value:       p.Value,
+		})
+	}
+
+	split, err := h.service.Create(userID, newSplit)
+	if err != nil {
+		return h.splitError(c, err, "Failed to create bill split")
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Bill split created and payment requests sent",
+		"split":   split,
+	})
+}
+
+// Lists splits the user created or is part of, optionally filtered with ?status=
+func (h *BillSplitHandler) ListSplits(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	splits, err := h.service.List(userID, c.Query("status"))
+	if err != nil {
+		return h.splitError(c, err, "Failed to list bill splits")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"splits": splits,
+	})
+}
+
+func (h *BillSplitHandler) GetSplit(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	splitID, ok := parseSplitID(c)
+	if !ok {
+		return nil
+	}
+	split, err := h.service.Get(userID, splitID)
+	if err != nil {
+		return h.splitError(c, err, "Failed to get bill split")
+	}
+	return c.Status(fiber.StatusOK).JSON(split)
+}
+
+func (h *BillSplitHandler) RemindParticipants(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	splitID, ok := parseSplitID(c)
+	if !ok {
+		return nil
+	}
+	count, err := h.service.Remind(userID, splitID)
+	if err != nil {
+		return h.splitError(c, err, "Failed to send reminders")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Reminders sent",
+		"reminded": count,
+	})
+}
+
+func (h *BillSplitHandler) MarkParticipantPaid(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	splitID, ok := parseSplitID(c)
+	if !ok {
+		return nil
+	}
+	participantID, err := uuid.Parse(c.Params("participantID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid participant ID format",
+		})
+	}
+	split, err := h.service.MarkPaid(userID, splitID, participantID)
+	if err != nil {
+		return h.splitError(c, err, "Failed to mark participant as paid")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Participant marked as paid",
+		"split":   split,
+	})
+}
+
+func (h *BillSplitHandler) CancelSplit(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	splitID, ok := parseSplitID(c)
+	if !ok {
+		return nil
+	}
+	split, err := h.service.Cancel(userID, splitID)
+	if err != nil {
+		return h.splitError(c, err, "Failed to cancel bill split")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Bill split cancelled",
+		"split":   split,
+	})
+}
+
+func (h *BillSplitHandler) splitError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case services.ErrBillSplitNotFound, services.ErrParticipantNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrRecipientNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No user found with that email or phone number",
+		})
+	case services.ErrBillSplitNotOpen, services.ErrParticipantAlreadySettled:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrReminderTooSoon:
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrInvalidSplitMethod, services.ErrInvalidSplitShares, services.ErrNoParticipants,
+		services.ErrTooManyParticipants, services.ErrDuplicateParticipant, services.ErrCreatorAsParticipant,
+		services.ErrAmbiguousRecipient, services.ErrInvalidAmount:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrNoWalletInCurrency:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	h.logger.Error(fallback, zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
+
+func parseSplitID(c *fiber.Ctx) (uuid.UUID, bool) {
+	splitID, err := uuid.Parse(c.Params("splitID"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid split ID format",
+		})
+		return uuid.Nil, false
+	}
+	return splitID, true
+}
@@ -2,12 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"pgpockets/internal/cardissuer"
 	"pgpockets/internal/models"
 	"pgpockets/internal/services"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 )
 
@@ -26,20 +28,15 @@ func NewCardHandler(service services.CardService, logger *zap.Logger) *CardHandl
 }
 
 type CreateCardRequest struct {
-	UserID         string `json:"user_id" validate:"required,uuid"`
-	LastFourDigits string `json:"last_four_digits" validate:"required,len=4"`
-	CardToken      string `json:"card_token" validate:"required"`
-	CardType       string `json:"card_type" validate:"required,oneof=visa mastercard amex discover"`
-	ExpiryMonth    string `json:"expiry_month" validate:"required,len=2"`
-	ExpiryYear     string `json:"expiry_year" validate:"required,len=4"`
-	CardBrand      string `json:"card_brand" validate:"omitempty"`
-	BankName       string `json:"bank_name" validate:"omitempty"`
-	IsActive       bool   `json:"is_active" validate:"omitempty"`
+	WalletID   string `json:"wallet_id" validate:"required,uuid"`
+	CardType   string `json:"card_type" validate:"required,oneof=visa mastercard verve"`
+	NameOnCard string `json:"name_on_card" validate:"omitempty,max=26"`
 }
 
+// Issues a new virtual card funded by one of the user's wallets
 func (h *CardHandler) CreateCard(c *fiber.Ctx) error {
-	var card CreateCardRequest
-	if err := json.Unmarshal(c.Body(), &card); err != nil {
+	var req CreateCardRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
 		h.logger.Error("Failed to parse request body", zap.Error(err))
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request body",
@@ -47,46 +44,113 @@ func (h *CardHandler) CreateCard(c *fiber.Ctx) error {
 	}
 
 	userID := c.Locals("userID").(uuid.UUID)
-	card.UserID = userID.String()
 
-	if err := h.validator.Struct(&card); err != nil {
+	if err := h.validator.Struct(&req); err != nil {
 		h.logger.Error("Validation failed", zap.Error(err))
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Validation failed",
+			"error":   "Validation failed",
+			"details": err.Error(),
 		})
 	}
+	walletID := uuid.MustParse(req.WalletID)
 
+	newCard, err := h.service.IssueCard(userID, walletID, req.CardType, req.NameOnCard)
+	if err != nil {
+		switch err {
+		case services.ErrWalletNotOwned:
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Wallet not found",
+			})
+		case services.ErrWalletInactive:
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Wallet is not active",
+			})
+		case cardissuer.ErrUnsupportedCardType:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Card type is not supported by the issuer",
+			})
+		}
+		h.logger.Error("Failed to issue card", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to issue card",
+		})
+	}
+	h.logger.Info("Card issued successfully", zap.String("cardID", newCard.ID.String()))
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":          "Card issued successfully",
+		"card_id":          newCard.ID.String(),
+		"user_id":          newCard.UserID.String(),
+		"wallet_id":        newCard.WalletID.String(),
+		"last_four_digits": newCard.LastFourDigits,
+		"name_on_card":     newCard.NameOnCard,
+		"card_type":        newCard.CardType,
+		"expiry_month":     newCard.ExpiryMonth,
+		"expiry_year":      newCard.ExpiryYear,
+		"card_brand":       newCard.CardBrand,
+		"bank_name":        newCard.BankName,
+		"is_active":        newCard.IsActive,
+		"status":           newCard.Status,
+	})
+}
 
-	newCard := &models.Card{
-		UserID:         userID,
-		LastFourDigits: card.LastFourDigits,
-		CardToken:      card.CardToken,
-		CardType:       card.CardType,
-		ExpiryMonth:    card.ExpiryMonth,
-		ExpiryYear:     card.ExpiryYear,
-		CardBrand:      card.CardBrand,
-		BankName:       card.BankName,
-		IsActive:       card.IsActive,
+type RevealCardRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// One-time reveal of the full card number and CVV, guarded by password re-entry
+func (h *CardHandler) RevealCard(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	cardID, err := uuid.Parse(c.Params("cardID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Card ID format",
+		})
+	}
+
+	var req RevealCardRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
 	}
 
-	if err := h.service.CreateCard(newCard); err != nil {
-		h.logger.Error("Failed to create card", zap.Error(err))
+	details, err := h.service.RevealCard(userID, cardID, req.Password)
+	if err != nil {
+		switch err {
+		case services.ErrIncorrectPassword:
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Incorrect password",
+			})
+		case services.ErrCardNotFound:
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Card not found",
+			})
+		case services.ErrCardAlreadyRevealed:
+			return c.Status(fiber.StatusGone).JSON(fiber.Map{
+				"error": "Card details can only be revealed once",
+			})
+		case services.ErrCardNotVirtual:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Card details are only available for issued virtual cards",
+			})
+		}
+		h.logger.Error("Failed to reveal card", zap.Error(err))
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create card",
+			"error": "Failed to reveal card details",
 		})
 	}
-	h.logger.Info("Card added successfully", zap.String("cardID", newCard.ID.String()))
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"message":      "Card created successfully",
-		"card_id":      newCard.ID.String(),
-		"user_id":      newCard.UserID.String(),
-		"card_token":   newCard.CardToken,
-		"card_type":    newCard.CardType,
-		"expiry_month": newCard.ExpiryMonth,
-		"expiry_year":  newCard.ExpiryYear,
-		"card_brand":   newCard.CardBrand,
-		"bank_name":    newCard.BankName,
-		"is_active":    newCard.IsActive,
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Card details revealed. They will not be shown again",
+		"card":    details,
 	})
 }
 
@@ -112,63 +176,179 @@ func (h *CardHandler) RetrieveAllCards(c *fiber.Ctx) error {
 }
 
 func (h *CardHandler) GetCardByID(c *fiber.Ctx) error {
-	cardID := c.Params("cardID")
-	if cardID == "" {
-		h.logger.Error("Card ID is required")
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Card ID is required",
-		})
+	userID := c.Locals("userID").(uuid.UUID)
+	cardID, ok := parseCardID(c)
+	if !ok {
+		return nil
 	}
 
-	cardUUID, err := uuid.Parse(cardID)
+	card, err := h.service.GetCardByID(userID, cardID)
 	if err != nil {
-		h.logger.Error("Invalid Card ID format", zap.Error(err))
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid Card ID format",
-		})
+		return h.cardError(c, err, "Failed to retrieve card")
+	}
+	h.logger.Info("Card retrieved successfully", zap.String("cardID", cardID.String()))
+	return c.Status(fiber.StatusOK).JSON(card)
+}
+
+func (h *CardHandler) FreezeCard(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	cardID, ok := parseCardID(c)
+	if !ok {
+		return nil
 	}
-	card, err := h.service.GetCardByID(cardUUID.String())
+
+	card, err := h.service.FreezeCard(userID, cardID)
 	if err != nil {
-		h.logger.Error("Failed to retrieve card", zap.Error(err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to retrieve card",
-		})
+		return h.cardError(c, err, "Failed to freeze card")
 	}
-	if card == (models.Card{}) {
-		h.logger.Info("Card not found", zap.String("cardID", cardID))
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"message": "Card not found",
-		})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Card frozen",
+		"card":    card,
+	})
+}
+
+func (h *CardHandler) UnfreezeCard(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	cardID, ok := parseCardID(c)
+	if !ok {
+		return nil
 	}
-	h.logger.Info("Card retrieved successfully", zap.String("cardID", cardID))
-	return c.Status(fiber.StatusOK).JSON(card)
+
+	card, err := h.service.UnfreezeCard(userID, cardID)
+	if err != nil {
+		return h.cardError(c, err, "Failed to unfreeze card")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Card unfrozen",
+		"card":    card,
+	})
 }
 
+// Terminates the card. Cards are never hard-deleted so their payment history stays intact.
 func (h *CardHandler) DeleteCard(c *fiber.Ctx) error {
-	cardID := c.Params("cardID")
-	if cardID == "" {
-		h.logger.Error("Card ID is required")
+	userID := c.Locals("userID").(uuid.UUID)
+	cardID, ok := parseCardID(c)
+	if !ok {
+		return nil
+	}
+
+	if _, err := h.service.TerminateCard(userID, cardID); err != nil {
+		return h.cardError(c, err, "Failed to delete card")
+	}
+	h.logger.Info("Card terminated successfully", zap.String("cardID", cardID.String()))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Card terminated successfully",
+	})
+}
+
+func (h *CardHandler) GetControls(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	cardID, ok := parseCardID(c)
+	if !ok {
+		return nil
+	}
+
+	card, err := h.service.GetCardByID(userID, cardID)
+	if err != nil {
+		return h.cardError(c, err, "Failed to retrieve card controls")
+	}
+	return c.Status(fiber.StatusOK).JSON(cardControlsResponse(card))
+}
+
+type UpdateCardControlsRequest struct {
+	PerTransactionLimit *decimal.Decimal `json:"per_transaction_limit"`
+	DailyLimit          *decimal.Decimal `json:"daily_limit"`
+	MonthlyLimit        *decimal.Decimal `json:"monthly_limit"`
+	AllowedMCCs         []string         `json:"allowed_mccs" validate:"max=100,dive,len=4,numeric"`
+	BlockedMCCs         []string         `json:"blocked_mccs" validate:"max=100,dive,len=4,numeric"`
+	AllowedCountries    []string         `json:"allowed_countries" validate:"max=250,dive,len=2,alpha"`
+	BlockedCountries    []string         `json:"blocked_countries" validate:"max=250,dive,len=2,alpha"`
+	OnlineEnabled       *bool            `json:"online_enabled" validate:"required"`
+	ATMEnabled          *bool            `json:"atm_enabled" validate:"required"`
+}
+
+// Replaces the card's controls. Omitted limits and lists are cleared.
+func (h *CardHandler) UpdateControls(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	cardID, ok := parseCardID(c)
+	if !ok {
+		return nil
+	}
+
+	var req UpdateCardControlsRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.validator.Struct(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Card ID is required",
+			"error":   "Validation failed",
+			"details": err.Error(),
 		})
 	}
 
-	cardUUID, err := uuid.Parse(cardID)
+	card, err := h.service.UpdateControls(userID, cardID, services.CardControls{
+		PerTransactionLimit: req.PerTransactionLimit,
+		DailyLimit:          req.DailyLimit,
+		MonthlyLimit:        req.MonthlyLimit,
+		AllowedMCCs:         req.AllowedMCCs,
+		BlockedMCCs:         req.BlockedMCCs,
+		AllowedCountries:    req.AllowedCountries,
+		BlockedCountries:    req.BlockedCountries,
+		OnlineEnabled:       *req.OnlineEnabled,
+		ATMEnabled:          *req.ATMEnabled,
+	})
 	if err != nil {
-		h.logger.Error("Invalid Card ID format", zap.Error(err))
+		return h.cardError(c, err, "Failed to update card controls")
+	}
+	return c.Status(fiber.StatusOK).JSON(cardControlsResponse(card))
+}
+
+func (h *CardHandler) cardError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case services.ErrCardNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Card not found",
+		})
+	case services.ErrCardTerminated, services.ErrCardNotActive, services.ErrCardNotFrozen:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrInvalidCardLimits:
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid Card ID format",
+			"error": err.Error(),
 		})
 	}
+	h.logger.Error(fallback, zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
 
-	if err := h.service.DeleteCard(cardUUID.String()); err != nil {
-		h.logger.Error("Failed to delete card", zap.Error(err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete card",
+func parseCardID(c *fiber.Ctx) (uuid.UUID, bool) {
+	cardID, err := uuid.Parse(c.Params("cardID"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Card ID format",
 		})
+		return uuid.Nil, false
 	}
-	h.logger.Info("Card deleted successfully", zap.String("cardID", cardID))
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Card deleted successfully",
-	})
-}
\ No newline at end of file
+	return cardID, true
+}
+
+func cardControlsResponse(card *models.Card) fiber.Map {
+	return fiber.Map{
+		"card_id":               card.ID,
+		"status":                card.Status,
+		"per_transaction_limit": card.PerTransactionLimit,
+		"daily_limit":           card.DailyLimit,
+		"monthly_limit":         card.MonthlyLimit,
+		"allowed_mccs":          card.AllowedMCCs,
+		"blocked_mccs":          card.BlockedMCCs,
+		"allowed_countries":     card.AllowedCountries,
+		"blocked_countries":     card.BlockedCountries,
+		"online_enabled":        card.OnlineEnabled,
+		"atm_enabled":           card.ATMEnabled,
+	}
+}
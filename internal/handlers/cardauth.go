@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"pgpockets/internal/models"
+	"pgpockets/internal/services"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// Webhooks signed longer ago than this are rejected to limit replays
+const cardWebhookTolerance = 5 * time.Minute
+
+type CardAuthorizationHandler struct {
+	service       services.CardAuthorizationService
+	logger        *zap.Logger
+	validator     *validator.Validate
+	webhookSecret string
+}
+
+func NewCardAuthorizationHandler(service services.CardAuthorizationService, logger *zap.Logger, webhookSecret string) *CardAuthorizationHandler {
+	return &CardAuthorizationHandler{
+		service:       service,
+		logger:        logger,
+		validator:     validator.New(),
+		webhookSecret: webhookSecret,
+	}
+}
+
+type CardWebhookEvent struct {
+	Type string          `json:"type" validate:"required,oneof=authorization.request authorization.capture authorization.reversal"`
+	Data json.RawMessage `json:"data" validate:"required"`
+}
+
+type IssuerAuthorizationRequest struct {
+	IssuerReference      string          `json:"issuer_reference" validate:"required,max=255"`
+	CardToken            string          `json:"card_token" validate:"required"`
+	Amount               decimal.Decimal `json:"amount" validate:"required"`
+	Currency             string          `json:"currency" validate:"required,len=3"`
+	MerchantName         string          `json:"merchant_name" validate:"max=255"`
+	MerchantCategoryCode string          `json:"merchant_category_code" validate:"omitempty,len=4,numeric"`
+	MerchantCity         string          `json:"merchant_city" validate:"max=128"`
+	MerchantCountry      string          `json:"merchant_country" validate:"omitempty,len=2"`
+	Channel              string          `json:"channel" validate:"omitempty,oneof=pos online atm"`
+}
+
+type IssuerSettlementRequest struct {
+	IssuerReference string           `json:"issuer_reference" validate:"required"`
+	Amount          *decimal.Decimal `json:"amount"`
+}
+
+/*
+Receives authorization, capture and reversal events from the card issuer.
+The issuer signs "<timestamp>.<body>" with the shared webhook secret and sends
+the hex digest in X-Issuer-Signature alongside X-Issuer-Timestamp (unix seconds).
+*/
+func (h *CardAuthorizationHandler) HandleIssuerWebhook(c *fiber.Ctx) error {
+	if !h.verifyWebhookSignature(c) {
+		h.logger.Warn("Rejected card issuer webhook with invalid signature", zap.String("ip", c.IP()))
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid signature",
+		})
+	}
+
+	var event CardWebhookEvent
+	if err := json.Unmarshal(c.Body(), &event); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.validator.Struct(&event); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	switch event.Type {
+	case "authorization.request":
+		var req IssuerAuthorizationRequest
+		if !h.decodeEventData(c, event.Data, &req) {
+			return nil
+		}
+		auth, err := h.service.Authorize(services.AuthorizationRequest{
+			IssuerReference:      req.IssuerReference,
+			CardToken:            req.CardToken,
+			Amount:               req.Amount,
+			Currency:             req.Currency,
+			MerchantName:         req.MerchantName,
+			MerchantCategoryCode: req.MerchantCategoryCode,
+			MerchantCity:         req.MerchantCity,
+			MerchantCountry:      req.MerchantCountry,
+			Channel:              req.Channel,
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to process authorization",
+			})
+		}
+		return c.Status(fiber.StatusOK).JSON(authorizationDecision(auth))
+	case "authorization.capture":
+		var req IssuerSettlementRequest
+		if !h.decodeEventData(c, event.Data, &req) {
+			return nil
+		}
+		auth, err := h.service.CaptureByIssuerReference(req.IssuerReference, req.Amount)
+		if err != nil {
+			return h.settlementError(c, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(authorizationDecision(auth))
+	default:
+		var req IssuerSettlementRequest
+		if !h.decodeEventData(c, event.Data, &req) {
+			return nil
+		}
+		auth, err := h.service.ReverseByIssuerReference(req.IssuerReference)
+		if err != nil {
+			return h.settlementError(c, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(authorizationDecision(auth))
+	}
+}
+
+func (h *CardAuthorizationHandler) ListAuthorizations(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	cardID, err := uuid.Parse(c.Params("cardID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Card ID format",
+		})
+	}
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	auths, err := h.service.ListForCard(userID, cardID, limit, offset)
+	if err != nil {
+		if err == services.ErrCardNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Card not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve authorizations",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"authorizations": auths,
+		"limit":          limit,
+		"offset":         offset,
+	})
+}
+
+type SimulateAuthorizationRequest struct {
+	Amount               decimal.Decimal `json:"amount" validate:"required"`
+	Currency             string          `json:"currency" validate:"required,len=3"`
+	MerchantName         string          `json:"merchant_name" validate:"required,max=255"`
+	MerchantCategoryCode string          `json:"merchant_category_code" validate:"omitempty,len=4,numeric"`
+	MerchantCity         string          `json:"merchant_city" validate:"max=128"`
+	MerchantCountry      string          `json:"merchant_country" validate:"omitempty,len=2"`
+	Channel              string          `json:"channel" validate:"omitempty,oneof=pos online atm"`
+}
+
+// Simulates a merchant authorizing a payment on one of the user's cards
+func (h *CardAuthorizationHandler) SimulateAuthorization(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	cardID, err := uuid.Parse(c.Params("cardID"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Card ID format",
+		})
+	}
+
+	var req SimulateAuthorizationRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.validator.Struct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	auth, err := h.service.SimulateAuthorization(userID, cardID, services.AuthorizationRequest{
+		Amount:               req.Amount,
+		Currency:             req.Currency,
+		MerchantName:         req.MerchantName,
+		MerchantCategoryCode: req.MerchantCategoryCode,
+		MerchantCity:         req.MerchantCity,
+		MerchantCountry:      req.MerchantCountry,
+		Channel:              req.Channel,
+	})
+	if err != nil {
+		if err == services.ErrCardNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Card not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to simulate authorization",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(auth)
+}
+
+type SimulateCaptureRequest struct {
+	Amount *decimal.Decimal `json:"amount"`
+}
+
+// Simulates the issuer settling an authorization, optionally for a lower amount
+func (h *CardAuthorizationHandler) SimulateCapture(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	cardID, authID, ok := parseCardAndAuthorizationIDs(c)
+	if !ok {
+		return nil
+	}
+
+	var req SimulateCaptureRequest
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	auth, err := h.service.SimulateCapture(userID, cardID, authID, req.Amount)
+	if err != nil {
+		return h.settlementError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(auth)
+}
+
+// Simulates the merchant cancelling an authorization before settlement
+func (h *CardAuthorizationHandler) SimulateReversal(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	cardID, authID, ok := parseCardAndAuthorizationIDs(c)
+	if !ok {
+		return nil
+	}
+
+	auth, err := h.service.SimulateReversal(userID, cardID, authID)
+	if err != nil {
+		return h.settlementError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(auth)
+}
+
+func (h *CardAuthorizationHandler) verifyWebhookSignature(c *fiber.Ctx) bool {
+	if h.webhookSecret == "" {
+		return false
+	}
+	timestamp := c.Get("X-Issuer-Timestamp")
+	signature, err := hex.DecodeString(c.Get("X-Issuer-Signature"))
+	if err != nil || timestamp == "" {
+		return false
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(unix, 0))
+	if age > cardWebhookTolerance || age < -cardWebhookTolerance {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(h.webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(c.Body())
+	return hmac.Equal(mac.Sum(nil), signature)
+}
+
+// Decodes and validates event data, writing a 400 response when it is malformed
+func (h *CardAuthorizationHandler) decodeEventData(c *fiber.Ctx, data json.RawMessage, dst interface{}) bool {
+	if err := json.Unmarshal(data, dst); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid event data",
+		})
+		return false
+	}
+	if err := h.validator.Struct(dst); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+func (h *CardAuthorizationHandler) settlementError(c *fiber.Ctx, err error) error {
+	switch err {
+	case services.ErrCardNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Card not found",
+		})
+	case services.ErrAuthorizationNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Authorization not found",
+		})
+	case services.ErrAuthorizationNotPending:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Authorization is no longer pending",
+		})
+	case services.ErrCaptureExceedsAuthorization, services.ErrInvalidAmount:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to process authorization",
+	})
+}
+
+func parseCardAndAuthorizationIDs(c *fiber.Ctx) (uuid.UUID, uuid.UUID, bool) {
+	cardID, err := uuid.Parse(c.Params("cardID"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Card ID format",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+	authID, err := uuid.Parse(c.Params("authID"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid Authorization ID format",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return cardID, authID, true
+}
+
+// The response the issuer acts on
+func authorizationDecision(auth *models.CardAuthorization) fiber.Map {
+	return fiber.Map{
+		"approved":         auth.Status != models.AuthorizationStatusDeclined,
+		"status":           auth.Status,
+		"decline_reason":   auth.DeclineReason,
+		"authorization_id": auth.ID,
+		"issuer_reference": auth.IssuerReference,
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// How long the readiness probe waits on the database before reporting not ready
+const readinessPingTimeout = 2 * time.Second
+
+/*
+HealthHandler serves the liveness and readiness probes. Readiness turns off as soon as
+a shutdown starts, so load balancers stop sending new requests while in-flight ones drain.
+*/
+type HealthHandler struct {
+	db           *gorm.DB
+	logger       *zap.Logger
+	shuttingDown atomic.Bool
+}
+
+func NewHealthHandler(db *gorm.DB, logger *zap.Logger) *HealthHandler {
+	return &HealthHandler{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Reports not ready from now on
+func (h *HealthHandler) MarkShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+// The process is up and serving; says nothing about its dependencies
+func (h *HealthHandler) Live(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "ok",
+	})
+}
+
+// Ready to take traffic: not shutting down and the database answers
+func (h *HealthHandler) Ready(c *fiber.Ctx) error {
+	if h.shuttingDown.Load() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"status": "shutting_down",
+		})
+	}
+
+	sqlDB, err := h.db.DB()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), readinessPingTimeout)
+		defer cancel()
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		h.logger.Warn("Readiness check failed", zap.Error(err))
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"status": "unavailable",
+			"error":  "Database unreachable",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "ok",
+	})
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"encoding/json"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
 	"pgpockets/internal/services"
 	"strconv"
+	"time"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -11,19 +16,43 @@ import (
 
 type NotificationHandlers struct {
 	notifService services.NotificationService
+	dispatcher   services.NotificationDispatcher
 	logger       *zap.Logger
+	validator    *validator.Validate
 }
 
 func NewNotificationHandlers(
 	notifService services.NotificationService,
+	dispatcher services.NotificationDispatcher,
 	logger *zap.Logger,
 ) *NotificationHandlers {
 	return &NotificationHandlers{
 		notifService: notifService,
+		dispatcher:   dispatcher,
 		logger:       logger,
+		validator:    validator.New(),
 	}
 }
 
+type ChannelPreferenceRequest struct {
+	Category string `json:"category" validate:"required"`
+	Channel  string `json:"channel" validate:"required"`
+	Enabled  bool   `json:"enabled"`
+}
+
+type QuietHoursRequest struct {
+	Enabled   bool   `json:"enabled"`
+	StartTime string `json:"start_time" validate:"required"`
+	EndTime   string `json:"end_time" validate:"required"`
+	Timezone  string `json:"timezone" validate:"required"`
+}
+
+// Only the preferences listed are changed; quiet hours are left alone when omitted
+type UpdateNotificationPreferencesRequest struct {
+	Preferences []ChannelPreferenceRequest `json:"preferences" validate:"dive"`
+	QuietHours  *QuietHoursRequest         `json:"quiet_hours"`
+}
+
 func (h *NotificationHandlers) GetNotificationCount(c *fiber.Ctx) error {
 	userID, err := getUserIDFromContext(c)
 	if err != nil {
@@ -81,6 +110,7 @@ func (h *NotificationHandlers) GetUnreadNotificationCount(c *fiber.Ctx) error {
 	})
 }
 
+// Newest first. Filter with ?category=, ?priority= and a ?from=/?to= date range (RFC 3339 or YYYY-MM-DD).
 func (h *NotificationHandlers) GetNotifications(c *fiber.Ctx) error {
 	userID, err := getUserIDFromContext(c)
 	if err != nil {
@@ -90,9 +120,20 @@ func (h *NotificationHandlers) GetNotifications(c *fiber.Ctx) error {
 		})
 	}
 
+	filter, err := getNotificationFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 	limit, offset := getPaginationParams(c)
-	notifications, err := h.notifService.GetNotificationsByUserID(userID, limit, offset)
+	notifications, err := h.notifService.GetNotificationsByUserID(userID, filter, limit, offset)
 	if err != nil {
+		if isNotificationQueryError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		h.logger.Error("Failed to get notifications",
 			zap.Error(err),
 			zap.String("userID", userID.String()),
@@ -110,6 +151,7 @@ func (h *NotificationHandlers) GetNotifications(c *fiber.Ctx) error {
 	return c.JSON(notifications)
 }
 
+// Takes the same filters as GetNotifications
 func (h *NotificationHandlers) GetUnreadNotifications(c *fiber.Ctx) error {
 	userID, err := getUserIDFromContext(c)
 	if err != nil {
@@ -119,9 +161,20 @@ func (h *NotificationHandlers) GetUnreadNotifications(c *fiber.Ctx) error {
 		})
 	}
 
+	filter, err := getNotificationFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 	limit, offset := getPaginationParams(c)
-	notifications, err := h.notifService.GetUnreadNotificationsByUserID(userID, limit, offset)
+	notifications, err := h.notifService.GetUnreadNotificationsByUserID(userID, filter, limit, offset)
 	if err != nil {
+		if isNotificationQueryError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		h.logger.Error("Failed to get unread notifications",
 			zap.Error(err),
 			zap.String("userID", userID.String()),
@@ -251,6 +304,7 @@ func (h *NotificationHandlers) MarkAsUnread(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
+// Limit to one category with ?category=
 func (h *NotificationHandlers) MarkAllAsRead(c *fiber.Ctx) error {
 	userID, err := getUserIDFromContext(c)
 	if err != nil {
@@ -260,8 +314,13 @@ func (h *NotificationHandlers) MarkAllAsRead(c *fiber.Ctx) error {
 		})
 	}
 
-	count, err := h.notifService.MarkAllNotificationsAsRead(userID)
+	count, err := h.notifService.MarkAllNotificationsAsRead(userID, c.Query("category"))
 	if err != nil {
+		if isNotificationQueryError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		h.logger.Error("Failed to mark all notifications as read",
 			zap.Error(err),
 			zap.String("userID", userID.String()),
@@ -316,6 +375,7 @@ func (h *NotificationHandlers) DeleteNotification(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
+// Limit to one category with ?category=
 func (h *NotificationHandlers) DeleteAllNotifications(c *fiber.Ctx) error {
 	userID, err := getUserIDFromContext(c)
 	if err != nil {
@@ -325,8 +385,13 @@ func (h *NotificationHandlers) DeleteAllNotifications(c *fiber.Ctx) error {
 		})
 	}
 
-	count, err := h.notifService.DeleteAllNotifications(userID)
+	count, err := h.notifService.DeleteAllNotifications(userID, c.Query("category"))
 	if err != nil {
+		if isNotificationQueryError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		h.logger.Error("Failed to delete all notifications",
 			zap.Error(err),
 			zap.String("userID", userID.String()),
@@ -344,6 +409,7 @@ func (h *NotificationHandlers) DeleteAllNotifications(c *fiber.Ctx) error {
 	})
 }
 
+// Limit to one category with ?category=
 func (h *NotificationHandlers) DeleteAllReadNotifications(c *fiber.Ctx) error {
 	userID, err := getUserIDFromContext(c)
 	if err != nil {
@@ -353,8 +419,13 @@ func (h *NotificationHandlers) DeleteAllReadNotifications(c *fiber.Ctx) error {
 		})
 	}
 
-	count, err := h.notifService.DeleteAllReadNotifications(userID)
+	count, err := h.notifService.DeleteAllReadNotifications(userID, c.Query("category"))
 	if err != nil {
+		if isNotificationQueryError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		h.logger.Error("Failed to delete read notifications",
 			zap.Error(err),
 			zap.String("userID", userID.String()),
@@ -372,13 +443,153 @@ func (h *NotificationHandlers) DeleteAllReadNotifications(c *fiber.Ctx) error {
 	})
 }
 
+func (h *NotificationHandlers) GetPreferences(c *fiber.Ctx) error {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.logger.Error("Invalid user", zap.Error(err))
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid user",
+		})
+	}
+
+	preferences, err := h.dispatcher.GetPreferences(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get notification preferences",
+		})
+	}
+	return c.JSON(preferences)
+}
+
+func (h *NotificationHandlers) UpdatePreferences(c *fiber.Ctx) error {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.logger.Error("Invalid user", zap.Error(err))
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid user",
+		})
+	}
+
+	var req UpdateNotificationPreferencesRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.validator.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	update := services.NotificationPreferencesUpdate{}
+	for _, preference := range req.Preferences {
+		update.Preferences = append(update.Preferences, services.ChannelPreference{
+			Category: preference.Category,
+			Channel:  preference.Channel,
+			Enabled:  preference.Enabled,
+		})
+	}
+	if req.QuietHours != nil {
+		update.QuietHours = &models.QuietHours{
+			Enabled:   req.QuietHours.Enabled,
+			StartTime: req.QuietHours.StartTime,
+			EndTime:   req.QuietHours.EndTime,
+			Timezone:  req.QuietHours.Timezone,
+		}
+	}
+
+	preferences, err := h.dispatcher.UpdatePreferences(userID, update)
+	if err != nil {
+		switch err {
+		case services.ErrUnknownNotificationCategory, services.ErrUnknownNotificationChannel,
+			services.ErrSecurityInAppRequired, services.ErrInvalidQuietHours:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update notification preferences",
+		})
+	}
+	h.logger.Info("Updated notification preferences", zap.String("userID", userID.String()))
+	return c.JSON(preferences)
+}
+
+// Delivery log across every channel, newest first. Filter with ?status= and ?channel=
+func (h *NotificationHandlers) ListDeliveries(c *fiber.Ctx) error {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.logger.Error("Invalid user", zap.Error(err))
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid user",
+		})
+	}
+
+	limit, offset := getPaginationParams(c)
+	deliveries, count, err := h.dispatcher.ListDeliveries(userID, c.Query("status"), c.Query("channel"), limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get notification deliveries",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"deliveries": deliveries,
+		"count":      count,
+	})
+}
+
 // Helper functions
 func getUserIDFromContext(c *fiber.Ctx) (uuid.UUID, error) {
-	userIDStr, ok := c.Locals("userID").(string)
+	userID, ok := c.Locals("userID").(uuid.UUID)
 	if !ok {
 		return uuid.Nil, fiber.NewError(fiber.StatusUnauthorized, "invalid user")
 	}
-	return uuid.Parse(userIDStr)
+	return userID, nil
+}
+
+func getNotificationFilter(c *fiber.Ctx) (repositories.NotificationFilter, error) {
+	filter := repositories.NotificationFilter{
+		Category: c.Query("category"),
+		Priority: c.Query("priority"),
+	}
+	if value := c.Query("from"); value != "" {
+		from, _, err := parseNotificationTime(value)
+		if err != nil {
+			return filter, fiber.NewError(fiber.StatusBadRequest, "from must be an RFC 3339 time or YYYY-MM-DD date")
+		}
+		filter.From = &from
+	}
+	if value := c.Query("to"); value != "" {
+		to, dateOnly, err := parseNotificationTime(value)
+		if err != nil {
+			return filter, fiber.NewError(fiber.StatusBadRequest, "to must be an RFC 3339 time or YYYY-MM-DD date")
+		}
+		// A bare date includes the whole day
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = &to
+	}
+	return filter, nil
+}
+
+func parseNotificationTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	return t, true, err
+}
+
+func isNotificationQueryError(err error) bool {
+	switch err {
+	case services.ErrUnknownNotificationCategory, services.ErrUnknownNotificationPriority,
+		services.ErrInvalidNotificationRange:
+		return true
+	}
+	return false
 }
 
 func getPaginationParams(c *fiber.Ctx) (limit, offset int) {
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"encoding/json"
+	"pgpockets/internal/services"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type PaymentLinkHandler struct {
+	service          services.PaymentLinkService
+	twoFactorService services.TwoFactorService
+	stepUpThreshold  decimal.Decimal
+	logger           *zap.Logger
+	validator        *validator.Validate
+}
+
+func NewPaymentLinkHandler(
+	service services.PaymentLinkService,
+	twoFactorService services.TwoFactorService,
+	stepUpThreshold decimal.Decimal,
+	logger *zap.Logger,
+) *PaymentLinkHandler {
+	return &PaymentLinkHandler{
+		service:          service,
+		twoFactorService: twoFactorService,
+		stepUpThreshold:  stepUpThreshold,
+		logger:           logger,
+		validator:        validator.New(),
+	}
+}
+
+type CreatePaymentLinkRequest struct {
+	WalletID    string           `json:"wallet_id" validate:"required_without=Currency,omitempty,uuid"`
+	Currency    string           `json:"currency" validate:"required_without=WalletID,omitempty,len=3"`
+	Amount      *decimal.Decimal `json:"amount"` // Omit for an open amount
+	Description string           `json:"description" validate:"max=255"`
+	Reusable    bool             `json:"reusable"`
+	ExpiresAt   *time.Time       `json:"expires_at"`
+}
+
+func (h *PaymentLinkHandler) CreateLink(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	var req CreatePaymentLinkRequest
+	if !h.parseRequest(c, &req) {
+		return nil
+	}
+
+	newLink := services.NewPaymentLink{
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Description: req.Description,
+		Reusable:    req.Reusable,
+		ExpiresAt:   req.ExpiresAt,
+	}
+	if req.WalletID != "" {
+		walletID := uuid.MustParse(req.WalletID)
+		newLink.WalletID = &walletID
+	}
+
+	link, err := h.service.Create(userID, newLink)
+	if err != nil {
+		return h.linkError(c, err, "Failed to create payment link")
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Payment link created",
+		"link":    link,
+	})
+}
+
+func (h *PaymentLinkHandler) ListLinks(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	links, err := h.service.List(userID, c.Query("status"))
+	if err != nil {
+		return h.linkError(c, err, "Failed to list payment links")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"links": links,
+	})
+}
+
+func (h *PaymentLinkHandler) GetLink(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	linkID, ok := parseLinkID(c)
+	if !ok {
+		return nil
+	}
+	link, err := h.service.Get(userID, linkID)
+	if err != nil {
+		return h.linkError(c, err, "Failed to get payment link")
+	}
+	return c.Status(fiber.StatusOK).JSON(link)
+}
+
+func (h *PaymentLinkHandler) DisableLink(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	linkID, ok := parseLinkID(c)
+	if !ok {
+		return nil
+	}
+	link, err := h.service.Disable(userID, linkID)
+	if err != nil {
+		return h.linkError(c, err, "Failed to disable payment link")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Payment link disabled",
+		"link":    link,
+	})
+}
+
+// Public: shows who a link pays and how much, without needing an account
+func (h *PaymentLinkHandler) ResolveLink(c *fiber.Ctx) error {
+	link, err := h.service.Resolve(c.Params("code"))
+	if err != nil {
+		return h.linkError(c, err, "Failed to resolve payment link")
+	}
+	return c.Status(fiber.StatusOK).JSON(link)
+}
+
+type ResolveQRRequest struct {
+	Payload string `json:"payload" validate:"required,max=512"`
+}
+
+// Public: decodes a scanned QR payload into the link it carries
+func (h *PaymentLinkHandler) ResolveQR(c *fiber.Ctx) error {
+	var req ResolveQRRequest
+	if !h.parseRequest(c, &req) {
+		return nil
+	}
+	link, err := h.service.ResolveQR(req.Payload)
+	if err != nil {
+		return h.linkError(c, err, "Failed to resolve QR code")
+	}
+	return c.Status(fiber.StatusOK).JSON(link)
+}
+
+type PayLinkRequest struct {
+	SenderWalletID string           `json:"sender_wallet_id" validate:"omitempty,uuid"`
+	Amount         *decimal.Decimal `json:"amount"`
+}
+
+func (h *PaymentLinkHandler) PayLink(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	code := c.Params("code")
+
+	var req PayLinkRequest
+	if !h.parseRequest(c, &req) {
+		return nil
+	}
+
+	link, err := h.service.Resolve(code)
+	if err != nil {
+		return h.linkError(c, err, "Failed to resolve payment link")
+	}
+	amount := link.Amount
+	if amount == nil {
+		amount = req.Amount
+	}
+	// Large payments need a fresh 2FA verification, same as a transfer
+	if amount != nil && amount.GreaterThan(h.stepUpThreshold) {
+		sessionID := c.Locals("sessionID").(uuid.UUID)
+		if err := h.twoFactorService.RequireStepUp(userID, sessionID); err != nil {
+			if err == services.ErrStepUpRequired {
+				return stepUpRequired(c)
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to verify two-factor status",
+			})
+		}
+	}
+
+	payment := services.PayLinkRequest{Amount: req.Amount}
+	if req.SenderWalletID != "" {
+		walletID := uuid.MustParse(req.SenderWalletID)
+		payment.SenderWalletID = &walletID
+	}
+	txn, err := h.service.Pay(userID, code, payment)
+	if err != nil {
+		return h.linkError(c, err, "Failed to pay payment link")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Payment successful",
+		"transaction": txn,
+	})
+}
+
+func (h *PaymentLinkHandler) parseRequest(c *fiber.Ctx, req interface{}) bool {
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+		return false
+	}
+	if err := h.validator.Struct(req); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+func (h *PaymentLinkHandler) linkError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case services.ErrPaymentLinkNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Payment link not found",
+		})
+	case services.ErrWalletNotOwned, services.ErrSenderWalletNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Wallet not found",
+		})
+	case services.ErrPaymentLinkInactive:
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrAmountRequired, services.ErrAmountMismatch, services.ErrInvalidAmount,
+		services.ErrInvalidLinkExpiry, services.ErrInvalidQRPayload, services.ErrSelfTransfer:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrNoWalletInCurrency, services.ErrCurrencyMismatch, services.ErrInsufficientFunds:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	h.logger.Error(fallback, zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
+
+func parseLinkID(c *fiber.Ctx) (uuid.UUID, bool) {
+	linkID, err := uuid.Parse(c.Params("linkID"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid payment link ID format",
+		})
+		return uuid.Nil, false
+	}
+	return linkID, true
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"encoding/json"
+	"pgpockets/internal/repositories"
+	"pgpockets/internal/services"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type PaymentRequestHandler struct {
+	service          services.PaymentRequestService
+	twoFactorService services.TwoFactorService
+	stepUpThreshold  decimal.Decimal
+	logger           *zap.Logger
+	validator        *validator.Validate
+}
+
+func NewPaymentRequestHandler(
+	service services.PaymentRequestService,
+	twoFactorService services.TwoFactorService,
+	stepUpThreshold decimal.Decimal,
+	logger *zap.Logger,
+) *PaymentRequestHandler {
+	return &PaymentRequestHandler{
+		service:          service,
+		twoFactorService: twoFactorService,
+		stepUpThreshold:  stepUpThreshold,
+		logger:           logger,
+		validator:        validator.New(),
+	}
+}
+
+type CreatePaymentRequestRequest struct {
+	Email       string          `json:"email" validate:"required_without=PhoneNumber,omitempty,email"`
+	PhoneNumber string          `json:"phone_number" validate:"required_without=Email,omitempty,min=7,max=20"`
+	Amount      decimal.Decimal `json:"amount" validate:"required"`
+	Currency    string          `json:"currency" validate:"required,len=3"`
+	Note        string          `json:"note" validate:"max=255"`
+	ExpiresAt   *time.Time      `json:"expires_at"`
+}
+
+func (h *PaymentRequestHandler) CreateRequest(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	var req CreatePaymentRequestRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.validator.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	request, err := h.service.Create(userID, services.NewPaymentRequest{
+		Email:       req.Email,
+		PhoneNumber: req.PhoneNumber,
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Note:        req.Note,
+		ExpiresAt:   req.ExpiresAt,
+	})
+	if err != nil {
+		return h.paymentRequestError(c, err, "Failed to create payment request")
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Payment request sent",
+		"request": request,
+	})
+}
+
+// Lists requests the user sent and received. Filter with ?direction=incoming|outgoing and ?status=
+func (h *PaymentRequestHandler) ListRequests(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	direction := c.Query("direction")
+	if direction != "" && direction != repositories.PaymentRequestIncoming && direction != repositories.PaymentRequestOutgoing {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "direction must be incoming or outgoing",
+		})
+	}
+
+	limit, offset := getPaginationParams(c)
+	requests, err := h.service.List(userID, direction, c.Query("status"), limit, offset)
+	if err != nil {
+		return h.paymentRequestError(c, err, "Failed to list payment requests")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"requests": requests,
+	})
+}
+
+func (h *PaymentRequestHandler) GetRequest(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	requestID, ok := parsePaymentRequestID(c)
+	if !ok {
+		return nil
+	}
+	request, err := h.service.Get(userID, requestID)
+	if err != nil {
+		return h.paymentRequestError(c, err, "Failed to get payment request")
+	}
+	return c.Status(fiber.StatusOK).JSON(request)
+}
+
+type ApprovePaymentRequestRequest struct {
+	SenderWalletID string `json:"sender_wallet_id" validate:"omitempty,uuid"`
+}
+
+func (h *PaymentRequestHandler) ApproveRequest(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	requestID, ok := parsePaymentRequestID(c)
+	if !ok {
+		return nil
+	}
+
+	var req ApprovePaymentRequestRequest
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if err := h.validator.Struct(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Validation failed",
+				"details": err.Error(),
+			})
+		}
+	}
+
+	request, err := h.service.Get(userID, requestID)
+	if err != nil {
+		return h.paymentRequestError(c, err, "Failed to get payment request")
+	}
+	// Paying a large request needs a fresh 2FA verification, same as a transfer
+	if request.Amount.GreaterThan(h.stepUpThreshold) {
+		sessionID := c.Locals("sessionID").(uuid.UUID)
+		if err := h.twoFactorService.RequireStepUp(userID, sessionID); err != nil {
+			if err == services.ErrStepUpRequired {
+				return stepUpRequired(c)
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to verify two-factor status",
+			})
+		}
+	}
+
+	var senderWalletID *uuid.UUID
+	if req.SenderWalletID != "" {
+		walletID := uuid.MustParse(req.SenderWalletID)
+		senderWalletID = &walletID
+	}
+	request, err = h.service.Approve(userID, requestID, senderWalletID)
+	if err != nil {
+		return h.paymentRequestError(c, err, "Failed to pay payment request")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Payment request paid",
+		"request": request,
+	})
+}
+
+func (h *PaymentRequestHandler) DeclineRequest(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	requestID, ok := parsePaymentRequestID(c)
+	if !ok {
+		return nil
+	}
+	request, err := h.service.Decline(userID, requestID)
+	if err != nil {
+		return h.paymentRequestError(c, err, "Failed to decline payment request")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Payment request declined",
+		"request": request,
+	})
+}
+
+func (h *PaymentRequestHandler) CancelRequest(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	requestID, ok := parsePaymentRequestID(c)
+	if !ok {
+		return nil
+	}
+	request, err := h.service.Cancel(userID, requestID)
+	if err != nil {
+		return h.paymentRequestError(c, err, "Failed to cancel payment request")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Payment request cancelled",
+		"request": request,
+	})
+}
+
+func (h *PaymentRequestHandler) paymentRequestError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case services.ErrPaymentRequestNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Payment request not found",
+		})
+	case services.ErrRecipientNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No user found with that email or phone number",
+		})
+	case services.ErrWalletNotOwned, services.ErrSenderWalletNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Wallet not found",
+		})
+	case services.ErrPaymentRequestNotPending, services.ErrPaymentRequestExpired:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrAmbiguousRecipient, services.ErrInvalidAmount, services.ErrInvalidRequestExpiry,
+		services.ErrCannotRequestFromSelf, services.ErrSelfTransfer:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrNoWalletInCurrency, services.ErrCurrencyMismatch, services.ErrInsufficientFunds:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	h.logger.Error(fallback, zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
+
+func parsePaymentRequestID(c *fiber.Ctx) (uuid.UUID, bool) {
+	requestID, err := uuid.Parse(c.Params("requestID"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid payment request ID format",
+		})
+		return uuid.Nil, false
+	}
+	return requestID, true
+}
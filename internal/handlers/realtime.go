@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"pgpockets/internal/realtime"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// Keeps proxies from closing idle streams and lets us notice dead clients
+	realtimeHeartbeat = 25 * time.Second
+	// A WebSocket client that has not answered a ping within this long is dropped
+	realtimePongWait  = 60 * time.Second
+	realtimeWriteWait = 10 * time.Second
+	// How long EventSource clients wait before reconnecting
+	sseRetry = 5 * time.Second
+	// Clients further behind than this are told to reload instead of being replayed to
+	realtimeReplayLimit = 500
+)
+
+type RealtimeHandler struct {
+	hub    *realtime.Hub
+	logger *zap.Logger
+}
+
+func NewRealtimeHandler(hub *realtime.Hub, logger *zap.Logger) *RealtimeHandler {
+	return &RealtimeHandler{
+		hub:    hub,
+		logger: logger,
+	}
+}
+
+// Server-Sent Events stream of the user's live updates. Each event's id can be used to resume.
+func (h *RealtimeHandler) StreamEvents(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	sub, backlog, err := h.open(userID, lastEventID(c))
+	if err != nil {
+		h.logger.Error("Failed to open realtime stream", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to open event stream",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	// Stops nginx from buffering the stream
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer h.hub.Unsubscribe(sub)
+
+		fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+		if err := w.Flush(); err != nil {
+			return
+		}
+		h.pump(sub, backlog, nil,
+			func(msg realtime.Message) error {
+				return writeSSE(w, msg)
+			},
+			func() error {
+				fmt.Fprint(w, ": heartbeat\n\n")
+				return w.Flush()
+			},
+		)
+	})
+	return nil
+}
+
+// Rejects plain HTTP requests to the WebSocket endpoint and captures the resume point before upgrading
+func (h *RealtimeHandler) RequireWebSocketUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
+			"error": "WebSocket upgrade required",
+		})
+	}
+	c.Locals("lastEventID", lastEventID(c))
+	return c.Next()
+}
+
+// WebSocket stream of the user's live updates. Messages are JSON; clients resume with ?last_event_id=
+func (h *RealtimeHandler) StreamWebSocket() fiber.Handler {
+	return websocket.New(func(conn *websocket.Conn) {
+		userID := conn.Locals("userID").(uuid.UUID)
+		lastID, _ := conn.Locals("lastEventID").(int64)
+
+		sub, backlog, err := h.open(userID, lastID)
+		if err != nil {
+			h.logger.Error("Failed to open realtime stream", zap.Error(err))
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "failed to open stream"),
+				time.Now().Add(realtimeWriteWait))
+			return
+		}
+		defer h.hub.Unsubscribe(sub)
+
+		// Clients do not send us anything, but reading is what processes pongs and notices a closed socket
+		done := make(chan struct{})
+		conn.SetReadDeadline(time.Now().Add(realtimePongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(realtimePongWait))
+		})
+		go func() {
+			defer close(done)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		h.pump(sub, backlog, done,
+			func(msg realtime.Message) error {
+				conn.SetWriteDeadline(time.Now().Add(realtimeWriteWait))
+				return conn.WriteJSON(msg)
+			},
+			func() error {
+				return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(realtimeWriteWait))
+			},
+		)
+		// Tells the client to reconnect, e.g. when the hub closed the stream for a shutdown
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "stream closed"),
+			time.Now().Add(realtimeWriteWait))
+	})
+}
+
+/*
+Subscribes before reading the backlog so nothing published in between is lost; pump
+skips the live copies of anything that was also replayed.
+*/
+func (h *RealtimeHandler) open(userID uuid.UUID, lastID int64) (*realtime.Subscriber, []realtime.Message, error) {
+	sub := h.hub.Subscribe(userID)
+	if lastID <= 0 {
+		return sub, nil, nil
+	}
+	backlog, err := h.hub.Since(userID, lastID, realtimeReplayLimit+1)
+	if err != nil {
+		h.hub.Unsubscribe(sub)
+		return nil, nil, err
+	}
+	if len(backlog) > realtimeReplayLimit {
+		backlog = []realtime.Message{{UserID: userID, Type: realtime.TypeResync, CreatedAt: time.Now()}}
+	}
+	return sub, backlog, nil
+}
+
+// Writes the backlog and then live messages, with heartbeats in between, until a write fails,
+// the client goes away (done closes) or the hub drops the subscription
+func (h *RealtimeHandler) pump(
+	sub *realtime.Subscriber,
+	backlog []realtime.Message,
+	done <-chan struct{},
+	send func(realtime.Message) error,
+	heartbeat func() error,
+) {
+	replayed := make(map[int64]bool, len(backlog))
+	for _, msg := range backlog {
+		if err := send(msg); err != nil {
+			return
+		}
+		replayed[msg.ID] = true
+	}
+
+	ticker := time.NewTicker(realtimeHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if replayed[msg.ID] {
+				continue
+			}
+			if err := send(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := heartbeat(); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func writeSSE(w *bufio.Writer, msg realtime.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if msg.ID > 0 {
+		fmt.Fprintf(w, "id: %d\n", msg.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Type, data)
+	return w.Flush()
+}
+
+// EventSource sends Last-Event-ID itself when reconnecting; other clients can use ?last_event_id=
+func lastEventID(c *fiber.Ctx) int64 {
+	value := c.Get("Last-Event-ID")
+	if value == "" {
+		value = c.Query("last_event_id")
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
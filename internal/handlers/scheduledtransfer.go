@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"encoding/json"
+	"pgpockets/internal/models"
+	"pgpockets/internal/services"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type ScheduledTransferHandler struct {
+	service          services.ScheduledTransferService
+	twoFactorService services.TwoFactorService
+	stepUpThreshold  decimal.Decimal
+	logger           *zap.Logger
+	validator        *validator.Validate
+}
+
+func NewScheduledTransferHandler(
+	service services.ScheduledTransferService,
+	twoFactorService services.TwoFactorService,
+	stepUpThreshold decimal.Decimal,
+	logger *zap.Logger,
+) *ScheduledTransferHandler {
+	return &ScheduledTransferHandler{
+		service:          service,
+		twoFactorService: twoFactorService,
+		stepUpThreshold:  stepUpThreshold,
+		logger:           logger,
+		validator:        validator.New(),
+	}
+}
+
+type CreateScheduledTransferRequest struct {
+	SenderWalletID   string          `json:"sender_wallet_id" validate:"required,uuid"`
+	ReceiverWalletID string          `json:"receiver_wallet_id" validate:"required_without=BeneficiaryID,omitempty,uuid"`
+	BeneficiaryID    string          `json:"beneficiary_id" validate:"required_without=ReceiverWalletID,omitempty,uuid"`
+	Amount           decimal.Decimal `json:"amount" validate:"required"`
+	Description      string          `json:"description" validate:"max=255"`
+	Frequency        string          `json:"frequency" validate:"required,oneof=once daily weekly monthly"`
+	StartAt          time.Time       `json:"start_at" validate:"required"`
+	EndDate          *time.Time      `json:"end_date"`
+}
+
+func (h *ScheduledTransferHandler) CreateSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	var req CreateScheduledTransferRequest
+	if !h.parseRequest(c, &req) {
+		return nil
+	}
+	if req.Amount.GreaterThan(h.stepUpThreshold) && !h.requireStepUp(c, userID) {
+		return nil
+	}
+
+	newSchedule := services.NewScheduledTransfer{
+		SenderWalletID: uuid.MustParse(req.SenderWalletID),
+		Amount:         req.Amount,
+		Description:    req.Description,
+		Frequency:      req.Frequency,
+		StartAt:        req.StartAt,
+		EndDate:        req.EndDate,
+	}
+	if req.ReceiverWalletID != "" {
+		receiverID := uuid.MustParse(req.ReceiverWalletID)
+		newSchedule.ReceiverWalletID = &receiverID
+	}
+	if req.BeneficiaryID != "" {
+		beneficiaryID := uuid.MustParse(req.BeneficiaryID)
+		newSchedule.BeneficiaryID = &beneficiaryID
+	}
+
+	schedule, err := h.service.Create(userID, newSchedule)
+	if err != nil {
+		return h.scheduleError(c, err, "Failed to create scheduled transfer")
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":  "Transfer scheduled successfully",
+		"schedule": schedule,
+	})
+}
+
+// Lists the user's schedules, optionally filtered with ?status=
+func (h *ScheduledTransferHandler) ListSchedules(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	schedules, err := h.service.List(userID, c.Query("status"))
+	if err != nil {
+		return h.scheduleError(c, err, "Failed to list scheduled transfers")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"schedules": schedules,
+	})
+}
+
+func (h *ScheduledTransferHandler) GetSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	scheduleID, ok := parseScheduleID(c)
+	if !ok {
+		return nil
+	}
+	schedule, err := h.service.Get(userID, scheduleID)
+	if err != nil {
+		return h.scheduleError(c, err, "Failed to get scheduled transfer")
+	}
+	return c.Status(fiber.StatusOK).JSON(schedule)
+}
+
+type UpdateScheduledTransferRequest struct {
+	Amount       *decimal.Decimal `json:"amount"`
+	Description  *string          `json:"description" validate:"omitempty,max=255"`
+	Frequency    *string          `json:"frequency" validate:"omitempty,oneof=once daily weekly monthly"`
+	NextRunAt    *time.Time       `json:"next_run_at"`
+	EndDate      *time.Time       `json:"end_date"`
+	ClearEndDate bool             `json:"clear_end_date"`
+}
+
+func (h *ScheduledTransferHandler) UpdateSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	scheduleID, ok := parseScheduleID(c)
+	if !ok {
+		return nil
+	}
+
+	var req UpdateScheduledTransferRequest
+	if !h.parseRequest(c, &req) {
+		return nil
+	}
+	if req.Amount != nil && req.Amount.GreaterThan(h.stepUpThreshold) && !h.requireStepUp(c, userID) {
+		return nil
+	}
+
+	schedule, err := h.service.Update(userID, scheduleID, services.ScheduledTransferUpdate{
+		Amount:       req.Amount,
+		Description:  req.Description,
+		Frequency:    req.Frequency,
+		NextRunAt:    req.NextRunAt,
+		EndDate:      req.EndDate,
+		ClearEndDate: req.ClearEndDate,
+	})
+	if err != nil {
+		return h.scheduleError(c, err, "Failed to update scheduled transfer")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Scheduled transfer updated",
+		"schedule": schedule,
+	})
+}
+
+func (h *ScheduledTransferHandler) PauseSchedule(c *fiber.Ctx) error {
+	return h.changeState(c, h.service.Pause, "Scheduled transfer paused")
+}
+
+func (h *ScheduledTransferHandler) ResumeSchedule(c *fiber.Ctx) error {
+	return h.changeState(c, h.service.Resume, "Scheduled transfer resumed")
+}
+
+func (h *ScheduledTransferHandler) CancelSchedule(c *fiber.Ctx) error {
+	return h.changeState(c, h.service.Cancel, "Scheduled transfer cancelled")
+}
+
+func (h *ScheduledTransferHandler) changeState(
+	c *fiber.Ctx,
+	change func(userID, scheduleID uuid.UUID) (*models.ScheduledTransfer, error),
+	message string,
+) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	scheduleID, ok := parseScheduleID(c)
+	if !ok {
+		return nil
+	}
+	schedule, err := change(userID, scheduleID)
+	if err != nil {
+		return h.scheduleError(c, err, "Failed to update scheduled transfer")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  message,
+		"schedule": schedule,
+	})
+}
+
+// Writes the step-up response and returns false when a fresh 2FA verification is needed
+func (h *ScheduledTransferHandler) requireStepUp(c *fiber.Ctx, userID uuid.UUID) bool {
+	sessionID := c.Locals("sessionID").(uuid.UUID)
+	if err := h.twoFactorService.RequireStepUp(userID, sessionID); err != nil {
+		if err == services.ErrStepUpRequired {
+			stepUpRequired(c)
+			return false
+		}
+		c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify two-factor status",
+		})
+		return false
+	}
+	return true
+}
+
+func (h *ScheduledTransferHandler) parseRequest(c *fiber.Ctx, req interface{}) bool {
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+		return false
+	}
+	if err := h.validator.Struct(req); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+func (h *ScheduledTransferHandler) scheduleError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case services.ErrScheduleNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Scheduled transfer not found",
+		})
+	case services.ErrWalletNotOwned:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Wallet not found",
+		})
+	case services.ErrBeneficiaryNotFound, services.ErrRecipientNotFound, services.ErrReceiverWalletNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Recipient not found",
+		})
+	case services.ErrScheduleNotActive, services.ErrScheduleNotPaused, services.ErrScheduleFinished:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrInvalidSchedule, services.ErrInvalidFrequency, services.ErrScheduleRecipient,
+		services.ErrInvalidAmount, services.ErrSelfTransfer:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrCurrencyMismatch, services.ErrRecipientCurrencyUnavailable, services.ErrExternalTransferUnsupported:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	h.logger.Error(fallback, zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
+
+func parseScheduleID(c *fiber.Ctx) (uuid.UUID, bool) {
+	scheduleID, err := uuid.Parse(c.Params("scheduleID"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid schedule ID format",
+		})
+		return uuid.Nil, false
+	}
+	return scheduleID, true
+}
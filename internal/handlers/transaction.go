@@ -14,24 +14,32 @@ import (
 
 type TransactionHandler struct {
 	transactionService services.TransactionService
+	twoFactorService   services.TwoFactorService
+	stepUpThreshold    decimal.Decimal
 	logger             *zap.Logger
 	validator          *validator.Validate
 }
 
 func NewTransactionHandler(
 	transactionService services.TransactionService,
+	twoFactorService services.TwoFactorService,
+	stepUpThreshold decimal.Decimal,
 	logger *zap.Logger,
 ) *TransactionHandler {
 	return &TransactionHandler{
 		transactionService: transactionService,
+		twoFactorService:   twoFactorService,
+		stepUpThreshold:    stepUpThreshold,
 		logger:             logger,
 		validator:          validator.New(),
 	}
 }
 
 type MakeTransferRequest struct {
-	SenderWalletID   string `json:"sender_wallet_id" validate:"required"`
-	ReceiverWalletID string `json:"rwi" validate:"required"`
+	SenderWalletID   string `json:"sender_wallet_id" validate:"required,uuid"`
+	ReceiverWalletID string `json:"receiver_wallet_id" validate:"required_without=LegacyReceiverID,omitempty,uuid"`
+	// Older clients send the receiver under "rwi"
+	LegacyReceiverID string `json:"rwi" validate:"omitempty,uuid"`
 	Amount           string `json:"amount" validate:"required,numeric"`
 	Currency         string `json:"currency" validate:"required,len=3"`
 	TransactionType  string `json:"transaction_type" validate:"required"`
@@ -41,7 +49,7 @@ type MakeTransferRequest struct {
 func (h *TransactionHandler) TransferFunds(c *fiber.Ctx) error {
 	// Get relevant data from the request body
 	var req MakeTransferRequest
-	userID := c.Locals("userID")
+	userUUID := c.Locals("userID").(uuid.UUID)
 	if err := c.BodyParser(&req); err != nil {
 		h.logger.Error("Failed to parse request body for fund transfer", zap.Error(err))
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -58,19 +66,13 @@ func (h *TransactionHandler) TransferFunds(c *fiber.Ctx) error {
 		})
 	}
 
-	// Type casting
-	senderUUID, err := uuid.Parse(req.SenderWalletID)
-	if err != nil {
-		h.logger.Error("Something went wrong while trying to convert sender id to uuid")
-	}
-	recieverUUID, err := uuid.Parse(req.ReceiverWalletID)
-	if err != nil {
-		h.logger.Error("Something went wrong while trying to convert reciever id to uuid", zap.Error(err))
-	}
-	userUUID, err := uuid.Parse(userID.(string))
-	if err != nil {
-		h.logger.Error("Something went wrong while trying to convert user id to uuid", zap.Error(err))
+	// Type casting (both IDs were validated as UUIDs above)
+	senderUUID := uuid.MustParse(req.SenderWalletID)
+	receiverID := req.ReceiverWalletID
+	if receiverID == "" {
+		receiverID = req.LegacyReceiverID
 	}
+	recieverUUID := uuid.MustParse(receiverID)
 	amount, err := decimal.NewFromString(req.Amount)
 	if err != nil {
 		h.logger.Error("Something went wrong while trying to convert amount to decimal", zap.Error(err))
@@ -78,6 +80,24 @@ func (h *TransactionHandler) TransferFunds(c *fiber.Ctx) error {
 			"error": "Invalid amount format",
 		})
 	}
+	if !amount.IsPositive() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": services.ErrInvalidAmount.Error(),
+		})
+	}
+
+	// Large transfers need a fresh 2FA verification on this session
+	if amount.GreaterThan(h.stepUpThreshold) {
+		sessionID := c.Locals("sessionID").(uuid.UUID)
+		if err := h.twoFactorService.RequireStepUp(userUUID, sessionID); err != nil {
+			if err == services.ErrStepUpRequired {
+				return stepUpRequired(c)
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to verify two-factor status",
+			})
+		}
+	}
 
 	// Actually make the transfer via service call
 	txnDetails, err := h.transactionService.TransferFunds(
@@ -88,11 +108,7 @@ func (h *TransactionHandler) TransferFunds(c *fiber.Ctx) error {
 		req.Currency,
 		req.Description)
 	if err != nil {
-		h.logger.Error("Failed to transfer funds", zap.Error(err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to transfer funds",
-			"details": err.Error(),
-		})
+		return transferError(c, h.logger, err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"pgpockets/internal/services"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type TransferHandler struct {
+	transferService  services.TransferService
+	twoFactorService services.TwoFactorService
+	stepUpThreshold  decimal.Decimal
+	logger           *zap.Logger
+	validator        *validator.Validate
+}
+
+func NewTransferHandler(
+	transferService services.TransferService,
+	twoFactorService services.TwoFactorService,
+	stepUpThreshold decimal.Decimal,
+	logger *zap.Logger,
+) *TransferHandler {
+	return &TransferHandler{
+		transferService:  transferService,
+		twoFactorService: twoFactorService,
+		stepUpThreshold:  stepUpThreshold,
+		logger:           logger,
+		validator:        validator.New(),
+	}
+}
+
+// Exactly one of BeneficiaryID, Email or PhoneNumber identifies the recipient
+type TransferRecipientRequest struct {
+	SenderWalletID string          `json:"sender_wallet_id" validate:"required,uuid"`
+	BeneficiaryID  string          `json:"beneficiary_id" validate:"omitempty,uuid"`
+	Email          string          `json:"email" validate:"omitempty,email"`
+	PhoneNumber    string          `json:"phone_number" validate:"omitempty,max=20"`
+	Amount         decimal.Decimal `json:"amount" validate:"required"`
+}
+
+type SendTransferRequest struct {
+	TransferRecipientRequest
+	Description     string `json:"description" validate:"max=255"`
+	SaveBeneficiary bool   `json:"save_beneficiary"`
+	Nickname        string `json:"nickname" validate:"max=64"`
+}
+
+// Resolves the recipient and shows their masked name so the sender can confirm before paying
+func (h *TransferHandler) PreviewTransfer(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	var req TransferRecipientRequest
+	if !h.parseRequest(c, &req) {
+		return nil
+	}
+
+	preview, err := h.transferService.Preview(userID, uuid.MustParse(req.SenderWalletID), transferTarget(req), req.Amount)
+	if err != nil {
+		return transferError(c, h.logger, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Confirm the recipient before sending",
+		"preview": preview,
+	})
+}
+
+func (h *TransferHandler) SendTransfer(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	var req SendTransferRequest
+	if !h.parseRequest(c, &req) {
+		return nil
+	}
+
+	// Large transfers need a fresh 2FA verification on this session
+	if req.Amount.GreaterThan(h.stepUpThreshold) {
+		sessionID := c.Locals("sessionID").(uuid.UUID)
+		if err := h.twoFactorService.RequireStepUp(userID, sessionID); err != nil {
+			if err == services.ErrStepUpRequired {
+				return stepUpRequired(c)
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to verify two-factor status",
+			})
+		}
+	}
+
+	result, err := h.transferService.Transfer(userID, services.TransferRequest{
+		SenderWalletID:  uuid.MustParse(req.SenderWalletID),
+		Target:          transferTarget(req.TransferRecipientRequest),
+		Amount:          req.Amount,
+		Description:     req.Description,
+		SaveBeneficiary: req.SaveBeneficiary,
+		Nickname:        req.Nickname,
+	})
+	if err != nil {
+		return transferError(c, h.logger, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Funds transferred successfully",
+		"transaction": result.Transaction,
+		"beneficiary": result.Beneficiary,
+	})
+}
+
+func (h *TransferHandler) parseRequest(c *fiber.Ctx, req interface{}) bool {
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+		return false
+	}
+	if err := h.validator.Struct(req); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// Maps transfer failures to responses; shared by the transfer and legacy transaction endpoints
+func transferError(c *fiber.Ctx, logger *zap.Logger, err error) error {
+	switch err {
+	case services.ErrWalletNotOwned, services.ErrNotWalletOwner, services.ErrSenderWalletNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Wallet not found",
+		})
+	case services.ErrRecipientNotFound, services.ErrBeneficiaryNotFound, services.ErrReceiverWalletNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Recipient not found",
+		})
+	case services.ErrAmbiguousRecipient, services.ErrInvalidAmount, services.ErrSelfTransfer:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrRecipientCurrencyUnavailable, services.ErrExternalTransferUnsupported, services.ErrInsufficientFunds:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	logger.Error("Failed to transfer funds", zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to transfer funds",
+	})
+}
+
+func transferTarget(req TransferRecipientRequest) services.TransferTarget {
+	target := services.TransferTarget{
+		Email:       req.Email,
+		PhoneNumber: req.PhoneNumber,
+	}
+	if req.BeneficiaryID != "" {
+		beneficiaryID := uuid.MustParse(req.BeneficiaryID)
+		target.BeneficiaryID = &beneficiaryID
+	}
+	return target
+}
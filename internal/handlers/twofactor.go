@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"pgpockets/internal/services"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type TwoFactorHandler struct {
+	twoFactorService services.TwoFactorService
+	logger           *zap.Logger
+	validator        *validator.Validate
+}
+
+func NewTwoFactorHandler(twoFactorService services.TwoFactorService, logger *zap.Logger) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactorService: twoFactorService,
+		logger:           logger,
+		validator:        validator.New(),
+	}
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required,min=6,max=11"`
+}
+
+func (h *TwoFactorHandler) GetStatus(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	enabled, remaining, err := h.twoFactorService.GetStatus(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get two-factor status",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"enabled":                  enabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+func (h *TwoFactorHandler) BeginEnrollment(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	secret, uri, err := h.twoFactorService.BeginEnrollment(userID)
+	if err != nil {
+		if err == services.ErrTwoFactorAlreadyEnabled {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Two-factor authentication is already enabled",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start two-factor enrollment",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Scan the QR code with your authenticator app, then confirm with a code",
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_payload":  uri,
+	})
+}
+
+func (h *TwoFactorHandler) ConfirmEnrollment(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	req, ok := h.parseCodeRequest(c)
+	if !ok {
+		return nil
+	}
+
+	codes, err := h.twoFactorService.ConfirmEnrollment(userID, req.Code)
+	if err != nil {
+		return h.handleError(c, err, "Failed to enable two-factor authentication")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled. Store these recovery codes somewhere safe, they will not be shown again",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *TwoFactorHandler) Disable(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	req, ok := h.parseCodeRequest(c)
+	if !ok {
+		return nil
+	}
+
+	if err := h.twoFactorService.Disable(userID, req.Code); err != nil {
+		return h.handleError(c, err, "Failed to disable two-factor authentication")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	req, ok := h.parseCodeRequest(c)
+	if !ok {
+		return nil
+	}
+
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		return h.handleError(c, err, "Failed to regenerate recovery codes")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":        "Recovery codes regenerated. Previous codes no longer work",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *TwoFactorHandler) StepUp(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	sessionID := c.Locals("sessionID").(uuid.UUID)
+	req, ok := h.parseCodeRequest(c)
+	if !ok {
+		return nil
+	}
+
+	if err := h.twoFactorService.StepUp(userID, sessionID, req.Code); err != nil {
+		return h.handleError(c, err, "Failed to verify two-factor code")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Two-factor verification successful",
+	})
+}
+
+// Parses and validates the code body. On failure the 400 response has already been written.
+func (h *TwoFactorHandler) parseCodeRequest(c *fiber.Ctx) (*TwoFactorCodeRequest, bool) {
+	var req TwoFactorCodeRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		h.logger.Warn("Failed to parse request body", zap.Error(err))
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+	if err := h.validator.Struct(req); err != nil {
+		h.logger.Warn("Validation failed", zap.Error(err))
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+	return &req, true
+}
+
+func (h *TwoFactorHandler) handleError(c *fiber.Ctx, err error, fallback string) error {
+	var throttled *services.LoginThrottledError
+	if errors.As(err, &throttled) {
+		retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error":               "Too many invalid two-factor codes, please try again later",
+			"retry_after_seconds": retryAfter,
+		})
+	}
+	switch err {
+	case services.ErrInvalidTwoFactorCode:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid two-factor code",
+		})
+	case services.ErrTwoFactorNotEnabled, services.ErrTwoFactorNotEnrolled:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrTwoFactorAlreadyEnabled:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	h.logger.Error(fallback, zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
+
+// Writes the standard response for a sensitive operation attempted without a recent 2FA step-up
+func stepUpRequired(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":            "Two-factor verification required",
+		"step_up_required": true,
+		"step_up_endpoint": "/api/v1/auth/2fa/step-up",
+	})
+}
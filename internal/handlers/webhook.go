@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"encoding/json"
+	"pgpockets/internal/services"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type WebhookHandler struct {
+	service   services.WebhookService
+	logger    *zap.Logger
+	validator *validator.Validate
+}
+
+func NewWebhookHandler(service services.WebhookService, logger *zap.Logger) *WebhookHandler {
+	return &WebhookHandler{
+		service:   service,
+		logger:    logger,
+		validator: validator.New(),
+	}
+}
+
+type CreateWebhookEndpointRequest struct {
+	URL         string   `json:"url" validate:"required,url,max=2048"`
+	Description string   `json:"description" validate:"max=255"`
+	EventTypes  []string `json:"event_types" validate:"required,min=1,dive,required"`
+}
+
+type UpdateWebhookEndpointRequest struct {
+	URL         *string  `json:"url" validate:"omitempty,url,max=2048"`
+	Description *string  `json:"description" validate:"omitempty,max=255"`
+	EventTypes  []string `json:"event_types" validate:"omitempty,min=1,dive,required"`
+	Enabled     *bool    `json:"enabled"`
+}
+
+// Lists the event types an endpoint can subscribe to
+func (h *WebhookHandler) ListEventTypes(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"event_types": services.WebhookEventTypes,
+	})
+}
+
+func (h *WebhookHandler) CreateEndpoint(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+
+	var req CreateWebhookEndpointRequest
+	if !h.parseRequest(c, &req) {
+		return nil
+	}
+	endpoint, err := h.service.CreateEndpoint(userID, services.NewWebhookEndpoint{
+		URL:         req.URL,
+		Description: req.Description,
+		EventTypes:  req.EventTypes,
+	})
+	if err != nil {
+		return h.webhookError(c, err, "Failed to create webhook endpoint")
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":  "Webhook endpoint created. Store the secret now; it will not be shown again.",
+		"endpoint": endpoint,
+	})
+}
+
+func (h *WebhookHandler) ListEndpoints(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	endpoints, err := h.service.ListEndpoints(userID)
+	if err != nil {
+		return h.webhookError(c, err, "Failed to list webhook endpoints")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"endpoints": endpoints,
+	})
+}
+
+func (h *WebhookHandler) GetEndpoint(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	endpointID, ok := parseWebhookParam(c, "endpointID", "endpoint")
+	if !ok {
+		return nil
+	}
+	endpoint, err := h.service.GetEndpoint(userID, endpointID)
+	if err != nil {
+		return h.webhookError(c, err, "Failed to get webhook endpoint")
+	}
+	return c.Status(fiber.StatusOK).JSON(endpoint)
+}
+
+func (h *WebhookHandler) UpdateEndpoint(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	endpointID, ok := parseWebhookParam(c, "endpointID", "endpoint")
+	if !ok {
+		return nil
+	}
+	var req UpdateWebhookEndpointRequest
+	if !h.parseRequest(c, &req) {
+		return nil
+	}
+	endpoint, err := h.service.UpdateEndpoint(userID, endpointID, services.WebhookEndpointUpdate{
+		URL:         req.URL,
+		Description: req.Description,
+		EventTypes:  req.EventTypes,
+		Enabled:     req.Enabled,
+	})
+	if err != nil {
+		return h.webhookError(c, err, "Failed to update webhook endpoint")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Webhook endpoint updated",
+		"endpoint": endpoint,
+	})
+}
+
+func (h *WebhookHandler) RotateSecret(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	endpointID, ok := parseWebhookParam(c, "endpointID", "endpoint")
+	if !ok {
+		return nil
+	}
+	endpoint, err := h.service.RotateSecret(userID, endpointID)
+	if err != nil {
+		return h.webhookError(c, err, "Failed to rotate webhook secret")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Webhook secret rotated. Store the secret now; it will not be shown again.",
+		"endpoint": endpoint,
+	})
+}
+
+func (h *WebhookHandler) DeleteEndpoint(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	endpointID, ok := parseWebhookParam(c, "endpointID", "endpoint")
+	if !ok {
+		return nil
+	}
+	if err := h.service.DeleteEndpoint(userID, endpointID); err != nil {
+		return h.webhookError(c, err, "Failed to delete webhook endpoint")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Webhook endpoint deleted",
+	})
+}
+
+func (h *WebhookHandler) ListDeliveries(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	endpointID, ok := parseWebhookParam(c, "endpointID", "endpoint")
+	if !ok {
+		return nil
+	}
+	limit, offset := getPaginationParams(c)
+	deliveries, count, err := h.service.ListDeliveries(userID, endpointID, c.Query("status"), limit, offset)
+	if err != nil {
+		return h.webhookError(c, err, "Failed to list webhook deliveries")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"deliveries": deliveries,
+		"count":      count,
+	})
+}
+
+func (h *WebhookHandler) GetDelivery(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	endpointID, ok := parseWebhookParam(c, "endpointID", "endpoint")
+	if !ok {
+		return nil
+	}
+	deliveryID, ok := parseWebhookParam(c, "deliveryID", "delivery")
+	if !ok {
+		return nil
+	}
+	delivery, err := h.service.GetDelivery(userID, endpointID, deliveryID)
+	if err != nil {
+		return h.webhookError(c, err, "Failed to get webhook delivery")
+	}
+	return c.Status(fiber.StatusOK).JSON(delivery)
+}
+
+func (h *WebhookHandler) Redeliver(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uuid.UUID)
+	endpointID, ok := parseWebhookParam(c, "endpointID", "endpoint")
+	if !ok {
+		return nil
+	}
+	deliveryID, ok := parseWebhookParam(c, "deliveryID", "delivery")
+	if !ok {
+		return nil
+	}
+	delivery, err := h.service.Redeliver(userID, endpointID, deliveryID)
+	if err != nil {
+		return h.webhookError(c, err, "Failed to redeliver webhook")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Webhook redelivered",
+		"delivery": delivery,
+	})
+}
+
+func (h *WebhookHandler) parseRequest(c *fiber.Ctx, req interface{}) bool {
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+		return false
+	}
+	if err := h.validator.Struct(req); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+func (h *WebhookHandler) webhookError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case services.ErrWebhookEndpointNotFound, services.ErrWebhookDeliveryNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrInvalidWebhookURL, services.ErrWebhookURLNotPublic, services.ErrUnknownWebhookEventType:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case services.ErrWebhookEndpointDisabled:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	h.logger.Error(fallback, zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
+
+func parseWebhookParam(c *fiber.Ctx, name, label string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Params(name))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid " + label + " ID format",
+		})
+		return uuid.Nil, false
+	}
+	return id, true
+}
@@ -0,0 +1,462 @@
+/*
+Package jobs is a durable background job queue stored in Postgres. Jobs are enqueued
+as rows, claimed by workers with SELECT ... FOR UPDATE SKIP LOCKED so any number of
+instances can share a queue, retried with backoff when their handler fails and left
+in the dead state for an admin to inspect once they run out of attempts. Recurring
+jobs replace per-instance tickers: one run is queued at a time however many instances
+are up.
+*/
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrJobNotFound       = errors.New("job not found")
+	ErrJobNotRequeueable = errors.New("only dead or completed jobs can be requeued")
+	ErrUnknownJobType    = errors.New("no handler is registered for this job type")
+	ErrRunnerStarted     = errors.New("handlers and queues must be registered before the runner starts")
+	ErrJobAlreadyQueued  = errors.New("a job with this unique key is already queued")
+)
+
+const (
+	DefaultQueue       = "default"
+	defaultMaxAttempts = 5
+	// How long a claimed job is held; running jobs renew it so only a dead worker lets it lapse
+	jobLease        = 5 * time.Minute
+	jobPollInterval = 2 * time.Second
+	// Wait before each retry, doubled per attempt up to the limit
+	jobBackoffBase  = 10 * time.Second
+	jobBackoffLimit = time.Hour
+	// Completed jobs are kept this long for the admin API
+	jobRetention = 7 * 24 * time.Hour
+	// How often each instance makes sure every recurring job has a run queued
+	recurringCheckInterval = time.Minute
+)
+
+// Handler runs one job. ctx is cancelled when shutdown gives up waiting for the job.
+type Handler func(ctx context.Context, job *models.Job) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error as not worth retrying; the job goes straight to the dead state
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type EnqueueOptions struct {
+	Priority    int       // Higher runs first among due jobs in the same queue
+	RunAt       time.Time // Defaults to now
+	MaxAttempts int       // Defaults to the job type's setting
+	UniqueKey   string    // At most one queued job per key; Enqueue returns ErrJobAlreadyQueued for the rest
+}
+
+type registration struct {
+	queue       string
+	maxAttempts int
+	handler     Handler
+}
+
+// Runner enqueues jobs and runs the registered handlers for them
+type Runner struct {
+	repo     repositories.JobRepository
+	logger   *zap.Logger
+	workerID string
+
+	mu          sync.Mutex
+	handlers    map[string]registration
+	concurrency map[string]int
+	recurring   map[string]time.Duration
+	started     bool
+
+	stop    context.CancelFunc // Stops claiming new jobs
+	abort   context.CancelFunc // Cancels running handlers
+	workers sync.WaitGroup     // Each worker returns once its current job is done
+}
+
+func NewRunner(repo repositories.JobRepository, logger *zap.Logger) *Runner {
+	host, _ := os.Hostname()
+	return &Runner{
+		repo:        repo,
+		logger:      logger,
+		workerID:    fmt.Sprintf("%s/%d/%s", host, os.Getpid(), uuid.NewString()[:8]),
+		handlers:    make(map[string]registration),
+		concurrency: map[string]int{DefaultQueue: 1},
+		recurring:   make(map[string]time.Duration),
+	}
+}
+
+// Queue sets how many jobs from the queue this instance runs at once
+func (r *Runner) Queue(name string, concurrency int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return ErrRunnerStarted
+	}
+	r.concurrency[name] = max(concurrency, 1)
+	return nil
+}
+
+// Register sets the handler for a job type and the queue its jobs go to
+func (r *Runner) Register(jobType, queue string, maxAttempts int, handler Handler) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return ErrRunnerStarted
+	}
+	if queue == "" {
+		queue = DefaultQueue
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	if _, ok := r.concurrency[queue]; !ok {
+		r.concurrency[queue] = 1
+	}
+	r.handlers[jobType] = registration{queue: queue, maxAttempts: maxAttempts, handler: handler}
+	return nil
+}
+
+// Handle registers a handler that receives the job's payload decoded into T
+func Handle[T any](r *Runner, jobType, queue string, maxAttempts int, handler func(ctx context.Context, payload T) error) error {
+	return r.Register(jobType, queue, maxAttempts, func(ctx context.Context, job *models.Job) error {
+		var payload T
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			return Permanent(fmt.Errorf("decoding %s payload: %w", jobType, err))
+		}
+		return handler(ctx, payload)
+	})
+}
+
+/*
+	Every runs fn as a recurring job. Each run queues the next one interval later before doing
+	its work, so a slow or failed run does not hold up the schedule; a failed run is left dead
+	for inspection rather than retried, since the next run is the retry. Every instance checks
+	the schedule is intact, so the first run starts once the runner does.
+*/
+func (r *Runner) Every(jobType, queue string, interval time.Duration, fn func(ctx context.Context) error) error {
+	if err := r.Register(jobType, queue, 1, func(ctx context.Context, job *models.Job) error {
+		r.enqueueRecurring(jobType, time.Now().Add(interval))
+		return fn(ctx)
+	}); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recurring[jobType] = interval
+	return nil
+}
+
+// Enqueue adds a job for a registered type. Pass tx to enqueue with another change, or nil.
+func (r *Runner) Enqueue(tx *gorm.DB, jobType string, payload any, opts EnqueueOptions) (*models.Job, error) {
+	r.mu.Lock()
+	reg, ok := r.handlers[jobType]
+	r.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownJobType
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := &models.Job{
+		Queue:       reg.queue,
+		Type:        jobType,
+		Payload:     string(encoded),
+		Priority:    opts.Priority,
+		Status:      models.JobStatusQueued,
+		RunAt:       opts.RunAt,
+		MaxAttempts: opts.MaxAttempts,
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = reg.maxAttempts
+	}
+	repo := r.repo
+	if tx != nil {
+		repo = repo.WithTx(tx)
+	}
+	if opts.UniqueKey == "" {
+		if err := repo.Create(job); err != nil {
+			r.logger.Error("Failed to enqueue job", zap.String("job_type", jobType), zap.Error(err))
+			return nil, err
+		}
+		return job, nil
+	}
+	job.UniqueKey = &opts.UniqueKey
+	created, err := repo.CreateUnique(job)
+	if err != nil {
+		r.logger.Error("Failed to enqueue job", zap.String("job_type", jobType), zap.Error(err))
+		return nil, err
+	}
+	if !created {
+		return nil, ErrJobAlreadyQueued
+	}
+	return job, nil
+}
+
+// Queues the next run of a recurring job unless one is already waiting
+func (r *Runner) enqueueRecurring(jobType string, runAt time.Time) {
+	_, err := r.Enqueue(nil, jobType, struct{}{}, EnqueueOptions{RunAt: runAt, UniqueKey: jobType})
+	if err != nil && err != ErrJobAlreadyQueued {
+		r.logger.Error("Failed to schedule recurring job", zap.String("job_type", jobType), zap.Error(err))
+	}
+}
+
+func (r *Runner) Get(id uuid.UUID) (*models.Job, error) {
+	job, err := r.repo.GetByID(id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+func (r *Runner) List(filter repositories.JobFilter, limit, offset int) ([]models.Job, int64, error) {
+	return r.repo.List(filter, limit, offset)
+}
+
+func (r *Runner) Stats() ([]repositories.JobCount, error) {
+	return r.repo.CountByStatus()
+}
+
+// Requeue runs a dead or completed job again from its first attempt
+func (r *Runner) Requeue(id uuid.UUID) (*models.Job, error) {
+	if _, err := r.Get(id); err != nil {
+		return nil, err
+	}
+	requeued, err := r.repo.Requeue(id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !requeued {
+		return nil, ErrJobNotRequeueable
+	}
+	return r.Get(id)
+}
+
+// Start polls every queue with its configured number of workers until Shutdown
+func (r *Runner) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return
+	}
+	r.started = true
+
+	pollCtx, stop := context.WithCancel(context.Background())
+	handlerCtx, abort := context.WithCancel(context.Background())
+	r.stop, r.abort = stop, abort
+	for queue, concurrency := range r.concurrency {
+		for i := 0; i < concurrency; i++ {
+			r.workers.Add(1)
+			go r.poll(pollCtx, handlerCtx, queue)
+		}
+	}
+	r.workers.Add(2)
+	go r.prune(pollCtx)
+	go r.keepRecurring(pollCtx)
+	r.logger.Info("Job runner started", zap.String("worker_id", r.workerID))
+}
+
+/*
+	Shutdown stops claiming jobs and waits for running ones to finish. If ctx ends first the
+	handlers are cancelled; a job whose outcome is never recorded runs again once its lease ends.
+*/
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	started := r.started
+	r.mu.Unlock()
+	if !started {
+		return nil
+	}
+	r.stop()
+
+	done := make(chan struct{})
+	go func() {
+		r.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		r.abort()
+		return nil
+	case <-ctx.Done():
+		r.abort()
+		return ctx.Err()
+	}
+}
+
+// One worker: claims a job at a time from the queue and runs it
+func (r *Runner) poll(pollCtx, handlerCtx context.Context, queue string) {
+	defer r.workers.Done()
+	for {
+		claimed := r.runNext(handlerCtx, queue)
+		if claimed {
+			// Keep draining while there is work, unless shutting down
+			if pollCtx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-pollCtx.Done():
+			return
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+func (r *Runner) runNext(ctx context.Context, queue string) bool {
+	now := time.Now()
+	jobs, err := r.repo.Claim(queue, r.workerID, now, now.Add(jobLease), 1)
+	if err != nil {
+		r.logger.Error("Failed to claim job", zap.String("queue", queue), zap.Error(err))
+		return false
+	}
+	if len(jobs) == 0 {
+		return false
+	}
+	r.run(ctx, &jobs[0])
+	return true
+}
+
+// Runs a claimed job, renewing its lease meanwhile, and records the outcome
+func (r *Runner) run(ctx context.Context, job *models.Job) {
+	logger := r.logger.With(
+		zap.String("job_id", job.ID.String()),
+		zap.String("job_type", job.Type),
+		zap.Int("attempt", job.Attempts))
+
+	// Claimed again after its worker died mid-run too many times
+	if job.Attempts > job.MaxAttempts {
+		if err := r.repo.Bury(job.ID, r.workerID, "out of attempts: "+job.LastError); err != nil {
+			logger.Error("Failed to record job outcome", zap.Error(err))
+		}
+		return
+	}
+
+	r.mu.Lock()
+	reg, ok := r.handlers[job.Type]
+	r.mu.Unlock()
+	var err error
+	if !ok {
+		err = Permanent(ErrUnknownJobType)
+	} else {
+		err = r.call(ctx, reg.handler, job)
+	}
+
+	now := time.Now()
+	var permanent permanentError
+	switch {
+	case err == nil:
+		err = r.repo.Complete(job.ID, r.workerID, now)
+	case errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts:
+		logger.Error("Job failed for good", zap.Error(err))
+		err = r.repo.Bury(job.ID, r.workerID, err.Error())
+	default:
+		logger.Warn("Job failed, will retry", zap.Error(err))
+		err = r.repo.Retry(job.ID, r.workerID, now.Add(jobBackoff(job.Attempts)), err.Error())
+	}
+	if err != nil {
+		logger.Error("Failed to record job outcome", zap.Error(err))
+	}
+}
+
+func (r *Runner) call(ctx context.Context, handler Handler, job *models.Job) (err error) {
+	stopRenewing := make(chan struct{})
+	defer close(stopRenewing)
+	go func() {
+		ticker := time.NewTicker(jobLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopRenewing:
+				return
+			case <-ticker.C:
+				if err := r.repo.ExtendLease(job.ID, r.workerID, time.Now().Add(jobLease)); err != nil {
+					r.logger.Error("Failed to extend job lease", zap.String("job_id", job.ID.String()), zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handler panicked: %v", p)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func (r *Runner) prune(ctx context.Context) {
+	defer r.workers.Done()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.repo.DeleteCompletedBefore(time.Now().Add(-jobRetention)); err != nil {
+				r.logger.Error("Failed to prune completed jobs", zap.Error(err))
+			}
+		}
+	}
+}
+
+/*
+	Queues a run of every recurring job that has none waiting: at start, and after a run whose
+	worker died before it could queue the next one. Nothing happens while a run is queued, so
+	this never speeds a schedule up.
+*/
+func (r *Runner) keepRecurring(ctx context.Context) {
+	defer r.workers.Done()
+	ticker := time.NewTicker(recurringCheckInterval)
+	defer ticker.Stop()
+	for {
+		r.mu.Lock()
+		jobTypes := make([]string, 0, len(r.recurring))
+		for jobType := range r.recurring {
+			jobTypes = append(jobTypes, jobType)
+		}
+		r.mu.Unlock()
+		for _, jobType := range jobTypes {
+			r.enqueueRecurring(jobType, time.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func jobBackoff(attempts int) time.Duration {
+	wait := jobBackoffBase
+	for i := 1; i < attempts && wait < jobBackoffLimit; i++ {
+		wait *= 2
+	}
+	return min(wait, jobBackoffLimit)
+}
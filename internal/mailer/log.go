@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// logMailer is for local development. It logs every message and, when outboxDir
+// is set, also writes it to a .eml file there so links can be clicked.
+type logMailer struct {
+	outboxDir string
+	logger    *zap.Logger
+}
+
+func NewLogMailer(outboxDir string, logger *zap.Logger) Mailer {
+	return &logMailer{
+		outboxDir: outboxDir,
+		logger:    logger,
+	}
+}
+
+func (m *logMailer) Send(msg Message) error {
+	m.logger.Info("Email sent (log mailer)",
+		zap.String("to", msg.To),
+		zap.String("subject", msg.Subject),
+		zap.String("body", msg.Body),
+	)
+	if m.outboxDir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(m.outboxDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create outbox dir: %w", err)
+	}
+	name := fmt.Sprintf("%s_%s.eml", time.Now().Format("20060102T150405"), uuid.NewString()[:8])
+	content := strings.Join([]string{
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"",
+		msg.Body,
+	}, "\n")
+	if err := os.WriteFile(filepath.Join(m.outboxDir, name), []byte(content), 0o644); err != nil {
+		return fmt.Errorf("failed to write email to outbox: %w", err)
+	}
+	return nil
+}
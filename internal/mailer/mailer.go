@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"errors"
+	"pgpockets/internal/config"
+
+	"go.uber.org/zap"
+)
+
+var ErrUnknownDriver = errors.New("unknown mail driver")
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Builds the mailer selected by MAIL_DRIVER. "log" (the default) never leaves the machine.
+func NewFromConfig(cfg config.Config, logger *zap.Logger) (Mailer, error) {
+	switch cfg.MailDriver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "log", "":
+		return NewLogMailer(cfg.MailOutboxDir, logger), nil
+	}
+	return nil, ErrUnknownDriver
+}
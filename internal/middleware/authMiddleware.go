@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"pgpockets/internal/models"
 	"pgpockets/internal/repositories"
 	"strings"
 
@@ -100,6 +101,10 @@ func (a *AuthMiddleware) RequireAuth() fiber.Handler {
 			})
 		}
 
+		if err := a.userRepo.TouchSession(session.ID); err != nil {
+			a.logger.Warn("Failed to update session last seen time", zap.Error(err))
+		}
+
 		// Store in context
 		c.Locals("userID", userID)
 		c.Locals("sessionID", session.ID)
@@ -108,3 +113,68 @@ func (a *AuthMiddleware) RequireAuth() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// RequireAdmin restricts a route to users with the admin role. Must run after RequireAuth.
+func (a *AuthMiddleware) RequireAdmin() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := c.Locals("userID").(uuid.UUID)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid user",
+			})
+		}
+
+		user, err := a.userRepo.GetUserByID(userID)
+		if err != nil || user.Role != models.UserRoleAdmin {
+			a.logger.Warn("Non-admin attempted admin route", zap.String("user_id", userID.String()))
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Forbidden",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireVerifiedEmail blocks money movement for accounts that have not confirmed their email.
+// Must run after RequireAuth.
+func (a *AuthMiddleware) RequireVerifiedEmail() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := c.Locals("userID").(uuid.UUID)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid user",
+			})
+		}
+
+		user, err := a.userRepo.GetUserByID(userID)
+		if err != nil {
+			a.logger.Error("Failed to look up user", zap.Error(err))
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid user",
+			})
+		}
+
+		if !user.IsEmailVerified {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":              "Please verify your email address before moving money",
+				"email_not_verified": true,
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// AcceptQueryToken lets browser EventSource and WebSocket clients, which cannot set headers,
+// pass the access token as ?access_token=. Only use it on streaming routes, before RequireAuth.
+func AcceptQueryToken() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" {
+			if token := c.Query("access_token"); token != "" {
+				c.Request().Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		return c.Next()
+	}
+}
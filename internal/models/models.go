@@ -25,6 +25,7 @@ const (
 	TransactionTypeFee        string = "fee"
 	TransactionTypeRefund     string = "refund"
 	TransactionTypeChargeback string = "chargeback"
+	TransactionTypeAdjustment string = "adjustment" // Balance corrected by an operator
 )
 
 const (
@@ -36,6 +37,14 @@ const (
 	TransactionStatusCancelled string = "cancelled"
 )
 
+const (
+	AuthorizationStatusPending  string = "pending" // Approved and holding funds until captured or reversed
+	AuthorizationStatusCaptured string = "captured"
+	AuthorizationStatusReversed string = "reversed"
+	AuthorizationStatusExpired  string = "expired"
+	AuthorizationStatusDeclined string = "declined"
+)
+
 const (
 	CurrencyUSD string = "USD"
 	CurrencyNGN string = "NGN"
@@ -46,6 +55,24 @@ const (
 	CurrencyJPY string = "JPY"
 )
 
+const (
+	TokenPurposeEmailVerification string = "email_verification"
+	TokenPurposePasswordReset     string = "password_reset"
+	TokenPurposeAccountUnlock     string = "account_unlock"
+	TokenPurposeTwoFactorLogin    string = "two_factor_login" // Challenge between the password and code steps of a 2FA login
+)
+
+const (
+	UserRoleUser  string = "user"
+	UserRoleAdmin string = "admin"
+)
+
+const (
+	LoginThrottleScopeAccount   string = "account"
+	LoginThrottleScopeIP        string = "ip"
+	LoginThrottleScopeTwoFactor string = "two_factor" // Keyed by user ID; wrong codes for signed-in 2FA checks
+)
+
 const (
 	CardTypeMastercard string = "mastercard"
 	CardTypeVisa       string = "visa"
@@ -54,13 +81,134 @@ const (
 	CardTypeVerve      string = "verve"
 )
 
+const (
+	CardStatusActive     string = "active"
+	CardStatusFrozen     string = "frozen"     // Temporarily blocked by the owner; can be unfrozen
+	CardStatusTerminated string = "terminated" // Permanently closed
+	CardStatusExpired    string = "expired"
+)
+
+const (
+	BeneficiaryKindWallet      string = "wallet"
+	BeneficiaryKindBankAccount string = "bank_account"
+	BeneficiaryKindPhone       string = "phone"
+)
+
+const (
+	ScheduleFrequencyOnce    string = "once"
+	ScheduleFrequencyDaily   string = "daily"
+	ScheduleFrequencyWeekly  string = "weekly"
+	ScheduleFrequencyMonthly string = "monthly"
+)
+
+const (
+	ScheduleStatusActive    string = "active"
+	ScheduleStatusPaused    string = "paused"
+	ScheduleStatusCompleted string = "completed"
+	ScheduleStatusCancelled string = "cancelled"
+	ScheduleStatusFailed    string = "failed"
+)
+
+const (
+	PaymentRequestStatusPending   string = "pending"
+	PaymentRequestStatusPaid      string = "paid"
+	PaymentRequestStatusDeclined  string = "declined"
+	PaymentRequestStatusCancelled string = "cancelled"
+	PaymentRequestStatusExpired   string = "expired"
+)
+
+const (
+	PaymentLinkStatusActive   string = "active"
+	PaymentLinkStatusUsed     string = "used"
+	PaymentLinkStatusDisabled string = "disabled"
+	PaymentLinkStatusExpired  string = "expired"
+)
+
+const (
+	SplitMethodEqual      string = "equal"
+	SplitMethodPercentage string = "percentage"
+	SplitMethodExact      string = "exact"
+)
+
+const (
+	BillSplitStatusOpen      string = "open"
+	BillSplitStatusSettled   string = "settled"
+	BillSplitStatusCancelled string = "cancelled"
+)
+
+// What a notification is about; users set channel preferences per category
+const (
+	NotificationCategoryTransaction string = "transaction"
+	NotificationCategoryPayment     string = "payment" // Payment requests, bill splits and links
+	NotificationCategoryCard        string = "card"
+	NotificationCategorySecurity    string = "security"
+	NotificationCategoryInvoice     string = "invoice"
+	NotificationCategoryAccount     string = "account"
+	NotificationCategoryMarketing   string = "marketing"
+)
+
+const (
+	NotificationPriorityLow    string = "low"
+	NotificationPriorityNormal string = "normal"
+	NotificationPriorityHigh   string = "high"
+	NotificationPriorityUrgent string = "urgent" // Needs the user's attention now, e.g. a sign-in they may not recognise
+)
+
+const (
+	NotificationChannelInApp string = "in_app"
+	NotificationChannelEmail string = "email"
+	NotificationChannelSMS   string = "sms"
+	NotificationChannelPush  string = "push"
+)
+
+const (
+	DeliveryStatusPending string = "pending" // Waiting for its first attempt, a retry or the end of quiet hours
+	DeliveryStatusSent    string = "sent"
+	DeliveryStatusFailed  string = "failed"  // Out of attempts
+	DeliveryStatusSkipped string = "skipped" // The user cannot be reached on the channel, e.g. no phone number
+)
+
+const (
+	WebhookEndpointStatusActive   string = "active"
+	WebhookEndpointStatusDisabled string = "disabled" // Turned off by the owner or after failing persistently
+)
+
+const (
+	JobStatusQueued    string = "queued" // Waiting for run_at, including retries
+	JobStatusRunning   string = "running"
+	JobStatusCompleted string = "completed"
+	JobStatusDead      string = "dead" // Out of attempts or failed permanently; only an admin requeue runs it again
+)
+
+// Where a card payment was made, as reported by the issuer
+const (
+	CardChannelPOS    string = "pos"
+	CardChannelOnline string = "online"
+	CardChannelATM    string = "atm"
+)
+
 type Beneficiary struct {
 	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	UserID      uuid.UUID `gorm:"type:uuid;not null references" json:"user_id"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null references;uniqueIndex:idx_beneficiaries_user_target,where:target_key <> ''" json:"user_id"`
+	Kind        string    `gorm:"type:varchar(20);not null;default:'wallet'" json:"kind"` // Maps to BeneficiaryKind constants
 	Description string    `gorm:"type:text" json:"description"`
-	WalletID    uuid.UUID `gorm:"type:uuid;not null" json:"wallet_id"`
-	Wallet      Wallet    `gorm:"foreignKey:WalletID;references:ID"`
+	Nickname    string    `gorm:"type:varchar(64)" json:"nickname"`
+	IsFavorite  bool      `gorm:"not null;default:false;index" json:"is_favorite"`
+	// Identifies what the beneficiary points at (e.g. "bank:058:0123456789") so a user cannot save the same payee twice
+	TargetKey string `gorm:"type:varchar(128);uniqueIndex:idx_beneficiaries_user_target" json:"-"`
+	// Internal payees (wallet and phone kinds)
+	WalletID        *uuid.UUID `gorm:"type:uuid" json:"wallet_id,omitempty"`
+	RecipientUserID *uuid.UUID `gorm:"type:uuid" json:"recipient_user_id,omitempty"`
+	PhoneNumber     string     `gorm:"type:varchar(20)" json:"phone_number,omitempty"`
+	// External bank accounts
+	AccountNumber string `gorm:"type:varchar(10)" json:"account_number,omitempty"`
+	BankCode      string `gorm:"type:varchar(10)" json:"bank_code,omitempty"`
+	BankName      string `gorm:"type:varchar(255)" json:"bank_name,omitempty"`
+	// Confirmed holder name; masked for internal payees
+	AccountName string    `gorm:"type:varchar(255)" json:"account_name"`
+	Wallet      *Wallet   `gorm:"foreignKey:WalletID;references:ID" json:"-"`
 	CreatedAt   time.Time `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"not null;default:now()" json:"updated_at"`
 }
 
 // User represents the users table in the database.
@@ -69,6 +217,7 @@ type User struct {
 	Email           string    `gorm:"type:varchar(255);unique;not null" json:"email"`
 	PasswordHash    string    `gorm:"type:varchar(255);not null" json:"-"`
 	IsEmailVerified bool      `gorm:"default:false" json:"is_email_verified"`
+	Role            string    `gorm:"type:varchar(16);not null;default:'user'" json:"role"` // Maps to UserRole constants
 	CreatedAt       time.Time `gorm:"not null;default:now()" json:"created_at"`
 	UpdatedAt       time.Time `gorm:"not null;default:now()" json:"updated_at"`
 }
@@ -122,6 +271,8 @@ type Wallet struct {
 	UserID    uuid.UUID       `gorm:"type:uuid;not null" json:"user_id"`
 	Currency  string          `gorm:"type:varchar(3);not null;default:NGN" json:"currency"`
 	Balance   decimal.Decimal `gorm:"type:numeric(18,2);not null;default:0.00" json:"balance"`
+	// Funds reserved by pending card authorizations; available balance is Balance - HeldBalance
+	HeldBalance decimal.Decimal `gorm:"type:numeric(18,2);not null;default:0.00" json:"held_balance"`
 	Name      string          `gorm:"type:varchar(255);default:Naira Wallet" json:"name"`
 	IsActive  bool            `gorm:"default:true" json:"is_active"`
 	CreatedAt time.Time       `gorm:"not null;default:now()" json:"created_at"`
@@ -180,12 +331,164 @@ type Card struct {
 	ExpiryYear     string    `gorm:"type:varchar(4);not null" json:"expiry_year"`  // YYYY
 	CardBrand      string    `gorm:"type:varchar(50)" json:"card_brand"`
 	BankName       string    `gorm:"type:varchar(255)" json:"bank_name"`
-	IsActive       bool      `gorm:"default:true" json:"is_active"`
-	CreatedAt      time.Time `gorm:"not null;default:now()" json:"created_at"`
-	UpdatedAt      time.Time `gorm:"not null;default:now()" json:"updated_at"`
+	IsActive       bool      `gorm:"default:true" json:"is_active"` // Kept in sync with Status == active
+	Status         string    `gorm:"type:varchar(20);not null;default:'active';index" json:"status"` // Maps to CardStatus constants
+	// Spending limits in the funding wallet's currency. Nil means no limit.
+	PerTransactionLimit *decimal.Decimal `gorm:"type:numeric(18,2)" json:"per_transaction_limit"`
+	DailyLimit          *decimal.Decimal `gorm:"type:numeric(18,2)" json:"daily_limit"`
+	MonthlyLimit        *decimal.Decimal `gorm:"type:numeric(18,2)" json:"monthly_limit"`
+	// Merchant category codes and ISO 3166 alpha-2 countries. An empty allow list allows everything.
+	AllowedMCCs      []string `gorm:"column:allowed_mccs;serializer:json;type:jsonb" json:"allowed_mccs"`
+	BlockedMCCs      []string `gorm:"column:blocked_mccs;serializer:json;type:jsonb" json:"blocked_mccs"`
+	AllowedCountries []string `gorm:"serializer:json;type:jsonb" json:"allowed_countries"`
+	BlockedCountries []string `gorm:"serializer:json;type:jsonb" json:"blocked_countries"`
+	OnlineEnabled    bool     `gorm:"not null;default:true" json:"online_enabled"`
+	ATMEnabled       bool     `gorm:"not null;default:true" json:"atm_enabled"`
+	// Virtual cards spend from this wallet. Nil for legacy cards added before issuing existed.
+	WalletID   *uuid.UUID `gorm:"type:uuid;index" json:"wallet_id"`
+	NameOnCard string     `gorm:"type:varchar(255)" json:"name_on_card"`
+	Issuer     string     `gorm:"type:varchar(50)" json:"issuer"`
+	// Set the first (and only) time the full PAN/CVV is shown to the user
+	RevealedAt *time.Time `json:"revealed_at"`
+	// Set once the owner has been told the card is about to expire
+	ExpiryNotifiedAt *time.Time `json:"-"`
+	// The card automatically issued to take over from this one before it expired
+	ReplacedByCardID *uuid.UUID `gorm:"type:uuid" json:"replaced_by_card_id"`
+	CreatedAt  time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"not null;default:now()" json:"updated_at"`
 
 	// Associations
-	User User `gorm:"foreignKey:UserID;references:ID"`
+	User   User    `gorm:"foreignKey:UserID;references:ID"`
+	Wallet *Wallet `gorm:"foreignKey:WalletID;references:ID" json:"-"`
+}
+
+// CardAuthorization is an issuer authorization against a card. Approved authorizations hold
+// funds on the card's wallet until they are captured at settlement, reversed or expire.
+type CardAuthorization struct {
+	ID                   uuid.UUID       `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	CardID               uuid.UUID       `gorm:"type:uuid;not null;index" json:"card_id"`
+	WalletID             *uuid.UUID      `gorm:"type:uuid;index" json:"wallet_id"` // Nil on declines for cards with no funding wallet
+	IssuerReference      string          `gorm:"type:varchar(255);unique;not null" json:"issuer_reference"` // Idempotency key from the issuer
+	Amount               decimal.Decimal `gorm:"type:numeric(18,2);not null" json:"amount"`
+	CapturedAmount       decimal.Decimal `gorm:"type:numeric(18,2);not null;default:0.00" json:"captured_amount"`
+	Currency             string          `gorm:"type:varchar(3);not null" json:"currency"`
+	Status               string          `gorm:"type:varchar(20);not null" json:"status"` // Maps to AuthorizationStatus constants
+	DeclineReason        string          `gorm:"type:varchar(64)" json:"decline_reason,omitempty"`
+	MerchantName         string          `gorm:"type:varchar(255)" json:"merchant_name"`
+	MerchantCategoryCode string          `gorm:"type:varchar(4)" json:"merchant_category_code"`
+	MerchantCity         string          `gorm:"type:varchar(128)" json:"merchant_city"`
+	MerchantCountry      string          `gorm:"type:varchar(2)" json:"merchant_country"`
+	Channel              string          `gorm:"type:varchar(10);not null;default:'pos'" json:"channel"` // Maps to CardChannel constants
+	TransactionID        *uuid.UUID      `gorm:"type:uuid" json:"transaction_id"`
+	ExpiresAt            time.Time       `gorm:"not null;index" json:"expires_at"`
+	CreatedAt            time.Time       `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt            time.Time       `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// PaymentRequest asks another user to pay money into one of the requester's wallets
+type PaymentRequest struct {
+	ID                uuid.UUID       `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	RequesterID       uuid.UUID       `gorm:"type:uuid;not null;index" json:"requester_id"`
+	PayerID           uuid.UUID       `gorm:"type:uuid;not null;index" json:"payer_id"`
+	RequesterWalletID uuid.UUID       `gorm:"type:uuid;not null" json:"requester_wallet_id"`
+	Amount            decimal.Decimal `gorm:"type:decimal(18,2);not null" json:"amount"`
+	Currency          string          `gorm:"type:varchar(3);not null" json:"currency"`
+	Note              string          `gorm:"type:varchar(255)" json:"note"`
+	Status            string          `gorm:"type:varchar(20);not null;default:'pending';index" json:"status"` // Maps to PaymentRequestStatus constants
+	ExpiresAt         time.Time       `gorm:"not null;index" json:"expires_at"`
+	RespondedAt       *time.Time      `json:"responded_at"`
+	TransactionID     *uuid.UUID      `gorm:"type:uuid" json:"transaction_id"`
+	CreatedAt         time.Time       `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt         time.Time       `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// PaymentLink is a shareable code anyone can open to pay into one of the owner's wallets.
+// A nil Amount lets the payer choose how much to send.
+type PaymentLink struct {
+	ID             uuid.UUID        `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID         uuid.UUID        `gorm:"type:uuid;not null;index" json:"user_id"`
+	WalletID       uuid.UUID        `gorm:"type:uuid;not null" json:"wallet_id"`
+	Code           string           `gorm:"type:varchar(16);not null;uniqueIndex" json:"code"`
+	Amount         *decimal.Decimal `gorm:"type:decimal(18,2)" json:"amount"`
+	Currency       string           `gorm:"type:varchar(3);not null" json:"currency"`
+	Description    string           `gorm:"type:varchar(255)" json:"description"`
+	Reusable       bool             `gorm:"not null;default:false" json:"reusable"`
+	Status         string           `gorm:"type:varchar(12);not null;default:'active'" json:"status"` // Maps to PaymentLinkStatus constants
+	ExpiresAt      *time.Time       `json:"expires_at"`
+	UseCount       int              `gorm:"not null;default:0" json:"use_count"`
+	TotalCollected decimal.Decimal  `gorm:"type:decimal(18,2);not null;default:0" json:"total_collected"`
+	LastPaidAt     *time.Time       `json:"last_paid_at"`
+	CreatedAt      time.Time        `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt      time.Time        `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// BillSplit shares a group expense paid by the creator. Each participant is sent a
+// payment request for their share, and the split settles once all of them are paid.
+type BillSplit struct {
+	ID           uuid.UUID       `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	CreatorID    uuid.UUID       `gorm:"type:uuid;not null;index" json:"creator_id"`
+	Title        string          `gorm:"type:varchar(100);not null" json:"title"`
+	TotalAmount  decimal.Decimal `gorm:"type:decimal(18,2);not null" json:"total_amount"`
+	Currency     string          `gorm:"type:varchar(3);not null" json:"currency"`
+	Method       string          `gorm:"type:varchar(12);not null" json:"method"` // Maps to SplitMethod constants
+	CreatorShare decimal.Decimal `gorm:"type:decimal(18,2);not null;default:0" json:"creator_share"`
+	Status       string          `gorm:"type:varchar(12);not null;default:'open';index" json:"status"` // Maps to BillSplitStatus constants
+	SettledAt    *time.Time      `json:"settled_at"`
+	CreatedAt    time.Time       `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt    time.Time       `gorm:"not null;default:now()" json:"updated_at"`
+
+	Participants []BillSplitParticipant `gorm:"foreignKey:SplitID" json:"participants"`
+}
+
+type BillSplitParticipant struct {
+	ID               uuid.UUID        `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	SplitID          uuid.UUID        `gorm:"type:uuid;not null;index" json:"split_id"`
+	UserID           uuid.UUID        `gorm:"type:uuid;not null;index" json:"user_id"`
+	Amount           decimal.Decimal  `gorm:"type:decimal(18,2);not null" json:"amount"`
+	Percentage       *decimal.Decimal `gorm:"type:decimal(5,2)" json:"percentage,omitempty"`
+	PaymentRequestID *uuid.UUID       `gorm:"type:uuid" json:"payment_request_id"`
+	Status           string           `gorm:"type:varchar(20);not null;default:'pending'" json:"status"` // Maps to PaymentRequestStatus constants
+	PaidAt           *time.Time       `json:"paid_at"`
+	LastRemindedAt   *time.Time       `json:"last_reminded_at"`
+	ReminderCount    int              `gorm:"not null;default:0" json:"reminder_count"`
+	CreatedAt        time.Time        `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt        time.Time        `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// ScheduledTransfer is a one-off future transfer or a recurring one, paid to either
+// a wallet directly or one of the user's beneficiaries
+type ScheduledTransfer struct {
+	ID               uuid.UUID       `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID           uuid.UUID       `gorm:"type:uuid;not null;index" json:"user_id"`
+	SenderWalletID   uuid.UUID       `gorm:"type:uuid;not null" json:"sender_wallet_id"`
+	ReceiverWalletID *uuid.UUID      `gorm:"type:uuid" json:"receiver_wallet_id,omitempty"`
+	BeneficiaryID    *uuid.UUID      `gorm:"type:uuid" json:"beneficiary_id,omitempty"`
+	Amount           decimal.Decimal `gorm:"type:numeric(18,2);not null" json:"amount"`
+	Currency         string          `gorm:"type:varchar(3);not null" json:"currency"`
+	Description      string          `gorm:"type:text" json:"description"`
+	Frequency        string          `gorm:"type:varchar(10);not null" json:"frequency"` // Maps to ScheduleFrequency constants
+	// Occurrences are counted from here; monthly schedules keep its day of month (clamped in shorter months)
+	StartAt   time.Time  `gorm:"not null" json:"start_at"`
+	NextRunAt time.Time  `gorm:"not null;index" json:"next_run_at"`
+	EndDate   *time.Time `json:"end_date"`
+	Status    string     `gorm:"type:varchar(20);not null;default:'active';index" json:"status"` // Maps to ScheduleStatus constants
+	// Retries of the current occurrence after insufficient funds
+	RetryCount        int        `gorm:"not null;default:0" json:"retry_count"`
+	RunCount          int        `gorm:"not null;default:0" json:"run_count"`
+	LastRunAt         *time.Time `json:"last_run_at"`
+	LastError         string     `gorm:"type:text" json:"last_error,omitempty"`
+	LastTransactionID *uuid.UUID `gorm:"type:uuid" json:"last_transaction_id,omitempty"`
+	CreatedAt         time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt         time.Time  `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// CardVaultEntry is the local card issuer simulator's vault. A real issuer keeps PAN/CVV on
+// its side; the simulator keeps them here, encrypted, and cards only ever reference the token.
+type CardVaultEntry struct {
+	VaultToken   string    `gorm:"primaryKey;type:varchar(64)" json:"-"`
+	EncryptedPAN string    `gorm:"type:text;not null" json:"-"`
+	EncryptedCVV string    `gorm:"type:text;not null" json:"-"`
+	CreatedAt    time.Time `gorm:"not null;default:now()" json:"-"`
 }
 
 // Transaction represents the transactions table in the database.
@@ -202,6 +505,13 @@ type Transaction struct {
 	Description string `gorm:"type:text" json:"description"`
 	ReferenceID string `gorm:"type:varchar(255);unique" json:"reference_id"`
 
+	// Card payment details, only set for TransactionTypePayment made with a card
+	CardID               *uuid.UUID `gorm:"type:uuid;index" json:"card_id,omitempty"`
+	MerchantName         string     `gorm:"type:varchar(255)" json:"merchant_name,omitempty"`
+	MerchantCategoryCode string     `gorm:"type:varchar(4)" json:"merchant_category_code,omitempty"`
+	MerchantCity         string     `gorm:"type:varchar(128)" json:"merchant_city,omitempty"`
+	MerchantCountry      string     `gorm:"type:varchar(2)" json:"merchant_country,omitempty"`
+
 	MadeAt    time.Time `gorm:"not null;default:now()" json:"made_at"`
 	CreatedAt time.Time `gorm:"not null;default:now()" json:"created_at"`
 	UpdatedAt time.Time `gorm:"not null;default:now()" json:"updated_at"`
@@ -221,17 +531,244 @@ type Session struct {
 	RefreshToken          string    `gorm:"type:text;not null" json:"refresh_token"`
 	RefreshTokenExpiresAt time.Time `gorm:"not null" json:"refresh_token_expires_at"`
 	IsActive              bool      `gorm:"default:true" json:"is_active"`
-	CreatedAt             time.Time `gorm:"not null;default:now()" json:"created_at"`
-	UpdatedAt             time.Time `gorm:"not null;default:now()" json:"updated_at"`
+	// FamilyID groups every session produced by rotating the same login's
+	// refresh token, so a replayed token can revoke the whole chain.
+	FamilyID     uuid.UUID  `gorm:"type:uuid;index" json:"family_id"`
+	ReplacedByID *uuid.UUID `gorm:"type:uuid" json:"replaced_by_id"`
+	LastSeenAt   time.Time  `gorm:"not null;default:now()" json:"last_seen_at"`
+	// Set when the user re-enters a 2FA code on this session for a sensitive operation
+	StepUpAt *time.Time `json:"step_up_at"`
+	CreatedAt    time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"not null;default:now()" json:"updated_at"`
 
 	// Associations
 	User User `gorm:"foreignKey:UserID;references:ID"`
 }
 
+// TwoFactorAuth holds a user's TOTP enrollment. It stays disabled until the first code is confirmed.
+type TwoFactorAuth struct {
+	ID           uuid.UUID  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID       uuid.UUID  `gorm:"type:uuid;unique;not null" json:"user_id"`
+	Secret       string     `gorm:"type:varchar(64);not null" json:"-"`
+	IsEnabled    bool       `gorm:"default:false" json:"is_enabled"`
+	LastUsedStep int64      `gorm:"not null;default:0" json:"-"` // Last accepted TOTP time step, blocks code replay
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	CreatedAt    time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"not null;default:now()" json:"updated_at"`
+
+	User User `gorm:"foreignKey:UserID;references:ID"`
+}
+
+// RecoveryCode is a hashed single-use fallback for when the authenticator app is unavailable
+type RecoveryCode struct {
+	ID        uuid.UUID  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"type:varchar(64);not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"not null;default:now()" json:"created_at"`
+}
+
+// VerificationToken is a single-use emailed token. Only a keyed hash of the token is stored.
+type VerificationToken struct {
+	ID        uuid.UUID  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Purpose   string     `gorm:"type:varchar(32);not null" json:"purpose"` // Maps to TokenPurpose constants
+	TokenHash string     `gorm:"type:varchar(64);unique;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	Attempts  int        `gorm:"not null;default:0" json:"attempts"` // Codes tried against a 2FA login challenge
+	CreatedAt time.Time  `gorm:"not null;default:now()" json:"created_at"`
+}
+
+// LoginThrottle tracks failed logins for one account (keyed by normalised email, so unknown
+// emails behave exactly like real ones) or one client IP, and wrong 2FA codes from signed-in users.
+type LoginThrottle struct {
+	ID            uuid.UUID  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Scope         string     `gorm:"type:varchar(16);not null;uniqueIndex:idx_login_throttles_scope_key" json:"scope"` // Maps to LoginThrottleScope constants
+	Key           string     `gorm:"type:varchar(255);not null;uniqueIndex:idx_login_throttles_scope_key" json:"key"`
+	FailedCount   int        `gorm:"not null;default:0" json:"failed_count"`
+	LastFailedAt  *time.Time `json:"last_failed_at"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"` // Progressive delay: attempts before this are refused
+	LockedUntil   *time.Time `json:"locked_until"`
+	CreatedAt     time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// KnownDevice is a browser/OS combination a user has signed in from, used to spot logins from new devices
+type KnownDevice struct {
+	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_known_devices_user_fingerprint" json:"user_id"`
+	Fingerprint string    `gorm:"type:varchar(128);not null;uniqueIndex:idx_known_devices_user_fingerprint" json:"fingerprint"`
+	LastIP      string    `gorm:"type:varchar(45)" json:"last_ip"`
+	FirstSeenAt time.Time `gorm:"not null;default:now()" json:"first_seen_at"`
+	LastSeenAt  time.Time `gorm:"not null;default:now()" json:"last_seen_at"`
+}
+
 type Notification struct {
-	Title       string    `gorm:"type:text;not null" json:"title"`
-	Description string    `gorm:"type:text;not null" json:"description"`
-	RecipientID uuid.UUID `gorm:"type:uuid;not null" json:"recipient_id"`
-	IsRead      bool      `gorm:"default:false" json:"is_read"`
-	CreatedAt   time.Time `gorm:"not null;default:now()" json:"created_at"`
+	ID          uuid.UUID           `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID           `gorm:"type:uuid;not null;index:idx_notifications_user_category,priority:1" json:"user_id"`
+	Type        string              `gorm:"type:varchar(64);not null;default:''" json:"type"`                                                             // Event type that produced it, e.g. transfer.received
+	Category    string              `gorm:"type:varchar(32);not null;default:'account';index:idx_notifications_user_category,priority:2" json:"category"` // Maps to NotificationCategory constants
+	Priority    string              `gorm:"type:varchar(16);not null;default:'normal'" json:"priority"`                                                   // Maps to NotificationPriority constants
+	Title       string              `gorm:"type:text;not null" json:"title"`
+	Description string              `gorm:"type:text;not null" json:"description"`
+	Payload     NotificationPayload `gorm:"serializer:json;type:jsonb" json:"payload"`
+	IsRead      bool                `gorm:"default:false" json:"is_read"`
+	ExpiresAt   *time.Time          `json:"expires_at"` // Hidden from the user once passed
+	CreatedAt   time.Time           `gorm:"not null;default:now()" json:"created_at"`
+}
+
+// NotificationPayload tells a client what a notification is about and where tapping it should go
+type NotificationPayload struct {
+	TargetType string            `json:"target_type,omitempty"` // e.g. transaction, card, payment_request
+	TargetID   string            `json:"target_id,omitempty"`
+	DeepLink   string            `json:"deep_link,omitempty"` // e.g. pgpockets://transactions/<id>
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+// NotificationPreference overrides the default for one category on one channel
+type NotificationPreference struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_notification_preferences_user_category_channel" json:"user_id"`
+	Category  string    `gorm:"type:varchar(32);not null;uniqueIndex:idx_notification_preferences_user_category_channel" json:"category"` // Maps to NotificationCategory constants
+	Channel   string    `gorm:"type:varchar(16);not null;uniqueIndex:idx_notification_preferences_user_category_channel" json:"channel"`  // Maps to NotificationChannel constants
+	Enabled   bool      `gorm:"not null" json:"enabled"`
+	UpdatedAt time.Time `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// QuietHours holds email, SMS and push for non-security notifications until the window ends
+type QuietHours struct {
+	UserID    uuid.UUID `gorm:"primaryKey;type:uuid" json:"-"`
+	Enabled   bool      `gorm:"not null" json:"enabled"`
+	StartTime string    `gorm:"type:varchar(5);not null" json:"start_time"` // HH:MM in Timezone
+	EndTime   string    `gorm:"type:varchar(5);not null" json:"end_time"`
+	Timezone  string    `gorm:"type:varchar(64);not null" json:"timezone"` // IANA name, e.g. Africa/Lagos
+	UpdatedAt time.Time `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// NotificationDelivery records one attempt-tracked send of a notification on one channel
+type NotificationDelivery struct {
+	ID            uuid.UUID           `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID        uuid.UUID           `gorm:"type:uuid;not null;index" json:"user_id"`
+	EventType     string              `gorm:"type:varchar(64);not null" json:"event_type"`
+	Category      string              `gorm:"type:varchar(32);not null" json:"category"`
+	Channel       string              `gorm:"type:varchar(16);not null" json:"channel"`
+	Recipient     string              `gorm:"type:varchar(255);not null" json:"recipient"` // Email address, phone number or user id
+	Priority      string              `gorm:"type:varchar(16);not null;default:'normal'" json:"priority"`
+	Title         string              `gorm:"type:text;not null" json:"title"`
+	Body          string              `gorm:"type:text;not null" json:"body"`
+	Payload       NotificationPayload `gorm:"serializer:json;type:jsonb" json:"payload"`
+	ExpiresAt     *time.Time          `json:"expires_at"`                                                                               // Deliveries still pending after this are skipped
+	Status        string              `gorm:"type:varchar(16);not null;index:idx_notification_deliveries_due,priority:1" json:"status"` // Maps to DeliveryStatus constants
+	Attempts      int                 `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time           `gorm:"not null;index:idx_notification_deliveries_due,priority:2" json:"next_attempt_at"`
+	LastError     string              `gorm:"type:text" json:"last_error,omitempty"`
+	SentAt        *time.Time          `json:"sent_at"`
+	CreatedAt     time.Time           `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt     time.Time           `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// RealtimeEvent is an update pushed to a user's live connections. Rows are kept for a day so
+// clients that reconnect can resume from the last id they saw.
+type RealtimeEvent struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement;index:idx_realtime_events_user_id_id,priority:2" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index:idx_realtime_events_user_id_id,priority:1" json:"user_id"`
+	Type      string    `gorm:"type:varchar(64);not null" json:"type"`
+	Payload   string    `gorm:"type:jsonb;not null" json:"payload"`
+	CreatedAt time.Time `gorm:"not null;default:now();index" json:"created_at"`
+}
+
+// WebhookEndpoint is a URL a user has registered to receive account events
+type WebhookEndpoint struct {
+	ID              uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID          uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	URL             string    `gorm:"type:varchar(2048);not null" json:"url"`
+	Description     string    `gorm:"type:varchar(255)" json:"description"`
+	EventTypes      []string  `gorm:"serializer:json;type:jsonb;not null" json:"event_types"`
+	EncryptedSecret string    `gorm:"type:text;not null" json:"-"`                              // Signing secret, encrypted with the app secret
+	Status          string    `gorm:"type:varchar(16);not null;default:'active'" json:"status"` // Maps to WebhookEndpointStatus constants
+	DisabledReason  string    `gorm:"type:varchar(255)" json:"disabled_reason,omitempty"`
+	// Failed attempts since the last success, and when that run of failures started
+	ConsecutiveFailures int        `gorm:"not null;default:0" json:"consecutive_failures"`
+	FailingSince        *time.Time `json:"failing_since"`
+	LastSuccessAt       *time.Time `json:"last_success_at"`
+	DisabledAt          *time.Time `json:"disabled_at"`
+	CreatedAt           time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt           time.Time  `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// WebhookDelivery is one event queued for one endpoint. The payload is stored exactly as signed and sent.
+type WebhookDelivery struct {
+	ID            uuid.UUID  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	EndpointID    uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_webhook_deliveries_endpoint_event" json:"endpoint_id"`
+	EventID       uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_webhook_deliveries_endpoint_event" json:"event_id"`
+	EventType     string     `gorm:"type:varchar(64);not null" json:"event_type"`
+	Payload       string     `gorm:"type:text;not null" json:"payload"`                                                   // Text rather than jsonb so the bytes are not normalised
+	Status        string     `gorm:"type:varchar(16);not null;index:idx_webhook_deliveries_due,priority:1" json:"status"` // Maps to DeliveryStatus constants
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_webhook_deliveries_due,priority:2" json:"next_attempt_at"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `gorm:"not null;default:now();index" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"not null;default:now()" json:"updated_at"`
+
+	AttemptLog []WebhookDeliveryAttempt `gorm:"foreignKey:DeliveryID" json:"attempt_log,omitempty"`
+}
+
+// WebhookDeliveryAttempt records one HTTP request made for a delivery
+type WebhookDeliveryAttempt struct {
+	ID             uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	DeliveryID     uuid.UUID `gorm:"type:uuid;not null;index" json:"delivery_id"`
+	ResponseStatus int       `json:"response_status"`                // Zero when no response was received
+	ResponseBody   string    `gorm:"type:text" json:"response_body"` // Truncated
+	Error          string    `gorm:"type:text" json:"error,omitempty"`
+	DurationMs     int64     `json:"duration_ms"`
+	CreatedAt      time.Time `gorm:"not null;default:now()" json:"created_at"`
+}
+
+// OutboxEvent is a domain event written in the same database transaction as the change it
+// describes. The relay publishes pending rows in sequence order once that transaction commits.
+type OutboxEvent struct {
+	Sequence      int64             `gorm:"primaryKey;autoIncrement" json:"sequence"`
+	EventID       uuid.UUID         `gorm:"type:uuid;not null;uniqueIndex" json:"event_id"`
+	Type          string            `gorm:"type:varchar(64);not null" json:"type"`
+	UserID        uuid.UUID         `gorm:"type:uuid;not null" json:"user_id"`
+	OccurredAt    time.Time         `gorm:"not null" json:"occurred_at"`
+	Data          map[string]string `gorm:"serializer:json;type:jsonb;not null" json:"data"`
+	Attempts      int               `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time         `gorm:"not null;default:now()" json:"next_attempt_at"`
+	LastError     string            `gorm:"type:text" json:"last_error,omitempty"`
+	PublishedAt   *time.Time        `gorm:"index" json:"published_at"`
+	// Set when the relay gave up on the event; it is skipped so later events are not held up
+	FailedAt  *time.Time `gorm:"index" json:"failed_at"`
+	CreatedAt time.Time  `gorm:"not null;default:now()" json:"created_at"`
+}
+
+// ProcessedEvent records that a consumer has handled an event, so redelivery from the outbox is a no-op
+type ProcessedEvent struct {
+	Consumer    string    `gorm:"primaryKey;type:varchar(64)" json:"consumer"`
+	EventID     uuid.UUID `gorm:"primaryKey;type:uuid" json:"event_id"`
+	ProcessedAt time.Time `gorm:"not null;default:now();index" json:"processed_at"`
+}
+
+// Job is a unit of background work in the Postgres-backed queue
+type Job struct {
+	ID       uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Queue    string    `gorm:"type:varchar(64);not null;index:idx_jobs_claim,priority:1" json:"queue"`
+	Type     string    `gorm:"type:varchar(128);not null;index" json:"type"`
+	Payload  string    `gorm:"type:jsonb;not null" json:"payload"`
+	Priority int       `gorm:"not null;default:0" json:"priority"`                                      // Higher runs first among due jobs
+	Status   string    `gorm:"type:varchar(16);not null;index:idx_jobs_claim,priority:2" json:"status"` // Maps to JobStatus constants
+	RunAt    time.Time `gorm:"not null;index:idx_jobs_claim,priority:3" json:"run_at"`
+	// At most one queued job per key, e.g. the next run of a recurring job
+	UniqueKey *string `gorm:"type:varchar(255)" json:"unique_key,omitempty"`
+	// A running job whose lease has passed is assumed lost with its worker and is run again
+	LockedUntil *time.Time `json:"locked_until"`
+	LockedBy    string     `gorm:"type:varchar(128)" json:"locked_by,omitempty"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts int        `gorm:"not null" json:"max_attempts"`
+	LastError   string     `gorm:"type:text" json:"last_error,omitempty"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `gorm:"not null;default:now();index" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"not null;default:now()" json:"updated_at"`
 }
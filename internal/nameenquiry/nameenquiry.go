@@ -0,0 +1,43 @@
+package nameenquiry
+
+import (
+	"errors"
+	"pgpockets/internal/config"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown name enquiry provider")
+	ErrUnknownBank     = errors.New("unknown bank code")
+	ErrAccountNotFound = errors.New("account not found")
+	ErrInvalidAccount  = errors.New("account number must be 10 digits")
+)
+
+type Bank struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// AccountName is the holder of an external bank account as reported by the bank
+type AccountName struct {
+	AccountNumber string `json:"account_number"`
+	BankCode      string `json:"bank_code"`
+	BankName      string `json:"bank_name"`
+	AccountName   string `json:"account_name"`
+}
+
+// NameEnquiry resolves a bank account to its holder's name so users can
+// confirm who they are paying before saving or sending to an account
+type NameEnquiry interface {
+	Name() string
+	Banks() []Bank
+	Resolve(accountNumber, bankCode string) (*AccountName, error)
+}
+
+// Builds the provider selected by NAME_ENQUIRY_PROVIDER. Only the local stub exists for now.
+func NewFromConfig(cfg config.Config) (NameEnquiry, error) {
+	switch cfg.NameEnquiryProvider {
+	case "stub", "":
+		return NewStub(), nil
+	}
+	return nil, ErrUnknownProvider
+}
@@ -0,0 +1,83 @@
+package nameenquiry
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+)
+
+const stubName = "stub"
+
+// Any account number starting with this prefix resolves as not found, for testing the failure path
+const stubNotFoundPrefix = "000"
+
+var stubBanks = []Bank{
+	{Code: "044", Name: "Access Bank"},
+	{Code: "058", Name: "Guaranty Trust Bank"},
+	{Code: "011", Name: "First Bank of Nigeria"},
+	{Code: "033", Name: "United Bank for Africa"},
+	{Code: "057", Name: "Zenith Bank"},
+	{Code: "50515", Name: "Moniepoint MFB"},
+	{Code: "999992", Name: "OPay"},
+}
+
+var stubFirstNames = []string{"Adaeze", "Babajide", "Chiamaka", "Damilola", "Emeka", "Funmilayo", "Ibrahim", "Kemi", "Olumide", "Zainab"}
+var stubLastNames = []string{"Adeyemi", "Bello", "Chukwu", "Eze", "Lawal", "Nwosu", "Okafor", "Olawale", "Suleiman", "Usman"}
+
+// stub answers name enquiries locally. The same account number always resolves
+// to the same made-up name so flows can be exercised repeatedly.
+type stub struct {
+	banks map[string]Bank
+}
+
+func NewStub() NameEnquiry {
+	banks := make(map[string]Bank, len(stubBanks))
+	for _, bank := range stubBanks {
+		banks[bank.Code] = bank
+	}
+	return &stub{banks: banks}
+}
+
+func (s *stub) Name() string {
+	return stubName
+}
+
+func (s *stub) Banks() []Bank {
+	return stubBanks
+}
+
+func (s *stub) Resolve(accountNumber, bankCode string) (*AccountName, error) {
+	if !isNUBAN(accountNumber) {
+		return nil, ErrInvalidAccount
+	}
+	bank, ok := s.banks[bankCode]
+	if !ok {
+		return nil, ErrUnknownBank
+	}
+	if strings.HasPrefix(accountNumber, stubNotFoundPrefix) {
+		return nil, ErrAccountNotFound
+	}
+
+	sum := sha256.Sum256([]byte(bankCode + ":" + accountNumber))
+	first := stubFirstNames[binary.BigEndian.Uint32(sum[0:4])%uint32(len(stubFirstNames))]
+	last := stubLastNames[binary.BigEndian.Uint32(sum[4:8])%uint32(len(stubLastNames))]
+	return &AccountName{
+		AccountNumber: accountNumber,
+		BankCode:      bank.Code,
+		BankName:      bank.Name,
+		AccountName:   strings.ToUpper(last + " " + first),
+	}, nil
+}
+
+// Nigerian bank account numbers (NUBAN) are 10 digits
+func isNUBAN(accountNumber string) bool {
+	if len(accountNumber) != 10 {
+		return false
+	}
+	for _, r := range accountNumber {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
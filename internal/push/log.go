@@ -0,0 +1,22 @@
+package push
+
+import "go.uber.org/zap"
+
+// logSender is for local development. It only logs the notifications it is asked to send.
+type logSender struct {
+	logger *zap.Logger
+}
+
+func NewLogSender(logger *zap.Logger) PushSender {
+	return &logSender{logger: logger}
+}
+
+func (s *logSender) Send(msg Message) error {
+	s.logger.Info("Push notification sent (log sender)",
+		zap.String("external_user_id", msg.ExternalUserID),
+		zap.String("title", msg.Title),
+		zap.String("body", msg.Body),
+		zap.Any("data", msg.Data),
+	)
+	return nil
+}
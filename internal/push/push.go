@@ -0,0 +1,36 @@
+package push
+
+import (
+	"errors"
+	"pgpockets/internal/config"
+
+	"go.uber.org/zap"
+)
+
+var ErrUnknownDriver = errors.New("unknown push driver")
+
+/*
+	Message is addressed to a user rather than a device. The push provider keeps track
+	of which devices a user's app is signed in on, keyed by the external user id the
+	app registers with.
+*/
+type Message struct {
+	ExternalUserID string
+	Title          string
+	Body           string
+	Data           map[string]string
+}
+
+// PushSender delivers push notifications to a user's devices. Implementations must be safe for concurrent use.
+type PushSender interface {
+	Send(msg Message) error
+}
+
+// Builds the sender selected by PUSH_DRIVER. Only the local log sender exists for now.
+func NewFromConfig(cfg config.Config, logger *zap.Logger) (PushSender, error) {
+	switch cfg.PushDriver {
+	case "log", "":
+		return NewLogSender(logger), nil
+	}
+	return nil, ErrUnknownDriver
+}
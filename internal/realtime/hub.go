@@ -0,0 +1,289 @@
+/*
+Package realtime pushes live updates to users' open WebSocket and SSE connections.
+
+Every update is stored in realtime_events and announced with Postgres NOTIFY. Each
+server instance LISTENs on the channel and hands the update to the connections it
+holds, so a user connected to one instance sees changes made on another. The stored
+rows let a client that reconnects ask for everything after the last id it saw.
+*/
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Message types pushed to clients
+const (
+	TypeNotification   = "notification.created"
+	TypeBalanceChanged = "wallet.balance_changed"
+	TypeTransaction    = "transaction.updated"
+	// Sent instead of a replay when a resuming client has missed too much; it should refetch over REST
+	TypeResync = "stream.resync"
+)
+
+const (
+	notifyChannel = "realtime_events"
+	// Postgres caps NOTIFY payloads at 8000 bytes; larger messages are sent by id only
+	maxNotifyPayload = 7000
+	subscriberBuffer = 64
+	maxListenBackoff = 30 * time.Second
+)
+
+// Message is one update for a user. ID increases monotonically and is what clients resume from.
+type Message struct {
+	ID        int64           `json:"id"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Publisher is what services depend on to push updates
+type Publisher interface {
+	Publish(userID uuid.UUID, messageType string, data interface{}) error
+}
+
+/*
+Subscriber receives a user's messages on C. If the client falls so far behind that
+the buffer fills, C is closed; the client is expected to reconnect and resume from
+the last id it received.
+*/
+type Subscriber struct {
+	C      <-chan Message
+	ch     chan Message
+	userID uuid.UUID
+	closed bool
+}
+
+type Hub struct {
+	repo   repositories.RealtimeEventRepository
+	db     *gorm.DB
+	dsn    string
+	logger *zap.Logger
+
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[*Subscriber]struct{}
+	closed      bool
+}
+
+func NewHub(repo repositories.RealtimeEventRepository, db *gorm.DB, dsn string, logger *zap.Logger) *Hub {
+	return &Hub{
+		repo:        repo,
+		db:          db,
+		dsn:         dsn,
+		logger:      logger,
+		subscribers: make(map[uuid.UUID]map[*Subscriber]struct{}),
+	}
+}
+
+// Stores the message and announces it to every instance once the insert commits
+func (h *Hub) Publish(userID uuid.UUID, messageType string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	event := &models.RealtimeEvent{
+		UserID:  userID,
+		Type:    messageType,
+		Payload: string(body),
+	}
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		repo := h.repo.WithTx(tx)
+		if err := repo.Create(event); err != nil {
+			return err
+		}
+		msg := toMessage(event)
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if len(payload) > maxNotifyPayload {
+			msg.Data = nil
+			if payload, err = json.Marshal(msg); err != nil {
+				return err
+			}
+		}
+		return repo.Notify(notifyChannel, string(payload))
+	})
+}
+
+func (h *Hub) Subscribe(userID uuid.UUID) *Subscriber {
+	ch := make(chan Message, subscriberBuffer)
+	sub := &Subscriber{C: ch, ch: ch, userID: userID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		sub.closed = true
+		close(ch)
+		return sub
+	}
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*Subscriber]struct{})
+	}
+	h.subscribers[userID][sub] = struct{}{}
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+/*
+Close ends every open stream by closing its subscriber, and any opened afterwards
+straight away, so that long-lived connections do not hold up a server shutdown.
+Clients reconnect to another instance and resume from the last id they received.
+*/
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subscribers {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
+
+// Must be called with h.mu held
+func (h *Hub) remove(sub *Subscriber) {
+	if subs := h.subscribers[sub.userID]; subs != nil {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(h.subscribers, sub.userID)
+		}
+	}
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+	}
+}
+
+// Returns the user's stored messages after afterID, oldest first
+func (h *Hub) Since(userID uuid.UUID, afterID int64, limit int) ([]Message, error) {
+	events, err := h.repo.ListSince(userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]Message, len(events))
+	for i := range events {
+		messages[i] = toMessage(&events[i])
+	}
+	return messages, nil
+}
+
+func (h *Hub) dispatch(msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers[msg.UserID] {
+		select {
+		case sub.ch <- msg:
+		default:
+			h.logger.Warn("Realtime subscriber fell behind, disconnecting",
+				zap.String("user_id", msg.UserID.String()))
+			h.remove(sub)
+		}
+	}
+}
+
+/*
+Listen holds a dedicated connection LISTENing for messages from every instance and
+reconnects with backoff until ctx is cancelled. Messages published while the
+listener is down are not pushed live but remain available to resuming clients.
+*/
+func (h *Hub) Listen(ctx context.Context) {
+	backoff := time.Second
+	for {
+		connected, err := h.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		h.logger.Error("Realtime listener disconnected", zap.Error(err), zap.Duration("retry_in", backoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < maxListenBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+func (h *Hub) listen(ctx context.Context) (bool, error) {
+	conn, err := pgx.Connect(ctx, h.dsn)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		return false, err
+	}
+	h.logger.Info("Realtime listener connected")
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		var msg Message
+		if err := json.Unmarshal([]byte(notification.Payload), &msg); err != nil {
+			h.logger.Warn("Ignoring malformed realtime notification", zap.Error(err))
+			continue
+		}
+		if msg.Data == nil {
+			event, err := h.repo.GetByID(msg.ID)
+			if err != nil {
+				h.logger.Error("Failed to load realtime event", zap.Int64("id", msg.ID), zap.Error(err))
+				continue
+			}
+			msg = toMessage(event)
+		}
+		h.dispatch(msg)
+	}
+}
+
+// Deletes stored messages older than retention until ctx is cancelled
+func (h *Hub) RunPruning(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := h.repo.DeleteOlderThan(time.Now().Add(-retention))
+			if err != nil {
+				h.logger.Error("Failed to prune realtime events", zap.Error(err))
+			} else if deleted > 0 {
+				h.logger.Info("Pruned realtime events", zap.Int64("count", deleted))
+			}
+		}
+	}
+}
+
+func toMessage(event *models.RealtimeEvent) Message {
+	return Message{
+		ID:        event.ID,
+		UserID:    event.UserID,
+		Type:      event.Type,
+		Data:      json.RawMessage(event.Payload),
+		CreatedAt: event.CreatedAt,
+	}
+}
@@ -2,14 +2,25 @@ package repositories
 
 import (
 	"pgpockets/internal/models"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// BeneficiaryFilter narrows a user's beneficiary list. Zero values match everything.
+type BeneficiaryFilter struct {
+	Kind          string
+	FavoritesOnly bool
+	Search        string // Matched against nickname and account name
+}
+
 type BeneficiaryRepository interface {
 	Create(beneficiary *models.Beneficiary) (*models.Beneficiary, error)
-	GetBeneficiaries(userID string) ([]models.Beneficiary, error)
+	GetBeneficiaries(userID uuid.UUID, filter BeneficiaryFilter) ([]models.Beneficiary, error)
+	GetBeneficiaryByID(beneID uuid.UUID, userID uuid.UUID) (*models.Beneficiary, error)
+	GetBeneficiaryByTargetKey(userID uuid.UUID, targetKey string) (*models.Beneficiary, error)
+	UpdateBeneficiary(beneficiary *models.Beneficiary, columns ...string) error
 	DeleteBeneficiary(beneID uuid.UUID, userID uuid.UUID) error 
 }
 
@@ -29,19 +40,56 @@ func (r *beneficiaryRepository) Create(beneficiary *models.Beneficiary) (*models
 	return beneficiary, nil
 }
 
-func (r *beneficiaryRepository) GetBeneficiaries(userID string) ([]models.Beneficiary, error) {
+// Favorites first, then most recently added
+func (r *beneficiaryRepository) GetBeneficiaries(userID uuid.UUID, filter BeneficiaryFilter) ([]models.Beneficiary, error) {
 	var beneficiaries []models.Beneficiary
-	if err := r.db.Where("user_id = ?", userID).Find(&beneficiaries).Error; err != nil {
+	query := r.db.Where("user_id = ?", userID)
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if filter.FavoritesOnly {
+		query = query.Where("is_favorite = ?", true)
+	}
+	if filter.Search != "" {
+		pattern := "%" + filter.Search + "%"
+		query = query.Where("nickname ILIKE ? OR account_name ILIKE ?", pattern, pattern)
+	}
+	if err := query.Order("is_favorite DESC, created_at DESC").Find(&beneficiaries).Error; err != nil {
 		return nil, err
 	}
 	return beneficiaries, nil
 }
 
-// Deletes a beneficiary by their beneficiary ID
-func (r *beneficiaryRepository) DeleteBeneficiary(beneID uuid.UUID, userID uuid.UUID) error {
-	if err := r.db.Where("id = ? AND user_id = ?", beneID, userID).Delete(&models.Beneficiary{}).Error; err != nil {
-		return err
+func (r *beneficiaryRepository) GetBeneficiaryByID(beneID uuid.UUID, userID uuid.UUID) (*models.Beneficiary, error) {
+	var beneficiary models.Beneficiary
+	if err := r.db.Where("id = ? AND user_id = ?", beneID, userID).First(&beneficiary).Error; err != nil {
+		return nil, err
 	}
+	return &beneficiary, nil
+}
 
+func (r *beneficiaryRepository) GetBeneficiaryByTargetKey(userID uuid.UUID, targetKey string) (*models.Beneficiary, error) {
+	var beneficiary models.Beneficiary
+	if err := r.db.Where("user_id = ? AND target_key = ?", userID, targetKey).First(&beneficiary).Error; err != nil {
+		return nil, err
+	}
+	return &beneficiary, nil
+}
+
+// Writes only the named columns
+func (r *beneficiaryRepository) UpdateBeneficiary(beneficiary *models.Beneficiary, columns ...string) error {
+	beneficiary.UpdatedAt = time.Now()
+	return r.db.Model(beneficiary).Select(append(columns, "updated_at")).Updates(beneficiary).Error
+}
+
+// Deletes a beneficiary by their beneficiary ID. Returns ErrRecordNotFound if the user has no such beneficiary.
+func (r *beneficiaryRepository) DeleteBeneficiary(beneID uuid.UUID, userID uuid.UUID) error {
+	result := r.db.Where("id = ? AND user_id = ?", beneID, userID).Delete(&models.Beneficiary{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	return nil
 }
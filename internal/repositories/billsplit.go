@@ -0,0 +1,97 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type BillSplitRepository interface {
+	Create(split *models.BillSplit) error
+	GetByID(id uuid.UUID) (*models.BillSplit, error)
+	ListForUser(userID uuid.UUID, status string) ([]models.BillSplit, error)
+	ListOpen(after *models.BillSplit, limit int) ([]models.BillSplit, error)
+	UpdateSplit(split *models.BillSplit, columns ...string) error
+	UpdateParticipant(participant *models.BillSplitParticipant, columns ...string) error
+	Transition(id uuid.UUID, from, to string) (bool, error)
+}
+
+type billSplitRepository struct {
+	db *gorm.DB
+}
+
+func NewBillSplitRepository(db *gorm.DB) BillSplitRepository {
+	return &billSplitRepository{db: db}
+}
+
+// Creates the split together with its participants
+func (r *billSplitRepository) Create(split *models.BillSplit) error {
+	return r.db.Create(split).Error
+}
+
+func (r *billSplitRepository) GetByID(id uuid.UUID) (*models.BillSplit, error) {
+	var split models.BillSplit
+	if err := r.db.Preload("Participants", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC")
+	}).Where("id = ?", id).First(&split).Error; err != nil {
+		return nil, err
+	}
+	return &split, nil
+}
+
+// Splits the user created or takes part in
+func (r *billSplitRepository) ListForUser(userID uuid.UUID, status string) ([]models.BillSplit, error) {
+	query := r.db.Preload("Participants").
+		Where("creator_id = ? OR id IN (?)", userID,
+			r.db.Model(&models.BillSplitParticipant{}).Select("split_id").Where("user_id = ?", userID))
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var splits []models.BillSplit
+	if err := query.Order("created_at DESC").Find(&splits).Error; err != nil {
+		return nil, err
+	}
+	return splits, nil
+}
+
+// Lists open splits oldest first, starting after the given split so callers can page through all of them
+func (r *billSplitRepository) ListOpen(after *models.BillSplit, limit int) ([]models.BillSplit, error) {
+	var splits []models.BillSplit
+	query := r.db.Preload("Participants").Where("status = ?", models.BillSplitStatusOpen)
+	if after != nil {
+		query = query.Where("(created_at, id) > (?, ?)", after.CreatedAt, after.ID)
+	}
+	if err := query.Order("created_at ASC, id ASC").
+		Limit(limit).
+		Find(&splits).Error; err != nil {
+		return nil, err
+	}
+	return splits, nil
+}
+
+func (r *billSplitRepository) UpdateSplit(split *models.BillSplit, columns ...string) error {
+	split.UpdatedAt = time.Now()
+	return r.db.Model(split).Select(append(columns, "updated_at")).Updates(split).Error
+}
+
+func (r *billSplitRepository) UpdateParticipant(participant *models.BillSplitParticipant, columns ...string) error {
+	participant.UpdatedAt = time.Now()
+	return r.db.Model(participant).Select(append(columns, "updated_at")).Updates(participant).Error
+}
+
+// Moves a split between statuses only if nobody else already has
+func (r *billSplitRepository) Transition(id uuid.UUID, from, to string) (bool, error) {
+	result := r.db.Model(&models.BillSplit{}).
+		Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{
+			"status":     to,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
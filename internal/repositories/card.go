@@ -1,16 +1,26 @@
 package repositories
 
 import (
+	"fmt"
 	"pgpockets/internal/models"
+	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CardRepository interface {
+	WithTx(tx *gorm.DB) CardRepository
 	CreateCard(card *models.Card) error
 	RetrieveAllCards(userID string) ([]*models.Card, error)
 	GetCardByID(cardID string) (models.Card, error) 
-	DeleteCard(cardID string) error
+	GetCardByIDForUpdate(cardID uuid.UUID) (*models.Card, error)
+	UpdateCard(card *models.Card, columns ...string) error
+	ListCardsExpiringBefore(yearMonth string, skip []uuid.UUID, limit int) ([]models.Card, error)
+	MarkExpiredBefore(yearMonth string) (int64, error)
+	MarkRevealed(cardID uuid.UUID) error
+	GetCardByToken(cardToken string) (*models.Card, error)
 }
 
 
@@ -24,6 +34,11 @@ func NewCardRepository(db *gorm.DB) CardRepository {
 	}
 }
 
+// WithTx returns a copy of the repository bound to an open database transaction
+func (r *cardRepository) WithTx(tx *gorm.DB) CardRepository {
+	return &cardRepository{db: tx}
+}
+
 func (r *cardRepository) CreateCard(card *models.Card) error {
 	if err := r.db.Create(card).Error; err != nil {
 		return err
@@ -48,9 +63,85 @@ func (r *cardRepository) GetCardByID(cardID string) (models.Card, error) {
 	return card, nil
 }
 
-func (r *cardRepository) DeleteCard(cardID string) error {
-	if err := r.db.Where("id = ?", cardID).Delete(&models.Card{}).Error; err != nil {
+// Locks the card row for the rest of the transaction
+func (r *cardRepository) GetCardByIDForUpdate(cardID uuid.UUID) (*models.Card, error) {
+	var card models.Card
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", cardID).First(&card).Error; err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+/*
+	Writes only the named columns so concurrent changes to other fields are not overwritten.
+	GORM silently skips names it cannot map to a field, so those are refused here instead of
+	the change quietly not being saved.
+*/
+func (r *cardRepository) UpdateCard(card *models.Card, columns ...string) error {
+	stmt := &gorm.Statement{DB: r.db}
+	if err := stmt.Parse(card); err != nil {
 		return err
 	}
+	for _, column := range columns {
+		if field := stmt.Schema.LookUpField(column); field == nil || field.DBName != column {
+			return fmt.Errorf("card has no column %q", column)
+		}
+	}
+	card.UpdatedAt = time.Now()
+	return r.db.Model(card).Select(append(columns, "updated_at")).Updates(card).Error
+}
+
+// Records that a card's details were revealed. Fails with ErrRecordNotFound if they already were.
+func (r *cardRepository) MarkRevealed(cardID uuid.UUID) error {
+	result := r.db.Model(&models.Card{}).
+		Where("id = ? AND revealed_at IS NULL", cardID).
+		Update("revealed_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (r *cardRepository) GetCardByToken(cardToken string) (*models.Card, error) {
+	var card models.Card
+	if err := r.db.Where("card_token = ?", cardToken).First(&card).Error; err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// Expiry is stored as separate MM and YYYY strings, so "YYYYMM" is compared lexically.
+// A card is valid through its expiry month, i.e. it has expired once that month is before the current one.
+const cardExpiryYearMonth = "expiry_year || lpad(expiry_month, 2, '0')"
+
+// Live cards whose expiry month is before yearMonth and whose owner has not been notified yet,
+// leaving out the skip IDs
+func (r *cardRepository) ListCardsExpiringBefore(yearMonth string, skip []uuid.UUID, limit int) ([]models.Card, error) {
+	var cards []models.Card
+	query := r.db.Where("status IN ? AND expiry_notified_at IS NULL", []string{models.CardStatusActive, models.CardStatusFrozen}).
+		Where(cardExpiryYearMonth+" < ?", yearMonth)
+	if len(skip) > 0 {
+		query = query.Where("id NOT IN ?", skip)
+	}
+	if err := query.Order(cardExpiryYearMonth).
+		Limit(limit).
+		Find(&cards).Error; err != nil {
+		return nil, err
+	}
+	return cards, nil
+}
+
+func (r *cardRepository) MarkExpiredBefore(yearMonth string) (int64, error) {
+	result := r.db.Model(&models.Card{}).
+		Where("status IN ?", []string{models.CardStatusActive, models.CardStatusFrozen}).
+		Where(cardExpiryYearMonth+" < ?", yearMonth).
+		Updates(map[string]interface{}{
+			"status":     models.CardStatusExpired,
+			"is_active":  false,
+			"updated_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
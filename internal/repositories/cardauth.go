@@ -0,0 +1,112 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CardAuthorizationRepository interface {
+	WithTx(tx *gorm.DB) CardAuthorizationRepository
+	Create(auth *models.CardAuthorization) error
+	GetByID(id uuid.UUID) (*models.CardAuthorization, error)
+	GetByIssuerReference(reference string) (*models.CardAuthorization, error)
+	GetByIDForUpdate(id uuid.UUID) (*models.CardAuthorization, error)
+	Update(auth *models.CardAuthorization) error
+	ListByCardID(cardID uuid.UUID, limit, offset int) ([]models.CardAuthorization, error)
+	ListExpiredPending(now time.Time, limit int) ([]models.CardAuthorization, error)
+	SumSpendSince(cardID uuid.UUID, since time.Time) (decimal.Decimal, error)
+}
+
+type cardAuthorizationRepository struct {
+	db *gorm.DB
+}
+
+func NewCardAuthorizationRepository(db *gorm.DB) CardAuthorizationRepository {
+	return &cardAuthorizationRepository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to an open database transaction
+func (r *cardAuthorizationRepository) WithTx(tx *gorm.DB) CardAuthorizationRepository {
+	return &cardAuthorizationRepository{db: tx}
+}
+
+func (r *cardAuthorizationRepository) Create(auth *models.CardAuthorization) error {
+	return r.db.Create(auth).Error
+}
+
+func (r *cardAuthorizationRepository) GetByID(id uuid.UUID) (*models.CardAuthorization, error) {
+	var auth models.CardAuthorization
+	if err := r.db.Where("id = ?", id).First(&auth).Error; err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+func (r *cardAuthorizationRepository) GetByIssuerReference(reference string) (*models.CardAuthorization, error) {
+	var auth models.CardAuthorization
+	if err := r.db.Where("issuer_reference = ?", reference).First(&auth).Error; err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+// Locks the authorization row for the rest of the transaction
+func (r *cardAuthorizationRepository) GetByIDForUpdate(id uuid.UUID) (*models.CardAuthorization, error) {
+	var auth models.CardAuthorization
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&auth).Error; err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+func (r *cardAuthorizationRepository) Update(auth *models.CardAuthorization) error {
+	auth.UpdatedAt = time.Now()
+	return r.db.Save(auth).Error
+}
+
+func (r *cardAuthorizationRepository) ListByCardID(cardID uuid.UUID, limit, offset int) ([]models.CardAuthorization, error) {
+	var auths []models.CardAuthorization
+	if err := r.db.Where("card_id = ?", cardID).
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&auths).Error; err != nil {
+		return nil, err
+	}
+	return auths, nil
+}
+
+// Pending authorizations whose hold has outlived its expiry, oldest first
+func (r *cardAuthorizationRepository) ListExpiredPending(now time.Time, limit int) ([]models.CardAuthorization, error) {
+	var auths []models.CardAuthorization
+	if err := r.db.Where("status = ? AND expires_at <= ?", models.AuthorizationStatusPending, now).
+		Order("expires_at ASC").
+		Limit(limit).
+		Find(&auths).Error; err != nil {
+		return nil, err
+	}
+	return auths, nil
+}
+
+// Total committed spend on a card since the given time: held amounts of pending
+// authorizations plus the settled amounts of captured ones
+func (r *cardAuthorizationRepository) SumSpendSince(cardID uuid.UUID, since time.Time) (decimal.Decimal, error) {
+	var total decimal.NullDecimal
+	err := r.db.Model(&models.CardAuthorization{}).
+		Select("SUM(CASE WHEN status = ? THEN amount WHEN status = ? THEN captured_amount ELSE 0 END)",
+			models.AuthorizationStatusPending, models.AuthorizationStatusCaptured).
+		Where("card_id = ? AND created_at >= ?", cardID, since).
+		Row().Scan(&total)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if !total.Valid {
+		return decimal.Zero, nil
+	}
+	return total.Decimal, nil
+}
@@ -0,0 +1,198 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type JobFilter struct {
+	Queue  string
+	Type   string
+	Status string
+}
+
+// JobCount is the number of jobs in one queue with one status
+type JobCount struct {
+	Queue  string `json:"queue"`
+	Status string `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+type JobRepository interface {
+	WithTx(tx *gorm.DB) JobRepository
+	Create(job *models.Job) error
+	// Inserts the job unless one with the same unique key is already queued; reports whether it did
+	CreateUnique(job *models.Job) (bool, error)
+	GetByID(id uuid.UUID) (*models.Job, error)
+	List(filter JobFilter, limit, offset int) ([]models.Job, int64, error)
+	CountByStatus() ([]JobCount, error)
+	// Locks up to limit due jobs in the queue for workerID, highest priority first
+	Claim(queue, workerID string, now, leaseUntil time.Time, limit int) ([]models.Job, error)
+	ExtendLease(id uuid.UUID, workerID string, leaseUntil time.Time) error
+	// The outcome is only written while workerID still holds the job, so a worker whose lease
+	// ran out cannot overwrite the result of the one that took over
+	Complete(id uuid.UUID, workerID string, at time.Time) error
+	Retry(id uuid.UUID, workerID string, runAt time.Time, lastError string) error
+	Bury(id uuid.UUID, workerID string, lastError string) error
+	// Puts a dead or completed job back in its queue with a fresh set of attempts
+	Requeue(id uuid.UUID, runAt time.Time) (bool, error)
+	DeleteCompletedBefore(cutoff time.Time) (int64, error)
+}
+
+type jobRepository struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) JobRepository {
+	return &jobRepository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to an open database transaction
+func (r *jobRepository) WithTx(tx *gorm.DB) JobRepository {
+	return &jobRepository{db: tx}
+}
+
+func (r *jobRepository) Create(job *models.Job) error {
+	return r.db.Create(job).Error
+}
+
+func (r *jobRepository) CreateUnique(job *models.Job) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "unique_key"}},
+		// A literal predicate, so Postgres can match it to the partial unique index
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "status = '" + models.JobStatusQueued + "'"}}},
+		DoNothing:   true,
+	}).Create(job)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *jobRepository) GetByID(id uuid.UUID) (*models.Job, error) {
+	var job models.Job
+	if err := r.db.Where("id = ?", id).First(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *jobRepository) List(filter JobFilter, limit, offset int) ([]models.Job, int64, error) {
+	query := r.db.Model(&models.Job{})
+	if filter.Queue != "" {
+		query = query.Where("queue = ?", filter.Queue)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	var jobs []models.Job
+	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&jobs).Error; err != nil {
+		return nil, 0, err
+	}
+	return jobs, count, nil
+}
+
+func (r *jobRepository) CountByStatus() ([]JobCount, error) {
+	var counts []JobCount
+	err := r.db.Model(&models.Job{}).
+		Select("queue, status, COUNT(*) AS count").
+		Group("queue, status").
+		Order("queue, status").
+		Scan(&counts).Error
+	return counts, err
+}
+
+func (r *jobRepository) Claim(queue, workerID string, now, leaseUntil time.Time, limit int) ([]models.Job, error) {
+	var jobs []models.Job
+	err := r.db.Raw(`
+		UPDATE jobs
+		SET status = ?, locked_by = ?, locked_until = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE queue = ? AND (
+				(status = ? AND run_at <= ?) OR
+				(status = ? AND locked_until <= ?)
+			)
+			ORDER BY priority DESC, run_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		models.JobStatusRunning, workerID, leaseUntil, now,
+		queue, models.JobStatusQueued, now, models.JobStatusRunning, now, limit,
+	).Scan(&jobs).Error
+	return jobs, err
+}
+
+func (r *jobRepository) ExtendLease(id uuid.UUID, workerID string, leaseUntil time.Time) error {
+	return r.db.Model(&models.Job{}).
+		Where("id = ? AND status = ? AND locked_by = ?", id, models.JobStatusRunning, workerID).
+		Update("locked_until", leaseUntil).Error
+}
+
+func (r *jobRepository) Complete(id uuid.UUID, workerID string, at time.Time) error {
+	return r.finish(id, workerID, map[string]interface{}{
+		"status":       models.JobStatusCompleted,
+		"completed_at": at,
+		"last_error":   "",
+	})
+}
+
+func (r *jobRepository) Retry(id uuid.UUID, workerID string, runAt time.Time, lastError string) error {
+	return r.finish(id, workerID, map[string]interface{}{
+		"status":     models.JobStatusQueued,
+		"run_at":     runAt,
+		"last_error": lastError,
+	})
+}
+
+func (r *jobRepository) Bury(id uuid.UUID, workerID string, lastError string) error {
+	return r.finish(id, workerID, map[string]interface{}{
+		"status":     models.JobStatusDead,
+		"last_error": lastError,
+	})
+}
+
+func (r *jobRepository) finish(id uuid.UUID, workerID string, updates map[string]interface{}) error {
+	updates["locked_by"] = ""
+	updates["locked_until"] = nil
+	updates["updated_at"] = time.Now()
+	return r.db.Model(&models.Job{}).
+		Where("id = ? AND status = ? AND locked_by = ?", id, models.JobStatusRunning, workerID).
+		Updates(updates).Error
+}
+
+func (r *jobRepository) Requeue(id uuid.UUID, runAt time.Time) (bool, error) {
+	result := r.db.Model(&models.Job{}).
+		Where("id = ? AND status IN ?", id, []string{models.JobStatusDead, models.JobStatusCompleted}).
+		Updates(map[string]interface{}{
+			"status":       models.JobStatusQueued,
+			"run_at":       runAt,
+			"attempts":     0,
+			"completed_at": nil,
+			// A requeued run is a one-off; the key stays with whichever job is already queued
+			"unique_key": nil,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *jobRepository) DeleteCompletedBefore(cutoff time.Time) (int64, error) {
+	result := r.db.Where("status = ? AND completed_at < ?", models.JobStatusCompleted, cutoff).Delete(&models.Job{})
+	return result.RowsAffected, result.Error
+}
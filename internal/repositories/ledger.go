@@ -0,0 +1,76 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// WalletTotals sets a wallet's stored balances beside what its transactions and pending card holds add up to
+type WalletTotals struct {
+	WalletID      uuid.UUID       `json:"wallet_id"`
+	UserID        uuid.UUID       `json:"user_id"`
+	Currency      string          `json:"currency"`
+	Balance       decimal.Decimal `json:"balance"`
+	LedgerBalance decimal.Decimal `json:"ledger_balance"` // Completed credits minus completed debits
+	HeldBalance   decimal.Decimal `json:"held_balance"`
+	PendingHolds  decimal.Decimal `json:"pending_holds"` // Sum of pending card authorizations
+}
+
+type LedgerRepository interface {
+	CountWallets() (int64, error)
+	// Only wallets whose stored balances disagree with their transactions or holds
+	ListMismatchedWallets() ([]WalletTotals, error)
+}
+
+type ledgerRepository struct {
+	db *gorm.DB
+}
+
+func NewLedgerRepository(db *gorm.DB) LedgerRepository {
+	return &ledgerRepository{db: db}
+}
+
+func (r *ledgerRepository) CountWallets() (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Wallet{}).Count(&count).Error
+	return count, err
+}
+
+func (r *ledgerRepository) ListMismatchedWallets() ([]WalletTotals, error) {
+	var totals []WalletTotals
+	err := r.db.Raw(`
+		SELECT * FROM (
+			SELECT
+				w.id AS wallet_id,
+				w.user_id,
+				w.currency,
+				w.balance,
+				COALESCE(credits.total, 0) - COALESCE(debits.total, 0) AS ledger_balance,
+				w.held_balance,
+				COALESCE(holds.total, 0) AS pending_holds
+			FROM wallets w
+			LEFT JOIN (
+				SELECT receiver_wallet_id AS wallet_id, SUM(amount) AS total
+				FROM transactions WHERE status = ? AND receiver_wallet_id IS NOT NULL
+				GROUP BY receiver_wallet_id
+			) credits ON credits.wallet_id = w.id
+			LEFT JOIN (
+				SELECT sender_wallet_id AS wallet_id, SUM(amount) AS total
+				FROM transactions WHERE status = ? AND sender_wallet_id IS NOT NULL
+				GROUP BY sender_wallet_id
+			) debits ON debits.wallet_id = w.id
+			LEFT JOIN (
+				SELECT wallet_id, SUM(amount) AS total
+				FROM card_authorizations WHERE status = ?
+				GROUP BY wallet_id
+			) holds ON holds.wallet_id = w.id
+		) totals
+		WHERE balance <> ledger_balance OR held_balance <> pending_holds
+		ORDER BY wallet_id`,
+		models.TransactionStatusCompleted, models.TransactionStatusCompleted, models.AuthorizationStatusPending,
+	).Scan(&totals).Error
+	return totals, err
+}
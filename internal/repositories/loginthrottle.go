@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LoginThrottleRepository interface {
+	Get(scope, key string) (*models.LoginThrottle, error)
+	RecordFailure(scope, key string, update func(throttle *models.LoginThrottle)) (*models.LoginThrottle, error)
+	Reset(scope, key string) error
+}
+
+type loginThrottleRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginThrottleRepository(db *gorm.DB) LoginThrottleRepository {
+	return &loginThrottleRepository{db: db}
+}
+
+func (r *loginThrottleRepository) Get(scope, key string) (*models.LoginThrottle, error) {
+	var throttle models.LoginThrottle
+	if err := r.db.Where("scope = ? AND key = ?", scope, key).First(&throttle).Error; err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// RecordFailure loads (or creates) the row under a row lock, lets update apply the
+// throttling policy to it and saves the result, so concurrent failures are all counted.
+func (r *loginThrottleRepository) RecordFailure(
+	scope, key string,
+	update func(throttle *models.LoginThrottle),
+) (*models.LoginThrottle, error) {
+	var throttle models.LoginThrottle
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.LoginThrottle{Scope: scope, Key: key}).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("scope = ? AND key = ?", scope, key).
+			First(&throttle).Error; err != nil {
+			return err
+		}
+		update(&throttle)
+		throttle.UpdatedAt = time.Now()
+		return tx.Save(&throttle).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+func (r *loginThrottleRepository) Reset(scope, key string) error {
+	return r.db.Where("scope = ? AND key = ?", scope, key).Delete(&models.LoginThrottle{}).Error
+}
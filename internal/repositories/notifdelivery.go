@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type NotificationPreferenceRepository interface {
+	ListByUserID(userID uuid.UUID) ([]models.NotificationPreference, error)
+	Upsert(preferences []models.NotificationPreference) error
+	GetQuietHours(userID uuid.UUID) (*models.QuietHours, error)
+	SaveQuietHours(quietHours *models.QuietHours) error
+}
+
+type notificationPreferenceRepository struct {
+	db *gorm.DB
+}
+
+func NewNotificationPreferenceRepository(db *gorm.DB) NotificationPreferenceRepository {
+	return &notificationPreferenceRepository{db: db}
+}
+
+func (r *notificationPreferenceRepository) ListByUserID(userID uuid.UUID) ([]models.NotificationPreference, error) {
+	var preferences []models.NotificationPreference
+	err := r.db.Where("user_id = ?", userID).Find(&preferences).Error
+	return preferences, err
+}
+
+func (r *notificationPreferenceRepository) Upsert(preferences []models.NotificationPreference) error {
+	if len(preferences) == 0 {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "category"}, {Name: "channel"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled", "updated_at"}),
+	}).Create(&preferences).Error
+}
+
+func (r *notificationPreferenceRepository) GetQuietHours(userID uuid.UUID) (*models.QuietHours, error) {
+	var quietHours models.QuietHours
+	if err := r.db.Where("user_id = ?", userID).First(&quietHours).Error; err != nil {
+		return nil, err
+	}
+	return &quietHours, nil
+}
+
+func (r *notificationPreferenceRepository) SaveQuietHours(quietHours *models.QuietHours) error {
+	quietHours.UpdatedAt = time.Now()
+	return r.db.Save(quietHours).Error
+}
+
+type NotificationDeliveryRepository interface {
+	Create(delivery *models.NotificationDelivery) error
+	Claim(id uuid.UUID, now, leaseUntil time.Time) (bool, error)
+	ClaimDue(now, leaseUntil time.Time, limit int) ([]models.NotificationDelivery, error)
+	Update(delivery *models.NotificationDelivery, columns ...string) error
+	ListByUserID(userID uuid.UUID, status, channel string, limit, offset int) ([]models.NotificationDelivery, int64, error)
+}
+
+type notificationDeliveryRepository struct {
+	db *gorm.DB
+}
+
+func NewNotificationDeliveryRepository(db *gorm.DB) NotificationDeliveryRepository {
+	return &notificationDeliveryRepository{db: db}
+}
+
+func (r *notificationDeliveryRepository) Create(delivery *models.NotificationDelivery) error {
+	return r.db.Create(delivery).Error
+}
+
+/*
+	Takes a due delivery for one attempt. The attempt is counted and the delivery pushed
+	out to leaseUntil, so if the process dies mid-send it becomes due again once the lease
+	runs out instead of being stuck.
+*/
+func (r *notificationDeliveryRepository) Claim(id uuid.UUID, now, leaseUntil time.Time) (bool, error) {
+	result := r.db.Model(&models.NotificationDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, models.DeliveryStatusPending, now).
+		Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": leaseUntil,
+			"updated_at":      now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Claims up to limit due deliveries; SKIP LOCKED lets several workers share the queue
+func (r *notificationDeliveryRepository) ClaimDue(now, leaseUntil time.Time, limit int) ([]models.NotificationDelivery, error) {
+	var deliveries []models.NotificationDelivery
+	err := r.db.Raw(`
+		UPDATE notification_deliveries
+		SET attempts = attempts + 1, next_attempt_at = ?, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM notification_deliveries
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		leaseUntil, now, models.DeliveryStatusPending, now, limit,
+	).Scan(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *notificationDeliveryRepository) Update(delivery *models.NotificationDelivery, columns ...string) error {
+	delivery.UpdatedAt = time.Now()
+	return r.db.Model(delivery).Select(append(columns, "updated_at")).Updates(delivery).Error
+}
+
+func (r *notificationDeliveryRepository) ListByUserID(userID uuid.UUID, status, channel string, limit, offset int) ([]models.NotificationDelivery, int64, error) {
+	query := r.db.Model(&models.NotificationDelivery{}).Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if channel != "" {
+		query = query.Where("channel = ?", channel)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	var deliveries []models.NotificationDelivery
+	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	return deliveries, count, nil
+}
@@ -2,24 +2,38 @@ package repositories
 
 import (
 	"pgpockets/internal/models"
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// Empty fields do not filter. From and To bound created_at.
+type NotificationFilter struct {
+    Category string
+    Priority string
+    From     *time.Time
+    To       *time.Time
+}
+
 type NotifRepo interface {
+    Create(notification *models.Notification) error
+
     GetNotificationCount(userID uuid.UUID, includeRead bool) (int64, error)
     GetUnreadNotificationCount(userID uuid.UUID) (int64, error)
 
     GetByID(id uuid.UUID, userID uuid.UUID) (*models.Notification, error)
-    GetByUserID(userID uuid.UUID, limit, offset int) ([]models.Notification, error)
-    GetUnreadByUserID(userID uuid.UUID, limit, offset int) ([]models.Notification, error)
+    GetByUserID(userID uuid.UUID, filter NotificationFilter, limit, offset int) ([]models.Notification, error)
+    GetUnreadByUserID(userID uuid.UUID, filter NotificationFilter, limit, offset int) ([]models.Notification, error)
 
     UpdateReadStatus(id uuid.UUID, userID uuid.UUID, isRead bool) error
-    MarkAllAsReadByUserID(userID uuid.UUID) (int64, error)
+    // An empty category applies to every category
+    MarkAllAsReadByUserID(userID uuid.UUID, category string) (int64, error)
 
     DeleteByID(id uuid.UUID, userID uuid.UUID) error
-    DeleteAllByUserID(userID uuid.UUID) (int64, error)
-    DeleteAllReadByUserID(userID uuid.UUID) (int64, error)
+    DeleteAllByUserID(userID uuid.UUID, category string) (int64, error)
+    DeleteAllReadByUserID(userID uuid.UUID, category string) (int64, error)
+    DeleteExpired(now time.Time) (int64, error)
 }
 
 type notifRepo struct {
@@ -30,9 +44,36 @@ func NewNotifRepo(db *gorm.DB) NotifRepo {
     return &notifRepo{db: db}
 }
 
+func (r *notifRepo) Create(notification *models.Notification) error {
+    return r.db.Create(notification).Error
+}
+
+// The user's notifications that have not expired; expired rows stay until DeleteExpired clears them
+func (r *notifRepo) live(userID uuid.UUID) *gorm.DB {
+    return r.db.Model(&models.Notification{}).
+	Where("user_id = ? AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now())
+}
+
+func (r *notifRepo) filtered(userID uuid.UUID, filter NotificationFilter) *gorm.DB {
+    query := r.live(userID)
+    if filter.Category != "" {
+        query = query.Where("category = ?", filter.Category)
+    }
+    if filter.Priority != "" {
+        query = query.Where("priority = ?", filter.Priority)
+    }
+    if filter.From != nil {
+        query = query.Where("created_at >= ?", *filter.From)
+    }
+    if filter.To != nil {
+        query = query.Where("created_at < ?", *filter.To)
+    }
+    return query
+}
+
 func (r *notifRepo) GetNotificationCount(userID uuid.UUID, includeRead bool) (int64, error) {
     var count int64
-    query := r.db.Model(&models.Notification{}).Where("user_id = ?", userID)
+    query := r.live(userID)
     if !includeRead {
         query = query.Where("is_read = ?", false)
     }
@@ -54,9 +95,9 @@ func (r *notifRepo) GetByID(id uuid.UUID, userID uuid.UUID) (*models.Notificatio
     return &notification, nil
 }
 
-func (r *notifRepo) GetByUserID(userID uuid.UUID, limit, offset int) ([]models.Notification, error) {
+func (r *notifRepo) GetByUserID(userID uuid.UUID, filter NotificationFilter, limit, offset int) ([]models.Notification, error) {
     var notifications []models.Notification
-    if err := r.db.Where("user_id = ?", userID).
+    if err := r.filtered(userID, filter).
         Limit(limit).
         Offset(offset).
         Order("created_at DESC").
@@ -66,9 +107,10 @@ func (r *notifRepo) GetByUserID(userID uuid.UUID, limit, offset int) ([]models.N
     return notifications, nil
 }
 
-func (r *notifRepo) GetUnreadByUserID(userID uuid.UUID, limit, offset int) ([]models.Notification, error) {
+func (r *notifRepo) GetUnreadByUserID(userID uuid.UUID, filter NotificationFilter, limit, offset int) ([]models.Notification, error) {
     var notifications []models.Notification
-    if err := r.db.Where("user_id = ? AND is_read = ?", userID, false).
+    if err := r.filtered(userID, filter).
+        Where("is_read = ?", false).
         Limit(limit).
         Offset(offset).
         Order("created_at DESC").
@@ -91,9 +133,9 @@ func (r *notifRepo) UpdateReadStatus(id uuid.UUID, userID uuid.UUID, isRead bool
     return nil
 }
 
-func (r *notifRepo) MarkAllAsReadByUserID(userID uuid.UUID) (int64, error) {
-    result := r.db.Model(&models.Notification{}).
-	Where("user_id = ? AND is_read = ?", userID, false).
+func (r *notifRepo) MarkAllAsReadByUserID(userID uuid.UUID, category string) (int64, error) {
+    result := r.filtered(userID, NotificationFilter{Category: category}).
+	Where("is_read = ?", false).
 	Update("is_read", true)
     return result.RowsAffected, result.Error
 }
@@ -109,12 +151,25 @@ func (r *notifRepo) DeleteByID(id uuid.UUID, userID uuid.UUID) error {
     return nil
 }
 
-func (r *notifRepo) DeleteAllByUserID(userID uuid.UUID) (int64, error) {
-    result := r.db.Where("user_id = ?", userID).Delete(&models.Notification{})
+func (r *notifRepo) DeleteAllByUserID(userID uuid.UUID, category string) (int64, error) {
+    query := r.db.Where("user_id = ?", userID)
+    if category != "" {
+        query = query.Where("category = ?", category)
+    }
+    result := query.Delete(&models.Notification{})
+    return result.RowsAffected, result.Error
+}
+
+func (r *notifRepo) DeleteAllReadByUserID(userID uuid.UUID, category string) (int64, error) {
+    query := r.db.Where("user_id = ? AND is_read = ?", userID, true)
+    if category != "" {
+        query = query.Where("category = ?", category)
+    }
+    result := query.Delete(&models.Notification{})
     return result.RowsAffected, result.Error
 }
 
-func (r *notifRepo) DeleteAllReadByUserID(userID uuid.UUID) (int64, error) {
-    result := r.db.Where("user_id = ? AND is_read = ?", userID, true).Delete(&models.Notification{})
+func (r *notifRepo) DeleteExpired(now time.Time) (int64, error) {
+    result := r.db.Where("expires_at <= ?", now).Delete(&models.Notification{})
     return result.RowsAffected, result.Error
-}
\ No newline at end of file
+}
@@ -0,0 +1,99 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Arbitrary key for the advisory lock that lets only one relay publish at a time
+const outboxRelayLockKey = 7_301_046
+
+type OutboxRepository interface {
+	WithTx(tx *gorm.DB) OutboxRepository
+	Create(event *models.OutboxEvent) error
+	// Takes the relay lock until the open transaction ends; false when another instance holds it
+	TryLockRelay() (bool, error)
+	// Events not yet published or given up on, oldest first
+	ListPending(limit int) ([]models.OutboxEvent, error)
+	MarkPublished(sequence int64, at time.Time) error
+	RecordFailure(event *models.OutboxEvent) error
+	DeletePublishedBefore(cutoff time.Time) (int64, error)
+
+	HasProcessed(consumer string, eventID uuid.UUID) (bool, error)
+	MarkProcessed(consumer string, eventID uuid.UUID) error
+	DeleteProcessedBefore(cutoff time.Time) (int64, error)
+}
+
+type outboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) OutboxRepository {
+	return &outboxRepository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to an open database transaction
+func (r *outboxRepository) WithTx(tx *gorm.DB) OutboxRepository {
+	return &outboxRepository{db: tx}
+}
+
+func (r *outboxRepository) Create(event *models.OutboxEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *outboxRepository) TryLockRelay() (bool, error) {
+	var locked bool
+	err := r.db.Raw("SELECT pg_try_advisory_xact_lock(?)", outboxRelayLockKey).Scan(&locked).Error
+	return locked, err
+}
+
+func (r *outboxRepository) ListPending(limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := r.db.Where("published_at IS NULL AND failed_at IS NULL").
+		Order("sequence ASC").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+func (r *outboxRepository) MarkPublished(sequence int64, at time.Time) error {
+	return r.db.Model(&models.OutboxEvent{}).Where("sequence = ?", sequence).Updates(map[string]interface{}{
+		"published_at": at,
+		"last_error":   "",
+	}).Error
+}
+
+func (r *outboxRepository) RecordFailure(event *models.OutboxEvent) error {
+	return r.db.Model(event).Select("attempts", "next_attempt_at", "last_error", "failed_at").Updates(event).Error
+}
+
+// Failed events are kept for inspection
+func (r *outboxRepository) DeletePublishedBefore(cutoff time.Time) (int64, error) {
+	result := r.db.Where("published_at < ?", cutoff).Delete(&models.OutboxEvent{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *outboxRepository) HasProcessed(consumer string, eventID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.ProcessedEvent{}).
+		Where("consumer = ? AND event_id = ?", consumer, eventID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *outboxRepository) MarkProcessed(consumer string, eventID uuid.UUID) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ProcessedEvent{
+		Consumer:    consumer,
+		EventID:     eventID,
+		ProcessedAt: time.Now(),
+	}).Error
+}
+
+func (r *outboxRepository) DeleteProcessedBefore(cutoff time.Time) (int64, error) {
+	result := r.db.Where("processed_at < ?", cutoff).Delete(&models.ProcessedEvent{})
+	return result.RowsAffected, result.Error
+}
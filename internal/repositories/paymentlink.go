@@ -0,0 +1,111 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type PaymentLinkRepository interface {
+	Create(link *models.PaymentLink) error
+	GetByID(id, userID uuid.UUID) (*models.PaymentLink, error)
+	GetByCode(code string) (*models.PaymentLink, error)
+	ListByUserID(userID uuid.UUID, status string) ([]models.PaymentLink, error)
+	Update(link *models.PaymentLink, columns ...string) error
+	Transition(id uuid.UUID, from, to string) (bool, error)
+	RecordPayment(id uuid.UUID, amount decimal.Decimal, paidAt time.Time, singleUse bool) (bool, error)
+	WithTx(tx *gorm.DB) PaymentLinkRepository
+}
+
+type paymentLinkRepository struct {
+	db *gorm.DB
+}
+
+func NewPaymentLinkRepository(db *gorm.DB) PaymentLinkRepository {
+	return &paymentLinkRepository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to an open database transaction
+func (r *paymentLinkRepository) WithTx(tx *gorm.DB) PaymentLinkRepository {
+	return &paymentLinkRepository{db: tx}
+}
+
+func (r *paymentLinkRepository) Create(link *models.PaymentLink) error {
+	return r.db.Create(link).Error
+}
+
+func (r *paymentLinkRepository) GetByID(id, userID uuid.UUID) (*models.PaymentLink, error) {
+	var link models.PaymentLink
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&link).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (r *paymentLinkRepository) GetByCode(code string) (*models.PaymentLink, error) {
+	var link models.PaymentLink
+	if err := r.db.Where("code = ?", code).First(&link).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (r *paymentLinkRepository) ListByUserID(userID uuid.UUID, status string) ([]models.PaymentLink, error) {
+	query := r.db.Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var links []models.PaymentLink
+	if err := query.Order("created_at DESC").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (r *paymentLinkRepository) Update(link *models.PaymentLink, columns ...string) error {
+	link.UpdatedAt = time.Now()
+	return r.db.Model(link).Select(append(columns, "updated_at")).Updates(link).Error
+}
+
+// Moves a link between statuses only if it is still in the expected one, so a
+// single-use link cannot be paid twice by concurrent payers
+func (r *paymentLinkRepository) Transition(id uuid.UUID, from, to string) (bool, error) {
+	result := r.db.Model(&models.PaymentLink{}).
+		Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{
+			"status":     to,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+/*
+	Adds a payment to the link's running totals in one statement so concurrent payments all count,
+	and uses up a single-use link in the same statement. Reports false, changing nothing, when the
+	link is no longer active or has expired.
+*/
+func (r *paymentLinkRepository) RecordPayment(id uuid.UUID, amount decimal.Decimal, paidAt time.Time, singleUse bool) (bool, error) {
+	updates := map[string]interface{}{
+		"use_count":       gorm.Expr("use_count + 1"),
+		"total_collected": gorm.Expr("total_collected + ?", amount),
+		"last_paid_at":    paidAt,
+		"updated_at":      paidAt,
+	}
+	if singleUse {
+		updates["status"] = models.PaymentLinkStatusUsed
+	}
+	result := r.db.Model(&models.PaymentLink{}).
+		Where("id = ? AND status = ? AND (expires_at IS NULL OR expires_at > ?)", id, models.PaymentLinkStatusActive, paidAt).
+		Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
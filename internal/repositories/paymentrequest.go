@@ -0,0 +1,129 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Which side of a payment request the user is on
+const (
+	PaymentRequestIncoming = "incoming"
+	PaymentRequestOutgoing = "outgoing"
+)
+
+type PaymentRequestRepository interface {
+	Create(request *models.PaymentRequest) error
+	GetByID(id uuid.UUID) (*models.PaymentRequest, error)
+	ListForUser(userID uuid.UUID, direction, status string, limit, offset int) ([]models.PaymentRequest, error)
+	Update(request *models.PaymentRequest, columns ...string) error
+	Transition(id uuid.UUID, from, to string) (bool, error)
+	MarkPaid(id, transactionID uuid.UUID, now time.Time) (bool, error)
+	ExpirePending(now time.Time) ([]models.PaymentRequest, error)
+	WithTx(tx *gorm.DB) PaymentRequestRepository
+}
+
+type paymentRequestRepository struct {
+	db *gorm.DB
+}
+
+func NewPaymentRequestRepository(db *gorm.DB) PaymentRequestRepository {
+	return &paymentRequestRepository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to an open database transaction
+func (r *paymentRequestRepository) WithTx(tx *gorm.DB) PaymentRequestRepository {
+	return &paymentRequestRepository{db: tx}
+}
+
+func (r *paymentRequestRepository) Create(request *models.PaymentRequest) error {
+	return r.db.Create(request).Error
+}
+
+func (r *paymentRequestRepository) GetByID(id uuid.UUID) (*models.PaymentRequest, error) {
+	var request models.PaymentRequest
+	if err := r.db.Where("id = ?", id).First(&request).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// Lists requests the user sent, received or both when direction is empty
+func (r *paymentRequestRepository) ListForUser(userID uuid.UUID, direction, status string, limit, offset int) ([]models.PaymentRequest, error) {
+	query := r.db.Model(&models.PaymentRequest{})
+	switch direction {
+	case PaymentRequestIncoming:
+		query = query.Where("payer_id = ?", userID)
+	case PaymentRequestOutgoing:
+		query = query.Where("requester_id = ?", userID)
+	default:
+		query = query.Where("payer_id = ? OR requester_id = ?", userID, userID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var requests []models.PaymentRequest
+	if err := query.Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&requests).Error; err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (r *paymentRequestRepository) Update(request *models.PaymentRequest, columns ...string) error {
+	request.UpdatedAt = time.Now()
+	return r.db.Model(request).Select(append(columns, "updated_at")).Updates(request).Error
+}
+
+// Moves a request between statuses only if it is still in the expected one, so two
+// responses racing on the same request cannot both win
+func (r *paymentRequestRepository) Transition(id uuid.UUID, from, to string) (bool, error) {
+	result := r.db.Model(&models.PaymentRequest{}).
+		Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{
+			"status":       to,
+			"responded_at": time.Now(),
+			"updated_at":   time.Now(),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Marks a request paid by the given transaction, only while it is still pending and unexpired
+func (r *paymentRequestRepository) MarkPaid(id, transactionID uuid.UUID, now time.Time) (bool, error) {
+	result := r.db.Model(&models.PaymentRequest{}).
+		Where("id = ? AND status = ? AND expires_at > ?", id, models.PaymentRequestStatusPending, now).
+		Updates(map[string]interface{}{
+			"status":         models.PaymentRequestStatusPaid,
+			"transaction_id": transactionID,
+			"responded_at":   now,
+			"updated_at":     now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Marks every pending request past its expiry as expired and returns the ones that changed
+func (r *paymentRequestRepository) ExpirePending(now time.Time) ([]models.PaymentRequest, error) {
+	var expired []models.PaymentRequest
+	if err := r.db.Model(&expired).
+		Clauses(clause.Returning{}).
+		Where("status = ? AND expires_at <= ?", models.PaymentRequestStatusPending, now).
+		Updates(map[string]interface{}{
+			"status":     models.PaymentRequestStatusExpired,
+			"updated_at": now,
+		}).Error; err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
@@ -9,6 +9,7 @@ import (
 type ProfileRepository interface {
 	GetProfileByUserID(userID string) (*models.Profile, error)
 	UpdateProfile(profile *models.Profile) error
+	GetProfileByPhoneNumber(phoneNumber string) (*models.Profile, error)
 }
 
 type profileRepository struct {
@@ -34,4 +35,12 @@ func (r *profileRepository) UpdateProfile(profile *models.Profile) error {
 		return err
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (r *profileRepository) GetProfileByPhoneNumber(phoneNumber string) (*models.Profile, error) {
+	var profile models.Profile
+	if err := r.db.Where("phone_number = ?", phoneNumber).First(&profile).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
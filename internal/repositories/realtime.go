@@ -0,0 +1,61 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type RealtimeEventRepository interface {
+	WithTx(tx *gorm.DB) RealtimeEventRepository
+	Create(event *models.RealtimeEvent) error
+	GetByID(id int64) (*models.RealtimeEvent, error)
+	ListSince(userID uuid.UUID, afterID int64, limit int) ([]models.RealtimeEvent, error)
+	// Notify sends a Postgres NOTIFY; inside a transaction it is only delivered on commit
+	Notify(channel, payload string) error
+	DeleteOlderThan(cutoff time.Time) (int64, error)
+}
+
+type realtimeEventRepository struct {
+	db *gorm.DB
+}
+
+func NewRealtimeEventRepository(db *gorm.DB) RealtimeEventRepository {
+	return &realtimeEventRepository{db: db}
+}
+
+func (r *realtimeEventRepository) WithTx(tx *gorm.DB) RealtimeEventRepository {
+	return &realtimeEventRepository{db: tx}
+}
+
+func (r *realtimeEventRepository) Create(event *models.RealtimeEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *realtimeEventRepository) GetByID(id int64) (*models.RealtimeEvent, error) {
+	var event models.RealtimeEvent
+	if err := r.db.First(&event, id).Error; err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (r *realtimeEventRepository) ListSince(userID uuid.UUID, afterID int64, limit int) ([]models.RealtimeEvent, error) {
+	var events []models.RealtimeEvent
+	err := r.db.Where("user_id = ? AND id > ?", userID, afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+func (r *realtimeEventRepository) Notify(channel, payload string) error {
+	return r.db.Exec("SELECT pg_notify(?, ?)", channel, payload).Error
+}
+
+func (r *realtimeEventRepository) DeleteOlderThan(cutoff time.Time) (int64, error) {
+	result := r.db.Where("created_at < ?", cutoff).Delete(&models.RealtimeEvent{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ScheduledTransferRepository interface {
+	Create(schedule *models.ScheduledTransfer) error
+	GetByID(id, userID uuid.UUID) (*models.ScheduledTransfer, error)
+	ListByUserID(userID uuid.UUID, status string) ([]models.ScheduledTransfer, error)
+	Update(schedule *models.ScheduledTransfer, columns ...string) error
+	ListDue(now time.Time, limit int) ([]models.ScheduledTransfer, error)
+	Claim(id uuid.UUID, dueAt, leaseUntil time.Time) (bool, error)
+	RecordRun(schedule *models.ScheduledTransfer, leaseUntil time.Time, columns ...string) (bool, error)
+	WithTx(tx *gorm.DB) ScheduledTransferRepository
+}
+
+type scheduledTransferRepository struct {
+	db *gorm.DB
+}
+
+func NewScheduledTransferRepository(db *gorm.DB) ScheduledTransferRepository {
+	return &scheduledTransferRepository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to an open database transaction
+func (r *scheduledTransferRepository) WithTx(tx *gorm.DB) ScheduledTransferRepository {
+	return &scheduledTransferRepository{db: tx}
+}
+
+func (r *scheduledTransferRepository) Create(schedule *models.ScheduledTransfer) error {
+	return r.db.Create(schedule).Error
+}
+
+func (r *scheduledTransferRepository) GetByID(id, userID uuid.UUID) (*models.ScheduledTransfer, error) {
+	var schedule models.ScheduledTransfer
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&schedule).Error; err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// Lists a user's schedules, soonest first. An empty status lists all of them.
+func (r *scheduledTransferRepository) ListByUserID(userID uuid.UUID, status string) ([]models.ScheduledTransfer, error) {
+	var schedules []models.ScheduledTransfer
+	query := r.db.Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Order("next_run_at ASC").Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// Writes only the named columns
+func (r *scheduledTransferRepository) Update(schedule *models.ScheduledTransfer, columns ...string) error {
+	schedule.UpdatedAt = time.Now()
+	return r.db.Model(schedule).Select(append(columns, "updated_at")).Updates(schedule).Error
+}
+
+func (r *scheduledTransferRepository) ListDue(now time.Time, limit int) ([]models.ScheduledTransfer, error) {
+	var schedules []models.ScheduledTransfer
+	if err := r.db.Where("status = ? AND next_run_at <= ?", models.ScheduleStatusActive, now).
+		Order("next_run_at ASC").
+		Limit(limit).
+		Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+/*
+	Takes a due schedule for this worker by pushing its next_run_at out to leaseUntil.
+	Only succeeds if nobody else has claimed or changed it since it was listed,
+	so several workers can poll the same table without running a schedule twice.
+*/
+func (r *scheduledTransferRepository) Claim(id uuid.UUID, dueAt, leaseUntil time.Time) (bool, error) {
+	result := r.db.Model(&models.ScheduledTransfer{}).
+		Where("id = ? AND status = ? AND next_run_at = ?", id, models.ScheduleStatusActive, dueAt).
+		Update("next_run_at", leaseUntil)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+/*
+	Writes the outcome of a run, but only if the schedule is still active and still holds the
+	lease this worker claimed it with. Returns false when the owner paused, cancelled or edited
+	it in the meantime, in which case their change is kept.
+*/
+func (r *scheduledTransferRepository) RecordRun(schedule *models.ScheduledTransfer, leaseUntil time.Time, columns ...string) (bool, error) {
+	schedule.UpdatedAt = time.Now()
+	result := r.db.Model(schedule).
+		Where("status = ? AND next_run_at = ?", models.ScheduleStatusActive, leaseUntil).
+		Select(append(columns, "updated_at")).
+		Updates(schedule)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
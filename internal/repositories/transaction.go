@@ -24,6 +24,7 @@ type TransactionRepository interface {
     ) (*[]models.Transaction, error)
 	UpdateTransactionStatus(walletID uuid.UUID, newStatus string) error
 	VerifyOwnership(userID, walletID uuid.UUID) error
+	WithTx(tx *gorm.DB) TransactionRepository
 }
 
 type transactionRepository struct {
@@ -34,6 +35,11 @@ func NewTransactionRepository(db *gorm.DB) TransactionRepository {
 	return &transactionRepository{db: db}
 }
 
+// WithTx returns a copy of the repository bound to an open database transaction
+func (r *transactionRepository) WithTx(tx *gorm.DB) TransactionRepository {
+	return &transactionRepository{db: tx}
+}
+
 func (r *transactionRepository) CreateTransaction(transaction *models.Transaction) (*models.Transaction, error) {
 	if err := r.db.Create(transaction).Error; err != nil {
 		return nil, err
@@ -0,0 +1,141 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type TwoFactorRepository interface {
+	GetByUserID(userID uuid.UUID) (*models.TwoFactorAuth, error)
+	Upsert(twoFactor *models.TwoFactorAuth) error
+	Enable(userID uuid.UUID, step int64) error
+	Disable(userID uuid.UUID) error
+	MarkStepUsed(userID uuid.UUID, step int64) error
+	ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error
+	UseRecoveryCode(userID uuid.UUID, codeHash string) error
+	CountUnusedRecoveryCodes(userID uuid.UUID) (int64, error)
+	MarkSessionSteppedUp(sessionID uuid.UUID) error
+	GetSessionStepUpAt(sessionID uuid.UUID) (*time.Time, error)
+}
+
+type twoFactorRepository struct {
+	db *gorm.DB
+}
+
+func NewTwoFactorRepository(db *gorm.DB) TwoFactorRepository {
+	return &twoFactorRepository{db: db}
+}
+
+func (r *twoFactorRepository) GetByUserID(userID uuid.UUID) (*models.TwoFactorAuth, error) {
+	var twoFactor models.TwoFactorAuth
+	if err := r.db.Where("user_id = ?", userID).First(&twoFactor).Error; err != nil {
+		return nil, err
+	}
+	return &twoFactor, nil
+}
+
+// Creates or replaces a user's pending enrollment
+func (r *twoFactorRepository) Upsert(twoFactor *models.TwoFactorAuth) error {
+	existing, err := r.GetByUserID(twoFactor.UserID)
+	if err == gorm.ErrRecordNotFound {
+		return r.db.Create(twoFactor).Error
+	}
+	if err != nil {
+		return err
+	}
+	return r.db.Model(existing).Updates(map[string]interface{}{
+		"secret":         twoFactor.Secret,
+		"is_enabled":     false,
+		"last_used_step": 0,
+		"confirmed_at":   nil,
+		"updated_at":     time.Now(),
+	}).Error
+}
+
+func (r *twoFactorRepository) Enable(userID uuid.UUID, step int64) error {
+	now := time.Now()
+	return r.db.Model(&models.TwoFactorAuth{}).
+		Where("user_id = ?", userID).
+		Updates(map[string]interface{}{
+			"is_enabled":     true,
+			"last_used_step": step,
+			"confirmed_at":   now,
+			"updated_at":     now,
+		}).Error
+}
+
+// Removes the enrollment and every recovery code along with it
+func (r *twoFactorRepository) Disable(userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.TwoFactorAuth{}).Error
+	})
+}
+
+// Records a TOTP step as used. Fails with ErrRecordNotFound if the step (or a later one) was already used.
+func (r *twoFactorRepository) MarkStepUsed(userID uuid.UUID, step int64) error {
+	result := r.db.Model(&models.TwoFactorAuth{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]models.RecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, models.RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// Burns a recovery code. Fails with ErrRecordNotFound if it does not exist or was already used.
+func (r *twoFactorRepository) UseRecoveryCode(userID uuid.UUID, codeHash string) error {
+	result := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) CountUnusedRecoveryCodes(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *twoFactorRepository) MarkSessionSteppedUp(sessionID uuid.UUID) error {
+	return r.db.Model(&models.Session{}).
+		Where("id = ?", sessionID).
+		Update("step_up_at", time.Now()).Error
+}
+
+func (r *twoFactorRepository) GetSessionStepUpAt(sessionID uuid.UUID) (*time.Time, error) {
+	var session models.Session
+	if err := r.db.Select("step_up_at").Where("id = ?", sessionID).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return session.StepUpAt, nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -15,17 +16,30 @@ var (
 	ErrPasswordHashFailed    = errors.New("failed to process password securely")
 	ErrTokenGenerationFailed = errors.New("failed to generate authentication token")
 	ErrUserNotFound          = errors.New("user not found")
+	ErrSessionAlreadyRotated = errors.New("session has already been rotated")
 )
 
 type UserRepository interface {
 	CreateUser(user *models.User) error
 	GetUserByEmail(email string) (*models.User, error)
+	GetUserByID(id uuid.UUID) (*models.User, error)
+	MarkEmailVerified(userID uuid.UUID) error
+	UpdatePassword(userID uuid.UUID, passwordHash string) error
 	DeleteUser(id uuid.UUID) error
 	CreateProfile(profile *models.Profile) error
 	CreateSession(session *models.Session) error
 	DeleteSession(userID uuid.UUID) error
 	GetActiveSessionByToken(accessToken string) (*models.Session, error)
 	RefreshSession(refreshToken string) (*models.Session, error)
+	GetSessionByRefreshToken(refreshToken string) (*models.Session, error)
+	RotateSession(oldSessionID uuid.UUID, newSession *models.Session) error
+	RevokeSessionFamily(familyID uuid.UUID) error
+	GetActiveSessionsByUserID(userID uuid.UUID) ([]models.Session, error)
+	RevokeSession(sessionID, userID uuid.UUID) error
+	RevokeOtherSessions(userID, keepSessionID uuid.UUID) (int64, error)
+	TouchSession(sessionID uuid.UUID) error
+	CountKnownDevices(userID uuid.UUID) (int64, error)
+	RecordKnownDevice(device *models.KnownDevice) (bool, error)
 }
 
 func (u *gormUserRepository) CreateProfile(profile *models.Profile) error {
@@ -67,6 +81,24 @@ func (r *gormUserRepository) GetUserByID(id uuid.UUID) (*models.User, error) {
 	return &user, nil
 }
 
+func (r *gormUserRepository) MarkEmailVerified(userID uuid.UUID) error {
+	return r.db.Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"is_email_verified": true,
+			"updated_at":        time.Now(),
+		}).Error
+}
+
+func (r *gormUserRepository) UpdatePassword(userID uuid.UUID, passwordHash string) error {
+	return r.db.Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"password_hash": passwordHash,
+			"updated_at":    time.Now(),
+		}).Error
+}
+
 func (r *gormUserRepository) CreateSession(session *models.Session) error {
 	return r.db.Create(session).Error
 }
@@ -95,6 +127,124 @@ func (r *gormUserRepository) RefreshSession(refreshToken string) (*models.Sessio
     return &session, nil
 }
 
+// GetSessionByRefreshToken looks a session up by refresh token regardless of
+// whether it is still active, so rotated tokens can be recognised on replay.
+func (r *gormUserRepository) GetSessionByRefreshToken(refreshToken string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.Where("refresh_token = ?", refreshToken).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateSession deactivates the old session and stores its replacement atomically.
+// Only one caller can win the rotation of a given session; the loser gets ErrSessionAlreadyRotated.
+func (r *gormUserRepository) RotateSession(oldSessionID uuid.UUID, newSession *models.Session) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(newSession).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&models.Session{}).
+			Where("id = ? AND is_active = ?", oldSessionID, true).
+			Updates(map[string]interface{}{
+				"is_active":      false,
+				"replaced_by_id": newSession.ID,
+				"updated_at":     time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrSessionAlreadyRotated
+		}
+		return nil
+	})
+}
+
+// RevokeSessionFamily deactivates every session descended from the same login
+func (r *gormUserRepository) RevokeSessionFamily(familyID uuid.UUID) error {
+	return r.db.Model(&models.Session{}).
+		Where("family_id = ? OR id = ?", familyID, familyID).
+		Updates(map[string]interface{}{
+			"is_active":  false,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// Lists sessions that can still be used or refreshed, most recently used first
+func (r *gormUserRepository) GetActiveSessionsByUserID(userID uuid.UUID) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.Where("user_id = ? AND is_active = ? AND refresh_token_expires_at > ?", userID, true, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *gormUserRepository) RevokeSession(sessionID, userID uuid.UUID) error {
+	result := r.db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND is_active = ?", sessionID, userID, true).
+		Updates(map[string]interface{}{
+			"is_active":  false,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *gormUserRepository) RevokeOtherSessions(userID, keepSessionID uuid.UUID) (int64, error) {
+	result := r.db.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND is_active = ?", userID, keepSessionID, true).
+		Updates(map[string]interface{}{
+			"is_active":  false,
+			"updated_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// Records activity on a session. Writes are skipped if the session was seen within the last minute.
+func (r *gormUserRepository) TouchSession(sessionID uuid.UUID) error {
+	now := time.Now()
+	return r.db.Model(&models.Session{}).
+		Where("id = ? AND last_seen_at < ?", sessionID, now.Add(-time.Minute)).
+		Update("last_seen_at", now).Error
+}
+
+func (r *gormUserRepository) CountKnownDevices(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.KnownDevice{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// Remembers a device for the user. Returns true when the device had not been seen before.
+func (r *gormUserRepository) RecordKnownDevice(device *models.KnownDevice) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "fingerprint"}},
+		DoNothing: true,
+	}).Create(device)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+	err := r.db.Model(&models.KnownDevice{}).
+		Where("user_id = ? AND fingerprint = ?", device.UserID, device.Fingerprint).
+		Updates(map[string]interface{}{
+			"last_ip":      device.LastIP,
+			"last_seen_at": time.Now(),
+		}).Error
+	return false, err
+}
+
 type gormUserRepository struct {
 	db *gorm.DB
 }
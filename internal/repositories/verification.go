@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type VerificationTokenRepository interface {
+	Create(token *models.VerificationToken) error
+	Consume(tokenHash, purpose string) (*models.VerificationToken, error)
+	ClaimAttempt(tokenHash, purpose string, maxAttempts int) (*models.VerificationToken, error)
+	InvalidateForUser(userID uuid.UUID, purpose string) error
+}
+
+type verificationTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewVerificationTokenRepository(db *gorm.DB) VerificationTokenRepository {
+	return &verificationTokenRepository{db: db}
+}
+
+func (r *verificationTokenRepository) Create(token *models.VerificationToken) error {
+	return r.db.Create(token).Error
+}
+
+// Consume marks an unused, unexpired token as used and returns it.
+// The conditional update means two concurrent requests cannot both redeem the same token.
+func (r *verificationTokenRepository) Consume(tokenHash, purpose string) (*models.VerificationToken, error) {
+	var token models.VerificationToken
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?",
+			tokenHash, purpose, time.Now()).First(&token).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&models.VerificationToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+/*
+ClaimAttempt counts one more attempt against an unused, unexpired token and returns it,
+or gorm.ErrRecordNotFound once maxAttempts have been used. The attempt is counted before
+the caller checks anything, so concurrent guesses cannot get past the limit.
+*/
+func (r *verificationTokenRepository) ClaimAttempt(tokenHash, purpose string, maxAttempts int) (*models.VerificationToken, error) {
+	var tokens []models.VerificationToken
+	result := r.db.Model(&tokens).
+		Clauses(clause.Returning{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?",
+			tokenHash, purpose, time.Now(), maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(tokens) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &tokens[0], nil
+}
+
+// Burns any outstanding tokens so only the most recently emailed one works
+func (r *verificationTokenRepository) InvalidateForUser(userID uuid.UUID, purpose string) error {
+	return r.db.Model(&models.VerificationToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
@@ -2,10 +2,12 @@ package repositories
 
 import (
 	"pgpockets/internal/models"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type WalletRepository interface {
@@ -16,6 +18,10 @@ type WalletRepository interface {
 	GetWalletByUserID(userID uuid.UUID) (*models.Wallet, error)
 	GetBalancesForAllWallets(userID uuid.UUID) ([]map[string]string, error)
 	UpdateWalletBalance(walletID uuid.UUID, newBalance string) error
+	WithTx(tx *gorm.DB) WalletRepository
+	GetWalletByIDForUpdate(walletID uuid.UUID) (*models.Wallet, error)
+	AdjustBalances(walletID uuid.UUID, balanceDelta, heldDelta decimal.Decimal) error
+	GetPrimaryWallet(userID uuid.UUID, currency string) (*models.Wallet, error)
 }
 
 type walletRepository struct {
@@ -28,6 +34,31 @@ func NewWalletRepository(db *gorm.DB) WalletRepository {
 	}
 }
 
+// WithTx returns a copy of the repository bound to an open database transaction
+func (r *walletRepository) WithTx(tx *gorm.DB) WalletRepository {
+	return &walletRepository{db: tx}
+}
+
+// Locks the wallet row for the rest of the transaction so balance checks cannot race
+func (r *walletRepository) GetWalletByIDForUpdate(walletID uuid.UUID) (*models.Wallet, error) {
+	var wallet models.Wallet
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", walletID).First(&wallet).Error; err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// Applies relative changes to the balance and held balance in a single statement
+func (r *walletRepository) AdjustBalances(walletID uuid.UUID, balanceDelta, heldDelta decimal.Decimal) error {
+	return r.db.Model(&models.Wallet{}).
+		Where("id = ?", walletID).
+		Updates(map[string]interface{}{
+			"balance":      gorm.Expr("balance + ?", balanceDelta),
+			"held_balance": gorm.Expr("held_balance + ?", heldDelta),
+			"updated_at":   time.Now(),
+		}).Error
+}
+
 func (r *walletRepository) CreateWallet(userID uuid.UUID) error {
 	wallet := &models.Wallet{
 		UserID:  userID,
@@ -74,6 +105,17 @@ func (r *walletRepository) GetWalletByEmail(email string) (*models.Wallet, error
 	return &wallet, nil
 }
 
+// A user's primary wallet in a currency is their oldest active wallet in it
+func (r *walletRepository) GetPrimaryWallet(userID uuid.UUID, currency string) (*models.Wallet, error) {
+	var wallet models.Wallet
+	if err := r.db.Where("user_id = ? AND currency = ? AND is_active = ?", userID, currency, true).
+		Order("created_at ASC").
+		First(&wallet).Error; err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
 func (r *walletRepository) UpdateWalletBalance(walletID uuid.UUID, newBalance string) error {
 	if err := r.db.Model(&models.Wallet{}).Where("id = ?", walletID).Update("balance", newBalance).Error; err != nil {
 		return err
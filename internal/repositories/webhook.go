@@ -0,0 +1,220 @@
+package repositories
+
+import (
+	"encoding/json"
+	"pgpockets/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WebhookRepository interface {
+	CreateEndpoint(endpoint *models.WebhookEndpoint) error
+	GetEndpoint(id, userID uuid.UUID) (*models.WebhookEndpoint, error)
+	GetEndpointByID(id uuid.UUID) (*models.WebhookEndpoint, error)
+	ListEndpoints(userID uuid.UUID) ([]models.WebhookEndpoint, error)
+	// Active endpoints of the user that subscribe to eventType
+	ListSubscribedEndpoints(userID uuid.UUID, eventType string) ([]models.WebhookEndpoint, error)
+	UpdateEndpoint(endpoint *models.WebhookEndpoint, columns ...string) error
+	DeleteEndpoint(id, userID uuid.UUID) error
+	RecordEndpointSuccess(id uuid.UUID, at time.Time) error
+	RecordEndpointFailure(id uuid.UUID, at time.Time) error
+	DisableFailingEndpoint(id uuid.UUID, minFailures int, failingBefore time.Time, reason string) (bool, error)
+
+	// Returns false when the event was already queued for the endpoint
+	CreateDelivery(delivery *models.WebhookDelivery) (bool, error)
+	GetDelivery(id, endpointID uuid.UUID) (*models.WebhookDelivery, error)
+	ListDeliveries(endpointID uuid.UUID, status string, limit, offset int) ([]models.WebhookDelivery, int64, error)
+	ClaimDelivery(id uuid.UUID, now, leaseUntil time.Time) (bool, error)
+	ClaimDueDeliveries(now, leaseUntil time.Time, limit int) ([]models.WebhookDelivery, error)
+	UpdateDelivery(delivery *models.WebhookDelivery, columns ...string) error
+	CreateAttempt(attempt *models.WebhookDeliveryAttempt) error
+}
+
+type webhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) WebhookRepository {
+	return &webhookRepository{db: db}
+}
+
+func (r *webhookRepository) CreateEndpoint(endpoint *models.WebhookEndpoint) error {
+	return r.db.Create(endpoint).Error
+}
+
+func (r *webhookRepository) GetEndpoint(id, userID uuid.UUID) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&endpoint).Error; err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+func (r *webhookRepository) GetEndpointByID(id uuid.UUID) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	if err := r.db.Where("id = ?", id).First(&endpoint).Error; err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+func (r *webhookRepository) ListEndpoints(userID uuid.UUID) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&endpoints).Error
+	return endpoints, err
+}
+
+func (r *webhookRepository) ListSubscribedEndpoints(userID uuid.UUID, eventType string) ([]models.WebhookEndpoint, error) {
+	eventTypes, err := json.Marshal([]string{eventType})
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []models.WebhookEndpoint
+	err = r.db.Where("user_id = ? AND status = ? AND event_types @> ?::jsonb",
+		userID, models.WebhookEndpointStatusActive, string(eventTypes)).
+		Find(&endpoints).Error
+	return endpoints, err
+}
+
+func (r *webhookRepository) UpdateEndpoint(endpoint *models.WebhookEndpoint, columns ...string) error {
+	endpoint.UpdatedAt = time.Now()
+	return r.db.Model(endpoint).Select(append(columns, "updated_at")).Updates(endpoint).Error
+}
+
+// Removes the endpoint with its delivery log
+func (r *webhookRepository) DeleteEndpoint(id, userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebhookEndpoint{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		deliveries := tx.Model(&models.WebhookDelivery{}).Select("id").Where("endpoint_id = ?", id)
+		if err := tx.Where("delivery_id IN (?)", deliveries).Delete(&models.WebhookDeliveryAttempt{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("endpoint_id = ?", id).Delete(&models.WebhookDelivery{}).Error
+	})
+}
+
+func (r *webhookRepository) RecordEndpointSuccess(id uuid.UUID, at time.Time) error {
+	return r.db.Model(&models.WebhookEndpoint{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"consecutive_failures": 0,
+		"failing_since":        nil,
+		"last_success_at":      at,
+		"updated_at":           at,
+	}).Error
+}
+
+func (r *webhookRepository) RecordEndpointFailure(id uuid.UUID, at time.Time) error {
+	return r.db.Model(&models.WebhookEndpoint{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"consecutive_failures": gorm.Expr("consecutive_failures + 1"),
+		"failing_since":        gorm.Expr("COALESCE(failing_since, ?)", at),
+		"updated_at":           at,
+	}).Error
+}
+
+// Disables an active endpoint that has failed at least minFailures times in a row since before failingBefore
+func (r *webhookRepository) DisableFailingEndpoint(id uuid.UUID, minFailures int, failingBefore time.Time, reason string) (bool, error) {
+	now := time.Now()
+	result := r.db.Model(&models.WebhookEndpoint{}).
+		Where("id = ? AND status = ? AND consecutive_failures >= ? AND failing_since <= ?",
+			id, models.WebhookEndpointStatusActive, minFailures, failingBefore).
+		Updates(map[string]interface{}{
+			"status":          models.WebhookEndpointStatusDisabled,
+			"disabled_reason": reason,
+			"disabled_at":     now,
+			"updated_at":      now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *webhookRepository) CreateDelivery(delivery *models.WebhookDelivery) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "endpoint_id"}, {Name: "event_id"}},
+		DoNothing: true,
+	}).Create(delivery)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Includes the attempt log, newest first
+func (r *webhookRepository) GetDelivery(id, endpointID uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := r.db.Preload("AttemptLog", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at DESC")
+	}).Where("id = ? AND endpoint_id = ?", id, endpointID).First(&delivery).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (r *webhookRepository) ListDeliveries(endpointID uuid.UUID, status string, limit, offset int) ([]models.WebhookDelivery, int64, error) {
+	query := r.db.Model(&models.WebhookDelivery{}).Where("endpoint_id = ?", endpointID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	var deliveries []models.WebhookDelivery
+	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	return deliveries, count, nil
+}
+
+// Same lease scheme as notification deliveries: the attempt is counted up front and the
+// delivery becomes due again if the process dies before recording the outcome
+func (r *webhookRepository) ClaimDelivery(id uuid.UUID, now, leaseUntil time.Time) (bool, error) {
+	result := r.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, models.DeliveryStatusPending, now).
+		Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": leaseUntil,
+			"updated_at":      now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *webhookRepository) ClaimDueDeliveries(now, leaseUntil time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.Raw(`
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, next_attempt_at = ?, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		leaseUntil, now, models.DeliveryStatusPending, now, limit,
+	).Scan(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *webhookRepository) UpdateDelivery(delivery *models.WebhookDelivery, columns ...string) error {
+	delivery.UpdatedAt = time.Now()
+	return r.db.Model(delivery).Select(append(columns, "updated_at")).Updates(delivery).Error
+}
+
+func (r *webhookRepository) CreateAttempt(attempt *models.WebhookDeliveryAttempt) error {
+	return r.db.Create(attempt).Error
+}
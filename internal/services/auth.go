@@ -2,6 +2,8 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"pgpockets/internal/events"
 	"pgpockets/internal/models"
 	"pgpockets/internal/repositories"
 	"pgpockets/internal/utils"
@@ -18,8 +20,30 @@ var (
 	ErrInvalidCredentials    = errors.New("invalid email or password")
 	ErrPasswordHashFailed    = errors.New("failed to process password securely")
 	ErrTokenGenerationFailed = errors.New("failed to generate authentication token")
+	ErrInvalidRefreshToken   = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused    = errors.New("refresh token has already been used")
+	ErrSessionNotFound       = errors.New("session not found")
+	ErrInvalidChallenge      = errors.New("invalid or expired two-factor challenge")
+	ErrUserNotFound          = errors.New("user not found")
 )
 
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+	challengeTTL    = 5 * time.Minute
+	// Codes that can be tried against one challenge before the password has to be entered again
+	challengeMaxAttempts = 5
+)
+
+// LoginResult carries either a token pair or, for accounts with 2FA on, a challenge
+// token that has to be exchanged together with a code via CompleteTwoFactorLogin.
+type LoginResult struct {
+	AccessToken       string
+	RefreshToken      string
+	TwoFactorRequired bool
+	ChallengeToken    string
+}
+
 type AuthService interface {
 	Register(
 		email string,
@@ -31,28 +55,48 @@ type AuthService interface {
 		address string,
 		gender string,
 	) (*models.User, error)
-	Login(email, password, ipAddr, userAgent string) (string, string, error)
-	Logout(userID uuid.UUID) error
+	Login(email, password, ipAddr, userAgent string) (*LoginResult, error)
+	CompleteTwoFactorLogin(challengeToken, code, ipAddr, userAgent string) (string, string, error)
+	Refresh(refreshToken, ipAddr, userAgent string) (string, string, error)
+	Logout(userID, sessionID uuid.UUID) error
+	ListSessions(userID uuid.UUID) ([]models.Session, error)
+	RevokeSession(userID, sessionID uuid.UUID) error
+	RevokeOtherSessions(userID, currentSessionID uuid.UUID) (int64, error)
 }
 
+// Compared against when the email is unknown so a miss costs the same bcrypt time as a wrong password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("pgpockets-timing-equaliser"), bcrypt.DefaultCost)
+
 type authService struct {
-	userRepo  repositories.UserRepository
+	userRepo   repositories.UserRepository
 	walletRepo repositories.WalletRepository
-	logger    *zap.Logger
-	jwtSecret string
+	tokenRepo  repositories.VerificationTokenRepository
+	twoFactor  TwoFactorService
+	loginGuard LoginGuard
+	logger     *zap.Logger
+	jwtSecret  string
+	bus        events.Bus
 }
 
 func NewAuthService(
 	userRepo repositories.UserRepository,
 	walletRepo repositories.WalletRepository,
+	tokenRepo repositories.VerificationTokenRepository,
+	twoFactor TwoFactorService,
+	loginGuard LoginGuard,
 	logger *zap.Logger,
 	jwtSecret string,
+	bus events.Bus,
 	) *authService {
 	return &authService{
-		userRepo:  userRepo,
+		userRepo:   userRepo,
 		walletRepo: walletRepo,
-		logger:    logger,
-		jwtSecret: jwtSecret,
+		tokenRepo:  tokenRepo,
+		twoFactor:  twoFactor,
+		loginGuard: loginGuard,
+		logger:     logger,
+		jwtSecret:  jwtSecret,
+		bus:        bus,
 	}
 }
 
@@ -126,75 +170,284 @@ func (s *authService) Register(
 	return user, nil
 }
 
-func (s *authService) Login(email, password, ipAddr, userAgent string) (string, string, error) {
+func (s *authService) Login(email, password, ipAddr, userAgent string) (*LoginResult, error) {
+	if err := s.loginGuard.Check(email, ipAddr); err != nil {
+		s.logger.Warn("Login throttled", zap.String("email", email), zap.String("ip", ipAddr))
+		return nil, err
+	}
+
 	user, err := s.userRepo.GetUserByEmail(email)
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			s.logger.Warn("Login failed: user not found", zap.String("email", email))
-			return "", "", ErrInvalidCredentials
-		}
+	if err != nil && err != gorm.ErrRecordNotFound {
 		s.logger.Error("Error retrieving user", zap.Error(err))
-		return "", "", err
+		return nil, err
 	}
-	// Check if password matches
+	// Unknown emails go through the same bcrypt work and failure tracking as wrong passwords
 	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
 		s.logger.Warn("Login failed: user not found", zap.String("email", email))
-		return "", "", ErrInvalidCredentials
+		s.loginGuard.RecordFailure(email, ipAddr, nil)
+		return nil, ErrInvalidCredentials
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
 		s.logger.Warn("Login failed: invalid password", zap.String("email", email))
-		return "", "", ErrInvalidCredentials
+		s.loginGuard.RecordFailure(email, ipAddr, user)
+		return nil, ErrInvalidCredentials
 	}
 
-	// Generate JWT tokens for access and refresh token
-	accessToken, err := utils.GenerateJWTToken(
-		user.ID,
-		s.jwtSecret,
-		time.Now().Add(15*time.Minute),
-	)
+	// With 2FA on the password alone only earns a short-lived challenge. The failure counter
+	// is only cleared once the code is right too, so codes cannot be guessed by logging in again.
+	twoFactorEnabled, err := s.twoFactor.IsEnabled(user.ID)
 	if err != nil {
-		s.logger.Error("Failed to generate JWT token", zap.Error(err))
-		return "", "", ErrTokenGenerationFailed
+		return nil, err
 	}
+	if twoFactorEnabled {
+		challenge, err := s.issueChallenge(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		s.logger.Info("Login requires 2FA", zap.String("email", email))
+		return &LoginResult{TwoFactorRequired: true, ChallengeToken: challenge}, nil
+	}
+	s.loginGuard.RecordSuccess(email)
 
-	refreshToken, err := utils.GenerateJWTToken(
-		user.ID,
-		s.jwtSecret,
-		time.Now().Add(15*time.Minute),
-	)
+	session, err := s.startSession(user.ID, ipAddr, userAgent)
 	if err != nil {
-		s.logger.Error("Failed to generate JWT token", zap.Error(err))
-		return "", "", ErrTokenGenerationFailed
+		return nil, err
 	}
+	s.logger.Info("User logged in successfully", zap.String("email", email))
+	return &LoginResult{AccessToken: session.AccessToken, RefreshToken: session.RefreshToken}, nil
+}
 
-	// Create a new session for the user
-	session := &models.Session{
-		UserID:                user.ID,
-		ClientIP:              ipAddr,
-		UserAgent:             userAgent,
-		AccessToken:           accessToken,
-		AccessTokenExpiresAt:  time.Now().Add(15 * time.Minute),
-		RefreshTokenExpiresAt: time.Now().Add(30 * 24 * time.Hour),
-		RefreshToken:          refreshToken,
-		IsActive:              true,
+/*
+	Second step of a 2FA login: trades the challenge token and a TOTP or recovery code for a session.
+	A challenge is single use and allows challengeMaxAttempts codes; wrong codes also count towards
+	the account's login lockout, just like wrong passwords.
+*/
+func (s *authService) CompleteTwoFactorLogin(challengeToken, code, ipAddr, userAgent string) (string, string, error) {
+	challenge, err := s.tokenRepo.ClaimAttempt(utils.SignOpaqueToken(challengeToken, s.jwtSecret),
+		models.TokenPurposeTwoFactorLogin, challengeMaxAttempts)
+	if err == gorm.ErrRecordNotFound {
+		s.logger.Warn("2FA login failed: invalid, used or exhausted challenge")
+		return "", "", ErrInvalidChallenge
+	}
+	if err != nil {
+		s.logger.Error("Failed to look up 2FA challenge", zap.Error(err))
+		return "", "", err
+	}
+
+	user, err := s.userRepo.GetUserByID(challenge.UserID)
+	if err != nil {
+		s.logger.Error("Failed to look up user for 2FA login", zap.Error(err))
+		return "", "", err
+	}
+	if err := s.loginGuard.Check(user.Email, ipAddr); err != nil {
+		s.logger.Warn("2FA login throttled", zap.String("user_id", user.ID.String()), zap.String("ip", ipAddr))
+		return "", "", err
+	}
+
+	if err := s.twoFactor.VerifyCode(user.ID, code); err != nil {
+		if err == ErrInvalidTwoFactorCode {
+			s.loginGuard.RecordFailure(user.Email, ipAddr, user)
+		}
+		return "", "", err
+	}
+	if _, err := s.tokenRepo.Consume(challenge.TokenHash, models.TokenPurposeTwoFactorLogin); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", "", ErrInvalidChallenge
+		}
+		s.logger.Error("Failed to use 2FA challenge", zap.Error(err))
+		return "", "", err
+	}
+	s.loginGuard.RecordSuccess(user.Email)
+
+	session, err := s.startSession(user.ID, ipAddr, userAgent)
+	if err != nil {
+		return "", "", err
+	}
+	s.logger.Info("User logged in successfully with 2FA", zap.String("user_id", user.ID.String()))
+	return session.AccessToken, session.RefreshToken, nil
+}
+
+// Stores a new 2FA challenge for the user, replacing any earlier one, and returns the token to hand back
+func (s *authService) issueChallenge(userID uuid.UUID) (string, error) {
+	if err := s.tokenRepo.InvalidateForUser(userID, models.TokenPurposeTwoFactorLogin); err != nil {
+		s.logger.Error("Failed to invalidate previous 2FA challenges", zap.Error(err))
+		return "", err
+	}
+	challenge, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		s.logger.Error("Failed to generate 2FA challenge", zap.Error(err))
+		return "", ErrTokenGenerationFailed
+	}
+	if err := s.tokenRepo.Create(&models.VerificationToken{
+		UserID:    userID,
+		Purpose:   models.TokenPurposeTwoFactorLogin,
+		TokenHash: utils.SignOpaqueToken(challenge, s.jwtSecret),
+		ExpiresAt: time.Now().Add(challengeTTL),
+	}); err != nil {
+		s.logger.Error("Failed to store 2FA challenge", zap.Error(err))
+		return "", err
+	}
+	return challenge, nil
+}
+
+// Every login starts a new session family which later refreshes rotate within
+func (s *authService) startSession(userID uuid.UUID, ipAddr, userAgent string) (*models.Session, error) {
+	session, err := s.newSession(userID, uuid.New(), ipAddr, userAgent)
+	if err != nil {
+		return nil, err
 	}
 
 	err = s.userRepo.CreateSession(session)
 	if err != nil {
 		s.logger.Error("Failed to create user session", zap.Error(err))
+		return nil, ErrTokenGenerationFailed
+	}
+	s.checkNewDevice(userID, ipAddr, userAgent)
+	return session, nil
+}
+
+/*
+	Remembers the device a login came from and warns the user when it is one we have not
+	seen before. A user's very first device is not announced. Failures here never block the login.
+*/
+func (s *authService) checkNewDevice(userID uuid.UUID, ipAddr, userAgent string) {
+	info := utils.ParseUserAgent(userAgent)
+	device := fmt.Sprintf("%s on %s", info.Browser, info.OS)
+
+	known, err := s.userRepo.CountKnownDevices(userID)
+	if err != nil {
+		s.logger.Error("Failed to count known devices", zap.Error(err))
+		return
+	}
+	isNew, err := s.userRepo.RecordKnownDevice(&models.KnownDevice{
+		UserID:      userID,
+		Fingerprint: fmt.Sprintf("%s|%s|%s", info.Browser, info.OS, info.DeviceType),
+		LastIP:      ipAddr,
+	})
+	if err != nil {
+		s.logger.Error("Failed to record login device", zap.Error(err))
+		return
+	}
+	if !isNew || known == 0 {
+		return
+	}
+	s.bus.Publish(events.Event{
+		Type:   events.LoginNewDevice,
+		UserID: userID,
+		Data: map[string]string{
+			"device":     device,
+			"ip_address": ipAddr,
+		},
+	})
+}
+
+// Exchanges a refresh token for a new access/refresh pair.
+// The presented refresh token is rotated out; presenting it again revokes the whole session family.
+func (s *authService) Refresh(refreshToken, ipAddr, userAgent string) (string, string, error) {
+	userID, err := utils.ParseJWTToken(refreshToken, s.jwtSecret)
+	if err != nil {
+		s.logger.Warn("Refresh failed: invalid refresh token", zap.Error(err))
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	session, err := s.userRepo.GetSessionByRefreshToken(refreshToken)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			s.logger.Warn("Refresh failed: session not found", zap.String("user_id", userID.String()))
+			return "", "", ErrInvalidRefreshToken
+		}
+		s.logger.Error("Error retrieving session", zap.Error(err))
+		return "", "", err
+	}
+	if session.UserID != userID {
+		s.logger.Warn("Refresh failed: token does not belong to session", zap.String("user_id", userID.String()))
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	familyID := session.FamilyID
+	if familyID == uuid.Nil {
+		familyID = session.ID
+	}
+
+	// A rotated token showing up again means it has leaked, so nobody in the family keeps access
+	if !session.IsActive {
+		if session.ReplacedByID != nil {
+			s.revokeFamily(familyID, session.UserID)
+			return "", "", ErrRefreshTokenReused
+		}
+		return "", "", ErrInvalidRefreshToken
+	}
+	if time.Now().After(session.RefreshTokenExpiresAt) {
+		s.logger.Warn("Refresh failed: refresh token expired", zap.String("session_id", session.ID.String()))
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	newSession, err := s.newSession(session.UserID, familyID, ipAddr, userAgent)
+	if err != nil {
+		return "", "", err
+	}
+	// The family keeps its original deadline so refreshing cannot extend a login forever
+	newSession.RefreshTokenExpiresAt = session.RefreshTokenExpiresAt
+
+	if err := s.userRepo.RotateSession(session.ID, newSession); err != nil {
+		if err == repositories.ErrSessionAlreadyRotated {
+			s.revokeFamily(familyID, session.UserID)
+			return "", "", ErrRefreshTokenReused
+		}
+		s.logger.Error("Failed to rotate session", zap.Error(err))
 		return "", "", ErrTokenGenerationFailed
 	}
-	s.logger.Info("User logged in successfully", zap.String("email", email))
-	return accessToken, refreshToken, nil
+
+	s.logger.Info("Session refreshed successfully", zap.String("user_id", session.UserID.String()))
+	return newSession.AccessToken, newSession.RefreshToken, nil
 }
 
-func (s *authService) Logout(userID uuid.UUID) error {
-	err := s.userRepo.DeleteSession(userID)
+// Mints an access/refresh token pair and wraps it in an unsaved session
+func (s *authService) newSession(userID, familyID uuid.UUID, ipAddr, userAgent string) (*models.Session, error) {
+	now := time.Now()
+	accessToken, err := utils.GenerateJWTToken(userID, s.jwtSecret, now.Add(accessTokenTTL))
+	if err != nil {
+		s.logger.Error("Failed to generate JWT token", zap.Error(err))
+		return nil, ErrTokenGenerationFailed
+	}
 
+	refreshToken, err := utils.GenerateJWTToken(userID, s.jwtSecret, now.Add(refreshTokenTTL))
 	if err != nil {
-		s.logger.Error("Failed to delete user sessions",
+		s.logger.Error("Failed to generate JWT token", zap.Error(err))
+		return nil, ErrTokenGenerationFailed
+	}
+
+	return &models.Session{
+		UserID:                userID,
+		ClientIP:              ipAddr,
+		UserAgent:             userAgent,
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  now.Add(accessTokenTTL),
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: now.Add(refreshTokenTTL),
+		FamilyID:              familyID,
+		IsActive:              true,
+	}, nil
+}
+
+func (s *authService) revokeFamily(familyID, userID uuid.UUID) {
+	s.logger.Warn("Refresh token reuse detected, revoking session family",
+		zap.String("user_id", userID.String()),
+		zap.String("family_id", familyID.String()))
+	if err := s.userRepo.RevokeSessionFamily(familyID); err != nil {
+		s.logger.Error("Failed to revoke session family", zap.Error(err))
+	}
+}
+
+// Ends only the session the request was authenticated with
+func (s *authService) Logout(userID, sessionID uuid.UUID) error {
+	err := s.userRepo.RevokeSession(sessionID, userID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		s.logger.Error("Failed to revoke user session",
 			zap.String("user_id", userID.String()),
+			zap.String("session_id", sessionID.String()),
 			zap.Error(err))
 		return err
 	}
@@ -203,3 +456,43 @@ func (s *authService) Logout(userID uuid.UUID) error {
 	return nil
 
 }
+
+func (s *authService) ListSessions(userID uuid.UUID) ([]models.Session, error) {
+	sessions, err := s.userRepo.GetActiveSessionsByUserID(userID)
+	if err != nil {
+		s.logger.Error("Failed to list user sessions",
+			zap.String("user_id", userID.String()),
+			zap.Error(err))
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *authService) RevokeSession(userID, sessionID uuid.UUID) error {
+	if err := s.userRepo.RevokeSession(sessionID, userID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrSessionNotFound
+		}
+		s.logger.Error("Failed to revoke session",
+			zap.String("user_id", userID.String()),
+			zap.String("session_id", sessionID.String()),
+			zap.Error(err))
+		return err
+	}
+	s.logger.Info("Session revoked", zap.String("session_id", sessionID.String()))
+	return nil
+}
+
+func (s *authService) RevokeOtherSessions(userID, currentSessionID uuid.UUID) (int64, error) {
+	revoked, err := s.userRepo.RevokeOtherSessions(userID, currentSessionID)
+	if err != nil {
+		s.logger.Error("Failed to revoke other sessions",
+			zap.String("user_id", userID.String()),
+			zap.Error(err))
+		return 0, err
+	}
+	s.logger.Info("Revoked other sessions",
+		zap.String("user_id", userID.String()),
+		zap.Int64("count", revoked))
+	return revoked, nil
+}
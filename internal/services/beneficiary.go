@@ -1,71 +1,292 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"pgpockets/internal/models"
+	"pgpockets/internal/nameenquiry"
 	"pgpockets/internal/repositories"
+	"pgpockets/internal/utils"
+	"strings"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+var (
+	ErrBeneficiaryNotFound     = errors.New("beneficiary not found")
+	ErrDuplicateBeneficiary    = errors.New("beneficiary already exists")
+	ErrRecipientNotFound       = errors.New("recipient not found")
+	ErrCannotAddSelf           = errors.New("you cannot add yourself as a beneficiary")
+	ErrInvalidBeneficiaryKind  = errors.New("invalid beneficiary kind")
+	ErrMissingBeneficiaryField = errors.New("missing recipient details for beneficiary kind")
+)
+
+// NewBeneficiary describes a payee to save. Which fields are needed depends on Kind.
+type NewBeneficiary struct {
+	Kind          string
+	Email         string     // wallet: recipient's email, or
+	WalletID      *uuid.UUID // wallet: recipient's wallet ID
+	PhoneNumber   string     // phone
+	AccountNumber string     // bank_account
+	BankCode      string     // bank_account
+	Nickname      string
+	Description   string
+	IsFavorite    bool
+}
+
+// BeneficiaryUpdate changes a beneficiary's labels. Nil fields are left as they are.
+type BeneficiaryUpdate struct {
+	Nickname    *string
+	Description *string
+	IsFavorite  *bool
+}
+
 type BeneficiaryService interface {
-	Create(beneficiary *models.Beneficiary) (*models.Beneficiary, error)
-	GetBeneficiaries(userID string) ([]models.Beneficiary, error)
-	DeleteBeneficiary(userIDStr, beneIDStr string) error
+	Create(userID uuid.UUID, req NewBeneficiary) (*models.Beneficiary, error)
+	GetBeneficiaries(userID uuid.UUID, filter repositories.BeneficiaryFilter) ([]models.Beneficiary, error)
+	GetBeneficiary(userID, beneID uuid.UUID) (*models.Beneficiary, error)
+	UpdateBeneficiary(userID, beneID uuid.UUID, update BeneficiaryUpdate) (*models.Beneficiary, error)
+	DeleteBeneficiary(userID, beneID uuid.UUID) error
+	ResolveAccountName(accountNumber, bankCode string) (*nameenquiry.AccountName, error)
+	ListBanks() []nameenquiry.Bank
 }
 
 type beneficiaryService struct {
-	repo repositories.BeneficiaryRepository
-	logger *zap.Logger
+	repo        repositories.BeneficiaryRepository
+	walletRepo  repositories.WalletRepository
+	profileRepo repositories.ProfileRepository
+	nameEnquiry nameenquiry.NameEnquiry
+	logger      *zap.Logger
 }
 
-
-func NewBeneficiaryService(repo repositories.BeneficiaryRepository, appLogger *zap.Logger) BeneficiaryService {
+func NewBeneficiaryService(
+	repo repositories.BeneficiaryRepository,
+	walletRepo repositories.WalletRepository,
+	profileRepo repositories.ProfileRepository,
+	nameEnquiry nameenquiry.NameEnquiry,
+	appLogger *zap.Logger,
+) BeneficiaryService {
 	return &beneficiaryService{
-		repo: repo,
-		logger: appLogger,
+		repo:        repo,
+		walletRepo:  walletRepo,
+		profileRepo: profileRepo,
+		nameEnquiry: nameEnquiry,
+		logger:      appLogger,
 	}
 }
 
-// Create implements BeneficiaryService.
-func (s *beneficiaryService) Create(beneficiary *models.Beneficiary) (*models.Beneficiary, error) {
+// Create resolves and confirms the payee, then saves it unless the user already has it
+func (s *beneficiaryService) Create(userID uuid.UUID, req NewBeneficiary) (*models.Beneficiary, error) {
+	beneficiary := &models.Beneficiary{
+		UserID:      userID,
+		Kind:        req.Kind,
+		Nickname:    strings.TrimSpace(req.Nickname),
+		Description: req.Description,
+		IsFavorite:  req.IsFavorite,
+	}
+
+	var err error
+	switch req.Kind {
+	case models.BeneficiaryKindWallet:
+		err = s.resolveWallet(beneficiary, req)
+	case models.BeneficiaryKindPhone:
+		err = s.resolvePhone(beneficiary, req)
+	case models.BeneficiaryKindBankAccount:
+		err = s.resolveBankAccount(beneficiary, req)
+	default:
+		err = ErrInvalidBeneficiaryKind
+	}
+	if err != nil {
+		return nil, err
+	}
+	if beneficiary.RecipientUserID != nil && *beneficiary.RecipientUserID == userID {
+		return nil, ErrCannotAddSelf
+	}
+
+	if _, err := s.repo.GetBeneficiaryByTargetKey(userID, beneficiary.TargetKey); err == nil {
+		return nil, ErrDuplicateBeneficiary
+	} else if err != gorm.ErrRecordNotFound {
+		s.logger.Error("Failed to check for duplicate beneficiary", zap.Error(err))
+		return nil, err
+	}
+
 	newBeneficiary, err := s.repo.Create(beneficiary)
 	if err != nil {
-		s.logger.Error("Failed to create beneficiary")
+		s.logger.Error("Failed to create beneficiary", zap.Error(err))
 		return nil, err
 	}
-	s.logger.Info("Successfully created new beneficiary")
+	s.logger.Info("Successfully created new beneficiary",
+		zap.String("beneficiary_id", newBeneficiary.ID.String()),
+		zap.String("kind", newBeneficiary.Kind))
 	return newBeneficiary, nil
-
 }
 
-func (s *beneficiaryService) GetBeneficiaries(userID string) ([]models.Beneficiary, error) {
-	beneficiaries, err := s.repo.GetBeneficiaries(userID)
+func (s *beneficiaryService) GetBeneficiaries(userID uuid.UUID, filter repositories.BeneficiaryFilter) ([]models.Beneficiary, error) {
+	beneficiaries, err := s.repo.GetBeneficiaries(userID, filter)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("Failed to get beneficiaries for user with id %s", userID))
 		return nil, err
 	}
-	s.logger.Error(fmt.Sprintf("Successfully got beneficiaries for user with id %s", userID))
+	s.logger.Info(fmt.Sprintf("Successfully got beneficiaries for user with id %s", userID))
 	return beneficiaries, nil
 }
 
-func (s *beneficiaryService) DeleteBeneficiary(userIDStr, beneIDStr string) error {
-	userID, err := uuid.Parse(userIDStr)
+func (s *beneficiaryService) GetBeneficiary(userID, beneID uuid.UUID) (*models.Beneficiary, error) {
+	beneficiary, err := s.repo.GetBeneficiaryByID(beneID, userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrBeneficiaryNotFound
+		}
+		s.logger.Error("Failed to get beneficiary", zap.Error(err))
+		return nil, err
+	}
+	return beneficiary, nil
+}
+
+func (s *beneficiaryService) UpdateBeneficiary(userID, beneID uuid.UUID, update BeneficiaryUpdate) (*models.Beneficiary, error) {
+	beneficiary, err := s.GetBeneficiary(userID, beneID)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	if update.Nickname != nil {
+		beneficiary.Nickname = strings.TrimSpace(*update.Nickname)
+		columns = append(columns, "nickname")
+	}
+	if update.Description != nil {
+		beneficiary.Description = *update.Description
+		columns = append(columns, "description")
+	}
+	if update.IsFavorite != nil {
+		beneficiary.IsFavorite = *update.IsFavorite
+		columns = append(columns, "is_favorite")
+	}
+	if len(columns) == 0 {
+		return beneficiary, nil
+	}
+
+	if err := s.repo.UpdateBeneficiary(beneficiary, columns...); err != nil {
+		s.logger.Error("Failed to update beneficiary", zap.Error(err))
+		return nil, err
+	}
+	return beneficiary, nil
+}
+
+func (s *beneficiaryService) DeleteBeneficiary(userID, beneID uuid.UUID) error {
+	err := s.repo.DeleteBeneficiary(beneID, userID)
 	if err != nil {
-		s.logger.Error("Failed to get parse user ID string to uuid format")
-		return err 
+		if err == gorm.ErrRecordNotFound {
+			return ErrBeneficiaryNotFound
+		}
+		s.logger.Error("Failed to delete beneficiary", zap.Error(err))
+		return err
 	}
+	return nil
+}
+
+// Looks up the holder of an external account so the user can confirm it before saving
+func (s *beneficiaryService) ResolveAccountName(accountNumber, bankCode string) (*nameenquiry.AccountName, error) {
+	account, err := s.nameEnquiry.Resolve(accountNumber, bankCode)
+	if err != nil {
+		if !isNameEnquiryClientError(err) {
+			s.logger.Error("Name enquiry failed", zap.String("provider", s.nameEnquiry.Name()), zap.Error(err))
+		}
+		return nil, err
+	}
+	return account, nil
+}
 
-	beneID, err := uuid.Parse(beneIDStr)
+func (s *beneficiaryService) ListBanks() []nameenquiry.Bank {
+	return s.nameEnquiry.Banks()
+}
+
+func (s *beneficiaryService) resolveWallet(beneficiary *models.Beneficiary, req NewBeneficiary) error {
+	var wallet *models.Wallet
+	var err error
+	switch {
+	case req.WalletID != nil:
+		wallet, err = s.walletRepo.GetWalletByID(*req.WalletID)
+	case req.Email != "":
+		wallet, err = s.walletRepo.GetWalletByEmail(strings.ToLower(strings.TrimSpace(req.Email)))
+	default:
+		return ErrMissingBeneficiaryField
+	}
 	if err != nil {
-		s.logger.Error("Failed to get parse beneficiary ID string to uuid format")
-		return err 
+		if err == gorm.ErrRecordNotFound {
+			return ErrRecipientNotFound
+		}
+		s.logger.Error("Failed to look up recipient wallet", zap.Error(err))
+		return err
 	}
-	err = s.repo.DeleteBeneficiary(beneID, userID)
+
+	beneficiary.WalletID = &wallet.ID
+	beneficiary.RecipientUserID = &wallet.UserID
+	beneficiary.AccountName = s.maskedRecipientName(wallet.UserID)
+	beneficiary.TargetKey = "wallet:" + wallet.ID.String()
+	return nil
+}
+
+func (s *beneficiaryService) resolvePhone(beneficiary *models.Beneficiary, req NewBeneficiary) error {
+	phone := utils.NormalizePhoneNumber(req.PhoneNumber)
+	if phone == "" {
+		return ErrMissingBeneficiaryField
+	}
+	profile, err := s.profileRepo.GetProfileByPhoneNumber(phone)
 	if err != nil {
-		s.logger.Error("Failed to delete beneficiary")
+		if err == gorm.ErrRecordNotFound {
+			return ErrRecipientNotFound
+		}
+		s.logger.Error("Failed to look up recipient by phone", zap.Error(err))
 		return err
 	}
-	return nil 
-}
\ No newline at end of file
+	wallet, err := s.walletRepo.GetWalletByUserID(profile.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrRecipientNotFound
+		}
+		s.logger.Error("Failed to look up recipient wallet", zap.Error(err))
+		return err
+	}
+
+	beneficiary.PhoneNumber = phone
+	beneficiary.WalletID = &wallet.ID
+	beneficiary.RecipientUserID = &profile.UserID
+	beneficiary.AccountName = utils.MaskName(profile.FirstName + " " + profile.LastName)
+	beneficiary.TargetKey = "phone:" + phone
+	return nil
+}
+
+func (s *beneficiaryService) resolveBankAccount(beneficiary *models.Beneficiary, req NewBeneficiary) error {
+	if req.AccountNumber == "" || req.BankCode == "" {
+		return ErrMissingBeneficiaryField
+	}
+	account, err := s.ResolveAccountName(req.AccountNumber, req.BankCode)
+	if err != nil {
+		return err
+	}
+
+	beneficiary.AccountNumber = account.AccountNumber
+	beneficiary.BankCode = account.BankCode
+	beneficiary.BankName = account.BankName
+	beneficiary.AccountName = account.AccountName
+	beneficiary.TargetKey = "bank:" + account.BankCode + ":" + account.AccountNumber
+	return nil
+}
+
+// Internal payees only see the initials of who they added
+func (s *beneficiaryService) maskedRecipientName(userID uuid.UUID) string {
+	profile, err := s.profileRepo.GetProfileByUserID(userID.String())
+	if err != nil {
+		return ""
+	}
+	return utils.MaskName(profile.FirstName + " " + profile.LastName)
+}
+
+func isNameEnquiryClientError(err error) bool {
+	return err == nameenquiry.ErrAccountNotFound ||
+		err == nameenquiry.ErrUnknownBank ||
+		err == nameenquiry.ErrInvalidAccount
+}
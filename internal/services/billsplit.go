@@ -0,0 +1,558 @@
+package services
+
+import (
+	"errors"
+	"pgpockets/internal/events"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrBillSplitNotFound         = errors.New("bill split not found")
+	ErrBillSplitNotOpen          = errors.New("bill split is no longer open")
+	ErrInvalidSplitMethod        = errors.New("split method must be equal, percentage or exact")
+	ErrInvalidSplitShares        = errors.New("shares do not add up to the total")
+	ErrNoParticipants            = errors.New("a split needs at least one participant")
+	ErrTooManyParticipants       = errors.New("a split can have at most 20 participants")
+	ErrDuplicateParticipant      = errors.New("the same person appears more than once")
+	ErrCreatorAsParticipant      = errors.New("use include_creator to take a share yourself")
+	ErrParticipantNotFound       = errors.New("participant not found")
+	ErrParticipantAlreadySettled = errors.New("participant has already paid")
+	ErrReminderTooSoon           = errors.New("participants were reminded recently")
+)
+
+const (
+	maxSplitParticipants = 20
+	// Manual reminders can be sent at most this often per participant
+	manualReminderInterval = 12 * time.Hour
+	// The worker reminds unpaid participants this often, up to autoReminderLimit times
+	autoReminderInterval = 72 * time.Hour
+	autoReminderLimit    = 3
+	openSplitPageSize    = 200
+)
+
+type SplitParticipant struct {
+	Email       string
+	PhoneNumber string
+	// Percentage for percentage splits, amount for exact splits, ignored for equal splits
+	Value decimal.Decimal
+}
+
+type NewBillSplit struct {
+	Title       string
+	TotalAmount decimal.Decimal
+	Currency    string
+	Method      string
+	// When set the creator keeps a share: one equal part, or whatever the participants' shares leave
+	IncludeCreator bool
+	Participants   []SplitParticipant
+	ExpiresAt      *time.Time // Expiry of the generated payment requests
+}
+
+// BillSplitService shares a group expense by sending each participant a payment request
+type BillSplitService interface {
+	Create(creatorID uuid.UUID, req NewBillSplit) (*models.BillSplit, error)
+	List(userID uuid.UUID, status string) ([]models.BillSplit, error)
+	Get(userID, splitID uuid.UUID) (*models.BillSplit, error)
+	Remind(creatorID, splitID uuid.UUID) (int, error)
+	MarkPaid(creatorID, splitID, participantID uuid.UUID) (*models.BillSplit, error)
+	Cancel(creatorID, splitID uuid.UUID) (*models.BillSplit, error)
+	ProcessOpenSplits(now time.Time)
+}
+
+type billSplitService struct {
+	repo                  repositories.BillSplitRepository
+	walletRepo            repositories.WalletRepository
+	userRepo              repositories.UserRepository
+	profileRepo           repositories.ProfileRepository
+	paymentRequestService PaymentRequestService
+	logger                *zap.Logger
+	bus                   events.Bus
+}
+
+func NewBillSplitService(
+	repo repositories.BillSplitRepository,
+	walletRepo repositories.WalletRepository,
+	userRepo repositories.UserRepository,
+	profileRepo repositories.ProfileRepository,
+	paymentRequestService PaymentRequestService,
+	logger *zap.Logger,
+	bus events.Bus,
+) *billSplitService {
+	return &billSplitService{
+		repo:                  repo,
+		walletRepo:            walletRepo,
+		userRepo:              userRepo,
+		profileRepo:           profileRepo,
+		paymentRequestService: paymentRequestService,
+		logger:                logger,
+		bus:                   bus,
+	}
+}
+
+func (s *billSplitService) Create(creatorID uuid.UUID, req NewBillSplit) (*models.BillSplit, error) {
+	if len(req.Participants) == 0 {
+		return nil, ErrNoParticipants
+	}
+	if len(req.Participants) > maxSplitParticipants {
+		return nil, ErrTooManyParticipants
+	}
+	if !req.TotalAmount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+
+	// Resolve everyone up front so a typo doesn't leave a half-sent split behind
+	participantIDs := make([]uuid.UUID, len(req.Participants))
+	seen := make(map[uuid.UUID]bool, len(req.Participants))
+	for i, p := range req.Participants {
+		if (p.Email == "") == (p.PhoneNumber == "") {
+			return nil, ErrAmbiguousRecipient
+		}
+		userID, err := findUserByContact(s.userRepo, s.profileRepo, p.Email, p.PhoneNumber)
+		if err != nil {
+			return nil, err
+		}
+		if userID == creatorID {
+			return nil, ErrCreatorAsParticipant
+		}
+		if seen[userID] {
+			return nil, ErrDuplicateParticipant
+		}
+		seen[userID] = true
+		participantIDs[i] = userID
+	}
+
+	values := make([]decimal.Decimal, len(req.Participants))
+	for i, p := range req.Participants {
+		values[i] = p.Value
+	}
+	shares, creatorShare, err := computeShares(req.Method, req.TotalAmount, values, req.IncludeCreator)
+	if err != nil {
+		return nil, err
+	}
+
+	currency := strings.ToUpper(req.Currency)
+	if _, err := s.walletRepo.GetPrimaryWallet(creatorID, currency); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNoWalletInCurrency
+		}
+		return nil, err
+	}
+
+	split := &models.BillSplit{
+		CreatorID:    creatorID,
+		Title:        strings.TrimSpace(req.Title),
+		TotalAmount:  req.TotalAmount,
+		Currency:     currency,
+		Method:       req.Method,
+		CreatorShare: creatorShare,
+		Status:       models.BillSplitStatusOpen,
+	}
+	for i, userID := range participantIDs {
+		participant := models.BillSplitParticipant{
+			UserID: userID,
+			Amount: shares[i],
+			Status: models.PaymentRequestStatusPending,
+		}
+		if req.Method == models.SplitMethodPercentage {
+			percentage := values[i]
+			participant.Percentage = &percentage
+		}
+		split.Participants = append(split.Participants, participant)
+	}
+	if err := s.repo.Create(split); err != nil {
+		s.logger.Error("Failed to create bill split", zap.Error(err))
+		return nil, err
+	}
+
+	// A participant whose request could not be sent is picked up again by the next reminder
+	for i := range split.Participants {
+		s.request(split, &split.Participants[i], req.ExpiresAt)
+	}
+	return split, nil
+}
+
+func (s *billSplitService) List(userID uuid.UUID, status string) ([]models.BillSplit, error) {
+	splits, err := s.repo.ListForUser(userID, status)
+	if err != nil {
+		return nil, err
+	}
+	for i := range splits {
+		s.refresh(&splits[i])
+	}
+	return splits, nil
+}
+
+// Both the creator and participants can see a split
+func (s *billSplitService) Get(userID, splitID uuid.UUID) (*models.BillSplit, error) {
+	split, err := s.repo.GetByID(splitID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrBillSplitNotFound
+		}
+		return nil, err
+	}
+	if split.CreatorID != userID && !hasParticipant(split, userID) {
+		return nil, ErrBillSplitNotFound
+	}
+	s.refresh(split)
+	return split, nil
+}
+
+// Nudges every unpaid participant, re-sending requests that expired or were declined.
+// Returns how many participants were reminded.
+func (s *billSplitService) Remind(creatorID, splitID uuid.UUID) (int, error) {
+	split, err := s.getOpenAsCreator(creatorID, splitID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	reminded, throttled := 0, 0
+	for i := range split.Participants {
+		participant := &split.Participants[i]
+		if participant.Status == models.PaymentRequestStatusPaid {
+			continue
+		}
+		if participant.LastRemindedAt != nil && now.Sub(*participant.LastRemindedAt) < manualReminderInterval {
+			throttled++
+			continue
+		}
+		if s.remind(split, participant, now) {
+			reminded++
+		}
+	}
+	if reminded == 0 && throttled > 0 {
+		return 0, ErrReminderTooSoon
+	}
+	return reminded, nil
+}
+
+// Records that a participant settled outside the app, withdrawing their payment request
+func (s *billSplitService) MarkPaid(creatorID, splitID, participantID uuid.UUID) (*models.BillSplit, error) {
+	split, err := s.getOpenAsCreator(creatorID, splitID)
+	if err != nil {
+		return nil, err
+	}
+
+	var participant *models.BillSplitParticipant
+	for i := range split.Participants {
+		if split.Participants[i].ID == participantID {
+			participant = &split.Participants[i]
+		}
+	}
+	if participant == nil {
+		return nil, ErrParticipantNotFound
+	}
+	if participant.Status == models.PaymentRequestStatusPaid {
+		return nil, ErrParticipantAlreadySettled
+	}
+
+	if participant.PaymentRequestID != nil {
+		_, err := s.paymentRequestService.Cancel(creatorID, *participant.PaymentRequestID)
+		if err == ErrPaymentRequestNotPending {
+			// It may have been paid in the meantime
+			s.refresh(split)
+			if participant.Status == models.PaymentRequestStatusPaid {
+				return nil, ErrParticipantAlreadySettled
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	participant.Status = models.PaymentRequestStatusPaid
+	participant.PaidAt = &now
+	if err := s.repo.UpdateParticipant(participant, "status", "paid_at"); err != nil {
+		return nil, err
+	}
+	s.settleIfPaid(split)
+	return split, nil
+}
+
+func (s *billSplitService) Cancel(creatorID, splitID uuid.UUID) (*models.BillSplit, error) {
+	split, err := s.getOpenAsCreator(creatorID, splitID)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := s.repo.Transition(split.ID, models.BillSplitStatusOpen, models.BillSplitStatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return nil, ErrBillSplitNotOpen
+	}
+	split.Status = models.BillSplitStatusCancelled
+
+	for i := range split.Participants {
+		participant := &split.Participants[i]
+		if participant.Status != models.PaymentRequestStatusPending || participant.PaymentRequestID == nil {
+			continue
+		}
+		if _, err := s.paymentRequestService.Cancel(creatorID, *participant.PaymentRequestID); err != nil && err != ErrPaymentRequestNotPending {
+			s.logger.Error("Failed to cancel payment request for cancelled split",
+				zap.String("split_id", split.ID.String()),
+				zap.Error(err),
+			)
+			continue
+		}
+		participant.Status = models.PaymentRequestStatusCancelled
+		if err := s.repo.UpdateParticipant(participant, "status"); err != nil {
+			s.logger.Error("Failed to update split participant", zap.Error(err))
+		}
+	}
+	return split, nil
+}
+
+/*
+Brings open splits up to date with their payment requests, settles the ones that
+are fully paid and sends automatic reminders to participants who still owe.
+Declined requests are left to the creator to chase.
+*/
+func (s *billSplitService) ProcessOpenSplits(now time.Time) {
+	// Paged by creation order so splits that stay open for good cannot crowd out newer ones
+	var after *models.BillSplit
+	for {
+		splits, err := s.repo.ListOpen(after, openSplitPageSize)
+		if err != nil {
+			s.logger.Error("Failed to list open bill splits", zap.Error(err))
+			return
+		}
+		for i := range splits {
+			s.processOpenSplit(&splits[i], now)
+		}
+		if len(splits) < openSplitPageSize {
+			return
+		}
+		after = &splits[len(splits)-1]
+	}
+}
+
+func (s *billSplitService) processOpenSplit(split *models.BillSplit, now time.Time) {
+	s.refresh(split)
+	if split.Status != models.BillSplitStatusOpen {
+		return
+	}
+	for j := range split.Participants {
+		participant := &split.Participants[j]
+		if participant.Status != models.PaymentRequestStatusPending && participant.Status != models.PaymentRequestStatusExpired {
+			continue
+		}
+		if participant.ReminderCount >= autoReminderLimit {
+			continue
+		}
+		last := participant.CreatedAt
+		if participant.LastRemindedAt != nil {
+			last = *participant.LastRemindedAt
+		}
+		if now.Sub(last) >= autoReminderInterval {
+			s.remind(split, participant, now)
+		}
+	}
+}
+
+func (s *billSplitService) getOpenAsCreator(creatorID, splitID uuid.UUID) (*models.BillSplit, error) {
+	split, err := s.Get(creatorID, splitID)
+	if err != nil {
+		return nil, err
+	}
+	if split.CreatorID != creatorID {
+		return nil, ErrBillSplitNotFound
+	}
+	if split.Status != models.BillSplitStatusOpen {
+		return nil, ErrBillSplitNotOpen
+	}
+	return split, nil
+}
+
+// Sends the participant a fresh payment request for their share
+func (s *billSplitService) request(split *models.BillSplit, participant *models.BillSplitParticipant, expiresAt *time.Time) bool {
+	request, err := s.paymentRequestService.RequestFromUser(split.CreatorID, participant.UserID, NewPaymentRequest{
+		Amount:    participant.Amount,
+		Currency:  split.Currency,
+		Note:      "Split: " + split.Title,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		s.logger.Error("Failed to send payment request for bill split",
+			zap.String("split_id", split.ID.String()),
+			zap.String("participant_id", participant.ID.String()),
+			zap.Error(err),
+		)
+		return false
+	}
+	participant.PaymentRequestID = &request.ID
+	participant.Status = models.PaymentRequestStatusPending
+	if err := s.repo.UpdateParticipant(participant, "payment_request_id", "status"); err != nil {
+		s.logger.Error("Failed to link payment request to split participant", zap.Error(err))
+	}
+	return true
+}
+
+// A still-pending request gets a notification; anything else gets a new request
+func (s *billSplitService) remind(split *models.BillSplit, participant *models.BillSplitParticipant, now time.Time) bool {
+	if participant.Status == models.PaymentRequestStatusPending && participant.PaymentRequestID != nil {
+		s.bus.Publish(events.Event{
+			Type:   events.BillSplitReminder,
+			UserID: participant.UserID,
+			Data: map[string]string{
+				"split_id":           split.ID.String(),
+				"payment_request_id": participant.PaymentRequestID.String(),
+				"title":              split.Title,
+				"amount":             formatMoney(participant.Amount, split.Currency),
+				"counterparty":       profileName(s.profileRepo, split.CreatorID),
+			},
+		})
+	} else if !s.request(split, participant, nil) {
+		return false
+	}
+
+	participant.LastRemindedAt = &now
+	participant.ReminderCount++
+	if err := s.repo.UpdateParticipant(participant, "last_reminded_at", "reminder_count"); err != nil {
+		s.logger.Error("Failed to record split reminder", zap.Error(err))
+	}
+	return true
+}
+
+// Copies payment request outcomes onto participants, then settles the split if everyone paid
+func (s *billSplitService) refresh(split *models.BillSplit) {
+	if split.Status != models.BillSplitStatusOpen {
+		return
+	}
+	for i := range split.Participants {
+		participant := &split.Participants[i]
+		if participant.Status == models.PaymentRequestStatusPaid || participant.PaymentRequestID == nil {
+			continue
+		}
+		request, err := s.paymentRequestService.Get(split.CreatorID, *participant.PaymentRequestID)
+		if err != nil {
+			s.logger.Error("Failed to load payment request for split participant", zap.Error(err))
+			continue
+		}
+		if request.Status == participant.Status {
+			continue
+		}
+		participant.Status = request.Status
+		columns := []string{"status"}
+		if request.Status == models.PaymentRequestStatusPaid {
+			participant.PaidAt = request.RespondedAt
+			columns = append(columns, "paid_at")
+		}
+		if err := s.repo.UpdateParticipant(participant, columns...); err != nil {
+			s.logger.Error("Failed to update split participant", zap.Error(err))
+		}
+	}
+	s.settleIfPaid(split)
+}
+
+func (s *billSplitService) settleIfPaid(split *models.BillSplit) {
+	for _, participant := range split.Participants {
+		if participant.Status != models.PaymentRequestStatusPaid {
+			return
+		}
+	}
+	settled, err := s.repo.Transition(split.ID, models.BillSplitStatusOpen, models.BillSplitStatusSettled)
+	if err != nil {
+		s.logger.Error("Failed to settle bill split", zap.Error(err))
+		return
+	}
+	if !settled {
+		return
+	}
+
+	now := time.Now()
+	split.Status = models.BillSplitStatusSettled
+	split.SettledAt = &now
+	if err := s.repo.UpdateSplit(split, "settled_at"); err != nil {
+		s.logger.Error("Failed to record bill split settlement time", zap.Error(err))
+	}
+	s.bus.Publish(events.Event{
+		Type:   events.BillSplitSettled,
+		UserID: split.CreatorID,
+		Data: map[string]string{
+			"split_id": split.ID.String(),
+			"title":    split.Title,
+			"amount":   formatMoney(split.TotalAmount, split.Currency),
+		},
+	})
+}
+
+func hasParticipant(split *models.BillSplit, userID uuid.UUID) bool {
+	for _, participant := range split.Participants {
+		if participant.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Works out each participant's share and the creator's own share. Shares are rounded
+down to the cent and the leftover cents go to the creator when they take part,
+otherwise to the first participant, so the shares always add up to the total.
+*/
+func computeShares(method string, total decimal.Decimal, values []decimal.Decimal, includeCreator bool) ([]decimal.Decimal, decimal.Decimal, error) {
+	shares := make([]decimal.Decimal, len(values))
+	hundred := decimal.NewFromInt(100)
+
+	switch method {
+	case models.SplitMethodEqual:
+		parts := int64(len(values))
+		if includeCreator {
+			parts++
+		}
+		each := total.Div(decimal.NewFromInt(parts)).RoundDown(2)
+		for i := range shares {
+			shares[i] = each
+		}
+	case models.SplitMethodPercentage:
+		sum := decimal.Zero
+		for i, percentage := range values {
+			if !percentage.IsPositive() || percentage.GreaterThan(hundred) {
+				return nil, decimal.Zero, ErrInvalidSplitShares
+			}
+			sum = sum.Add(percentage)
+			shares[i] = total.Mul(percentage).Div(hundred).RoundDown(2)
+		}
+		if (!includeCreator && !sum.Equal(hundred)) || (includeCreator && !sum.LessThan(hundred)) {
+			return nil, decimal.Zero, ErrInvalidSplitShares
+		}
+	case models.SplitMethodExact:
+		sum := decimal.Zero
+		for i, amount := range values {
+			if !amount.IsPositive() || !amount.Equal(amount.Round(2)) {
+				return nil, decimal.Zero, ErrInvalidSplitShares
+			}
+			sum = sum.Add(amount)
+			shares[i] = amount
+		}
+		if (!includeCreator && !sum.Equal(total)) || (includeCreator && !sum.LessThan(total)) {
+			return nil, decimal.Zero, ErrInvalidSplitShares
+		}
+	default:
+		return nil, decimal.Zero, ErrInvalidSplitMethod
+	}
+
+	allocated := decimal.Zero
+	for _, share := range shares {
+		if !share.IsPositive() {
+			return nil, decimal.Zero, ErrInvalidSplitShares
+		}
+		allocated = allocated.Add(share)
+	}
+	remainder := total.Sub(allocated)
+	if includeCreator {
+		return shares, remainder, nil
+	}
+	shares[0] = shares[0].Add(remainder)
+	return shares, decimal.Zero, nil
+}
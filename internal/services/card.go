@@ -1,39 +1,191 @@
 package services
 
 import (
+	"errors"
+	"pgpockets/internal/cardissuer"
+	"pgpockets/internal/events"
 	"pgpockets/internal/models"
 	"pgpockets/internal/repositories"
+	"slices"
+	"strings"
 
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+var (
+	ErrWalletNotOwned       = errors.New("wallet does not belong to user")
+	ErrWalletInactive       = errors.New("wallet is not active")
+	ErrCardNotFound         = errors.New("card not found")
+	ErrCardAlreadyRevealed  = errors.New("card details have already been revealed")
+	ErrCardNotVirtual       = errors.New("card was not issued by pgpockets")
+	ErrCardNotActive        = errors.New("card is not active")
+	ErrCardNotFrozen        = errors.New("card is not frozen")
+	ErrCardTerminated       = errors.New("card has been terminated")
+	ErrInvalidCardLimits    = errors.New("card limits must be positive and per-transaction <= daily <= monthly")
+	ErrCardControlsNotSaved = errors.New("card controls were not saved")
+)
+
+// CardControls are the owner-managed spending rules enforced when a card is authorized
+type CardControls struct {
+	PerTransactionLimit *decimal.Decimal `json:"per_transaction_limit"`
+	DailyLimit          *decimal.Decimal `json:"daily_limit"`
+	MonthlyLimit        *decimal.Decimal `json:"monthly_limit"`
+	AllowedMCCs         []string         `json:"allowed_mccs"`
+	BlockedMCCs         []string         `json:"blocked_mccs"`
+	AllowedCountries    []string         `json:"allowed_countries"`
+	BlockedCountries    []string         `json:"blocked_countries"`
+	OnlineEnabled       bool             `json:"online_enabled"`
+	ATMEnabled          bool             `json:"atm_enabled"`
+}
+
+// Columns written when a card's controls change
+var cardControlColumns = []string{
+	"per_transaction_limit", "daily_limit", "monthly_limit",
+	"allowed_mccs", "blocked_mccs", "allowed_countries", "blocked_countries",
+	"online_enabled", "atm_enabled",
+}
+
 type CardService interface {
-	CreateCard(card *models.Card) error
+	IssueCard(userID, walletID uuid.UUID, cardType, nameOnCard string) (*models.Card, error)
+	RevealCard(userID, cardID uuid.UUID, password string) (*cardissuer.CardDetails, error)
 	RetrieveAllCards(userID string) ([]*models.Card, error)
-	GetCardByID(cardID string) (models.Card, error)
-	DeleteCard(cardID string) error 
+	GetCardByID(userID, cardID uuid.UUID) (*models.Card, error)
+	FreezeCard(userID, cardID uuid.UUID) (*models.Card, error)
+	UnfreezeCard(userID, cardID uuid.UUID) (*models.Card, error)
+	TerminateCard(userID, cardID uuid.UUID) (*models.Card, error)
+	UpdateControls(userID, cardID uuid.UUID, controls CardControls) (*models.Card, error)
+	ReissueCard(cardID uuid.UUID) (*models.Card, error)
 }
 
 type cardService struct {
-	repository repositories.CardRepository
-	logger     *zap.Logger
+	repository  repositories.CardRepository
+	walletRepo  repositories.WalletRepository
+	userRepo    repositories.UserRepository
+	profileRepo repositories.ProfileRepository
+	issuer      cardissuer.CardIssuer
+	logger      *zap.Logger
+	bus         events.Bus
+	db          *gorm.DB
 }
 
-func NewCardService(repo repositories.CardRepository, appLogger *zap.Logger) *cardService {
+func NewCardService(
+	repo repositories.CardRepository,
+	walletRepo repositories.WalletRepository,
+	userRepo repositories.UserRepository,
+	profileRepo repositories.ProfileRepository,
+	issuer cardissuer.CardIssuer,
+	appLogger *zap.Logger,
+	bus events.Bus,
+	db *gorm.DB,
+) *cardService {
 	return &cardService{
-		repository: repo,
-		logger: appLogger,
+		repository:  repo,
+		walletRepo:  walletRepo,
+		userRepo:    userRepo,
+		profileRepo: profileRepo,
+		issuer:      issuer,
+		logger:      appLogger,
+		bus:         bus,
+		db:          db,
 	}
 }
 
+// Issues a virtual card through the card issuer and links it to one of the user's wallets.
+// Only the vault token and display details are stored on our side.
+func (s *cardService) IssueCard(userID, walletID uuid.UUID, cardType, nameOnCard string) (*models.Card, error) {
+	wallet, err := s.walletRepo.GetWalletByID(walletID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrWalletNotOwned
+		}
+		s.logger.Error("Failed to look up funding wallet", zap.Error(err))
+		return nil, err
+	}
+	if wallet.UserID != userID {
+		s.logger.Warn("Card issue attempted on another user's wallet",
+			zap.String("user_id", userID.String()),
+			zap.String("wallet_id", walletID.String()))
+		return nil, ErrWalletNotOwned
+	}
+	if !wallet.IsActive {
+		return nil, ErrWalletInactive
+	}
+
+	if nameOnCard == "" {
+		profile, err := s.profileRepo.GetProfileByUserID(userID.String())
+		if err != nil {
+			s.logger.Error("Failed to look up profile for card name", zap.Error(err))
+			return nil, err
+		}
+		nameOnCard = strings.ToUpper(strings.TrimSpace(profile.FirstName + " " + profile.LastName))
+	}
+
+	issued, err := s.issuer.IssueCard(cardissuer.IssueRequest{
+		CardType:   cardType,
+		NameOnCard: nameOnCard,
+		Currency:   wallet.Currency,
+	})
+	if err != nil {
+		s.logger.Error("Card issuer failed to issue card", zap.String("issuer", s.issuer.Name()), zap.Error(err))
+		return nil, err
+	}
 
-func (s *cardService) CreateCard(card *models.Card) error {
+	card := newIssuedCard(issued, userID, walletID, cardType, nameOnCard)
 	if err := s.repository.CreateCard(card); err != nil {
 		s.logger.Error("Failed to create card", zap.Error(err))
-		return err
+		return nil, err
 	}
-	s.logger.Info("Card created successfully", zap.String("cardID", card.ID.String()))
-	return nil
+	s.logger.Info("Card issued successfully", zap.String("cardID", card.ID.String()))
+	s.publish(events.CardIssued, card, map[string]string{"card_brand": card.CardBrand})
+	return card, nil
+}
+
+// Shows the full PAN and CVV exactly once, after the user re-enters their password
+func (s *cardService) RevealCard(userID, cardID uuid.UUID, password string) (*cardissuer.CardDetails, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		s.logger.Error("Failed to look up user for card reveal", zap.Error(err))
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		s.logger.Warn("Card reveal failed: incorrect password", zap.String("user_id", userID.String()))
+		return nil, ErrIncorrectPassword
+	}
+
+	card, err := s.getOwnedCard(userID, cardID)
+	if err != nil {
+		return nil, err
+	}
+	if card.Issuer == "" {
+		return nil, ErrCardNotVirtual
+	}
+	if card.RevealedAt != nil {
+		return nil, ErrCardAlreadyRevealed
+	}
+
+	// The reveal is only used up once the issuer has returned the details. If two requests race,
+	// both fetch but only the one that marks the card gets to return them.
+	details, err := s.issuer.RevealCard(card.CardToken)
+	if err != nil {
+		s.logger.Error("Card issuer failed to reveal card", zap.Error(err))
+		return nil, err
+	}
+	if err := s.repository.MarkRevealed(cardID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrCardAlreadyRevealed
+		}
+		s.logger.Error("Failed to mark card revealed", zap.Error(err))
+		return nil, err
+	}
+	details.ExpiryMonth = card.ExpiryMonth
+	details.ExpiryYear = card.ExpiryYear
+
+	s.logger.Info("Card details revealed", zap.String("cardID", cardID.String()))
+	return details, nil
 }
 
 func (s *cardService) RetrieveAllCards(userID string) ([]*models.Card, error){
@@ -46,22 +198,304 @@ func (s *cardService) RetrieveAllCards(userID string) ([]*models.Card, error){
 	return cards, nil
 }
 
-func (s *cardService) GetCardByID(cardID string) (models.Card, error) {
-	card, err := s.repository.GetCardByID(cardID)
+func (s *cardService) GetCardByID(userID, cardID uuid.UUID) (*models.Card, error) {
+	card, err := s.getOwnedCard(userID, cardID)
 	if err != nil {
-		s.logger.Error("Failed to retrieve card by ID", zap.Error(err))
-		return models.Card{}, err
+		return nil, err
 	}
 	s.logger.Info("Card retrieved successfully", zap.String("cardID", card.ID.String()))
 	return card, nil
 }
 
-func (s *cardService) DeleteCard(cardID string) error {
-	err := s.repository.DeleteCard(cardID)
+// Temporarily blocks all authorizations on the card
+func (s *cardService) FreezeCard(userID, cardID uuid.UUID) (*models.Card, error) {
+	card, err := s.getOwnedCard(userID, cardID)
 	if err != nil {
-		s.logger.Error("Failed to delete card", zap.Error(err))
-		return err
+		return nil, err
+	}
+	if card.Status == models.CardStatusTerminated {
+		return nil, ErrCardTerminated
+	}
+	if card.Status != models.CardStatusActive {
+		return nil, ErrCardNotActive
+	}
+	return s.setStatus(card, models.CardStatusFrozen)
+}
+
+func (s *cardService) UnfreezeCard(userID, cardID uuid.UUID) (*models.Card, error) {
+	card, err := s.getOwnedCard(userID, cardID)
+	if err != nil {
+		return nil, err
+	}
+	if card.Status != models.CardStatusFrozen {
+		return nil, ErrCardNotFrozen
+	}
+	return s.setStatus(card, models.CardStatusActive)
+}
+
+/*
+	Permanently closes the card. The row is kept so past authorizations and
+	transactions still resolve; pending holds can still be captured by the issuer.
+*/
+func (s *cardService) TerminateCard(userID, cardID uuid.UUID) (*models.Card, error) {
+	card, err := s.getOwnedCard(userID, cardID)
+	if err != nil {
+		return nil, err
+	}
+	if card.Status == models.CardStatusTerminated {
+		return nil, ErrCardTerminated
+	}
+	return s.setStatus(card, models.CardStatusTerminated)
+}
+
+// Replaces the card's spending limits and merchant/channel restrictions
+func (s *cardService) UpdateControls(userID, cardID uuid.UUID, controls CardControls) (*models.Card, error) {
+	if !validCardLimits(controls) {
+		return nil, ErrInvalidCardLimits
+	}
+	card, err := s.getOwnedCard(userID, cardID)
+	if err != nil {
+		return nil, err
+	}
+	if card.Status == models.CardStatusTerminated {
+		return nil, ErrCardTerminated
+	}
+
+	card.PerTransactionLimit = controls.PerTransactionLimit
+	card.DailyLimit = controls.DailyLimit
+	card.MonthlyLimit = controls.MonthlyLimit
+	card.AllowedMCCs = normalizeCodes(controls.AllowedMCCs)
+	card.BlockedMCCs = normalizeCodes(controls.BlockedMCCs)
+	card.AllowedCountries = normalizeCodes(controls.AllowedCountries)
+	card.BlockedCountries = normalizeCodes(controls.BlockedCountries)
+	card.OnlineEnabled = controls.OnlineEnabled
+	card.ATMEnabled = controls.ATMEnabled
+	if err := s.repository.UpdateCard(card, cardControlColumns...); err != nil {
+		s.logger.Error("Failed to update card controls", zap.Error(err))
+		return nil, err
 	}
-	s.logger.Info("Card deleted successfully", zap.String("cardID", cardID))
-	return nil
-}
\ No newline at end of file
+
+	// Answer with what was stored, and make sure it is what authorization will see
+	saved, err := s.repository.GetCardByID(card.ID.String())
+	if err != nil {
+		s.logger.Error("Failed to reload card after updating controls", zap.Error(err))
+		return nil, err
+	}
+	if !slices.Equal(saved.AllowedMCCs, card.AllowedMCCs) || !slices.Equal(saved.BlockedMCCs, card.BlockedMCCs) ||
+		!slices.Equal(saved.AllowedCountries, card.AllowedCountries) || !slices.Equal(saved.BlockedCountries, card.BlockedCountries) {
+		s.logger.Error("Card controls did not round-trip through the repository", zap.String("cardID", card.ID.String()))
+		return nil, ErrCardControlsNotSaved
+	}
+	s.logger.Info("Card controls updated", zap.String("cardID", card.ID.String()))
+	return &saved, nil
+}
+
+/*
+	Issues a replacement for a card that is about to expire. The new card keeps the
+	funding wallet, name, network, controls and frozen state of the old one.
+*/
+func (s *cardService) ReissueCard(cardID uuid.UUID) (*models.Card, error) {
+	old, err := s.repository.GetCardByID(cardID.String())
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrCardNotFound
+		}
+		return nil, err
+	}
+	if old.Issuer == "" || old.WalletID == nil {
+		return nil, ErrCardNotVirtual
+	}
+	if old.Status == models.CardStatusTerminated {
+		return nil, ErrCardTerminated
+	}
+	// A retried reissue hands back the replacement that was already issued
+	if old.ReplacedByCardID != nil {
+		replacement, err := s.repository.GetCardByID(old.ReplacedByCardID.String())
+		if err != nil {
+			s.logger.Error("Failed to look up existing replacement card", zap.Error(err))
+			return nil, err
+		}
+		return &replacement, nil
+	}
+	wallet, err := s.walletRepo.GetWalletByID(*old.WalletID)
+	if err != nil {
+		s.logger.Error("Failed to look up funding wallet for reissue", zap.Error(err))
+		return nil, err
+	}
+	if !wallet.IsActive {
+		return nil, ErrWalletInactive
+	}
+
+	issued, err := s.issuer.IssueCard(cardissuer.IssueRequest{
+		CardType:   old.CardType,
+		NameOnCard: old.NameOnCard,
+		Currency:   wallet.Currency,
+	})
+	if err != nil {
+		s.logger.Error("Card issuer failed to reissue card", zap.String("issuer", s.issuer.Name()), zap.Error(err))
+		return nil, err
+	}
+
+	card := newIssuedCard(issued, old.UserID, *old.WalletID, old.CardType, old.NameOnCard)
+	card.PerTransactionLimit = old.PerTransactionLimit
+	card.DailyLimit = old.DailyLimit
+	card.MonthlyLimit = old.MonthlyLimit
+	card.AllowedMCCs = old.AllowedMCCs
+	card.BlockedMCCs = old.BlockedMCCs
+	card.AllowedCountries = old.AllowedCountries
+	card.BlockedCountries = old.BlockedCountries
+
+	// The replacement, its controls and the link from the old card are written together, so a
+	// failure leaves no unlinked card behind for the next expiry run to duplicate
+	var replacement *models.Card
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		repo := s.repository.WithTx(tx)
+		// Locked so two reissues of the same card cannot both link a replacement
+		locked, err := repo.GetCardByIDForUpdate(old.ID)
+		if err != nil {
+			return err
+		}
+		if locked.ReplacedByCardID != nil {
+			existing, err := repo.GetCardByID(locked.ReplacedByCardID.String())
+			if err != nil {
+				return err
+			}
+			replacement = &existing
+			return nil
+		}
+
+		if err := repo.CreateCard(card); err != nil {
+			return err
+		}
+		// Toggles and status are written after insert: GORM skips false/zero fields that have
+		// a column default on create, which would silently turn disabled toggles back on
+		card.OnlineEnabled = old.OnlineEnabled
+		card.ATMEnabled = old.ATMEnabled
+		card.Status = old.Status
+		card.IsActive = old.Status == models.CardStatusActive
+		if err := repo.UpdateCard(card, "online_enabled", "atm_enabled", "status", "is_active"); err != nil {
+			return err
+		}
+		locked.ReplacedByCardID = &card.ID
+		return repo.UpdateCard(locked, "replaced_by_card_id")
+	})
+	if err != nil {
+		s.logger.Error("Failed to save replacement card", zap.Error(err))
+		return nil, err
+	}
+	if replacement != nil {
+		s.logger.Warn("Card was reissued concurrently, keeping the existing replacement",
+			zap.String("old_card_id", old.ID.String()),
+			zap.String("unused_last_four", card.LastFourDigits))
+		return replacement, nil
+	}
+	s.logger.Info("Card reissued",
+		zap.String("old_card_id", old.ID.String()),
+		zap.String("new_card_id", card.ID.String()))
+	s.publish(events.CardReissued, card, map[string]string{"old_last_four": old.LastFourDigits})
+	return card, nil
+}
+
+// Every card endpoint goes through here so users can only ever see or change their own cards
+func (s *cardService) getOwnedCard(userID, cardID uuid.UUID) (*models.Card, error) {
+	card, err := s.repository.GetCardByID(cardID.String())
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			s.logger.Error("Failed to retrieve card by ID", zap.Error(err))
+			return nil, err
+		}
+		return nil, ErrCardNotFound
+	}
+	if card.UserID != userID {
+		s.logger.Warn("Card access attempted by non-owner",
+			zap.String("user_id", userID.String()),
+			zap.String("card_id", cardID.String()))
+		return nil, ErrCardNotFound
+	}
+	return &card, nil
+}
+
+func (s *cardService) setStatus(card *models.Card, status string) (*models.Card, error) {
+	card.Status = status
+	card.IsActive = status == models.CardStatusActive
+	if err := s.repository.UpdateCard(card, "status", "is_active"); err != nil {
+		s.logger.Error("Failed to update card status", zap.Error(err))
+		return nil, err
+	}
+	s.logger.Info("Card status changed", zap.String("cardID", card.ID.String()), zap.String("status", status))
+	if eventType, ok := cardStatusEvents[status]; ok {
+		s.publish(eventType, card, nil)
+	}
+	return card, nil
+}
+
+// Status changes the card owner is told about
+var cardStatusEvents = map[string]string{
+	models.CardStatusFrozen:     events.CardFrozen,
+	models.CardStatusActive:     events.CardUnfrozen,
+	models.CardStatusTerminated: events.CardTerminated,
+}
+
+func (s *cardService) publish(eventType string, card *models.Card, extra map[string]string) {
+	data := map[string]string{
+		"card_id":   card.ID.String(),
+		"last_four": card.LastFourDigits,
+	}
+	for key, value := range extra {
+		data[key] = value
+	}
+	s.bus.Publish(events.Event{Type: eventType, UserID: card.UserID, Data: data})
+}
+
+func newIssuedCard(issued *cardissuer.IssuedCard, userID, walletID uuid.UUID, cardType, nameOnCard string) *models.Card {
+	return &models.Card{
+		UserID:         userID,
+		WalletID:       &walletID,
+		CardToken:      issued.VaultToken,
+		LastFourDigits: issued.LastFourDigits,
+		CardType:       cardType,
+		ExpiryMonth:    issued.ExpiryMonth,
+		ExpiryYear:     issued.ExpiryYear,
+		CardBrand:      issued.CardBrand,
+		BankName:       issued.BankName,
+		NameOnCard:     nameOnCard,
+		Issuer:         issued.Issuer,
+		IsActive:       true,
+		Status:         models.CardStatusActive,
+		OnlineEnabled:  true,
+		ATMEnabled:     true,
+	}
+}
+
+func validCardLimits(controls CardControls) bool {
+	limits := []*decimal.Decimal{controls.PerTransactionLimit, controls.DailyLimit, controls.MonthlyLimit}
+	var previous *decimal.Decimal
+	for _, limit := range limits {
+		if limit == nil {
+			continue
+		}
+		if !limit.IsPositive() {
+			return false
+		}
+		if previous != nil && limit.LessThan(*previous) {
+			return false
+		}
+		previous = limit
+	}
+	return true
+}
+
+// Upper-cases and de-duplicates MCC and country codes
+func normalizeCodes(codes []string) []string {
+	seen := make(map[string]bool, len(codes))
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		normalized = append(normalized, code)
+	}
+	return normalized
+}
@@ -0,0 +1,575 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"pgpockets/internal/events"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAuthorizationNotFound       = errors.New("authorization not found")
+	ErrAuthorizationNotPending     = errors.New("authorization is no longer pending")
+	ErrCaptureExceedsAuthorization = errors.New("capture amount exceeds authorized amount")
+	ErrInvalidAmount               = errors.New("amount must be greater than zero")
+)
+
+// Reasons returned to the issuer when an authorization is declined
+const (
+	DeclineCardNotFound      = "card_not_found"
+	DeclineCardInactive      = "card_inactive"
+	DeclineCardExpired       = "card_expired"
+	DeclineNoFundingWallet   = "no_funding_wallet"
+	DeclineWalletInactive    = "wallet_inactive"
+	DeclineCurrencyMismatch  = "currency_mismatch"
+	DeclineInsufficientFunds = "insufficient_funds"
+	DeclineInvalidAmount     = "invalid_amount"
+	DeclineCardFrozen        = "card_frozen"
+	DeclineCardTerminated    = "card_terminated"
+	DeclineMCCBlocked        = "merchant_category_blocked"
+	DeclineCountryBlocked    = "merchant_country_blocked"
+	DeclineOnlineDisabled    = "online_payments_disabled"
+	DeclineATMDisabled       = "atm_withdrawals_disabled"
+	DeclineTransactionLimit  = "exceeds_transaction_limit"
+	DeclineDailyLimit        = "exceeds_daily_limit"
+	DeclineMonthlyLimit      = "exceeds_monthly_limit"
+)
+
+// Merchant category code for ATM cash withdrawals
+const mccATMCash = "6011"
+
+// Holds that are never settled are released after this long
+const authorizationHoldTTL = 7 * 24 * time.Hour
+
+// AuthorizationRequest is an issuer's request to approve a card payment
+type AuthorizationRequest struct {
+	IssuerReference      string
+	CardToken            string
+	Amount               decimal.Decimal
+	Currency             string
+	MerchantName         string
+	MerchantCategoryCode string
+	MerchantCity         string
+	MerchantCountry      string
+	Channel              string // pos, online or atm; defaults to pos
+}
+
+type CardAuthorizationService interface {
+	Authorize(req AuthorizationRequest) (*models.CardAuthorization, error)
+	Capture(authID uuid.UUID, amount *decimal.Decimal) (*models.CardAuthorization, error)
+	CaptureByIssuerReference(reference string, amount *decimal.Decimal) (*models.CardAuthorization, error)
+	Reverse(authID uuid.UUID) (*models.CardAuthorization, error)
+	ReverseByIssuerReference(reference string) (*models.CardAuthorization, error)
+	ListForCard(userID, cardID uuid.UUID, limit, offset int) ([]models.CardAuthorization, error)
+	SimulateAuthorization(userID, cardID uuid.UUID, req AuthorizationRequest) (*models.CardAuthorization, error)
+	SimulateCapture(userID, cardID, authID uuid.UUID, amount *decimal.Decimal) (*models.CardAuthorization, error)
+	SimulateReversal(userID, cardID, authID uuid.UUID) (*models.CardAuthorization, error)
+	ExpireStaleHolds() (int, error)
+}
+
+type cardAuthorizationService struct {
+	authRepo   repositories.CardAuthorizationRepository
+	cardRepo   repositories.CardRepository
+	walletRepo repositories.WalletRepository
+	txnRepo    repositories.TransactionRepository
+	logger     *zap.Logger
+	db         *gorm.DB
+	bus        events.Bus
+}
+
+func NewCardAuthorizationService(
+	authRepo repositories.CardAuthorizationRepository,
+	cardRepo repositories.CardRepository,
+	walletRepo repositories.WalletRepository,
+	txnRepo repositories.TransactionRepository,
+	logger *zap.Logger,
+	db *gorm.DB,
+	bus events.Bus,
+) *cardAuthorizationService {
+	return &cardAuthorizationService{
+		authRepo:   authRepo,
+		cardRepo:   cardRepo,
+		walletRepo: walletRepo,
+		txnRepo:    txnRepo,
+		logger:     logger,
+		db:         db,
+		bus:        bus,
+	}
+}
+
+// Approves or declines a card payment. Approval places a hold on the funding wallet.
+// Replays of the same issuer reference return the original decision.
+func (s *cardAuthorizationService) Authorize(req AuthorizationRequest) (*models.CardAuthorization, error) {
+	if existing, err := s.authRepo.GetByIssuerReference(req.IssuerReference); err == nil {
+		return existing, nil
+	} else if err != gorm.ErrRecordNotFound {
+		s.logger.Error("Failed to look up authorization", zap.Error(err))
+		return nil, err
+	}
+
+	auth := &models.CardAuthorization{
+		IssuerReference:      req.IssuerReference,
+		Amount:               req.Amount,
+		Currency:             req.Currency,
+		MerchantName:         req.MerchantName,
+		MerchantCategoryCode: req.MerchantCategoryCode,
+		MerchantCity:         req.MerchantCity,
+		MerchantCountry:      req.MerchantCountry,
+		Channel:              req.Channel,
+		ExpiresAt:            time.Now().Add(authorizationHoldTTL),
+	}
+	if auth.Channel == "" {
+		auth.Channel = models.CardChannelPOS
+	}
+
+	card, err := s.cardRepo.GetCardByToken(req.CardToken)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			// Nothing to attach the decline to, so it is not persisted
+			auth.Status = models.AuthorizationStatusDeclined
+			auth.DeclineReason = DeclineCardNotFound
+			return auth, nil
+		}
+		s.logger.Error("Failed to look up card for authorization", zap.Error(err))
+		return nil, err
+	}
+	auth.CardID = card.ID
+	if card.WalletID != nil {
+		walletID := *card.WalletID
+		auth.WalletID = &walletID
+	}
+
+	var declineReason string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// The card lock serializes authorizations on the same card so spend limits hold under concurrency
+		locked, err := s.cardRepo.WithTx(tx).GetCardByIDForUpdate(card.ID)
+		if err != nil {
+			return err
+		}
+		if declineReason = checkCardControls(locked, auth); declineReason != "" {
+			return nil
+		}
+		if declineReason, err = s.checkSpendLimits(tx, locked, auth.Amount); err != nil || declineReason != "" {
+			return err
+		}
+
+		wallet, err := s.walletRepo.WithTx(tx).GetWalletByIDForUpdate(*auth.WalletID)
+		if err != nil {
+			return err
+		}
+		if !wallet.IsActive {
+			declineReason = DeclineWalletInactive
+			return nil
+		}
+		if wallet.Currency != req.Currency {
+			declineReason = DeclineCurrencyMismatch
+			return nil
+		}
+		if wallet.Balance.Sub(wallet.HeldBalance).LessThan(req.Amount) {
+			declineReason = DeclineInsufficientFunds
+			return nil
+		}
+
+		if err := s.walletRepo.WithTx(tx).AdjustBalances(wallet.ID, decimal.Zero, req.Amount); err != nil {
+			return err
+		}
+		auth.Status = models.AuthorizationStatusPending
+		return s.authRepo.WithTx(tx).Create(auth)
+	})
+	if err != nil {
+		s.logger.Error("Failed to authorize card payment", zap.Error(err))
+		return nil, err
+	}
+	if declineReason != "" {
+		return s.decline(card, auth, declineReason)
+	}
+
+	s.logger.Info("Card payment authorized",
+		zap.String("authorization_id", auth.ID.String()),
+		zap.String("card_id", card.ID.String()),
+		zap.String("amount", req.Amount.String()))
+	s.publishBalanceChange(card.UserID, auth)
+	s.bus.Publish(events.Event{
+		Type:   events.CardAuthorized,
+		UserID: card.UserID,
+		Data: map[string]string{
+			"authorization_id": auth.ID.String(),
+			"card_id":          card.ID.String(),
+			"last_four":        card.LastFourDigits,
+			"amount":           formatMoney(auth.Amount, auth.Currency),
+			"merchant":         auth.MerchantName,
+		},
+	})
+	return auth, nil
+}
+
+// Settles a pending authorization, debiting the wallet and recording a payment transaction.
+// amount may be lower than the authorized amount (partial capture); the rest of the hold is released.
+func (s *cardAuthorizationService) Capture(authID uuid.UUID, amount *decimal.Decimal) (*models.CardAuthorization, error) {
+	var auth *models.CardAuthorization
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		auth, err = s.authRepo.WithTx(tx).GetByIDForUpdate(authID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrAuthorizationNotFound
+			}
+			return err
+		}
+		if auth.Status != models.AuthorizationStatusPending {
+			return ErrAuthorizationNotPending
+		}
+
+		captureAmount := auth.Amount
+		if amount != nil {
+			captureAmount = *amount
+		}
+		if !captureAmount.IsPositive() {
+			return ErrInvalidAmount
+		}
+		if captureAmount.GreaterThan(auth.Amount) {
+			return ErrCaptureExceedsAuthorization
+		}
+
+		if _, err := s.walletRepo.WithTx(tx).GetWalletByIDForUpdate(*auth.WalletID); err != nil {
+			return err
+		}
+		if err := s.walletRepo.WithTx(tx).AdjustBalances(*auth.WalletID, captureAmount.Neg(), auth.Amount.Neg()); err != nil {
+			return err
+		}
+
+		cardID := auth.CardID
+		walletID := *auth.WalletID
+		txn, err := s.txnRepo.WithTx(tx).CreateTransaction(&models.Transaction{
+			SenderWalletID:       &walletID,
+			Amount:               captureAmount.String(),
+			Currency:             auth.Currency,
+			TransactionType:      models.TransactionTypePayment,
+			Status:               models.TransactionStatusCompleted,
+			Description:          fmt.Sprintf("Card payment at %s", auth.MerchantName),
+			ReferenceID:          fmt.Sprintf("Card_%d_%s", time.Now().Unix(), uuid.New()),
+			CardID:               &cardID,
+			MerchantName:         auth.MerchantName,
+			MerchantCategoryCode: auth.MerchantCategoryCode,
+			MerchantCity:         auth.MerchantCity,
+			MerchantCountry:      auth.MerchantCountry,
+		})
+		if err != nil {
+			return err
+		}
+
+		auth.Status = models.AuthorizationStatusCaptured
+		auth.CapturedAmount = captureAmount
+		auth.TransactionID = &txn.ID
+		return s.authRepo.WithTx(tx).Update(auth)
+	})
+	if err != nil {
+		if !isCardAuthorizationClientError(err) {
+			s.logger.Error("Failed to capture authorization", zap.Error(err))
+		}
+		return nil, err
+	}
+
+	s.logger.Info("Card authorization captured",
+		zap.String("authorization_id", auth.ID.String()),
+		zap.String("amount", auth.CapturedAmount.String()))
+	s.publishBalanceChange(uuid.Nil, auth)
+	return auth, nil
+}
+
+func (s *cardAuthorizationService) CaptureByIssuerReference(reference string, amount *decimal.Decimal) (*models.CardAuthorization, error) {
+	auth, err := s.authRepo.GetByIssuerReference(reference)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrAuthorizationNotFound
+		}
+		return nil, err
+	}
+	return s.Capture(auth.ID, amount)
+}
+
+// Releases the hold of a pending authorization without moving money
+func (s *cardAuthorizationService) Reverse(authID uuid.UUID) (*models.CardAuthorization, error) {
+	auth, err := s.release(authID, models.AuthorizationStatusReversed)
+	if err != nil {
+		if !isCardAuthorizationClientError(err) {
+			s.logger.Error("Failed to reverse authorization", zap.Error(err))
+		}
+		return nil, err
+	}
+	s.logger.Info("Card authorization reversed", zap.String("authorization_id", auth.ID.String()))
+	return auth, nil
+}
+
+func (s *cardAuthorizationService) ReverseByIssuerReference(reference string) (*models.CardAuthorization, error) {
+	auth, err := s.authRepo.GetByIssuerReference(reference)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrAuthorizationNotFound
+		}
+		return nil, err
+	}
+	return s.Reverse(auth.ID)
+}
+
+func (s *cardAuthorizationService) ListForCard(userID, cardID uuid.UUID, limit, offset int) ([]models.CardAuthorization, error) {
+	card, err := s.cardRepo.GetCardByID(cardID.String())
+	if err != nil || card.UserID != userID {
+		return nil, ErrCardNotFound
+	}
+	auths, err := s.authRepo.ListByCardID(cardID, limit, offset)
+	if err != nil {
+		s.logger.Error("Failed to list card authorizations", zap.Error(err))
+		return nil, err
+	}
+	return auths, nil
+}
+
+// Lets a card owner exercise the authorization flow against the local issuer simulator
+func (s *cardAuthorizationService) SimulateAuthorization(userID, cardID uuid.UUID, req AuthorizationRequest) (*models.CardAuthorization, error) {
+	card, err := s.cardRepo.GetCardByID(cardID.String())
+	if err != nil || card.UserID != userID {
+		return nil, ErrCardNotFound
+	}
+	req.CardToken = card.CardToken
+	req.IssuerReference = "sim_" + uuid.NewString()
+	return s.Authorize(req)
+}
+
+func (s *cardAuthorizationService) SimulateCapture(userID, cardID, authID uuid.UUID, amount *decimal.Decimal) (*models.CardAuthorization, error) {
+	if err := s.checkAuthorizationOwner(userID, cardID, authID); err != nil {
+		return nil, err
+	}
+	return s.Capture(authID, amount)
+}
+
+func (s *cardAuthorizationService) SimulateReversal(userID, cardID, authID uuid.UUID) (*models.CardAuthorization, error) {
+	if err := s.checkAuthorizationOwner(userID, cardID, authID); err != nil {
+		return nil, err
+	}
+	return s.Reverse(authID)
+}
+
+// Releases holds that were never settled by the issuer
+func (s *cardAuthorizationService) ExpireStaleHolds() (int, error) {
+	stale, err := s.authRepo.ListExpiredPending(time.Now(), 100)
+	if err != nil {
+		s.logger.Error("Failed to list stale authorizations", zap.Error(err))
+		return 0, err
+	}
+
+	expired := 0
+	for _, auth := range stale {
+		if _, err := s.release(auth.ID, models.AuthorizationStatusExpired); err != nil {
+			if err != ErrAuthorizationNotPending {
+				s.logger.Error("Failed to expire authorization", zap.String("authorization_id", auth.ID.String()), zap.Error(err))
+			}
+			continue
+		}
+		expired++
+	}
+	if expired > 0 {
+		s.logger.Info("Expired stale card authorizations", zap.Int("count", expired))
+	}
+	return expired, nil
+}
+
+func (s *cardAuthorizationService) release(authID uuid.UUID, status string) (*models.CardAuthorization, error) {
+	var auth *models.CardAuthorization
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		auth, err = s.authRepo.WithTx(tx).GetByIDForUpdate(authID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrAuthorizationNotFound
+			}
+			return err
+		}
+		if auth.Status != models.AuthorizationStatusPending {
+			return ErrAuthorizationNotPending
+		}
+		if _, err := s.walletRepo.WithTx(tx).GetWalletByIDForUpdate(*auth.WalletID); err != nil {
+			return err
+		}
+		if err := s.walletRepo.WithTx(tx).AdjustBalances(*auth.WalletID, decimal.Zero, auth.Amount.Neg()); err != nil {
+			return err
+		}
+		auth.Status = status
+		return s.authRepo.WithTx(tx).Update(auth)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.publishBalanceChange(uuid.Nil, auth)
+	return auth, nil
+}
+
+// Announces that a hold was placed, settled or released. The wallet owner is looked up when not known.
+func (s *cardAuthorizationService) publishBalanceChange(userID uuid.UUID, auth *models.CardAuthorization) {
+	if userID == uuid.Nil {
+		wallet, err := s.walletRepo.GetWalletByID(*auth.WalletID)
+		if err != nil {
+			s.logger.Error("Failed to look up wallet for balance event", zap.Error(err))
+			return
+		}
+		userID = wallet.UserID
+	}
+	data := map[string]string{"wallet_id": auth.WalletID.String()}
+	if auth.TransactionID != nil {
+		data["transaction_id"] = auth.TransactionID.String()
+		data["transaction_status"] = models.TransactionStatusCompleted
+	}
+	s.bus.Publish(events.Event{Type: events.WalletBalanceChanged, UserID: userID, Data: data})
+}
+
+func (s *cardAuthorizationService) checkAuthorizationOwner(userID, cardID, authID uuid.UUID) error {
+	card, err := s.cardRepo.GetCardByID(cardID.String())
+	if err != nil || card.UserID != userID {
+		return ErrCardNotFound
+	}
+	auth, err := s.authRepo.GetByID(authID)
+	if err != nil || auth.CardID != cardID {
+		return ErrAuthorizationNotFound
+	}
+	return nil
+}
+
+// Applies the card's lifecycle state and owner controls. Returns a decline reason or "" to continue.
+func checkCardControls(card *models.Card, auth *models.CardAuthorization) string {
+	if !auth.Amount.IsPositive() {
+		return DeclineInvalidAmount
+	}
+	switch card.Status {
+	case models.CardStatusFrozen:
+		return DeclineCardFrozen
+	case models.CardStatusTerminated:
+		return DeclineCardTerminated
+	case models.CardStatusExpired:
+		return DeclineCardExpired
+	}
+	if !card.IsActive {
+		return DeclineCardInactive
+	}
+	if isCardExpired(card, time.Now()) {
+		return DeclineCardExpired
+	}
+	if card.WalletID == nil {
+		return DeclineNoFundingWallet
+	}
+
+	if auth.Channel == models.CardChannelOnline && !card.OnlineEnabled {
+		return DeclineOnlineDisabled
+	}
+	if (auth.Channel == models.CardChannelATM || auth.MerchantCategoryCode == mccATMCash) && !card.ATMEnabled {
+		return DeclineATMDisabled
+	}
+	if !controlAllows(card.AllowedMCCs, card.BlockedMCCs, auth.MerchantCategoryCode) {
+		return DeclineMCCBlocked
+	}
+	if !controlAllows(card.AllowedCountries, card.BlockedCountries, strings.ToUpper(auth.MerchantCountry)) {
+		return DeclineCountryBlocked
+	}
+	if card.PerTransactionLimit != nil && auth.Amount.GreaterThan(*card.PerTransactionLimit) {
+		return DeclineTransactionLimit
+	}
+	return ""
+}
+
+// Checks the amount against the card's daily and monthly limits (calendar periods in UTC)
+func (s *cardAuthorizationService) checkSpendLimits(tx *gorm.DB, card *models.Card, amount decimal.Decimal) (string, error) {
+	now := time.Now().UTC()
+	limits := []struct {
+		limit  *decimal.Decimal
+		since  time.Time
+		reason string
+	}{
+		{card.DailyLimit, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), DeclineDailyLimit},
+		{card.MonthlyLimit, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), DeclineMonthlyLimit},
+	}
+	for _, l := range limits {
+		if l.limit == nil {
+			continue
+		}
+		spent, err := s.authRepo.WithTx(tx).SumSpendSince(card.ID, l.since)
+		if err != nil {
+			return "", err
+		}
+		if spent.Add(amount).GreaterThan(*l.limit) {
+			return l.reason, nil
+		}
+	}
+	return "", nil
+}
+
+// An allow list, when set, must contain the value; a block list must not.
+// Values the issuer did not send only pass when no allow list is set.
+func controlAllows(allowed, blocked []string, value string) bool {
+	if len(allowed) > 0 && !containsString(allowed, value) {
+		return false
+	}
+	return value == "" || !containsString(blocked, value)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *cardAuthorizationService) decline(card *models.Card, auth *models.CardAuthorization, reason string) (*models.CardAuthorization, error) {
+	auth.Status = models.AuthorizationStatusDeclined
+	auth.DeclineReason = reason
+	if err := s.authRepo.Create(auth); err != nil {
+		s.logger.Error("Failed to record declined authorization", zap.Error(err))
+		return nil, err
+	}
+	s.logger.Info("Card payment declined",
+		zap.String("card_id", auth.CardID.String()),
+		zap.String("reason", reason))
+	s.bus.Publish(events.Event{
+		Type:   events.CardAuthorizationDeclined,
+		UserID: card.UserID,
+		Data: map[string]string{
+			"authorization_id": auth.ID.String(),
+			"card_id":          card.ID.String(),
+			"last_four":        card.LastFourDigits,
+			"amount":           formatMoney(auth.Amount, auth.Currency),
+			"merchant":         auth.MerchantName,
+			"reason":           strings.ReplaceAll(reason, "_", " "),
+		},
+	})
+	return auth, nil
+}
+
+// A card is valid through the last day of its expiry month
+func isCardExpired(card *models.Card, now time.Time) bool {
+	month, err := strconv.Atoi(card.ExpiryMonth)
+	if err != nil {
+		return true
+	}
+	year, err := strconv.Atoi(card.ExpiryYear)
+	if err != nil {
+		return true
+	}
+	firstOfNextMonth := time.Date(year, time.Month(month)+1, 1, 0, 0, 0, 0, time.UTC)
+	return !now.Before(firstOfNextMonth)
+}
+
+func isCardAuthorizationClientError(err error) bool {
+	return err == ErrAuthorizationNotFound ||
+		err == ErrAuthorizationNotPending ||
+		err == ErrCaptureExceedsAuthorization ||
+		err == ErrInvalidAmount
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"pgpockets/internal/mailer"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// How far ahead owners are warned and replacement cards are issued
+const cardExpiryNoticeWindow = 30 * 24 * time.Hour
+
+type CardExpiryService interface {
+	ProcessExpiringCards(now time.Time) error
+}
+
+type cardExpiryService struct {
+	cardRepo    repositories.CardRepository
+	userRepo    repositories.UserRepository
+	cardService CardService
+	mailer      mailer.Mailer
+	logger      *zap.Logger
+}
+
+func NewCardExpiryService(
+	cardRepo repositories.CardRepository,
+	userRepo repositories.UserRepository,
+	cardService CardService,
+	mailer mailer.Mailer,
+	logger *zap.Logger,
+) *cardExpiryService {
+	return &cardExpiryService{
+		cardRepo:    cardRepo,
+		userRepo:    userRepo,
+		cardService: cardService,
+		mailer:      mailer,
+		logger:      logger,
+	}
+}
+
+/*
+	Marks cards past their expiry month as expired, then reissues and notifies
+	the owners of cards that expire within the notice window. Each card is only
+	handled once, so running this more than once a day is harmless.
+*/
+func (s *cardExpiryService) ProcessExpiringCards(now time.Time) error {
+	expired, err := s.cardRepo.MarkExpiredBefore(now.UTC().Format("200601"))
+	if err != nil {
+		s.logger.Error("Failed to mark expired cards", zap.Error(err))
+		return err
+	}
+	if expired > 0 {
+		s.logger.Info("Marked cards as expired", zap.Int64("count", expired))
+	}
+
+	noticeBefore := now.Add(cardExpiryNoticeWindow).UTC().Format("200601")
+	// Cards whose reissue failed for a reason that may clear up stay unmarked for the next run
+	var retryLater []uuid.UUID
+	for {
+		cards, err := s.cardRepo.ListCardsExpiringBefore(noticeBefore, retryLater, 100)
+		if err != nil {
+			s.logger.Error("Failed to list expiring cards", zap.Error(err))
+			return err
+		}
+		if len(cards) == 0 {
+			break
+		}
+		for i := range cards {
+			handled, err := s.handleExpiringCard(&cards[i], now)
+			if err != nil {
+				return err
+			}
+			if !handled {
+				retryLater = append(retryLater, cards[i].ID)
+			}
+		}
+	}
+	if len(retryLater) > 0 {
+		s.logger.Warn("Expiring cards left for the next run", zap.Int("count", len(retryLater)))
+	}
+	return nil
+}
+
+/*
+	Reissues the card and tells the owner. Cards that cannot be reissued automatically are
+	still marked and the owner is told to issue a new one; any other failure leaves the card
+	unmarked (handled is false) so the next run tries again.
+*/
+func (s *cardExpiryService) handleExpiringCard(card *models.Card, now time.Time) (bool, error) {
+	replacement, err := s.cardService.ReissueCard(card.ID)
+	switch err {
+	case nil, ErrCardNotVirtual, ErrWalletInactive, ErrCardTerminated:
+	default:
+		s.logger.Error("Failed to reissue expiring card, will retry on the next run",
+			zap.String("card_id", card.ID.String()), zap.Error(err))
+		return false, nil
+	}
+
+	// Mark before mailing so a mail outage cannot cause a card to be reissued twice
+	card.ExpiryNotifiedAt = &now
+	if err := s.cardRepo.UpdateCard(card, "expiry_notified_at"); err != nil {
+		s.logger.Error("Failed to record card expiry notice", zap.Error(err))
+		return false, err
+	}
+
+	user, err := s.userRepo.GetUserByID(card.UserID)
+	if err != nil {
+		s.logger.Error("Failed to look up card owner", zap.String("card_id", card.ID.String()), zap.Error(err))
+		return true, nil
+	}
+	subject, body := cardExpiryEmail(card, replacement)
+	if err := s.mailer.Send(mailer.Message{To: user.Email, Subject: subject, Body: body}); err != nil {
+		s.logger.Error("Failed to send card expiry notice", zap.String("card_id", card.ID.String()), zap.Error(err))
+	}
+	return true, nil
+}
+
+func cardExpiryEmail(card *models.Card, replacement *models.Card) (string, string) {
+	expiry := fmt.Sprintf("%s/%s", card.ExpiryMonth, card.ExpiryYear)
+	if replacement == nil {
+		return "Your PgPockets card is expiring soon", fmt.Sprintf(
+			"Your %s card ending in %s expires at the end of %s.\n\nWe could not issue a replacement automatically. You can create a new card in the app.",
+			card.CardBrand, card.LastFourDigits, expiry,
+		)
+	}
+	return "Your replacement PgPockets card is ready", fmt.Sprintf(
+		"Your %s card ending in %s expires at the end of %s.\n\nWe have issued a replacement card ending in %s (valid until %s/%s) with the same wallet and spending controls. Update any saved payment details before your old card expires.",
+		card.CardBrand, card.LastFourDigits, expiry,
+		replacement.LastFourDigits, replacement.ExpiryMonth, replacement.ExpiryYear,
+	)
+}
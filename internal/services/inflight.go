@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// inflight tracks work a service starts in the background, such as the first attempt of a
+// delivery, so shutdown can wait for it before the database pool closes
+type inflight struct {
+	wg sync.WaitGroup
+}
+
+func (f *inflight) Go(fn func()) {
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		fn()
+	}()
+}
+
+// Waits for everything started with Go, or until ctx ends
+func (f *inflight) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"pgpockets/internal/events"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrWalletNotFound        = errors.New("wallet not found")
+	ErrZeroAdjustment        = errors.New("adjustment amount must not be zero")
+	ErrAdjustmentNeedsReason = errors.New("a reason is required for balance adjustments")
+)
+
+type ReconciliationReport struct {
+	WalletsChecked int64                       `json:"wallets_checked"`
+	Mismatches     []repositories.WalletTotals `json:"mismatches"`
+}
+
+/*
+LedgerService covers operator-level bookkeeping: correcting a balance by hand and checking
+that every wallet's stored balance still matches the transactions recorded against it.
+*/
+type LedgerService interface {
+	// A positive amount credits the wallet, a negative one debits it; either way it is recorded as a transaction
+	AdjustWallet(walletID uuid.UUID, amount decimal.Decimal, reason string) (*models.Transaction, error)
+	Reconcile() (*ReconciliationReport, error)
+}
+
+type ledgerService struct {
+	ledgerRepo repositories.LedgerRepository
+	walletRepo repositories.WalletRepository
+	txnRepo    repositories.TransactionRepository
+	db         *gorm.DB
+	outbox     events.Outbox
+	logger     *zap.Logger
+}
+
+func NewLedgerService(
+	ledgerRepo repositories.LedgerRepository,
+	walletRepo repositories.WalletRepository,
+	txnRepo repositories.TransactionRepository,
+	db *gorm.DB,
+	outbox events.Outbox,
+	logger *zap.Logger,
+) LedgerService {
+	return &ledgerService{
+		ledgerRepo: ledgerRepo,
+		walletRepo: walletRepo,
+		txnRepo:    txnRepo,
+		db:         db,
+		outbox:     outbox,
+		logger:     logger,
+	}
+}
+
+func (s *ledgerService) AdjustWallet(walletID uuid.UUID, amount decimal.Decimal, reason string) (*models.Transaction, error) {
+	reason = strings.TrimSpace(reason)
+	if amount.IsZero() {
+		return nil, ErrZeroAdjustment
+	}
+	if reason == "" {
+		return nil, ErrAdjustmentNeedsReason
+	}
+
+	var txn *models.Transaction
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		walletRepo := s.walletRepo.WithTx(tx)
+		wallet, err := walletRepo.GetWalletByIDForUpdate(walletID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrWalletNotFound
+			}
+			return err
+		}
+		// A debit cannot dip into funds held by pending card authorizations
+		if wallet.Balance.Sub(wallet.HeldBalance).Add(amount).IsNegative() {
+			return ErrInsufficientFunds
+		}
+
+		txn = &models.Transaction{
+			Amount:          amount.Abs().String(),
+			Currency:        wallet.Currency,
+			TransactionType: models.TransactionTypeAdjustment,
+			Status:          models.TransactionStatusCompleted,
+			Description:     reason,
+			ReferenceID:     fmt.Sprintf("Adj_%d_%s", time.Now().Unix(), uuid.New()),
+		}
+		direction := "credit"
+		if amount.IsPositive() {
+			txn.ReceiverWalletID = &walletID
+		} else {
+			txn.SenderWalletID = &walletID
+			direction = "debit"
+		}
+		if _, err := s.txnRepo.WithTx(tx).CreateTransaction(txn); err != nil {
+			return err
+		}
+		if err := walletRepo.AdjustBalances(walletID, amount, decimal.Zero); err != nil {
+			return err
+		}
+
+		for _, event := range []events.Event{
+			{
+				Type:   events.WalletBalanceChanged,
+				UserID: wallet.UserID,
+				Data: map[string]string{
+					"wallet_id":          walletID.String(),
+					"transaction_id":     txn.ID.String(),
+					"transaction_status": models.TransactionStatusCompleted,
+				},
+			},
+			{
+				Type:   events.TransactionCompleted,
+				UserID: wallet.UserID,
+				Data: map[string]string{
+					"transaction_id":     txn.ID.String(),
+					"transaction_status": models.TransactionStatusCompleted,
+					"direction":          direction,
+					"amount":             formatMoney(amount.Abs(), wallet.Currency),
+					"description":        reason,
+				},
+			},
+		} {
+			if err := s.outbox.Record(tx, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if err != ErrWalletNotFound && err != ErrInsufficientFunds {
+			s.logger.Error("Failed to adjust wallet balance", zap.String("wallet_id", walletID.String()), zap.Error(err))
+		}
+		return nil, err
+	}
+	s.logger.Info("Wallet balance adjusted",
+		zap.String("wallet_id", walletID.String()),
+		zap.String("amount", amount.String()),
+		zap.String("transaction_id", txn.ID.String()))
+	s.outbox.Flush()
+	return txn, nil
+}
+
+/*
+	Stored balances should equal completed credits minus completed debits, and held balances
+	the sum of pending card authorizations. Anything else was changed outside the ledger,
+	e.g. a currency conversion or a manual update, and is reported rather than corrected.
+*/
+func (s *ledgerService) Reconcile() (*ReconciliationReport, error) {
+	count, err := s.ledgerRepo.CountWallets()
+	if err != nil {
+		s.logger.Error("Failed to count wallets", zap.Error(err))
+		return nil, err
+	}
+	mismatches, err := s.ledgerRepo.ListMismatchedWallets()
+	if err != nil {
+		s.logger.Error("Failed to reconcile wallets", zap.Error(err))
+		return nil, err
+	}
+	return &ReconciliationReport{WalletsChecked: count, Mismatches: mismatches}, nil
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// Failures older than this no longer count towards delays or lockout
+	loginFailureWindow = time.Hour
+
+	accountDelayAfter   = 3
+	accountLockAfter    = 10
+	accountLockDuration = 30 * time.Minute
+
+	ipDelayAfter    = 10
+	ipBlockAfter    = 50
+	ipBlockDuration = 15 * time.Minute
+	maxLoginDelay   = time.Minute
+)
+
+// LoginThrottledError is returned while an account or IP has to wait before trying again
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+type LoginGuard interface {
+	Check(email, ipAddr string) error
+	RecordFailure(email, ipAddr string, user *models.User)
+	RecordSuccess(email string)
+	Unlock(userID uuid.UUID) error
+	// Lock blocks sign-in for the account until the given time and signs out its sessions
+	Lock(userID uuid.UUID, until time.Time) error
+}
+
+type loginGuard struct {
+	throttleRepo repositories.LoginThrottleRepository
+	userRepo     repositories.UserRepository
+	verification VerificationService
+	logger       *zap.Logger
+}
+
+func NewLoginGuard(
+	throttleRepo repositories.LoginThrottleRepository,
+	userRepo repositories.UserRepository,
+	verification VerificationService,
+	logger *zap.Logger,
+) *loginGuard {
+	return &loginGuard{
+		throttleRepo: throttleRepo,
+		userRepo:     userRepo,
+		verification: verification,
+		logger:       logger,
+	}
+}
+
+// Refuses the attempt if either the account or the IP is currently delayed or locked.
+// Both lookups always run so the response time does not depend on which one tripped.
+func (g *loginGuard) Check(email, ipAddr string) error {
+	now := time.Now()
+	accountWait := g.waitFor(models.LoginThrottleScopeAccount, normaliseEmail(email), now)
+	ipWait := g.waitFor(models.LoginThrottleScopeIP, ipAddr, now)
+
+	wait := accountWait
+	if ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Counts a failed attempt against both the account and the IP.
+// user is nil when the email does not belong to an account.
+func (g *loginGuard) RecordFailure(email, ipAddr string, user *models.User) {
+	now := time.Now()
+
+	account, err := g.throttleRepo.RecordFailure(models.LoginThrottleScopeAccount, normaliseEmail(email),
+		func(t *models.LoginThrottle) {
+			applyFailure(t, now, accountDelayAfter, accountLockAfter, accountLockDuration)
+		})
+	if err != nil {
+		g.logger.Error("Failed to record failed login for account", zap.Error(err))
+	} else if account.FailedCount == accountLockAfter && user != nil {
+		g.logger.Warn("Account locked after repeated failed logins", zap.String("user_id", user.ID.String()))
+		if err := g.verification.SendAccountUnlock(user.ID); err != nil {
+			g.logger.Error("Failed to send account lock notice", zap.Error(err))
+		}
+	}
+
+	if ipAddr == "" {
+		return
+	}
+	if _, err := g.throttleRepo.RecordFailure(models.LoginThrottleScopeIP, ipAddr,
+		func(t *models.LoginThrottle) {
+			applyFailure(t, now, ipDelayAfter, ipBlockAfter, ipBlockDuration)
+		}); err != nil {
+		g.logger.Error("Failed to record failed login for IP", zap.Error(err))
+	}
+}
+
+// Clears the account counter after a successful password check. The IP counter is left
+// alone so one valid account cannot be used to reset a credential-stuffing run.
+func (g *loginGuard) RecordSuccess(email string) {
+	if err := g.throttleRepo.Reset(models.LoginThrottleScopeAccount, normaliseEmail(email)); err != nil {
+		g.logger.Error("Failed to reset login throttle", zap.Error(err))
+	}
+}
+
+func (g *loginGuard) Unlock(userID uuid.UUID) error {
+	user, err := g.userRepo.GetUserByID(userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrUserNotFound
+		}
+		g.logger.Error("Failed to look up user to unlock", zap.Error(err))
+		return err
+	}
+	if err := g.throttleRepo.Reset(models.LoginThrottleScopeAccount, normaliseEmail(user.Email)); err != nil {
+		g.logger.Error("Failed to unlock account", zap.Error(err))
+		return err
+	}
+	g.logger.Info("Account unlocked", zap.String("user_id", userID.String()))
+	return nil
+}
+
+func (g *loginGuard) Lock(userID uuid.UUID, until time.Time) error {
+	user, err := g.userRepo.GetUserByID(userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrUserNotFound
+		}
+		g.logger.Error("Failed to look up user to lock", zap.Error(err))
+		return err
+	}
+	if _, err := g.throttleRepo.RecordFailure(models.LoginThrottleScopeAccount, normaliseEmail(user.Email),
+		func(t *models.LoginThrottle) {
+			t.LockedUntil = &until
+		}); err != nil {
+		g.logger.Error("Failed to lock account", zap.Error(err))
+		return err
+	}
+	if err := g.userRepo.DeleteSession(userID); err != nil {
+		g.logger.Error("Failed to end sessions of locked account", zap.Error(err))
+		return err
+	}
+	g.logger.Warn("Account locked", zap.String("user_id", userID.String()), zap.Time("until", until))
+	return nil
+}
+
+func (g *loginGuard) waitFor(scope, key string, now time.Time) time.Duration {
+	throttle, err := g.throttleRepo.Get(scope, key)
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			g.logger.Error("Failed to read login throttle", zap.String("scope", scope), zap.Error(err))
+		}
+		return 0
+	}
+	return throttleWait(throttle, now)
+}
+
+// How long the throttle still makes callers wait: the longer of its lock and its progressive delay
+func throttleWait(throttle *models.LoginThrottle, now time.Time) time.Duration {
+	var wait time.Duration
+	if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+		wait = throttle.LockedUntil.Sub(now)
+	}
+	if throttle.NextAttemptAt != nil && throttle.NextAttemptAt.After(now) && throttle.NextAttemptAt.Sub(now) > wait {
+		wait = throttle.NextAttemptAt.Sub(now)
+	}
+	return wait
+}
+
+// Applies the progressive delay policy: free attempts up to delayAfter, then doubling
+// waits capped at maxLoginDelay, then a lock once lockAfter failures pile up.
+func applyFailure(t *models.LoginThrottle, now time.Time, delayAfter, lockAfter int, lockDuration time.Duration) {
+	if t.LastFailedAt == nil || now.Sub(*t.LastFailedAt) > loginFailureWindow ||
+		(t.LockedUntil != nil && !t.LockedUntil.After(now)) {
+		t.FailedCount = 0
+		t.LockedUntil = nil
+	}
+	t.FailedCount++
+	t.LastFailedAt = &now
+
+	if t.FailedCount > delayAfter {
+		delay := time.Duration(math.Pow(2, float64(t.FailedCount-delayAfter))) * time.Second
+		if delay > maxLoginDelay {
+			delay = maxLoginDelay
+		}
+		next := now.Add(delay)
+		t.NextAttemptAt = &next
+	}
+	if t.FailedCount >= lockAfter {
+		lockedUntil := now.Add(lockDuration)
+		t.LockedUntil = &lockedUntil
+	}
+}
+
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"pgpockets/internal/mailer"
+	"pgpockets/internal/models"
+	"pgpockets/internal/push"
+	"pgpockets/internal/repositories"
+	"pgpockets/internal/sms"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ChannelAdapter delivers notifications over one channel
+type ChannelAdapter interface {
+	Channel() string
+	// Where the user receives messages on this channel, or "" when they cannot be reached on it
+	Recipient(userID uuid.UUID) (string, error)
+	Send(delivery *models.NotificationDelivery) error
+}
+
+type inAppChannel struct {
+	notifService NotificationService
+}
+
+func NewInAppChannel(notifService NotificationService) ChannelAdapter {
+	return &inAppChannel{notifService: notifService}
+}
+
+func (c *inAppChannel) Channel() string { return models.NotificationChannelInApp }
+
+func (c *inAppChannel) Recipient(userID uuid.UUID) (string, error) {
+	return userID.String(), nil
+}
+
+func (c *inAppChannel) Send(delivery *models.NotificationDelivery) error {
+	return c.notifService.Notify(&models.Notification{
+		UserID:      delivery.UserID,
+		Type:        delivery.EventType,
+		Category:    delivery.Category,
+		Priority:    delivery.Priority,
+		Title:       delivery.Title,
+		Description: delivery.Body,
+		Payload:     delivery.Payload,
+		ExpiresAt:   delivery.ExpiresAt,
+	})
+}
+
+type emailChannel struct {
+	mailer   mailer.Mailer
+	userRepo repositories.UserRepository
+}
+
+func NewEmailChannel(m mailer.Mailer, userRepo repositories.UserRepository) ChannelAdapter {
+	return &emailChannel{mailer: m, userRepo: userRepo}
+}
+
+func (c *emailChannel) Channel() string { return models.NotificationChannelEmail }
+
+// Only verified addresses are used so a mistyped email never receives account activity
+func (c *emailChannel) Recipient(userID uuid.UUID) (string, error) {
+	user, err := c.userRepo.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if !user.IsEmailVerified {
+		return "", nil
+	}
+	return user.Email, nil
+}
+
+func (c *emailChannel) Send(delivery *models.NotificationDelivery) error {
+	return c.mailer.Send(mailer.Message{
+		To:      delivery.Recipient,
+		Subject: delivery.Title,
+		Body:    delivery.Body,
+	})
+}
+
+type smsChannel struct {
+	sender      sms.SMSSender
+	profileRepo repositories.ProfileRepository
+}
+
+func NewSMSChannel(sender sms.SMSSender, profileRepo repositories.ProfileRepository) ChannelAdapter {
+	return &smsChannel{sender: sender, profileRepo: profileRepo}
+}
+
+func (c *smsChannel) Channel() string { return models.NotificationChannelSMS }
+
+func (c *smsChannel) Recipient(userID uuid.UUID) (string, error) {
+	profile, err := c.profileRepo.GetProfileByUserID(userID.String())
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return profile.PhoneNumber, nil
+}
+
+func (c *smsChannel) Send(delivery *models.NotificationDelivery) error {
+	return c.sender.Send(delivery.Recipient, delivery.Title+": "+delivery.Body)
+}
+
+type pushChannel struct {
+	sender push.PushSender
+}
+
+func NewPushChannel(sender push.PushSender) ChannelAdapter {
+	return &pushChannel{sender: sender}
+}
+
+func (c *pushChannel) Channel() string { return models.NotificationChannelPush }
+
+// The push provider maps our user id to the devices the app is signed in on
+func (c *pushChannel) Recipient(userID uuid.UUID) (string, error) {
+	return userID.String(), nil
+}
+
+// Data carries what the app needs to open the right screen when the push is tapped
+func (c *pushChannel) Send(delivery *models.NotificationDelivery) error {
+	data := map[string]string{
+		"event_type": delivery.EventType,
+		"category":   delivery.Category,
+		"priority":   delivery.Priority,
+	}
+	if delivery.Payload.DeepLink != "" {
+		data["deep_link"] = delivery.Payload.DeepLink
+	}
+	if delivery.Payload.TargetID != "" {
+		data["target_type"] = delivery.Payload.TargetType
+		data["target_id"] = delivery.Payload.TargetID
+	}
+	return c.sender.Send(push.Message{
+		ExternalUserID: delivery.Recipient,
+		Title:          delivery.Title,
+		Body:           delivery.Body,
+		Data:           data,
+	})
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownNotificationCategory = errors.New("unknown notification category")
+	ErrUnknownNotificationChannel  = errors.New("unknown notification channel")
+	ErrSecurityInAppRequired       = errors.New("security notifications cannot be turned off in the app")
+	ErrInvalidQuietHours           = errors.New("quiet hours need HH:MM start and end times that differ and a valid timezone")
+)
+
+const (
+	maxDeliveryAttempts = 5
+	// How long a claimed delivery is held before another worker may retry it
+	deliveryLease     = 5 * time.Minute
+	deliveryBatchSize = 100
+)
+
+// Wait before each retry; the last entry is reused if attempts ever exceed the list
+var deliveryBackoff = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+var notificationCategories = []string{
+	models.NotificationCategoryTransaction,
+	models.NotificationCategoryPayment,
+	models.NotificationCategoryCard,
+	models.NotificationCategorySecurity,
+	models.NotificationCategoryInvoice,
+	models.NotificationCategoryAccount,
+	models.NotificationCategoryMarketing,
+}
+
+var notificationPriorities = []string{
+	models.NotificationPriorityLow,
+	models.NotificationPriorityNormal,
+	models.NotificationPriorityHigh,
+	models.NotificationPriorityUrgent,
+}
+
+var notificationChannels = []string{
+	models.NotificationChannelInApp,
+	models.NotificationChannelEmail,
+	models.NotificationChannelSMS,
+	models.NotificationChannelPush,
+}
+
+// OutgoingNotification is a rendered notification waiting to be fanned out to the user's channels
+type OutgoingNotification struct {
+	UserID    uuid.UUID
+	EventType string
+	Category  string
+	Priority  string // Defaults to normal
+	Title     string
+	Body      string
+	Payload   models.NotificationPayload
+	ExpiresAt *time.Time
+}
+
+type ChannelPreference struct {
+	Category string `json:"category"`
+	Channel  string `json:"channel"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// NotificationPreferences is the effective setting for every category and channel, defaults included
+type NotificationPreferences struct {
+	Preferences []ChannelPreference `json:"preferences"`
+	QuietHours  models.QuietHours   `json:"quiet_hours"`
+}
+
+type NotificationPreferencesUpdate struct {
+	Preferences []ChannelPreference
+	QuietHours  *models.QuietHours
+}
+
+type NotificationDispatcher interface {
+	Dispatch(notification OutgoingNotification) error
+	GetPreferences(userID uuid.UUID) (*NotificationPreferences, error)
+	UpdatePreferences(userID uuid.UUID, update NotificationPreferencesUpdate) (*NotificationPreferences, error)
+	ListDeliveries(userID uuid.UUID, status, channel string, limit, offset int) ([]models.NotificationDelivery, int64, error)
+	ProcessDue() (int, error)
+	// Drain waits for first delivery attempts still in flight, or until ctx ends
+	Drain(ctx context.Context) error
+}
+
+type notificationDispatcher struct {
+	preferenceRepo repositories.NotificationPreferenceRepository
+	deliveryRepo   repositories.NotificationDeliveryRepository
+	channels       map[string]ChannelAdapter
+	logger         *zap.Logger
+	attempts       inflight
+}
+
+func NewNotificationDispatcher(
+	preferenceRepo repositories.NotificationPreferenceRepository,
+	deliveryRepo repositories.NotificationDeliveryRepository,
+	logger *zap.Logger,
+	adapters ...ChannelAdapter,
+) *notificationDispatcher {
+	channels := make(map[string]ChannelAdapter, len(adapters))
+	for _, adapter := range adapters {
+		channels[adapter.Channel()] = adapter
+	}
+	return &notificationDispatcher{
+		preferenceRepo: preferenceRepo,
+		deliveryRepo:   deliveryRepo,
+		channels:       channels,
+		logger:         logger,
+	}
+}
+
+/*
+	Records a delivery for every channel the user has on for the category and sends the
+	ones that are due. In-app is sent before returning; the other channels are sent in the
+	background, or held until quiet hours end. Security and urgent notifications ignore
+	quiet hours.
+*/
+func (d *notificationDispatcher) Dispatch(notification OutgoingNotification) error {
+	preferences, err := d.GetPreferences(notification.UserID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if notification.ExpiresAt != nil && !notification.ExpiresAt.After(now) {
+		return nil
+	}
+	if notification.Priority == "" {
+		notification.Priority = models.NotificationPriorityNormal
+	}
+	quietUntil, quiet := quietHoursEnd(&preferences.QuietHours, now)
+	bypassQuiet := notification.Category == models.NotificationCategorySecurity ||
+		notification.Priority == models.NotificationPriorityUrgent
+
+	for _, channel := range notificationChannels {
+		adapter, ok := d.channels[channel]
+		if !ok || !preferenceEnabled(preferences.Preferences, notification.Category, channel) {
+			continue
+		}
+		delivery := &models.NotificationDelivery{
+			UserID:        notification.UserID,
+			EventType:     notification.EventType,
+			Category:      notification.Category,
+			Channel:       channel,
+			Priority:      notification.Priority,
+			Title:         notification.Title,
+			Body:          notification.Body,
+			Payload:       notification.Payload,
+			ExpiresAt:     notification.ExpiresAt,
+			Status:        models.DeliveryStatusPending,
+			NextAttemptAt: now,
+		}
+		recipient, err := adapter.Recipient(notification.UserID)
+		if err != nil {
+			d.logger.Error("Failed to resolve notification recipient", zap.String("channel", channel), zap.Error(err))
+			continue
+		}
+		delivery.Recipient = recipient
+		if recipient == "" {
+			delivery.Status = models.DeliveryStatusSkipped
+			delivery.LastError = "no " + channel + " address on file"
+		} else if quiet && channel != models.NotificationChannelInApp && !bypassQuiet {
+			delivery.NextAttemptAt = quietUntil
+		}
+		if err := d.deliveryRepo.Create(delivery); err != nil {
+			d.logger.Error("Failed to record notification delivery", zap.String("channel", channel), zap.Error(err))
+			continue
+		}
+
+		if delivery.Status != models.DeliveryStatusPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		if channel == models.NotificationChannelInApp {
+			d.attempt(delivery)
+		} else {
+			d.attempts.Go(func() { d.attempt(delivery) })
+		}
+	}
+	return nil
+}
+
+func (d *notificationDispatcher) GetPreferences(userID uuid.UUID) (*NotificationPreferences, error) {
+	stored, err := d.preferenceRepo.ListByUserID(userID)
+	if err != nil {
+		d.logger.Error("Failed to load notification preferences", zap.Error(err))
+		return nil, err
+	}
+	overrides := make(map[[2]string]bool, len(stored))
+	for _, preference := range stored {
+		overrides[[2]string{preference.Category, preference.Channel}] = preference.Enabled
+	}
+
+	result := &NotificationPreferences{}
+	for _, category := range notificationCategories {
+		for _, channel := range notificationChannels {
+			enabled, ok := overrides[[2]string{category, channel}]
+			if !ok {
+				enabled = defaultChannelEnabled(category, channel)
+			}
+			result.Preferences = append(result.Preferences, ChannelPreference{
+				Category: category,
+				Channel:  channel,
+				Enabled:  enabled,
+			})
+		}
+	}
+
+	quietHours, err := d.preferenceRepo.GetQuietHours(userID)
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			d.logger.Error("Failed to load quiet hours", zap.Error(err))
+			return nil, err
+		}
+		quietHours = &models.QuietHours{
+			UserID:    userID,
+			StartTime: "22:00",
+			EndTime:   "07:00",
+			Timezone:  "Africa/Lagos",
+		}
+	}
+	result.QuietHours = *quietHours
+	return result, nil
+}
+
+func (d *notificationDispatcher) UpdatePreferences(userID uuid.UUID, update NotificationPreferencesUpdate) (*NotificationPreferences, error) {
+	now := time.Now()
+	preferences := make([]models.NotificationPreference, 0, len(update.Preferences))
+	for _, preference := range update.Preferences {
+		if !slices.Contains(notificationCategories, preference.Category) {
+			return nil, ErrUnknownNotificationCategory
+		}
+		if !slices.Contains(notificationChannels, preference.Channel) {
+			return nil, ErrUnknownNotificationChannel
+		}
+		if preference.Category == models.NotificationCategorySecurity &&
+			preference.Channel == models.NotificationChannelInApp && !preference.Enabled {
+			return nil, ErrSecurityInAppRequired
+		}
+		preferences = append(preferences, models.NotificationPreference{
+			UserID:    userID,
+			Category:  preference.Category,
+			Channel:   preference.Channel,
+			Enabled:   preference.Enabled,
+			UpdatedAt: now,
+		})
+	}
+	if update.QuietHours != nil && !validQuietHours(update.QuietHours) {
+		return nil, ErrInvalidQuietHours
+	}
+
+	if err := d.preferenceRepo.Upsert(preferences); err != nil {
+		d.logger.Error("Failed to save notification preferences", zap.Error(err))
+		return nil, err
+	}
+	if update.QuietHours != nil {
+		quietHours := *update.QuietHours
+		quietHours.UserID = userID
+		if err := d.preferenceRepo.SaveQuietHours(&quietHours); err != nil {
+			d.logger.Error("Failed to save quiet hours", zap.Error(err))
+			return nil, err
+		}
+	}
+	return d.GetPreferences(userID)
+}
+
+func (d *notificationDispatcher) ListDeliveries(userID uuid.UUID, status, channel string, limit, offset int) ([]models.NotificationDelivery, int64, error) {
+	deliveries, count, err := d.deliveryRepo.ListByUserID(userID, status, channel, limit, offset)
+	if err != nil {
+		d.logger.Error("Failed to list notification deliveries", zap.Error(err))
+		return nil, 0, err
+	}
+	return deliveries, count, nil
+}
+
+// Sends deliveries that are due: retries, ones held for quiet hours and any whose first attempt was lost
+func (d *notificationDispatcher) ProcessDue() (int, error) {
+	now := time.Now()
+	deliveries, err := d.deliveryRepo.ClaimDue(now, now.Add(deliveryLease), deliveryBatchSize)
+	if err != nil {
+		d.logger.Error("Failed to claim due notification deliveries", zap.Error(err))
+		return 0, err
+	}
+	for i := range deliveries {
+		d.send(&deliveries[i])
+	}
+	return len(deliveries), nil
+}
+
+func (d *notificationDispatcher) Drain(ctx context.Context) error {
+	return d.attempts.Wait(ctx)
+}
+
+// First attempt of a fresh delivery. Claiming it keeps the worker from sending it at the same time.
+func (d *notificationDispatcher) attempt(delivery *models.NotificationDelivery) {
+	now := time.Now()
+	claimed, err := d.deliveryRepo.Claim(delivery.ID, now, now.Add(deliveryLease))
+	if err != nil {
+		d.logger.Error("Failed to claim notification delivery", zap.Error(err))
+		return
+	}
+	if !claimed {
+		return
+	}
+	delivery.Attempts++
+	d.send(delivery)
+}
+
+// Sends a claimed delivery and records the outcome
+func (d *notificationDispatcher) send(delivery *models.NotificationDelivery) {
+	// Held or retried past its expiry, e.g. a one-time code; sending it now would only confuse
+	if delivery.ExpiresAt != nil && !delivery.ExpiresAt.After(time.Now()) {
+		delivery.Status = models.DeliveryStatusSkipped
+		delivery.LastError = "expired before it could be sent"
+		if err := d.deliveryRepo.Update(delivery, "status", "last_error"); err != nil {
+			d.logger.Error("Failed to record notification delivery outcome", zap.Error(err))
+		}
+		return
+	}
+
+	adapter, ok := d.channels[delivery.Channel]
+	var err error
+	if !ok {
+		err = ErrUnknownNotificationChannel
+	} else {
+		err = adapter.Send(delivery)
+	}
+
+	switch {
+	case err == nil:
+		sentAt := time.Now()
+		delivery.Status = models.DeliveryStatusSent
+		delivery.SentAt = &sentAt
+		delivery.LastError = ""
+	case delivery.Attempts >= maxDeliveryAttempts:
+		delivery.Status = models.DeliveryStatusFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.NextAttemptAt = time.Now().Add(deliveryBackoff[min(delivery.Attempts, len(deliveryBackoff))-1])
+		delivery.LastError = err.Error()
+	}
+	if err != nil {
+		d.logger.Warn("Notification delivery failed",
+			zap.String("delivery_id", delivery.ID.String()),
+			zap.String("channel", delivery.Channel),
+			zap.Int("attempts", delivery.Attempts),
+			zap.Error(err))
+	}
+	if err := d.deliveryRepo.Update(delivery, "status", "sent_at", "last_error", "next_attempt_at"); err != nil {
+		d.logger.Error("Failed to record notification delivery outcome", zap.Error(err))
+	}
+}
+
+func preferenceEnabled(preferences []ChannelPreference, category, channel string) bool {
+	for _, preference := range preferences {
+		if preference.Category == category && preference.Channel == channel {
+			return preference.Enabled
+		}
+	}
+	return defaultChannelEnabled(category, channel)
+}
+
+/*
+	What a user gets before changing anything. SMS costs money per message, so it is kept
+	for security alerts. Marketing only shows in the app until the user opts in elsewhere.
+*/
+func defaultChannelEnabled(category, channel string) bool {
+	if category == models.NotificationCategoryMarketing {
+		return channel == models.NotificationChannelInApp
+	}
+	if channel == models.NotificationChannelSMS {
+		return category == models.NotificationCategorySecurity
+	}
+	return true
+}
+
+func validQuietHours(quietHours *models.QuietHours) bool {
+	start, err := time.Parse("15:04", quietHours.StartTime)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", quietHours.EndTime)
+	if err != nil || start.Equal(end) {
+		return false
+	}
+	_, err = time.LoadLocation(quietHours.Timezone)
+	return err == nil
+}
+
+// Returns when the quiet hours window that contains now ends, if it does. Windows may cross midnight.
+func quietHoursEnd(quietHours *models.QuietHours, now time.Time) (time.Time, bool) {
+	if !quietHours.Enabled || !validQuietHours(quietHours) {
+		return time.Time{}, false
+	}
+	location, _ := time.LoadLocation(quietHours.Timezone)
+	local := now.In(location)
+	at := func(hhmm string) time.Time {
+		t, _ := time.Parse("15:04", hhmm)
+		return time.Date(local.Year(), local.Month(), local.Day(), t.Hour(), t.Minute(), 0, 0, location)
+	}
+	start, end := at(quietHours.StartTime), at(quietHours.EndTime)
+
+	if start.Before(end) {
+		if !local.Before(start) && local.Before(end) {
+			return end, true
+		}
+		return time.Time{}, false
+	}
+	// e.g. 22:00-07:00: quiet from start until midnight and from midnight until end
+	if local.Before(end) {
+		return end, true
+	}
+	if !local.Before(start) {
+		return end.AddDate(0, 0, 1), true
+	}
+	return time.Time{}, false
+}
@@ -1,35 +1,71 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"pgpockets/internal/models"
+	"pgpockets/internal/realtime"
 	"pgpockets/internal/repositories"
+	"slices"
+	"time"
 
 	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUnknownNotificationPriority = errors.New("unknown notification priority")
+	ErrInvalidNotificationRange    = errors.New("from must be before to")
 )
 
 type NotificationService interface {
+	Notify(notification *models.Notification) error
+
 	GetNotificationCount(userID uuid.UUID, includeRead bool) (int64, error)
 	GetUnreadNotificationCount(userID uuid.UUID) (int64, error)
 
 	GetNotificationByID(id uuid.UUID, userID uuid.UUID) (*models.Notification, error)
-	GetNotificationsByUserID(userID uuid.UUID, limit, offset int) ([]models.Notification, error)
-	GetUnreadNotificationsByUserID(userID uuid.UUID, limit, offset int) ([]models.Notification, error)
+	GetNotificationsByUserID(userID uuid.UUID, filter repositories.NotificationFilter, limit, offset int) ([]models.Notification, error)
+	GetUnreadNotificationsByUserID(userID uuid.UUID, filter repositories.NotificationFilter, limit, offset int) ([]models.Notification, error)
 
 	MarkNotificationAsRead(id uuid.UUID, userID uuid.UUID) error
 	MarkNotificationAsUnread(id uuid.UUID, userID uuid.UUID) error
-	MarkAllNotificationsAsRead(userID uuid.UUID) (int64, error)
+	// Bulk operations take an optional category; empty means every category
+	MarkAllNotificationsAsRead(userID uuid.UUID, category string) (int64, error)
 
 	DeleteNotification(id uuid.UUID, userID uuid.UUID) error
-	DeleteAllNotifications(userID uuid.UUID) (int64, error)
-	DeleteAllReadNotifications(userID uuid.UUID) (int64, error)
+	DeleteAllNotifications(userID uuid.UUID, category string) (int64, error)
+	DeleteAllReadNotifications(userID uuid.UUID, category string) (int64, error)
+
+	RunExpiryCleanup(ctx context.Context, interval time.Duration)
 }
 
 type notificationService struct {
-	repo repositories.NotifRepo
+	repo      repositories.NotifRepo
+	publisher realtime.Publisher
+	logger    *zap.Logger
+}
+
+func NewNotificationService(repo repositories.NotifRepo, publisher realtime.Publisher, logger *zap.Logger) NotificationService {
+	return &notificationService{repo: repo, publisher: publisher, logger: logger}
 }
 
-func NewNotificationService(repo repositories.NotifRepo) NotificationService {
-	return &notificationService{repo: repo}
+// Creates an in-app notification for the user and pushes it to their open connections
+func (s *notificationService) Notify(notification *models.Notification) error {
+	if notification.Category == "" {
+		notification.Category = models.NotificationCategoryAccount
+	}
+	if notification.Priority == "" {
+		notification.Priority = models.NotificationPriorityNormal
+	}
+	if err := s.repo.Create(notification); err != nil {
+		return err
+	}
+	// The row is what matters; a failed push only means the client sees it on next fetch
+	if err := s.publisher.Publish(notification.UserID, realtime.TypeNotification, notification); err != nil {
+		s.logger.Error("Failed to push notification", zap.Error(err))
+	}
+	return nil
 }
 
 func (s *notificationService) GetNotificationCount(userID uuid.UUID, includeRead bool) (int64, error) {
@@ -44,12 +80,18 @@ func (s *notificationService) GetNotificationByID(id uuid.UUID, userID uuid.UUID
 	return s.repo.GetByID(id, userID)
 }
 
-func (s *notificationService) GetNotificationsByUserID(userID uuid.UUID, limit, offset int) ([]models.Notification, error) {
-	return s.repo.GetByUserID(userID, limit, offset)
+func (s *notificationService) GetNotificationsByUserID(userID uuid.UUID, filter repositories.NotificationFilter, limit, offset int) ([]models.Notification, error) {
+	if err := validateNotificationFilter(filter); err != nil {
+		return nil, err
+	}
+	return s.repo.GetByUserID(userID, filter, limit, offset)
 }
 
-func (s *notificationService) GetUnreadNotificationsByUserID(userID uuid.UUID, limit, offset int) ([]models.Notification, error) {
-	return s.repo.GetUnreadByUserID(userID, limit, offset)
+func (s *notificationService) GetUnreadNotificationsByUserID(userID uuid.UUID, filter repositories.NotificationFilter, limit, offset int) ([]models.Notification, error) {
+	if err := validateNotificationFilter(filter); err != nil {
+		return nil, err
+	}
+	return s.repo.GetUnreadByUserID(userID, filter, limit, offset)
 }
 
 func (s *notificationService) MarkNotificationAsRead(id uuid.UUID, userID uuid.UUID) error {
@@ -60,18 +102,59 @@ func (s *notificationService) MarkNotificationAsUnread(id uuid.UUID, userID uuid
 	return s.repo.UpdateReadStatus(id, userID, false)
 }
 
-func (s *notificationService) MarkAllNotificationsAsRead(userID uuid.UUID) (int64, error) {
-	return s.repo.MarkAllAsReadByUserID(userID)
+func (s *notificationService) MarkAllNotificationsAsRead(userID uuid.UUID, category string) (int64, error) {
+	if category != "" && !slices.Contains(notificationCategories, category) {
+		return 0, ErrUnknownNotificationCategory
+	}
+	return s.repo.MarkAllAsReadByUserID(userID, category)
 }
 
 func (s *notificationService) DeleteNotification(id uuid.UUID, userID uuid.UUID) error {
 	return s.repo.DeleteByID(id, userID)
 }
 
-func (s *notificationService) DeleteAllNotifications(userID uuid.UUID) (int64, error) {
-	return s.repo.DeleteAllByUserID(userID)
+func (s *notificationService) DeleteAllNotifications(userID uuid.UUID, category string) (int64, error) {
+	if category != "" && !slices.Contains(notificationCategories, category) {
+		return 0, ErrUnknownNotificationCategory
+	}
+	return s.repo.DeleteAllByUserID(userID, category)
+}
+
+func (s *notificationService) DeleteAllReadNotifications(userID uuid.UUID, category string) (int64, error) {
+	if category != "" && !slices.Contains(notificationCategories, category) {
+		return 0, ErrUnknownNotificationCategory
+	}
+	return s.repo.DeleteAllReadByUserID(userID, category)
+}
+
+// Deletes notifications past their expiry; they are already hidden from the user before this runs
+func (s *notificationService) RunExpiryCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.repo.DeleteExpired(time.Now())
+			if err != nil {
+				s.logger.Error("Failed to delete expired notifications", zap.Error(err))
+			} else if deleted > 0 {
+				s.logger.Info("Deleted expired notifications", zap.Int64("count", deleted))
+			}
+		}
+	}
 }
 
-func (s *notificationService) DeleteAllReadNotifications(userID uuid.UUID) (int64, error) {
-	return s.repo.DeleteAllReadByUserID(userID)
+func validateNotificationFilter(filter repositories.NotificationFilter) error {
+	if filter.Category != "" && !slices.Contains(notificationCategories, filter.Category) {
+		return ErrUnknownNotificationCategory
+	}
+	if filter.Priority != "" && !slices.Contains(notificationPriorities, filter.Priority) {
+		return ErrUnknownNotificationPriority
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return ErrInvalidNotificationRange
+	}
+	return nil
 }
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"pgpockets/internal/events"
+	"pgpockets/internal/models"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Scheme the mobile app registers for deep links
+const deepLinkScheme = "pgpockets://"
+
+type notificationTemplate struct {
+	category string // Decides which of the user's channel preferences apply
+	priority string // Normal when empty
+	title    string
+	body     string
+	// What tapping the notification opens: target names the kind of object, targetKey the
+	// event data key holding its id, and link the app path the id is appended to
+	target    string
+	targetKey string
+	link      string
+	ttl       time.Duration // How long the notification stays relevant; zero keeps it until deleted
+}
+
+/*
+Notification text for each event type, rendered with the event's Data.
+Events without a template here do not produce a notification.
+*/
+var notificationTemplates = map[string]notificationTemplate{
+	events.TransferSent: {
+		category:  models.NotificationCategoryTransaction,
+		title:     "Transfer sent",
+		body:      "You sent {{.amount}} to {{.counterparty}}.{{with .description}} {{.}}{{end}}",
+		target:    "transaction",
+		targetKey: "transaction_id",
+		link:      "transactions",
+	},
+	events.TransferReceived: {
+		category:  models.NotificationCategoryTransaction,
+		title:     "Money received",
+		body:      "You received {{.amount}} from {{.counterparty}}.{{with .description}} {{.}}{{end}}",
+		target:    "transaction",
+		targetKey: "transaction_id",
+		link:      "transactions",
+	},
+	events.LoginNewDevice: {
+		category: models.NotificationCategorySecurity,
+		priority: models.NotificationPriorityUrgent,
+		title:    "New sign-in to your account",
+		body:     "Your account was signed in to from {{.device}} (IP {{.ip_address}}). If this wasn't you, change your password and sign out other sessions.",
+		link:     "settings/sessions",
+	},
+	events.CardIssued: {
+		category:  models.NotificationCategoryCard,
+		title:     "Card issued",
+		body:      "Your {{.card_brand}} card ending in {{.last_four}} is ready to use.",
+		target:    "card",
+		targetKey: "card_id",
+		link:      "cards",
+	},
+	events.CardFrozen: {
+		category:  models.NotificationCategoryCard,
+		priority:  models.NotificationPriorityHigh,
+		title:     "Card frozen",
+		body:      "Your card ending in {{.last_four}} has been frozen. Payments will be declined until you unfreeze it.",
+		target:    "card",
+		targetKey: "card_id",
+		link:      "cards",
+	},
+	events.CardUnfrozen: {
+		category:  models.NotificationCategoryCard,
+		title:     "Card unfrozen",
+		body:      "Your card ending in {{.last_four}} is active again.",
+		target:    "card",
+		targetKey: "card_id",
+		link:      "cards",
+	},
+	events.CardTerminated: {
+		category:  models.NotificationCategoryCard,
+		priority:  models.NotificationPriorityHigh,
+		title:     "Card terminated",
+		body:      "Your card ending in {{.last_four}} has been permanently closed.",
+		target:    "card",
+		targetKey: "card_id",
+		link:      "cards",
+	},
+	events.CardReissued: {
+		category:  models.NotificationCategoryCard,
+		title:     "Replacement card issued",
+		body:      "Your card ending in {{.old_last_four}} expires soon, so we issued a replacement ending in {{.last_four}} with the same controls.",
+		target:    "card",
+		targetKey: "card_id",
+		link:      "cards",
+	},
+	events.CardAuthorizationDeclined: {
+		category:  models.NotificationCategoryCard,
+		priority:  models.NotificationPriorityHigh,
+		title:     "Card payment declined",
+		body:      "A payment of {{.amount}}{{with .merchant}} at {{.}}{{end}} on your card ending in {{.last_four}} was declined: {{.reason}}.",
+		target:    "card",
+		targetKey: "card_id",
+		link:      "cards",
+	},
+	events.PaymentRequestReceived: {
+		category:  models.NotificationCategoryPayment,
+		title:     "New payment request",
+		body:      "{{.counterparty}} requested {{.amount}}{{with .note}} ({{.}}){{end}}. It expires on {{.expires_on}}.",
+		target:    "payment_request",
+		targetKey: "payment_request_id",
+		link:      "payment-requests",
+	},
+	events.PaymentRequestPaid: {
+		category:  models.NotificationCategoryPayment,
+		title:     "Payment request paid",
+		body:      "{{.counterparty}} paid your request for {{.amount}}{{with .note}} ({{.}}){{end}}.",
+		target:    "payment_request",
+		targetKey: "payment_request_id",
+		link:      "payment-requests",
+	},
+	events.PaymentRequestDeclined: {
+		category:  models.NotificationCategoryPayment,
+		title:     "Payment request declined",
+		body:      "{{.counterparty}} declined your request for {{.amount}}{{with .note}} ({{.}}){{end}}.",
+		target:    "payment_request",
+		targetKey: "payment_request_id",
+		link:      "payment-requests",
+	},
+	events.PaymentRequestCancelled: {
+		category:  models.NotificationCategoryPayment,
+		priority:  models.NotificationPriorityLow,
+		title:     "Payment request cancelled",
+		body:      "{{.counterparty}} cancelled their request for {{.amount}}.",
+		target:    "payment_request",
+		targetKey: "payment_request_id",
+		link:      "payment-requests",
+	},
+	events.PaymentRequestExpired: {
+		category:  models.NotificationCategoryPayment,
+		priority:  models.NotificationPriorityLow,
+		title:     "Payment request expired",
+		body:      "{{if eq .role \"requester\"}}Your request for {{.amount}} from {{.counterparty}} expired without being paid.{{else}}The request for {{.amount}} from {{.counterparty}} has expired.{{end}}",
+		target:    "payment_request",
+		targetKey: "payment_request_id",
+		link:      "payment-requests",
+	},
+	events.BillSplitReminder: {
+		category:  models.NotificationCategoryPayment,
+		title:     "Payment reminder",
+		body:      "{{.counterparty}} is still waiting for your share of {{.amount}} for \"{{.title}}\".",
+		target:    "payment_request",
+		targetKey: "payment_request_id",
+		link:      "payment-requests",
+		ttl:       7 * 24 * time.Hour,
+	},
+	events.BillSplitSettled: {
+		category:  models.NotificationCategoryPayment,
+		title:     "Bill split settled",
+		body:      "Everyone has paid their share of \"{{.title}}\" ({{.amount}}).",
+		target:    "bill_split",
+		targetKey: "split_id",
+		link:      "bill-splits",
+	},
+	events.InvoiceReceived: {
+		category:  models.NotificationCategoryInvoice,
+		title:     "New invoice",
+		body:      "{{.counterparty}} sent you invoice {{.invoice_number}} for {{.amount}}{{with .due_on}}, due {{.}}{{end}}.",
+		target:    "invoice",
+		targetKey: "invoice_id",
+		link:      "invoices",
+	},
+	events.InvoicePaid: {
+		category:  models.NotificationCategoryInvoice,
+		title:     "Invoice paid",
+		body:      "Invoice {{.invoice_number}} for {{.amount}} has been paid.",
+		target:    "invoice",
+		targetKey: "invoice_id",
+		link:      "invoices",
+	},
+	events.InvoiceStatusChanged: {
+		category:  models.NotificationCategoryInvoice,
+		priority:  models.NotificationPriorityLow,
+		title:     "Invoice updated",
+		body:      "Invoice {{.invoice_number}} is now {{.status}}.",
+		target:    "invoice",
+		targetKey: "invoice_id",
+		link:      "invoices",
+	},
+	events.KYCApproved: {
+		category: models.NotificationCategoryAccount,
+		title:    "Identity verified",
+		body:     "Your identity has been verified. Your account limits have been raised.",
+		link:     "settings/verification",
+	},
+	events.KYCRejected: {
+		category: models.NotificationCategoryAccount,
+		priority: models.NotificationPriorityHigh,
+		title:    "Identity verification unsuccessful",
+		body:     "We couldn't verify your identity{{with .reason}}: {{.}}{{end}}. Please check your details and try again.",
+		link:     "settings/verification",
+	},
+}
+
+// NotificationSubscriber turns domain events into notifications and hands them to the dispatcher
+type NotificationSubscriber struct {
+	dispatcher NotificationDispatcher
+	templates  map[string]*template.Template
+	logger     *zap.Logger
+}
+
+func NewNotificationSubscriber(dispatcher NotificationDispatcher, logger *zap.Logger) *NotificationSubscriber {
+	templates := make(map[string]*template.Template, len(notificationTemplates))
+	for eventType, t := range notificationTemplates {
+		// Missing keys render as empty strings rather than "<no value>"
+		templates[eventType] = template.Must(template.New(eventType).Option("missingkey=zero").Parse(t.body))
+	}
+	return &NotificationSubscriber{
+		dispatcher: dispatcher,
+		templates:  templates,
+		logger:     logger,
+	}
+}
+
+// Subscribes to every event type that has a template
+func (s *NotificationSubscriber) Register(bus events.Bus) {
+	types := make([]string, 0, len(notificationTemplates))
+	for eventType := range notificationTemplates {
+		types = append(types, eventType)
+	}
+	bus.Subscribe("notifications", s.Handle, types...)
+}
+
+func (s *NotificationSubscriber) Handle(event events.Event) error {
+	tmpl, ok := s.templates[event.Type]
+	if !ok {
+		return nil
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, event.Data); err != nil {
+		return fmt.Errorf("rendering %s notification: %w", event.Type, err)
+	}
+	t := notificationTemplates[event.Type]
+	notification := OutgoingNotification{
+		UserID:    event.UserID,
+		EventType: event.Type,
+		Category:  t.category,
+		Priority:  t.priority,
+		Title:     t.title,
+		Body:      body.String(),
+		Payload:   notificationPayload(t, event),
+	}
+	if t.ttl > 0 {
+		expiresAt := event.OccurredAt.Add(t.ttl)
+		notification.ExpiresAt = &expiresAt
+	}
+	return s.dispatcher.Dispatch(notification)
+}
+
+func notificationPayload(t notificationTemplate, event events.Event) models.NotificationPayload {
+	payload := models.NotificationPayload{Data: event.Data}
+	if t.link != "" {
+		payload.DeepLink = deepLinkScheme + t.link
+	}
+	if id := event.Data[t.targetKey]; t.targetKey != "" && id != "" {
+		payload.TargetType = t.target
+		payload.TargetID = id
+		payload.DeepLink += "/" + id
+	}
+	return payload
+}
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"pgpockets/internal/emvqr"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"pgpockets/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPaymentLinkNotFound = errors.New("payment link not found")
+	ErrPaymentLinkInactive = errors.New("payment link can no longer be paid")
+	ErrAmountRequired      = errors.New("this link needs you to enter an amount")
+	ErrAmountMismatch      = errors.New("amount does not match the link's fixed amount")
+	ErrInvalidLinkExpiry   = errors.New("expiry must be in the future")
+	ErrInvalidQRPayload    = errors.New("QR code is not a valid PgPockets payment code")
+)
+
+// Unambiguous characters for link codes (no 0/O or 1/I/L)
+const paymentLinkAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+const paymentLinkCodeLength = 10
+
+type NewPaymentLink struct {
+	WalletID    *uuid.UUID // Defaults to the owner's wallet in Currency
+	Amount      *decimal.Decimal
+	Currency    string
+	Description string
+	Reusable    bool
+	ExpiresAt   *time.Time
+}
+
+// PaymentLinkView is a link as its owner sees it, with what is needed to share it
+type PaymentLinkView struct {
+	models.PaymentLink
+	URL       string `json:"url"`
+	QRPayload string `json:"qr_payload"`
+}
+
+// PublicPaymentLink is what anyone holding the link can see before paying
+type PublicPaymentLink struct {
+	Code        string           `json:"code"`
+	PayeeName   string           `json:"payee_name"` // Masked
+	Amount      *decimal.Decimal `json:"amount"`
+	Currency    string           `json:"currency"`
+	Description string           `json:"description"`
+	Reusable    bool             `json:"reusable"`
+	ExpiresAt   *time.Time       `json:"expires_at"`
+	URL         string           `json:"url"`
+	QRPayload   string           `json:"qr_payload"`
+}
+
+type PayLinkRequest struct {
+	SenderWalletID *uuid.UUID       // Defaults to the payer's wallet in the link's currency
+	Amount         *decimal.Decimal // Required for open-amount links
+}
+
+// PaymentLinkService manages shareable payment links and the QR codes that carry them
+type PaymentLinkService interface {
+	Create(userID uuid.UUID, req NewPaymentLink) (*PaymentLinkView, error)
+	List(userID uuid.UUID, status string) ([]PaymentLinkView, error)
+	Get(userID, linkID uuid.UUID) (*PaymentLinkView, error)
+	Disable(userID, linkID uuid.UUID) (*PaymentLinkView, error)
+	Resolve(code string) (*PublicPaymentLink, error)
+	ResolveQR(payload string) (*PublicPaymentLink, error)
+	Pay(payerID uuid.UUID, code string, req PayLinkRequest) (*models.Transaction, error)
+}
+
+type paymentLinkService struct {
+	repo        repositories.PaymentLinkRepository
+	walletRepo  repositories.WalletRepository
+	profileRepo repositories.ProfileRepository
+	txnService  TransactionService
+	logger      *zap.Logger
+	baseURL     string
+}
+
+func NewPaymentLinkService(
+	repo repositories.PaymentLinkRepository,
+	walletRepo repositories.WalletRepository,
+	profileRepo repositories.ProfileRepository,
+	txnService TransactionService,
+	logger *zap.Logger,
+	baseURL string,
+) *paymentLinkService {
+	return &paymentLinkService{
+		repo:        repo,
+		walletRepo:  walletRepo,
+		profileRepo: profileRepo,
+		txnService:  txnService,
+		logger:      logger,
+		baseURL:     strings.TrimRight(baseURL, "/"),
+	}
+}
+
+func (s *paymentLinkService) Create(userID uuid.UUID, req NewPaymentLink) (*PaymentLinkView, error) {
+	if req.Amount != nil && !req.Amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidLinkExpiry
+	}
+
+	var wallet *models.Wallet
+	var err error
+	if req.WalletID != nil {
+		wallet, err = s.walletRepo.GetWalletByID(*req.WalletID)
+		if err != nil || wallet.UserID != userID || !wallet.IsActive {
+			return nil, ErrWalletNotOwned
+		}
+	} else {
+		wallet, err = s.walletRepo.GetPrimaryWallet(userID, strings.ToUpper(req.Currency))
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, ErrNoWalletInCurrency
+			}
+			return nil, err
+		}
+	}
+
+	code, err := generateLinkCode()
+	if err != nil {
+		return nil, err
+	}
+	link := &models.PaymentLink{
+		UserID:      userID,
+		WalletID:    wallet.ID,
+		Code:        code,
+		Amount:      req.Amount,
+		Currency:    wallet.Currency,
+		Description: strings.TrimSpace(req.Description),
+		Reusable:    req.Reusable,
+		Status:      models.PaymentLinkStatusActive,
+		ExpiresAt:   req.ExpiresAt,
+	}
+	if err := s.repo.Create(link); err != nil {
+		s.logger.Error("Failed to create payment link", zap.Error(err))
+		return nil, err
+	}
+	return s.view(link)
+}
+
+func (s *paymentLinkService) List(userID uuid.UUID, status string) ([]PaymentLinkView, error) {
+	links, err := s.repo.ListByUserID(userID, status)
+	if err != nil {
+		return nil, err
+	}
+	views := make([]PaymentLinkView, 0, len(links))
+	for i := range links {
+		view, err := s.view(&links[i])
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, *view)
+	}
+	return views, nil
+}
+
+func (s *paymentLinkService) Get(userID, linkID uuid.UUID) (*PaymentLinkView, error) {
+	link, err := s.getOwned(userID, linkID)
+	if err != nil {
+		return nil, err
+	}
+	return s.view(link)
+}
+
+func (s *paymentLinkService) Disable(userID, linkID uuid.UUID) (*PaymentLinkView, error) {
+	link, err := s.getOwned(userID, linkID)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := s.repo.Transition(link.ID, models.PaymentLinkStatusActive, models.PaymentLinkStatusDisabled)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return nil, ErrPaymentLinkInactive
+	}
+	link.Status = models.PaymentLinkStatusDisabled
+	return s.view(link)
+}
+
+func (s *paymentLinkService) Resolve(code string) (*PublicPaymentLink, error) {
+	link, err := s.getPayable(code)
+	if err != nil {
+		return nil, err
+	}
+	return s.public(link)
+}
+
+// Decodes a scanned QR payload and resolves the link it carries
+func (s *paymentLinkService) ResolveQR(payload string) (*PublicPaymentLink, error) {
+	decoded, err := emvqr.Decode(strings.TrimSpace(payload))
+	if err != nil {
+		return nil, ErrInvalidQRPayload
+	}
+	link, err := s.getPayable(decoded.LinkCode)
+	if err != nil {
+		return nil, err
+	}
+	// A code whose wallet was edited to point elsewhere is not one we issued
+	if decoded.WalletID != link.WalletID.String() {
+		return nil, ErrInvalidQRPayload
+	}
+	return s.public(link)
+}
+
+/*
+Pays a link from the payer's wallet into the owner's wallet. A single-use link is
+marked used before the money moves so two payers cannot both pay it, and put back
+to active if the transfer fails.
+*/
+func (s *paymentLinkService) Pay(payerID uuid.UUID, code string, req PayLinkRequest) (*models.Transaction, error) {
+	link, err := s.getPayable(code)
+	if err != nil {
+		return nil, err
+	}
+	if link.UserID == payerID {
+		return nil, ErrSelfTransfer
+	}
+
+	amount, err := linkAmount(link, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	var senderWallet *models.Wallet
+	if req.SenderWalletID != nil {
+		senderWallet, err = s.walletRepo.GetWalletByID(*req.SenderWalletID)
+		if err != nil || senderWallet.UserID != payerID {
+			return nil, ErrWalletNotOwned
+		}
+		if senderWallet.Currency != link.Currency {
+			return nil, ErrCurrencyMismatch
+		}
+	} else {
+		senderWallet, err = s.walletRepo.GetPrimaryWallet(payerID, link.Currency)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, ErrNoWalletInCurrency
+			}
+			return nil, err
+		}
+	}
+
+	description := "Payment link " + link.Code
+	if link.Description != "" {
+		description = link.Description
+	}
+	// The payment is recorded, and a single-use link used up, in the transfer's own transaction;
+	// a link paid, disabled or expired meanwhile rolls the transfer back
+	return s.txnService.TransferFundsWith(payerID, senderWallet.ID, link.WalletID, amount, link.Currency, description,
+		func(tx *gorm.DB, txn *models.Transaction) error {
+			recorded, err := s.repo.WithTx(tx).RecordPayment(link.ID, amount, time.Now(), !link.Reusable)
+			if err != nil {
+				return err
+			}
+			if !recorded {
+				return ErrPaymentLinkInactive
+			}
+			return nil
+		},
+	)
+}
+
+func (s *paymentLinkService) getOwned(userID, linkID uuid.UUID) (*models.PaymentLink, error) {
+	link, err := s.repo.GetByID(linkID, userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrPaymentLinkNotFound
+		}
+		return nil, err
+	}
+	s.expireIfDue(link)
+	return link, nil
+}
+
+// Looks a link up by code and checks it can still be paid
+func (s *paymentLinkService) getPayable(code string) (*models.PaymentLink, error) {
+	link, err := s.repo.GetByCode(strings.ToUpper(strings.TrimSpace(code)))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrPaymentLinkNotFound
+		}
+		return nil, err
+	}
+	s.expireIfDue(link)
+	if link.Status != models.PaymentLinkStatusActive {
+		return nil, ErrPaymentLinkInactive
+	}
+	return link, nil
+}
+
+// Links are expired lazily, the first time they are looked at after their expiry
+func (s *paymentLinkService) expireIfDue(link *models.PaymentLink) {
+	if link.Status != models.PaymentLinkStatusActive || link.ExpiresAt == nil || time.Now().Before(*link.ExpiresAt) {
+		return
+	}
+	if _, err := s.repo.Transition(link.ID, models.PaymentLinkStatusActive, models.PaymentLinkStatusExpired); err != nil {
+		s.logger.Error("Failed to expire payment link", zap.Error(err))
+	}
+	link.Status = models.PaymentLinkStatusExpired
+}
+
+func (s *paymentLinkService) view(link *models.PaymentLink) (*PaymentLinkView, error) {
+	payload, err := s.qrPayload(link)
+	if err != nil {
+		return nil, err
+	}
+	return &PaymentLinkView{
+		PaymentLink: *link,
+		URL:         s.url(link),
+		QRPayload:   payload,
+	}, nil
+}
+
+func (s *paymentLinkService) public(link *models.PaymentLink) (*PublicPaymentLink, error) {
+	payload, err := s.qrPayload(link)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicPaymentLink{
+		Code:        link.Code,
+		PayeeName:   s.maskedName(link.UserID),
+		Amount:      link.Amount,
+		Currency:    link.Currency,
+		Description: link.Description,
+		Reusable:    link.Reusable,
+		ExpiresAt:   link.ExpiresAt,
+		URL:         s.url(link),
+		QRPayload:   payload,
+	}, nil
+}
+
+func (s *paymentLinkService) url(link *models.PaymentLink) string {
+	return s.baseURL + "/pay/" + link.Code
+}
+
+func (s *paymentLinkService) qrPayload(link *models.PaymentLink) (string, error) {
+	payload := emvqr.Payload{
+		LinkCode:     link.Code,
+		WalletID:     link.WalletID.String(),
+		SingleUse:    !link.Reusable,
+		Currency:     link.Currency,
+		MerchantName: s.maskedName(link.UserID),
+		Purpose:      link.Description,
+	}
+	if link.Amount != nil {
+		payload.Amount = link.Amount.StringFixed(2)
+	}
+	encoded, err := emvqr.Encode(payload)
+	if err == emvqr.ErrUnknownCurrency {
+		// Wallets in currencies without a numeric code can still be shared by URL
+		return "", nil
+	}
+	return encoded, err
+}
+
+func (s *paymentLinkService) maskedName(userID uuid.UUID) string {
+	profile, err := s.profileRepo.GetProfileByUserID(userID.String())
+	if err != nil {
+		return ""
+	}
+	return utils.MaskName(profile.FirstName + " " + profile.LastName)
+}
+
+// Fixed links charge their own amount; open links need the payer to choose one
+func linkAmount(link *models.PaymentLink, requested *decimal.Decimal) (decimal.Decimal, error) {
+	if link.Amount != nil {
+		if requested != nil && !requested.Equal(*link.Amount) {
+			return decimal.Zero, ErrAmountMismatch
+		}
+		return *link.Amount, nil
+	}
+	if requested == nil {
+		return decimal.Zero, ErrAmountRequired
+	}
+	if !requested.IsPositive() {
+		return decimal.Zero, ErrInvalidAmount
+	}
+	return *requested, nil
+}
+
+func generateLinkCode() (string, error) {
+	max := big.NewInt(int64(len(paymentLinkAlphabet)))
+	code := make([]byte, paymentLinkCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = paymentLinkAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
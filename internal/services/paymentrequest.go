@@ -0,0 +1,336 @@
+package services
+
+import (
+	"errors"
+	"pgpockets/internal/events"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPaymentRequestNotFound   = errors.New("payment request not found")
+	ErrPaymentRequestNotPending = errors.New("payment request is no longer pending")
+	ErrPaymentRequestExpired    = errors.New("payment request has expired")
+	ErrInvalidRequestExpiry     = errors.New("expiry must be in the future and within 30 days")
+	ErrNoWalletInCurrency       = errors.New("you have no active wallet in this currency")
+	ErrCannotRequestFromSelf    = errors.New("cannot request money from yourself")
+)
+
+const (
+	defaultPaymentRequestExpiry = 7 * 24 * time.Hour
+	maxPaymentRequestExpiry     = 30 * 24 * time.Hour
+)
+
+type NewPaymentRequest struct {
+	Email       string
+	PhoneNumber string
+	Amount      decimal.Decimal
+	Currency    string
+	Note        string
+	ExpiresAt   *time.Time // Defaults to a week from now
+}
+
+// PaymentRequestService lets users ask each other for money. Approving a request pays it.
+type PaymentRequestService interface {
+	Create(requesterID uuid.UUID, req NewPaymentRequest) (*models.PaymentRequest, error)
+	RequestFromUser(requesterID, payerID uuid.UUID, req NewPaymentRequest) (*models.PaymentRequest, error)
+	List(userID uuid.UUID, direction, status string, limit, offset int) ([]models.PaymentRequest, error)
+	Get(userID, requestID uuid.UUID) (*models.PaymentRequest, error)
+	Approve(payerID, requestID uuid.UUID, senderWalletID *uuid.UUID) (*models.PaymentRequest, error)
+	Decline(payerID, requestID uuid.UUID) (*models.PaymentRequest, error)
+	Cancel(requesterID, requestID uuid.UUID) (*models.PaymentRequest, error)
+	ExpireStale(now time.Time)
+}
+
+type paymentRequestService struct {
+	repo        repositories.PaymentRequestRepository
+	walletRepo  repositories.WalletRepository
+	userRepo    repositories.UserRepository
+	profileRepo repositories.ProfileRepository
+	txnService  TransactionService
+	logger      *zap.Logger
+	bus         events.Bus
+}
+
+func NewPaymentRequestService(
+	repo repositories.PaymentRequestRepository,
+	walletRepo repositories.WalletRepository,
+	userRepo repositories.UserRepository,
+	profileRepo repositories.ProfileRepository,
+	txnService TransactionService,
+	logger *zap.Logger,
+	bus events.Bus,
+) *paymentRequestService {
+	return &paymentRequestService{
+		repo:        repo,
+		walletRepo:  walletRepo,
+		userRepo:    userRepo,
+		profileRepo: profileRepo,
+		txnService:  txnService,
+		logger:      logger,
+		bus:         bus,
+	}
+}
+
+func (s *paymentRequestService) Create(requesterID uuid.UUID, req NewPaymentRequest) (*models.PaymentRequest, error) {
+	if (req.Email == "") == (req.PhoneNumber == "") {
+		return nil, ErrAmbiguousRecipient
+	}
+	payerID, err := findUserByContact(s.userRepo, s.profileRepo, req.Email, req.PhoneNumber)
+	if err != nil {
+		return nil, err
+	}
+	return s.RequestFromUser(requesterID, payerID, req)
+}
+
+// Sends a request to a payer who is already known, ignoring the contact fields on req
+func (s *paymentRequestService) RequestFromUser(requesterID, payerID uuid.UUID, req NewPaymentRequest) (*models.PaymentRequest, error) {
+	if !req.Amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(defaultPaymentRequestExpiry)
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	if !expiresAt.After(now) || expiresAt.Sub(now) > maxPaymentRequestExpiry {
+		return nil, ErrInvalidRequestExpiry
+	}
+
+	if payerID == requesterID {
+		return nil, ErrCannotRequestFromSelf
+	}
+
+	// The money lands in the requester's wallet for the requested currency
+	currency := strings.ToUpper(req.Currency)
+	wallet, err := s.walletRepo.GetPrimaryWallet(requesterID, currency)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNoWalletInCurrency
+		}
+		s.logger.Error("Failed to look up requester wallet", zap.Error(err))
+		return nil, err
+	}
+
+	request := &models.PaymentRequest{
+		RequesterID:       requesterID,
+		PayerID:           payerID,
+		RequesterWalletID: wallet.ID,
+		Amount:            req.Amount,
+		Currency:          currency,
+		Note:              strings.TrimSpace(req.Note),
+		Status:            models.PaymentRequestStatusPending,
+		ExpiresAt:         expiresAt,
+	}
+	if err := s.repo.Create(request); err != nil {
+		s.logger.Error("Failed to create payment request", zap.Error(err))
+		return nil, err
+	}
+
+	s.publish(events.PaymentRequestReceived, payerID, requesterID, request, map[string]string{
+		"expires_on": request.ExpiresAt.Format("2 Jan 2006"),
+	})
+	return request, nil
+}
+
+func (s *paymentRequestService) List(userID uuid.UUID, direction, status string, limit, offset int) ([]models.PaymentRequest, error) {
+	return s.repo.ListForUser(userID, direction, status, limit, offset)
+}
+
+// Both the requester and the payer can see a request
+func (s *paymentRequestService) Get(userID, requestID uuid.UUID) (*models.PaymentRequest, error) {
+	request, err := s.repo.GetByID(requestID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrPaymentRequestNotFound
+		}
+		return nil, err
+	}
+	if request.RequesterID != userID && request.PayerID != userID {
+		return nil, ErrPaymentRequestNotFound
+	}
+	return request, nil
+}
+
+/*
+Pays the request from senderWalletID, or from the payer's wallet in the request's
+currency when none is given. The request is marked paid before the money moves so a
+second approval cannot pay it twice, and put back to pending if the transfer fails.
+*/
+func (s *paymentRequestService) Approve(payerID, requestID uuid.UUID, senderWalletID *uuid.UUID) (*models.PaymentRequest, error) {
+	request, err := s.getAsPayer(payerID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPending(request); err != nil {
+		return nil, err
+	}
+
+	var senderWallet *models.Wallet
+	if senderWalletID != nil {
+		senderWallet, err = s.walletRepo.GetWalletByID(*senderWalletID)
+		if err != nil || senderWallet.UserID != payerID {
+			return nil, ErrWalletNotOwned
+		}
+		if senderWallet.Currency != request.Currency {
+			return nil, ErrCurrencyMismatch
+		}
+	} else {
+		senderWallet, err = s.walletRepo.GetPrimaryWallet(payerID, request.Currency)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, ErrNoWalletInCurrency
+			}
+			return nil, err
+		}
+	}
+
+	description := "Payment request"
+	if request.Note != "" {
+		description = "Payment request: " + request.Note
+	}
+	// The request is marked paid in the transfer's own transaction, so it is never paid without
+	// the money moving, and a request declined, cancelled or expired meanwhile rolls the transfer back
+	now := time.Now()
+	txn, err := s.txnService.TransferFundsWith(
+		payerID,
+		senderWallet.ID,
+		request.RequesterWalletID,
+		request.Amount,
+		request.Currency,
+		description,
+		func(tx *gorm.DB, txn *models.Transaction) error {
+			paid, err := s.repo.WithTx(tx).MarkPaid(request.ID, txn.ID, now)
+			if err != nil {
+				return err
+			}
+			if !paid {
+				return ErrPaymentRequestNotPending
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	request.Status = models.PaymentRequestStatusPaid
+	request.RespondedAt = &now
+	request.TransactionID = &txn.ID
+
+	// The payer already hears about the money leaving through the transfer itself
+	s.publish(events.PaymentRequestPaid, request.RequesterID, payerID, request, map[string]string{
+		"transaction_id": txn.ID.String(),
+	})
+	return request, nil
+}
+
+func (s *paymentRequestService) Decline(payerID, requestID uuid.UUID) (*models.PaymentRequest, error) {
+	request, err := s.getAsPayer(payerID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.respond(request, models.PaymentRequestStatusDeclined); err != nil {
+		return nil, err
+	}
+
+	s.publish(events.PaymentRequestDeclined, request.RequesterID, payerID, request, nil)
+	return request, nil
+}
+
+func (s *paymentRequestService) Cancel(requesterID, requestID uuid.UUID) (*models.PaymentRequest, error) {
+	request, err := s.Get(requesterID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if request.RequesterID != requesterID {
+		return nil, ErrPaymentRequestNotFound
+	}
+	if err := s.respond(request, models.PaymentRequestStatusCancelled); err != nil {
+		return nil, err
+	}
+
+	s.publish(events.PaymentRequestCancelled, request.PayerID, requesterID, request, nil)
+	return request, nil
+}
+
+// Expires pending requests that nobody answered in time and tells both sides
+func (s *paymentRequestService) ExpireStale(now time.Time) {
+	expired, err := s.repo.ExpirePending(now)
+	if err != nil {
+		s.logger.Error("Failed to expire payment requests", zap.Error(err))
+		return
+	}
+	for i := range expired {
+		request := &expired[i]
+		s.publish(events.PaymentRequestExpired, request.RequesterID, request.PayerID, request, map[string]string{"role": "requester"})
+		s.publish(events.PaymentRequestExpired, request.PayerID, request.RequesterID, request, map[string]string{"role": "payer"})
+	}
+	if len(expired) > 0 {
+		s.logger.Info("Expired payment requests", zap.Int("count", len(expired)))
+	}
+}
+
+func (s *paymentRequestService) getAsPayer(payerID, requestID uuid.UUID) (*models.PaymentRequest, error) {
+	request, err := s.Get(payerID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if request.PayerID != payerID {
+		return nil, ErrPaymentRequestNotFound
+	}
+	return request, nil
+}
+
+// Requests past their expiry are treated as expired even before the worker gets to them
+func (s *paymentRequestService) checkPending(request *models.PaymentRequest) error {
+	if request.Status != models.PaymentRequestStatusPending {
+		return ErrPaymentRequestNotPending
+	}
+	if !time.Now().Before(request.ExpiresAt) {
+		return ErrPaymentRequestExpired
+	}
+	return nil
+}
+
+func (s *paymentRequestService) respond(request *models.PaymentRequest, status string) error {
+	if err := s.checkPending(request); err != nil {
+		return err
+	}
+	changed, err := s.repo.Transition(request.ID, models.PaymentRequestStatusPending, status)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return ErrPaymentRequestNotPending
+	}
+	now := time.Now()
+	request.Status = status
+	request.RespondedAt = &now
+	return nil
+}
+
+// Publishes a payment request event to userID, naming the other side of the request
+func (s *paymentRequestService) publish(eventType string, userID, counterpartyID uuid.UUID, request *models.PaymentRequest, extra map[string]string) {
+	data := map[string]string{
+		"payment_request_id": request.ID.String(),
+		"amount":             formatMoney(request.Amount, request.Currency),
+		"note":               request.Note,
+		"counterparty":       profileName(s.profileRepo, counterpartyID),
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	s.bus.Publish(events.Event{Type: eventType, UserID: userID, Data: data})
+}
+
+func formatMoney(amount decimal.Decimal, currency string) string {
+	return currency + " " + amount.StringFixed(2)
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"pgpockets/internal/events"
+	"pgpockets/internal/models"
+	"pgpockets/internal/realtime"
+	"pgpockets/internal/repositories"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// WalletBalanceUpdate is the payload of a wallet.balance_changed push
+type WalletBalanceUpdate struct {
+	WalletID         uuid.UUID       `json:"wallet_id"`
+	Currency         string          `json:"currency"`
+	Balance          decimal.Decimal `json:"balance"`
+	HeldBalance      decimal.Decimal `json:"held_balance"`
+	AvailableBalance decimal.Decimal `json:"available_balance"`
+}
+
+// RealtimeSubscriber pushes balance and transaction updates to the affected user's open connections
+type RealtimeSubscriber struct {
+	publisher  realtime.Publisher
+	walletRepo repositories.WalletRepository
+	txnRepo    repositories.TransactionRepository
+	logger     *zap.Logger
+}
+
+func NewRealtimeSubscriber(
+	publisher realtime.Publisher,
+	walletRepo repositories.WalletRepository,
+	txnRepo repositories.TransactionRepository,
+	logger *zap.Logger,
+) *RealtimeSubscriber {
+	return &RealtimeSubscriber{
+		publisher:  publisher,
+		walletRepo: walletRepo,
+		txnRepo:    txnRepo,
+		logger:     logger,
+	}
+}
+
+func (s *RealtimeSubscriber) Register(bus events.Bus) {
+	bus.Subscribe("realtime", s.Handle, events.TransferSent, events.TransferReceived, events.WalletBalanceChanged)
+}
+
+// Balances and transactions are re-read rather than taken from the event so clients always get current values
+func (s *RealtimeSubscriber) Handle(event events.Event) error {
+	if walletID, err := uuid.Parse(event.Data["wallet_id"]); err == nil {
+		wallet, err := s.walletRepo.GetWalletByID(walletID)
+		if err != nil {
+			return err
+		}
+		if err := s.publisher.Publish(event.UserID, realtime.TypeBalanceChanged, balanceUpdate(wallet)); err != nil {
+			return err
+		}
+	}
+	if txnID, err := uuid.Parse(event.Data["transaction_id"]); err == nil {
+		txn, err := s.txnRepo.GetTransactionByID(txnID)
+		if err != nil {
+			return err
+		}
+		if err := s.publisher.Publish(event.UserID, realtime.TypeTransaction, txn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func balanceUpdate(wallet *models.Wallet) WalletBalanceUpdate {
+	return WalletBalanceUpdate{
+		WalletID:         wallet.ID,
+		Currency:         wallet.Currency,
+		Balance:          wallet.Balance,
+		HeldBalance:      wallet.HeldBalance,
+		AvailableBalance: wallet.Balance.Sub(wallet.HeldBalance),
+	}
+}
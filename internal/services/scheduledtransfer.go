@@ -0,0 +1,541 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"pgpockets/internal/mailer"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrScheduleNotFound  = errors.New("scheduled transfer not found")
+	ErrScheduleNotActive = errors.New("scheduled transfer is not active")
+	ErrScheduleNotPaused = errors.New("scheduled transfer is not paused")
+	ErrScheduleFinished  = errors.New("scheduled transfer has already finished")
+	ErrInvalidSchedule   = errors.New("schedule must start in the future and end after it starts")
+	ErrInvalidFrequency  = errors.New("frequency must be once, daily, weekly or monthly")
+	ErrScheduleRecipient = errors.New("provide exactly one of receiver_wallet_id or beneficiary_id")
+	ErrCurrencyMismatch  = errors.New("receiver wallet currency does not match sender wallet")
+
+	// Rolls a scheduled payment back when the schedule was paused, cancelled or edited mid-run
+	errScheduleChanged = errors.New("scheduled transfer changed while running")
+)
+
+const (
+	// Failed occurrences are retried this often, this many times, before being skipped
+	scheduleRetryDelay = time.Hour
+	scheduleMaxRetries = 3
+	// How long a worker holds a claimed schedule; a crashed worker's claim lapses after this
+	scheduleClaimLease = 10 * time.Minute
+)
+
+type NewScheduledTransfer struct {
+	SenderWalletID   uuid.UUID
+	ReceiverWalletID *uuid.UUID
+	BeneficiaryID    *uuid.UUID
+	Amount           decimal.Decimal
+	Description      string
+	Frequency        string
+	StartAt          time.Time
+	EndDate          *time.Time
+}
+
+// ScheduledTransferUpdate edits a schedule. Nil fields are left as they are.
+type ScheduledTransferUpdate struct {
+	Amount       *decimal.Decimal
+	Description  *string
+	Frequency    *string
+	NextRunAt    *time.Time
+	EndDate      *time.Time
+	ClearEndDate bool
+}
+
+type ScheduledTransferService interface {
+	Create(userID uuid.UUID, req NewScheduledTransfer) (*models.ScheduledTransfer, error)
+	List(userID uuid.UUID, status string) ([]models.ScheduledTransfer, error)
+	Get(userID, scheduleID uuid.UUID) (*models.ScheduledTransfer, error)
+	Update(userID, scheduleID uuid.UUID, update ScheduledTransferUpdate) (*models.ScheduledTransfer, error)
+	Pause(userID, scheduleID uuid.UUID) (*models.ScheduledTransfer, error)
+	Resume(userID, scheduleID uuid.UUID) (*models.ScheduledTransfer, error)
+	Cancel(userID, scheduleID uuid.UUID) (*models.ScheduledTransfer, error)
+	RunDue(now time.Time) (int, error)
+}
+
+type scheduledTransferService struct {
+	repo            repositories.ScheduledTransferRepository
+	walletRepo      repositories.WalletRepository
+	userRepo        repositories.UserRepository
+	txnService      TransactionService
+	transferService TransferService
+	mailer          mailer.Mailer
+	logger          *zap.Logger
+}
+
+func NewScheduledTransferService(
+	repo repositories.ScheduledTransferRepository,
+	walletRepo repositories.WalletRepository,
+	userRepo repositories.UserRepository,
+	txnService TransactionService,
+	transferService TransferService,
+	mailer mailer.Mailer,
+	logger *zap.Logger,
+) *scheduledTransferService {
+	return &scheduledTransferService{
+		repo:            repo,
+		walletRepo:      walletRepo,
+		userRepo:        userRepo,
+		txnService:      txnService,
+		transferService: transferService,
+		mailer:          mailer,
+		logger:          logger,
+	}
+}
+
+// Validates the schedule and its recipient up front so mistakes surface now rather than at run time
+func (s *scheduledTransferService) Create(userID uuid.UUID, req NewScheduledTransfer) (*models.ScheduledTransfer, error) {
+	if !validFrequency(req.Frequency) {
+		return nil, ErrInvalidFrequency
+	}
+	if !req.Amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+	now := time.Now()
+	if req.StartAt.Before(now.Add(-time.Minute)) || (req.EndDate != nil && !req.EndDate.After(req.StartAt)) {
+		return nil, ErrInvalidSchedule
+	}
+	if (req.ReceiverWalletID == nil) == (req.BeneficiaryID == nil) {
+		return nil, ErrScheduleRecipient
+	}
+
+	schedule := &models.ScheduledTransfer{
+		UserID:           userID,
+		SenderWalletID:   req.SenderWalletID,
+		ReceiverWalletID: req.ReceiverWalletID,
+		BeneficiaryID:    req.BeneficiaryID,
+		Amount:           req.Amount,
+		Description:      req.Description,
+		Frequency:        req.Frequency,
+		StartAt:          req.StartAt.UTC(),
+		NextRunAt:        req.StartAt.UTC(),
+		EndDate:          req.EndDate,
+		Status:           models.ScheduleStatusActive,
+	}
+	senderWallet, err := s.walletRepo.GetWalletByID(req.SenderWalletID)
+	if err != nil || senderWallet.UserID != userID {
+		return nil, ErrWalletNotOwned
+	}
+	schedule.Currency = senderWallet.Currency
+	if _, err := s.resolveReceiver(schedule); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(schedule); err != nil {
+		s.logger.Error("Failed to create scheduled transfer", zap.Error(err))
+		return nil, err
+	}
+	s.logger.Info("Scheduled transfer created",
+		zap.String("schedule_id", schedule.ID.String()),
+		zap.String("frequency", schedule.Frequency))
+	return schedule, nil
+}
+
+func (s *scheduledTransferService) List(userID uuid.UUID, status string) ([]models.ScheduledTransfer, error) {
+	schedules, err := s.repo.ListByUserID(userID, status)
+	if err != nil {
+		s.logger.Error("Failed to list scheduled transfers", zap.Error(err))
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (s *scheduledTransferService) Get(userID, scheduleID uuid.UUID) (*models.ScheduledTransfer, error) {
+	schedule, err := s.repo.GetByID(scheduleID, userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrScheduleNotFound
+		}
+		s.logger.Error("Failed to get scheduled transfer", zap.Error(err))
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *scheduledTransferService) Update(userID, scheduleID uuid.UUID, update ScheduledTransferUpdate) (*models.ScheduledTransfer, error) {
+	schedule, err := s.Get(userID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if isScheduleFinished(schedule) {
+		return nil, ErrScheduleFinished
+	}
+
+	columns := []string{}
+	if update.Amount != nil {
+		if !update.Amount.IsPositive() {
+			return nil, ErrInvalidAmount
+		}
+		schedule.Amount = *update.Amount
+		columns = append(columns, "amount")
+	}
+	if update.Description != nil {
+		schedule.Description = *update.Description
+		columns = append(columns, "description")
+	}
+	if update.Frequency != nil {
+		if !validFrequency(*update.Frequency) {
+			return nil, ErrInvalidFrequency
+		}
+		schedule.Frequency = *update.Frequency
+		columns = append(columns, "frequency")
+	}
+	if update.NextRunAt != nil {
+		if update.NextRunAt.Before(time.Now()) {
+			return nil, ErrInvalidSchedule
+		}
+		// Moving the next run re-anchors all later occurrences on it
+		schedule.StartAt = update.NextRunAt.UTC()
+		schedule.NextRunAt = update.NextRunAt.UTC()
+		schedule.RetryCount = 0
+		columns = append(columns, "start_at", "next_run_at", "retry_count")
+	}
+	if update.ClearEndDate {
+		schedule.EndDate = nil
+		columns = append(columns, "end_date")
+	} else if update.EndDate != nil {
+		schedule.EndDate = update.EndDate
+		columns = append(columns, "end_date")
+	}
+	if schedule.EndDate != nil && !schedule.EndDate.After(schedule.NextRunAt) {
+		return nil, ErrInvalidSchedule
+	}
+	if len(columns) == 0 {
+		return schedule, nil
+	}
+
+	if err := s.repo.Update(schedule, columns...); err != nil {
+		s.logger.Error("Failed to update scheduled transfer", zap.Error(err))
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *scheduledTransferService) Pause(userID, scheduleID uuid.UUID) (*models.ScheduledTransfer, error) {
+	schedule, err := s.Get(userID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Status != models.ScheduleStatusActive {
+		return nil, ErrScheduleNotActive
+	}
+	schedule.Status = models.ScheduleStatusPaused
+	if err := s.repo.Update(schedule, "status"); err != nil {
+		s.logger.Error("Failed to pause scheduled transfer", zap.Error(err))
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// Resumes a paused schedule. Occurrences missed while paused are skipped, not paid late.
+func (s *scheduledTransferService) Resume(userID, scheduleID uuid.UUID) (*models.ScheduledTransfer, error) {
+	schedule, err := s.Get(userID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Status != models.ScheduleStatusPaused {
+		return nil, ErrScheduleNotPaused
+	}
+
+	now := time.Now()
+	schedule.Status = models.ScheduleStatusActive
+	schedule.RetryCount = 0
+	if schedule.NextRunAt.Before(now) {
+		if schedule.Frequency == models.ScheduleFrequencyOnce {
+			schedule.NextRunAt = now
+		} else if next := nextOccurrence(schedule, now); next != nil {
+			schedule.NextRunAt = *next
+		} else {
+			schedule.Status = models.ScheduleStatusCompleted
+		}
+	}
+	if err := s.repo.Update(schedule, "status", "retry_count", "next_run_at"); err != nil {
+		s.logger.Error("Failed to resume scheduled transfer", zap.Error(err))
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *scheduledTransferService) Cancel(userID, scheduleID uuid.UUID) (*models.ScheduledTransfer, error) {
+	schedule, err := s.Get(userID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if isScheduleFinished(schedule) {
+		return nil, ErrScheduleFinished
+	}
+	schedule.Status = models.ScheduleStatusCancelled
+	if err := s.repo.Update(schedule, "status"); err != nil {
+		s.logger.Error("Failed to cancel scheduled transfer", zap.Error(err))
+		return nil, err
+	}
+	s.logger.Info("Scheduled transfer cancelled", zap.String("schedule_id", schedule.ID.String()))
+	return schedule, nil
+}
+
+// Executes every schedule that is due. Returns how many were attempted.
+func (s *scheduledTransferService) RunDue(now time.Time) (int, error) {
+	due, err := s.repo.ListDue(now, 100)
+	if err != nil {
+		s.logger.Error("Failed to list due scheduled transfers", zap.Error(err))
+		return 0, err
+	}
+
+	attempted := 0
+	for i := range due {
+		schedule := &due[i]
+		leaseUntil := now.Add(scheduleClaimLease)
+		claimed, err := s.repo.Claim(schedule.ID, schedule.NextRunAt, leaseUntil)
+		if err != nil {
+			s.logger.Error("Failed to claim scheduled transfer", zap.String("schedule_id", schedule.ID.String()), zap.Error(err))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		s.execute(schedule, leaseUntil, now)
+		attempted++
+	}
+	return attempted, nil
+}
+
+/*
+	Runs one claimed occurrence. A successful run is recorded in the same database transaction
+	as the transfer and only while the schedule still holds this worker's lease, so an
+	occurrence is never paid twice and a pause or cancel made meanwhile wins over the payment.
+*/
+func (s *scheduledTransferService) execute(schedule *models.ScheduledTransfer, leaseUntil, now time.Time) {
+	description := schedule.Description
+	if description == "" {
+		description = "Scheduled transfer"
+	}
+
+	receiverWalletID, err := s.resolveReceiver(schedule)
+	if err == nil {
+		var txn *models.Transaction
+		txn, err = s.txnService.TransferFundsWith(
+			schedule.UserID,
+			schedule.SenderWalletID,
+			receiverWalletID,
+			schedule.Amount,
+			schedule.Currency,
+			description,
+			func(tx *gorm.DB, txn *models.Transaction) error {
+				ran := *schedule
+				ran.RunCount++
+				ran.LastRunAt = &now
+				ran.LastTransactionID = &txn.ID
+				ran.LastError = ""
+				ran.RetryCount = 0
+				s.advance(&ran, now)
+				recorded, err := s.repo.WithTx(tx).RecordRun(&ran, leaseUntil,
+					"next_run_at", "status", "retry_count", "last_error", "run_count", "last_run_at", "last_transaction_id")
+				if err != nil {
+					return err
+				}
+				if !recorded {
+					return errScheduleChanged
+				}
+				return nil
+			},
+		)
+		if err == nil {
+			s.logger.Info("Scheduled transfer executed",
+				zap.String("schedule_id", schedule.ID.String()),
+				zap.String("transaction_id", txn.ID.String()))
+			return
+		}
+		if err == errScheduleChanged {
+			s.logger.Info("Scheduled transfer changed while running, occurrence not paid",
+				zap.String("schedule_id", schedule.ID.String()))
+			return
+		}
+	}
+
+	switch {
+	case isPermanentTransferError(err):
+		// Retrying cannot help (e.g. the beneficiary was deleted), so stop the schedule
+		schedule.Status = models.ScheduleStatusFailed
+		schedule.LastError = err.Error()
+		s.notifyFailure(schedule, err, false)
+	case schedule.RetryCount < scheduleMaxRetries:
+		schedule.RetryCount++
+		schedule.LastError = err.Error()
+		schedule.NextRunAt = now.Add(scheduleRetryDelay)
+		s.logger.Warn("Scheduled transfer failed, will retry",
+			zap.String("schedule_id", schedule.ID.String()),
+			zap.Int("retry", schedule.RetryCount),
+			zap.Error(err))
+	default:
+		// Out of retries: skip this occurrence and carry on with the next one
+		schedule.LastError = err.Error()
+		schedule.RetryCount = 0
+		s.advance(schedule, now)
+		s.notifyFailure(schedule, err, schedule.Status == models.ScheduleStatusActive)
+	}
+
+	recorded, err := s.repo.RecordRun(schedule, leaseUntil, "next_run_at", "status", "retry_count", "last_error")
+	if err != nil {
+		s.logger.Error("Failed to record scheduled transfer run", zap.String("schedule_id", schedule.ID.String()), zap.Error(err))
+	} else if !recorded {
+		s.logger.Info("Scheduled transfer changed while running, failed run not recorded",
+			zap.String("schedule_id", schedule.ID.String()))
+	}
+}
+
+// Moves the schedule to its next occurrence after now, or completes it
+func (s *scheduledTransferService) advance(schedule *models.ScheduledTransfer, now time.Time) {
+	next := nextOccurrence(schedule, now)
+	if next == nil {
+		schedule.Status = models.ScheduleStatusCompleted
+		return
+	}
+	schedule.NextRunAt = *next
+}
+
+func (s *scheduledTransferService) resolveReceiver(schedule *models.ScheduledTransfer) (uuid.UUID, error) {
+	if schedule.BeneficiaryID != nil {
+		preview, err := s.transferService.Preview(
+			schedule.UserID,
+			schedule.SenderWalletID,
+			TransferTarget{BeneficiaryID: schedule.BeneficiaryID},
+			schedule.Amount,
+		)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		return preview.RecipientWalletID, nil
+	}
+
+	receiver, err := s.walletRepo.GetWalletByID(*schedule.ReceiverWalletID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return uuid.Nil, ErrReceiverWalletNotFound
+		}
+		return uuid.Nil, err
+	}
+	if receiver.ID == schedule.SenderWalletID {
+		return uuid.Nil, ErrSelfTransfer
+	}
+	if receiver.Currency != schedule.Currency {
+		return uuid.Nil, ErrCurrencyMismatch
+	}
+	return receiver.ID, nil
+}
+
+func (s *scheduledTransferService) notifyFailure(schedule *models.ScheduledTransfer, cause error, continuing bool) {
+	user, err := s.userRepo.GetUserByID(schedule.UserID)
+	if err != nil {
+		s.logger.Error("Failed to look up user for schedule failure notice", zap.Error(err))
+		return
+	}
+
+	reason := "an unexpected error"
+	if cause == ErrInsufficientFunds {
+		reason = "insufficient funds"
+	} else if isPermanentTransferError(cause) {
+		reason = cause.Error()
+	}
+	next := "This schedule has been stopped. You can edit or recreate it in the app."
+	if continuing {
+		next = fmt.Sprintf("We will try again on the next scheduled date, %s.", schedule.NextRunAt.Format("2 Jan 2006"))
+	}
+	body := fmt.Sprintf(
+		"Your scheduled transfer of %s %s (%s) could not be completed because of %s.\n\n%s",
+		schedule.Currency, schedule.Amount.StringFixed(2), schedule.Description, reason, next,
+	)
+	if err := s.mailer.Send(mailer.Message{To: user.Email, Subject: "Your scheduled transfer failed", Body: body}); err != nil {
+		s.logger.Error("Failed to send schedule failure notice", zap.Error(err))
+	}
+}
+
+/*
+Returns the first occurrence strictly after `after`, counted from StartAt,
+or nil for one-off schedules and when it would fall past the end date.
+Missed occurrences (e.g. while the worker was down) are skipped, not paid in a burst.
+*/
+func nextOccurrence(schedule *models.ScheduledTransfer, after time.Time) *time.Time {
+	start := schedule.StartAt
+	var next time.Time
+	switch schedule.Frequency {
+	case models.ScheduleFrequencyDaily, models.ScheduleFrequencyWeekly:
+		period := 24 * time.Hour
+		if schedule.Frequency == models.ScheduleFrequencyWeekly {
+			period *= 7
+		}
+		if after.Before(start) {
+			next = start
+		} else {
+			next = start.Add((after.Sub(start)/period + 1) * period)
+		}
+	case models.ScheduleFrequencyMonthly:
+		months := (after.Year()-start.Year())*12 + int(after.Month()-start.Month()) - 1
+		if months < 0 {
+			months = 0
+		}
+		for next = addMonthsClamped(start, months); !next.After(after); months++ {
+			next = addMonthsClamped(start, months+1)
+		}
+	default:
+		return nil
+	}
+
+	if schedule.EndDate != nil && next.After(*schedule.EndDate) {
+		return nil
+	}
+	return &next
+}
+
+// Adds months keeping the day of month, clamped to the last day of shorter months (Jan 31 -> Feb 28)
+func addMonthsClamped(t time.Time, months int) time.Time {
+	firstOfTarget := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	lastDay := firstOfTarget.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return firstOfTarget.AddDate(0, 0, day-1)
+}
+
+func validFrequency(frequency string) bool {
+	switch frequency {
+	case models.ScheduleFrequencyOnce, models.ScheduleFrequencyDaily,
+		models.ScheduleFrequencyWeekly, models.ScheduleFrequencyMonthly:
+		return true
+	}
+	return false
+}
+
+func isScheduleFinished(schedule *models.ScheduledTransfer) bool {
+	switch schedule.Status {
+	case models.ScheduleStatusCompleted, models.ScheduleStatusCancelled, models.ScheduleStatusFailed:
+		return true
+	}
+	return false
+}
+
+// Errors that will not go away by trying again later
+func isPermanentTransferError(err error) bool {
+	switch err {
+	case ErrSenderWalletNotFound, ErrReceiverWalletNotFound, ErrNotWalletOwner, ErrWalletNotOwned,
+		ErrBeneficiaryNotFound, ErrRecipientNotFound, ErrRecipientCurrencyUnavailable,
+		ErrExternalTransferUnsupported, ErrSelfTransfer, ErrCurrencyMismatch:
+		return true
+	}
+	return false
+}
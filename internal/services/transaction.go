@@ -1,8 +1,10 @@
 package services
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"pgpockets/internal/events"
 	"pgpockets/internal/models"
 	"pgpockets/internal/repositories"
 	"time"
@@ -13,12 +15,27 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	ErrSenderWalletNotFound   = errors.New("sender wallet not found")
+	ErrReceiverWalletNotFound = errors.New("receiver wallet not found")
+	ErrNotWalletOwner         = errors.New("user is not owner of wallet")
+	ErrInsufficientFunds      = errors.New("insufficient funds")
+)
+
 type TransactionService interface {
 	TransferFunds(
 		userID, senderWalletID, recieverWalletID uuid.UUID,
 		amount decimal.Decimal,
 		currency, description string,
 	) (*models.Transaction, error)
+	// TransferFundsWith also runs fn inside the transfer's database transaction, after the balances
+	// have moved; an error from fn rolls the whole transfer back
+	TransferFundsWith(
+		userID, senderWalletID, recieverWalletID uuid.UUID,
+		amount decimal.Decimal,
+		currency, description string,
+		fn func(tx *gorm.DB, txn *models.Transaction) error,
+	) (*models.Transaction, error)
 	GetTransactionHistory(
 		userID uuid.UUID,
 		limit, offset int,
@@ -32,23 +49,29 @@ type TransactionService interface {
 }
 
 type transactionService struct {
-	txnRepo    repositories.TransactionRepository
-	walletRepo repositories.WalletRepository
-	appLogger  *zap.Logger
-	db         *gorm.DB
+	txnRepo     repositories.TransactionRepository
+	walletRepo  repositories.WalletRepository
+	profileRepo repositories.ProfileRepository
+	appLogger   *zap.Logger
+	db          *gorm.DB
+	outbox      events.Outbox
 }
 
 func NewTransactionService(
 	txnRepo repositories.TransactionRepository,
 	logger *zap.Logger,
 	walletRepo repositories.WalletRepository,
+	profileRepo repositories.ProfileRepository,
 	db *gorm.DB,
+	outbox events.Outbox,
 ) *transactionService {
 	return &transactionService{
-		txnRepo:    txnRepo,
-		walletRepo: walletRepo,
-		appLogger:  logger,
-		db:         db,
+		txnRepo:     txnRepo,
+		walletRepo:  walletRepo,
+		profileRepo: profileRepo,
+		appLogger:   logger,
+		db:          db,
+		outbox:      outbox,
 	}
 }
 
@@ -57,28 +80,57 @@ func (s *transactionService) TransferFunds(
 	amount decimal.Decimal,
 	currency, description string,
 ) (*models.Transaction, error) {
+	return s.TransferFundsWith(userID, senderWalletID, recieverWalletID, amount, currency, description, nil)
+}
+
+func (s *transactionService) TransferFundsWith(
+	userID, senderWalletID, recieverWalletID uuid.UUID,
+	amount decimal.Decimal,
+	currency, description string,
+	fn func(tx *gorm.DB, txn *models.Transaction) error,
+) (*models.Transaction, error) {
+	// Every caller goes through here, so a zero or negative amount can never move money backwards
+	if !amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
 	var txn *models.Transaction
+	var senderUserID, receiverUserID uuid.UUID
 
 	// Start a database transaction
 	err := s.db.Transaction(func(tx *gorm.DB) error {
-		senderWallet, err := s.walletRepo.GetWalletByID(senderWalletID)
-		if err != nil {
-			return errors.New("sender wallet not found")
+		walletRepo, txnRepo := s.walletRepo.WithTx(tx), s.txnRepo.WithTx(tx)
+		// Both wallets are locked, lowest ID first, so card captures, adjustments and other transfers
+		// cannot change them between the funds check and the update, nor deadlock with this one
+		lockOrder := []uuid.UUID{senderWalletID, recieverWalletID}
+		if bytes.Compare(recieverWalletID[:], senderWalletID[:]) < 0 {
+			lockOrder[0], lockOrder[1] = recieverWalletID, senderWalletID
 		}
-		receiverWallet, err := s.walletRepo.GetWalletByID(recieverWalletID)
-		if err != nil {
-			return errors.New("receiver wallet not found")
+		locked := make(map[uuid.UUID]*models.Wallet, 2)
+		for _, walletID := range lockOrder {
+			wallet, err := walletRepo.GetWalletByIDForUpdate(walletID)
+			if err == gorm.ErrRecordNotFound {
+				if walletID == senderWalletID {
+					return ErrSenderWalletNotFound
+				}
+				return ErrReceiverWalletNotFound
+			}
+			if err != nil {
+				return err
+			}
+			locked[walletID] = wallet
 		}
+		senderWallet, receiverWallet := locked[senderWalletID], locked[recieverWalletID]
+		senderUserID, receiverUserID = senderWallet.UserID, receiverWallet.UserID
 
 		// Check if the initiator is actually the owner of the wallet o!!!
-		err = s.txnRepo.VerifyOwnership(userID, senderWalletID)
+		err := txnRepo.VerifyOwnership(userID, senderWalletID)
 		if err != nil {
-			return errors.New("user is not owner of wallet")
+			return ErrNotWalletOwner
 		}
-		
-		senderBalance := senderWallet.Balance
-		if senderBalance.LessThan(amount) {
-			return errors.New("insufficient funds")
+
+		// Funds held by pending card authorizations are not available to transfer
+		if senderWallet.Balance.Sub(senderWallet.HeldBalance).LessThan(amount) {
+			return ErrInsufficientFunds
 		}
 
 		// Create new transaction record
@@ -93,28 +145,39 @@ func (s *transactionService) TransferFunds(
 			ReferenceID:      s.generateReferenceID(),
 		}
 
-		newTxn, err := s.txnRepo.CreateTransaction(txn)
+		newTxn, err := txnRepo.CreateTransaction(txn)
 		if err != nil {
 			return errors.New("failed to create transaction")
 		}
 		txn = newTxn
 
-		newSenderBalance := senderBalance.Sub(amount).String()
-		if err := s.walletRepo.UpdateWalletBalance(senderWalletID, newSenderBalance); err != nil {
+		// Relative updates, so the balances move by exactly the amount whatever else changed them
+		if err := walletRepo.AdjustBalances(senderWalletID, amount.Neg(), decimal.Zero); err != nil {
 			s.appLogger.Error("Failed to update sender's balance", zap.String("because", err.Error()))
 			return errors.New("failed to update sender's balance")
 		}
-
-		newRecieverBalance := receiverWallet.Balance.Add(amount)
-		if err := s.walletRepo.UpdateWalletBalance(recieverWalletID, newRecieverBalance.String()); err != nil {
+		if err := walletRepo.AdjustBalances(recieverWalletID, amount, decimal.Zero); err != nil {
 			s.appLogger.Error("Failed to update reciever's balance", zap.String("because", err.Error()))
 			return errors.New("failed to update reciever's balance")
 		}
 
-		if err := s.txnRepo.UpdateTransactionStatus(newTxn.ID, "completed"); err != nil {
+		if err := txnRepo.UpdateTransactionStatus(newTxn.ID, "completed"); err != nil {
 			s.appLogger.Error("Failed to update transaction status", zap.String("because", err.Error()))
 			return errors.New("failed to update transaction status")
 		}
+		if fn != nil {
+			if err := fn(tx, txn); err != nil {
+				return err
+			}
+		}
+
+		// Recorded with the balances so the events are published if and only if the transfer commits
+		for _, event := range s.transferEvents(txn, senderUserID, receiverUserID, amount, currency, description) {
+			if err := s.outbox.Record(tx, event); err != nil {
+				s.appLogger.Error("Failed to record transfer event", zap.String("because", err.Error()))
+				return errors.New("failed to record transfer event")
+			}
+		}
 
 		return nil
 	})
@@ -128,9 +191,72 @@ func (s *transactionService) TransferFunds(
 		zap.String("sender", senderWalletID.String()),
 		zap.String("receiver", recieverWalletID.String()),
 		zap.String("amount", amount.String()))
+	s.outbox.Flush()
 	return txn, nil
 }
 
+// Tells both sides about a completed transfer. The sender only sees the recipient's masked name.
+// Moves between a user's own wallets only report the balance change and the completed transaction.
+func (s *transactionService) transferEvents(
+	txn *models.Transaction,
+	senderUserID, receiverUserID uuid.UUID,
+	amount decimal.Decimal,
+	currency, description string,
+) []events.Event {
+	completed := func(userID uuid.UUID, direction string) events.Event {
+		return events.Event{
+			Type:   events.TransactionCompleted,
+			UserID: userID,
+			Data: map[string]string{
+				"transaction_id":     txn.ID.String(),
+				"transaction_status": models.TransactionStatusCompleted,
+				"direction":          direction,
+				"amount":             formatMoney(amount, currency),
+				"description":        description,
+			},
+		}
+	}
+	if senderUserID == receiverUserID {
+		var result []events.Event
+		for _, walletID := range []*uuid.UUID{txn.SenderWalletID, txn.ReceiverWalletID} {
+			result = append(result, events.Event{
+				Type:   events.WalletBalanceChanged,
+				UserID: senderUserID,
+				Data: map[string]string{
+					"wallet_id":          walletID.String(),
+					"transaction_id":     txn.ID.String(),
+					"transaction_status": models.TransactionStatusCompleted,
+				},
+			})
+		}
+		return append(result, completed(senderUserID, "internal"))
+	}
+	transferData := func(walletID *uuid.UUID, counterparty string) map[string]string {
+		return map[string]string{
+			"transaction_id":     txn.ID.String(),
+			"transaction_status": models.TransactionStatusCompleted,
+			"wallet_id":          walletID.String(),
+			"amount":             formatMoney(amount, currency),
+			"description":        description,
+			"counterparty":       counterparty,
+		}
+	}
+	return []events.Event{
+		{
+			Type:   events.TransferSent,
+			UserID: senderUserID,
+			Data:   transferData(txn.SenderWalletID, maskedProfileName(s.profileRepo, receiverUserID)),
+		},
+		{
+			Type:   events.TransferReceived,
+			UserID: receiverUserID,
+			Data:   transferData(txn.ReceiverWalletID, profileName(s.profileRepo, senderUserID)),
+		},
+		completed(senderUserID, "debit"),
+		completed(receiverUserID, "credit"),
+	}
+}
+
 func (s *transactionService) GetTransactionHistory(
 	userID uuid.UUID,
 	limit, offset int,
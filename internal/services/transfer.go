@@ -0,0 +1,254 @@
+package services
+
+import (
+	"errors"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"pgpockets/internal/utils"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAmbiguousRecipient           = errors.New("provide exactly one of beneficiary_id, email or phone_number")
+	ErrExternalTransferUnsupported  = errors.New("transfers to external bank accounts are not supported yet")
+	ErrRecipientCurrencyUnavailable = errors.New("recipient has no active wallet in this currency")
+	ErrSelfTransfer                 = errors.New("cannot transfer to the same wallet")
+)
+
+// TransferTarget identifies who to pay. Exactly one field must be set.
+type TransferTarget struct {
+	BeneficiaryID *uuid.UUID
+	Email         string
+	PhoneNumber   string
+}
+
+// TransferPreview is shown to the sender to confirm who they are paying before sending
+type TransferPreview struct {
+	SenderWalletID    uuid.UUID       `json:"sender_wallet_id"`
+	RecipientWalletID uuid.UUID       `json:"recipient_wallet_id"`
+	RecipientName     string          `json:"recipient_name"` // Masked
+	BeneficiaryID     *uuid.UUID      `json:"beneficiary_id,omitempty"`
+	Amount            decimal.Decimal `json:"amount"`
+	Currency          string          `json:"currency"`
+	AvailableBalance  decimal.Decimal `json:"available_balance"`
+}
+
+type TransferRequest struct {
+	SenderWalletID  uuid.UUID
+	Target          TransferTarget
+	Amount          decimal.Decimal
+	Description     string
+	SaveBeneficiary bool
+	Nickname        string
+}
+
+type TransferResult struct {
+	Transaction *models.Transaction `json:"transaction"`
+	Beneficiary *models.Beneficiary `json:"beneficiary,omitempty"`
+}
+
+// TransferService sends money to people by beneficiary, email or phone rather than raw wallet IDs
+type TransferService interface {
+	Preview(userID, senderWalletID uuid.UUID, target TransferTarget, amount decimal.Decimal) (*TransferPreview, error)
+	Transfer(userID uuid.UUID, req TransferRequest) (*TransferResult, error)
+}
+
+type transferService struct {
+	txnService         TransactionService
+	beneficiaryService BeneficiaryService
+	walletRepo         repositories.WalletRepository
+	userRepo           repositories.UserRepository
+	profileRepo        repositories.ProfileRepository
+	logger             *zap.Logger
+}
+
+func NewTransferService(
+	txnService TransactionService,
+	beneficiaryService BeneficiaryService,
+	walletRepo repositories.WalletRepository,
+	userRepo repositories.UserRepository,
+	profileRepo repositories.ProfileRepository,
+	logger *zap.Logger,
+) *transferService {
+	return &transferService{
+		txnService:         txnService,
+		beneficiaryService: beneficiaryService,
+		walletRepo:         walletRepo,
+		userRepo:           userRepo,
+		profileRepo:        profileRepo,
+		logger:             logger,
+	}
+}
+
+func (s *transferService) Preview(userID, senderWalletID uuid.UUID, target TransferTarget, amount decimal.Decimal) (*TransferPreview, error) {
+	if !amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+	senderWallet, err := s.walletRepo.GetWalletByID(senderWalletID)
+	if err != nil || senderWallet.UserID != userID {
+		return nil, ErrWalletNotOwned
+	}
+
+	recipientUserID, err := s.resolveRecipient(userID, target)
+	if err != nil {
+		return nil, err
+	}
+	// Pay into the recipient's wallet in the sender's currency so no conversion happens
+	recipientWallet, err := s.walletRepo.GetPrimaryWallet(recipientUserID, senderWallet.Currency)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrRecipientCurrencyUnavailable
+		}
+		s.logger.Error("Failed to look up recipient wallet", zap.Error(err))
+		return nil, err
+	}
+	if recipientWallet.ID == senderWallet.ID {
+		return nil, ErrSelfTransfer
+	}
+
+	return &TransferPreview{
+		SenderWalletID:    senderWallet.ID,
+		RecipientWalletID: recipientWallet.ID,
+		RecipientName:     s.maskedName(recipientUserID),
+		BeneficiaryID:     target.BeneficiaryID,
+		Amount:            amount,
+		Currency:          senderWallet.Currency,
+		AvailableBalance:  senderWallet.Balance.Sub(senderWallet.HeldBalance),
+	}, nil
+}
+
+// Resolves the recipient, sends the money and, if asked, saves the recipient as a beneficiary
+func (s *transferService) Transfer(userID uuid.UUID, req TransferRequest) (*TransferResult, error) {
+	preview, err := s.Preview(userID, req.SenderWalletID, req.Target, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	txn, err := s.txnService.TransferFunds(
+		userID,
+		preview.SenderWalletID,
+		preview.RecipientWalletID,
+		preview.Amount,
+		preview.Currency,
+		req.Description,
+	)
+	if err != nil {
+		return nil, err
+	}
+	result := &TransferResult{Transaction: txn}
+
+	if req.SaveBeneficiary && req.Target.BeneficiaryID == nil {
+		// The money has moved; failing to save the payee must not look like a failed transfer
+		beneficiary, err := s.saveBeneficiary(userID, req)
+		if err != nil && err != ErrDuplicateBeneficiary {
+			s.logger.Warn("Transfer succeeded but saving beneficiary failed", zap.Error(err))
+		}
+		result.Beneficiary = beneficiary
+	}
+	return result, nil
+}
+
+func (s *transferService) resolveRecipient(userID uuid.UUID, target TransferTarget) (uuid.UUID, error) {
+	set := 0
+	if target.BeneficiaryID != nil {
+		set++
+	}
+	if target.Email != "" {
+		set++
+	}
+	if target.PhoneNumber != "" {
+		set++
+	}
+	if set != 1 {
+		return uuid.Nil, ErrAmbiguousRecipient
+	}
+
+	switch {
+	case target.BeneficiaryID != nil:
+		beneficiary, err := s.beneficiaryService.GetBeneficiary(userID, *target.BeneficiaryID)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if beneficiary.Kind == models.BeneficiaryKindBankAccount {
+			return uuid.Nil, ErrExternalTransferUnsupported
+		}
+		if beneficiary.RecipientUserID != nil {
+			return *beneficiary.RecipientUserID, nil
+		}
+		// Beneficiaries saved before recipients were tracked only have a wallet
+		if beneficiary.WalletID == nil {
+			return uuid.Nil, ErrRecipientNotFound
+		}
+		wallet, err := s.walletRepo.GetWalletByID(*beneficiary.WalletID)
+		if err != nil {
+			return uuid.Nil, ErrRecipientNotFound
+		}
+		return wallet.UserID, nil
+	default:
+		return findUserByContact(s.userRepo, s.profileRepo, target.Email, target.PhoneNumber)
+	}
+}
+
+// Looks a user up by email, or by phone number when no email is given
+func findUserByContact(
+	userRepo repositories.UserRepository,
+	profileRepo repositories.ProfileRepository,
+	email, phoneNumber string,
+) (uuid.UUID, error) {
+	if email != "" {
+		user, err := userRepo.GetUserByEmail(strings.ToLower(strings.TrimSpace(email)))
+		if err != nil {
+			return uuid.Nil, ErrRecipientNotFound
+		}
+		return user.ID, nil
+	}
+	profile, err := profileRepo.GetProfileByPhoneNumber(utils.NormalizePhoneNumber(phoneNumber))
+	if err != nil {
+		return uuid.Nil, ErrRecipientNotFound
+	}
+	return profile.UserID, nil
+}
+
+func (s *transferService) saveBeneficiary(userID uuid.UUID, req TransferRequest) (*models.Beneficiary, error) {
+	newBeneficiary := NewBeneficiary{
+		Kind:     models.BeneficiaryKindWallet,
+		Email:    req.Target.Email,
+		Nickname: req.Nickname,
+	}
+	if req.Target.PhoneNumber != "" {
+		newBeneficiary.Kind = models.BeneficiaryKindPhone
+		newBeneficiary.PhoneNumber = req.Target.PhoneNumber
+	}
+	return s.beneficiaryService.Create(userID, newBeneficiary)
+}
+
+func (s *transferService) maskedName(userID uuid.UUID) string {
+	profile, err := s.profileRepo.GetProfileByUserID(userID.String())
+	if err != nil {
+		return ""
+	}
+	return utils.MaskName(profile.FirstName + " " + profile.LastName)
+}
+
+// The user's name as shown to people they deal with, or "Someone" if their profile has none
+func profileName(profileRepo repositories.ProfileRepository, userID uuid.UUID) string {
+	profile, err := profileRepo.GetProfileByUserID(userID.String())
+	if err != nil || strings.TrimSpace(profile.FirstName+profile.LastName) == "" {
+		return "Someone"
+	}
+	return strings.TrimSpace(profile.FirstName + " " + profile.LastName)
+}
+
+// Like profileName, but masked for people who may only know the user by email or phone
+func maskedProfileName(profileRepo repositories.ProfileRepository, userID uuid.UUID) string {
+	profile, err := profileRepo.GetProfileByUserID(userID.String())
+	if err != nil || strings.TrimSpace(profile.FirstName+profile.LastName) == "" {
+		return "the recipient"
+	}
+	return utils.MaskName(profile.FirstName + " " + profile.LastName)
+}
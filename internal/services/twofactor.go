@@ -0,0 +1,309 @@
+package services
+
+import (
+	"errors"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"pgpockets/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two-factor enrollment has not been started")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrStepUpRequired          = errors.New("two-factor verification is required for this operation")
+)
+
+const (
+	twoFactorIssuer    = "PgPockets"
+	recoveryCodeCount  = 10
+	stepUpValidity     = 5 * time.Minute
+	recoveryCodeLength = 11 // XXXXX-XXXXX
+
+	// Wrong codes on step-up, disable and recovery code regeneration, per user
+	twoFactorDelayAfter   = 3
+	twoFactorLockAfter    = 10
+	twoFactorLockDuration = 30 * time.Minute
+)
+
+type TwoFactorService interface {
+	BeginEnrollment(userID uuid.UUID) (string, string, error)
+	ConfirmEnrollment(userID uuid.UUID, code string) ([]string, error)
+	Disable(userID uuid.UUID, code string) error
+	RegenerateRecoveryCodes(userID uuid.UUID, code string) ([]string, error)
+	GetStatus(userID uuid.UUID) (bool, int64, error)
+	IsEnabled(userID uuid.UUID) (bool, error)
+	VerifyCode(userID uuid.UUID, code string) error
+	StepUp(userID, sessionID uuid.UUID, code string) error
+	RequireStepUp(userID, sessionID uuid.UUID) error
+}
+
+type twoFactorService struct {
+	twoFactorRepo repositories.TwoFactorRepository
+	userRepo      repositories.UserRepository
+	throttleRepo  repositories.LoginThrottleRepository
+	logger        *zap.Logger
+}
+
+func NewTwoFactorService(
+	twoFactorRepo repositories.TwoFactorRepository,
+	userRepo repositories.UserRepository,
+	throttleRepo repositories.LoginThrottleRepository,
+	logger *zap.Logger,
+) *twoFactorService {
+	return &twoFactorService{
+		twoFactorRepo: twoFactorRepo,
+		userRepo:      userRepo,
+		throttleRepo:  throttleRepo,
+		logger:        logger,
+	}
+}
+
+// Generates a fresh secret and returns it with the otpauth URI used as the QR payload.
+// Nothing is enforced until ConfirmEnrollment succeeds.
+func (s *twoFactorService) BeginEnrollment(userID uuid.UUID) (string, string, error) {
+	existing, err := s.twoFactorRepo.GetByUserID(userID)
+	if err == nil && existing.IsEnabled {
+		return "", "", ErrTwoFactorAlreadyEnabled
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		s.logger.Error("Failed to look up 2FA enrollment", zap.Error(err))
+		return "", "", err
+	}
+
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		s.logger.Error("Failed to look up user for 2FA enrollment", zap.Error(err))
+		return "", "", err
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		s.logger.Error("Failed to generate TOTP secret", zap.Error(err))
+		return "", "", err
+	}
+
+	if err := s.twoFactorRepo.Upsert(&models.TwoFactorAuth{
+		UserID: userID,
+		Secret: secret,
+	}); err != nil {
+		s.logger.Error("Failed to save 2FA enrollment", zap.Error(err))
+		return "", "", err
+	}
+
+	s.logger.Info("2FA enrollment started", zap.String("user_id", userID.String()))
+	return secret, utils.BuildTOTPURI(twoFactorIssuer, user.Email, secret), nil
+}
+
+// Enables 2FA once the user proves their authenticator works and hands back the recovery codes.
+// The plain codes are only ever returned here.
+func (s *twoFactorService) ConfirmEnrollment(userID uuid.UUID, code string) ([]string, error) {
+	twoFactor, err := s.twoFactorRepo.GetByUserID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if err != nil {
+		s.logger.Error("Failed to look up 2FA enrollment", zap.Error(err))
+		return nil, err
+	}
+	if twoFactor.IsEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	step, ok := utils.ValidateTOTPCode(twoFactor.Secret, code, time.Now())
+	if !ok {
+		s.logger.Warn("Invalid 2FA confirmation code", zap.String("user_id", userID.String()))
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if err := s.twoFactorRepo.Enable(userID, step); err != nil {
+		s.logger.Error("Failed to enable 2FA", zap.Error(err))
+		return nil, err
+	}
+
+	codes, err := s.issueRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info("2FA enabled", zap.String("user_id", userID.String()))
+	return codes, nil
+}
+
+func (s *twoFactorService) Disable(userID uuid.UUID, code string) error {
+	if err := s.verifyLimited(userID, code); err != nil {
+		return err
+	}
+	if err := s.twoFactorRepo.Disable(userID); err != nil {
+		s.logger.Error("Failed to disable 2FA", zap.Error(err))
+		return err
+	}
+	s.logger.Info("2FA disabled", zap.String("user_id", userID.String()))
+	return nil
+}
+
+func (s *twoFactorService) RegenerateRecoveryCodes(userID uuid.UUID, code string) ([]string, error) {
+	if err := s.verifyLimited(userID, code); err != nil {
+		return nil, err
+	}
+	return s.issueRecoveryCodes(userID)
+}
+
+// Reports whether 2FA is on and how many recovery codes are left
+func (s *twoFactorService) GetStatus(userID uuid.UUID) (bool, int64, error) {
+	enabled, err := s.IsEnabled(userID)
+	if err != nil || !enabled {
+		return false, 0, err
+	}
+	remaining, err := s.twoFactorRepo.CountUnusedRecoveryCodes(userID)
+	if err != nil {
+		s.logger.Error("Failed to count recovery codes", zap.Error(err))
+		return false, 0, err
+	}
+	return true, remaining, nil
+}
+
+func (s *twoFactorService) IsEnabled(userID uuid.UUID) (bool, error) {
+	twoFactor, err := s.twoFactorRepo.GetByUserID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		s.logger.Error("Failed to look up 2FA enrollment", zap.Error(err))
+		return false, err
+	}
+	return twoFactor.IsEnabled, nil
+}
+
+// Accepts either a current TOTP code or an unused recovery code. Each code is only accepted once.
+func (s *twoFactorService) VerifyCode(userID uuid.UUID, code string) error {
+	twoFactor, err := s.twoFactorRepo.GetByUserID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		s.logger.Error("Failed to look up 2FA enrollment", zap.Error(err))
+		return err
+	}
+	if !twoFactor.IsEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == recoveryCodeLength || len(code) == recoveryCodeLength-1 {
+		err := s.twoFactorRepo.UseRecoveryCode(userID, utils.HashRecoveryCode(code))
+		if err == gorm.ErrRecordNotFound {
+			s.logger.Warn("Invalid recovery code", zap.String("user_id", userID.String()))
+			return ErrInvalidTwoFactorCode
+		}
+		if err != nil {
+			s.logger.Error("Failed to use recovery code", zap.Error(err))
+			return err
+		}
+		s.logger.Info("Recovery code used", zap.String("user_id", userID.String()))
+		return nil
+	}
+
+	step, ok := utils.ValidateTOTPCode(twoFactor.Secret, code, time.Now())
+	if !ok {
+		s.logger.Warn("Invalid TOTP code", zap.String("user_id", userID.String()))
+		return ErrInvalidTwoFactorCode
+	}
+	if err := s.twoFactorRepo.MarkStepUsed(userID, step); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			s.logger.Warn("TOTP code replayed", zap.String("user_id", userID.String()))
+			return ErrInvalidTwoFactorCode
+		}
+		s.logger.Error("Failed to record TOTP usage", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+/*
+	VerifyCode for signed-in users, who could otherwise guess codes with a stolen access token.
+	The attempt is counted before the code is checked, under the throttle row's lock, so parallel
+	guesses cannot slip past the limit; a correct code clears the count again. Returns
+	*LoginThrottledError while the user has to wait.
+*/
+func (s *twoFactorService) verifyLimited(userID uuid.UUID, code string) error {
+	now := time.Now()
+	var wait time.Duration
+	if _, err := s.throttleRepo.RecordFailure(models.LoginThrottleScopeTwoFactor, userID.String(),
+		func(t *models.LoginThrottle) {
+			if wait = throttleWait(t, now); wait == 0 {
+				applyFailure(t, now, twoFactorDelayAfter, twoFactorLockAfter, twoFactorLockDuration)
+			}
+		}); err != nil {
+		s.logger.Error("Failed to record 2FA attempt", zap.Error(err))
+		return err
+	}
+	if wait > 0 {
+		s.logger.Warn("2FA attempt refused while throttled", zap.String("user_id", userID.String()))
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+
+	if err := s.VerifyCode(userID, code); err != nil {
+		return err
+	}
+	if err := s.throttleRepo.Reset(models.LoginThrottleScopeTwoFactor, userID.String()); err != nil {
+		s.logger.Error("Failed to reset 2FA throttle", zap.Error(err))
+	}
+	return nil
+}
+
+// Marks the current session as freshly verified so sensitive operations are allowed for a short window
+func (s *twoFactorService) StepUp(userID, sessionID uuid.UUID, code string) error {
+	if err := s.verifyLimited(userID, code); err != nil {
+		return err
+	}
+	if err := s.twoFactorRepo.MarkSessionSteppedUp(sessionID); err != nil {
+		s.logger.Error("Failed to record step-up", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Returns ErrStepUpRequired if the user has 2FA on and this session has not re-verified recently
+func (s *twoFactorService) RequireStepUp(userID, sessionID uuid.UUID) error {
+	enabled, err := s.IsEnabled(userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	stepUpAt, err := s.twoFactorRepo.GetSessionStepUpAt(sessionID)
+	if err != nil {
+		s.logger.Error("Failed to look up session step-up", zap.Error(err))
+		return err
+	}
+	if stepUpAt == nil || time.Since(*stepUpAt) > stepUpValidity {
+		return ErrStepUpRequired
+	}
+	return nil
+}
+
+func (s *twoFactorService) issueRecoveryCodes(userID uuid.UUID) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		s.logger.Error("Failed to generate recovery codes", zap.Error(err))
+		return nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, utils.HashRecoveryCode(code))
+	}
+	if err := s.twoFactorRepo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		s.logger.Error("Failed to store recovery codes", zap.Error(err))
+		return nil, err
+	}
+	return codes, nil
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"pgpockets/internal/mailer"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"pgpockets/internal/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired token")
+	ErrEmailAlreadyVerified     = errors.New("email is already verified")
+	ErrIncorrectPassword        = errors.New("current password is incorrect")
+	ErrPasswordUnchanged        = errors.New("new password must differ from the current password")
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = 30 * time.Minute
+	accountUnlockTTL     = 24 * time.Hour
+)
+
+type VerificationService interface {
+	SendEmailVerification(userID uuid.UUID) error
+	ConfirmEmail(token string) error
+	RequestPasswordReset(email string) error
+	ResetPassword(token, newPassword string) error
+	ChangePassword(userID, sessionID uuid.UUID, currentPassword, newPassword string) error
+	SendAccountUnlock(userID uuid.UUID) error
+	ConsumeAccountUnlock(token string) (uuid.UUID, error)
+}
+
+type verificationService struct {
+	userRepo  repositories.UserRepository
+	tokenRepo repositories.VerificationTokenRepository
+	mailer    mailer.Mailer
+	logger    *zap.Logger
+	jwtSecret string
+	baseURL   string
+}
+
+func NewVerificationService(
+	userRepo repositories.UserRepository,
+	tokenRepo repositories.VerificationTokenRepository,
+	mailer mailer.Mailer,
+	logger *zap.Logger,
+	jwtSecret string,
+	baseURL string,
+) *verificationService {
+	return &verificationService{
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+		mailer:    mailer,
+		logger:    logger,
+		jwtSecret: jwtSecret,
+		baseURL:   baseURL,
+	}
+}
+
+func (s *verificationService) SendEmailVerification(userID uuid.UUID) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		s.logger.Error("Failed to look up user for email verification", zap.Error(err))
+		return err
+	}
+	if user.IsEmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := s.issueToken(user.ID, models.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", s.baseURL, token)
+	return s.send(user.Email, "Verify your PgPockets email address", fmt.Sprintf(
+		"Welcome to PgPockets!\n\nConfirm your email address by opening the link below. It expires in 24 hours.\n\n%s\n\nIf you did not create an account you can ignore this email.",
+		link,
+	))
+}
+
+func (s *verificationService) ConfirmEmail(token string) error {
+	verification, err := s.tokenRepo.Consume(utils.SignOpaqueToken(token, s.jwtSecret), models.TokenPurposeEmailVerification)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidVerificationToken
+		}
+		s.logger.Error("Failed to consume email verification token", zap.Error(err))
+		return err
+	}
+
+	if err := s.userRepo.MarkEmailVerified(verification.UserID); err != nil {
+		s.logger.Error("Failed to mark email verified", zap.Error(err))
+		return err
+	}
+	s.logger.Info("Email verified", zap.String("user_id", verification.UserID.String()))
+	return nil
+}
+
+// Always succeeds for unknown emails so the endpoint cannot be used to discover accounts
+func (s *verificationService) RequestPasswordReset(email string) error {
+	user, err := s.userRepo.GetUserByEmail(email)
+	if err == gorm.ErrRecordNotFound {
+		s.logger.Info("Password reset requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		s.logger.Error("Failed to look up user for password reset", zap.Error(err))
+		return err
+	}
+
+	token, err := s.issueToken(user.ID, models.TokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", s.baseURL, token)
+	return s.send(user.Email, "Reset your PgPockets password", fmt.Sprintf(
+		"We received a request to reset your password. Open the link below to choose a new one. It expires in 30 minutes.\n\n%s\n\nIf you did not ask for this you can ignore this email; your password will not change.",
+		link,
+	))
+}
+
+// Sets a new password from a reset link and signs the user out everywhere
+func (s *verificationService) ResetPassword(token, newPassword string) error {
+	reset, err := s.tokenRepo.Consume(utils.SignOpaqueToken(token, s.jwtSecret), models.TokenPurposePasswordReset)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidVerificationToken
+		}
+		s.logger.Error("Failed to consume password reset token", zap.Error(err))
+		return err
+	}
+
+	if err := s.setPassword(reset.UserID, newPassword); err != nil {
+		return err
+	}
+	if _, err := s.userRepo.RevokeOtherSessions(reset.UserID, uuid.Nil); err != nil {
+		s.logger.Error("Failed to revoke sessions after password reset", zap.Error(err))
+		return err
+	}
+	s.logger.Info("Password reset", zap.String("user_id", reset.UserID.String()))
+	return nil
+}
+
+// Changes the password of a logged-in user and signs out every other session
+func (s *verificationService) ChangePassword(userID, sessionID uuid.UUID, currentPassword, newPassword string) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		s.logger.Error("Failed to look up user for password change", zap.Error(err))
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)); err != nil {
+		s.logger.Warn("Password change failed: incorrect current password", zap.String("user_id", userID.String()))
+		return ErrIncorrectPassword
+	}
+	if currentPassword == newPassword {
+		return ErrPasswordUnchanged
+	}
+
+	if err := s.setPassword(userID, newPassword); err != nil {
+		return err
+	}
+	if _, err := s.userRepo.RevokeOtherSessions(userID, sessionID); err != nil {
+		s.logger.Error("Failed to revoke sessions after password change", zap.Error(err))
+		return err
+	}
+	s.logger.Info("Password changed", zap.String("user_id", userID.String()))
+	return nil
+}
+
+// Tells the user their account was locked and emails a link that lifts the lock early
+func (s *verificationService) SendAccountUnlock(userID uuid.UUID) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		s.logger.Error("Failed to look up user for account unlock", zap.Error(err))
+		return err
+	}
+
+	token, err := s.issueToken(user.ID, models.TokenPurposeAccountUnlock, accountUnlockTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/unlock-account?token=%s", s.baseURL, token)
+	return s.send(user.Email, "Your PgPockets account has been locked", fmt.Sprintf(
+		"We locked your account after several failed sign-in attempts. It will unlock automatically in 30 minutes.\n\nIf this was you, you can unlock it now:\n\n%s\n\nIf it wasn't you, someone may be trying to guess your password. Consider resetting it and enabling two-factor authentication.",
+		link,
+	))
+}
+
+// Redeems an unlock link and returns the user it was issued for
+func (s *verificationService) ConsumeAccountUnlock(token string) (uuid.UUID, error) {
+	unlock, err := s.tokenRepo.Consume(utils.SignOpaqueToken(token, s.jwtSecret), models.TokenPurposeAccountUnlock)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return uuid.Nil, ErrInvalidVerificationToken
+		}
+		s.logger.Error("Failed to consume account unlock token", zap.Error(err))
+		return uuid.Nil, err
+	}
+	return unlock.UserID, nil
+}
+
+func (s *verificationService) setPassword(userID uuid.UUID, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		s.logger.Error("Failed to hash password", zap.Error(err))
+		return ErrPasswordHashFailed
+	}
+	if err := s.userRepo.UpdatePassword(userID, string(hashedPassword)); err != nil {
+		s.logger.Error("Failed to update password", zap.Error(err))
+		return err
+	}
+	// Outstanding reset links must not work once the password has changed
+	if err := s.tokenRepo.InvalidateForUser(userID, models.TokenPurposePasswordReset); err != nil {
+		s.logger.Error("Failed to invalidate password reset tokens", zap.Error(err))
+	}
+	return nil
+}
+
+// Creates a token, stores its signature and returns the plain value for the email link
+func (s *verificationService) issueToken(userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	if err := s.tokenRepo.InvalidateForUser(userID, purpose); err != nil {
+		s.logger.Error("Failed to invalidate previous tokens", zap.Error(err))
+		return "", err
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		s.logger.Error("Failed to generate token", zap.Error(err))
+		return "", ErrTokenGenerationFailed
+	}
+	if err := s.tokenRepo.Create(&models.VerificationToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: utils.SignOpaqueToken(token, s.jwtSecret),
+		ExpiresAt: time.Now().Add(ttl),
+	}); err != nil {
+		s.logger.Error("Failed to store token", zap.Error(err))
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *verificationService) send(to, subject, body string) error {
+	if err := s.mailer.Send(mailer.Message{To: to, Subject: subject, Body: body}); err != nil {
+		s.logger.Error("Failed to send email", zap.String("subject", subject), zap.Error(err))
+		return err
+	}
+	return nil
+}
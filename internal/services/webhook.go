@@ -0,0 +1,671 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"pgpockets/internal/events"
+	"pgpockets/internal/models"
+	"pgpockets/internal/repositories"
+	"pgpockets/internal/utils"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrWebhookEndpointNotFound = errors.New("webhook endpoint not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrInvalidWebhookURL       = errors.New("webhook URL must be an absolute https URL")
+	ErrWebhookURLNotPublic     = errors.New("webhook URL must resolve to a public internet address")
+	ErrUnknownWebhookEventType = errors.New("unknown webhook event type")
+	ErrWebhookEndpointDisabled = errors.New("webhook endpoint is disabled")
+)
+
+const (
+	maxWebhookAttempts = 10
+	// How long a claimed delivery is held before another worker may retry it
+	webhookLease        = 2 * time.Minute
+	webhookBatchSize    = 50
+	webhookTimeout      = 10 * time.Second
+	webhookBackoffBase  = 30 * time.Second
+	webhookBackoffLimit = 6 * time.Hour
+	// Responses are kept in the attempt log up to this many bytes
+	webhookResponseLimit = 1024
+	// An endpoint is disabled once it has failed this many times in a row over at least this long
+	webhookDisableAfterFailures = 20
+	webhookDisableAfter         = 72 * time.Hour
+	webhookResolveTimeout       = 5 * time.Second
+)
+
+// Request headers sent with every delivery
+const (
+	WebhookSignatureHeader = "X-PgPockets-Signature"
+	WebhookEventHeader     = "X-PgPockets-Event"
+	WebhookDeliveryHeader  = "X-PgPockets-Delivery"
+)
+
+// Event types an endpoint can subscribe to
+var WebhookEventTypes = []string{
+	events.TransactionCompleted,
+	events.TransferSent,
+	events.TransferReceived,
+	events.WalletBalanceChanged,
+	events.CardIssued,
+	events.CardFrozen,
+	events.CardUnfrozen,
+	events.CardTerminated,
+	events.CardReissued,
+	events.CardAuthorized,
+	events.CardAuthorizationDeclined,
+	events.PaymentRequestReceived,
+	events.PaymentRequestPaid,
+	events.PaymentRequestDeclined,
+	events.PaymentRequestCancelled,
+	events.PaymentRequestExpired,
+	events.BillSplitSettled,
+	events.InvoiceReceived,
+	events.InvoicePaid,
+	events.InvoiceStatusChanged,
+	events.KYCApproved,
+	events.KYCRejected,
+}
+
+// WebhookPayload is the JSON body POSTed to an endpoint. ID is the event ID, so receivers can
+// drop repeats: a delivery is retried until it succeeds and can be redelivered by hand.
+type WebhookPayload struct {
+	ID         uuid.UUID         `json:"id"`
+	Type       string            `json:"type"`
+	OccurredAt time.Time         `json:"occurred_at"`
+	Data       map[string]string `json:"data"`
+}
+
+type NewWebhookEndpoint struct {
+	URL         string
+	Description string
+	EventTypes  []string
+}
+
+type WebhookEndpointUpdate struct {
+	URL         *string
+	Description *string
+	EventTypes  []string // Left unchanged when nil
+	Enabled     *bool    // Re-enabling clears the failure count
+}
+
+// WebhookEndpointWithSecret is returned when a signing secret is created, the only time it is shown
+type WebhookEndpointWithSecret struct {
+	models.WebhookEndpoint
+	Secret string `json:"secret"`
+}
+
+// WebhookService lets users receive account events at their own URLs
+type WebhookService interface {
+	CreateEndpoint(userID uuid.UUID, req NewWebhookEndpoint) (*WebhookEndpointWithSecret, error)
+	ListEndpoints(userID uuid.UUID) ([]models.WebhookEndpoint, error)
+	GetEndpoint(userID, endpointID uuid.UUID) (*models.WebhookEndpoint, error)
+	UpdateEndpoint(userID, endpointID uuid.UUID, update WebhookEndpointUpdate) (*models.WebhookEndpoint, error)
+	RotateSecret(userID, endpointID uuid.UUID) (*WebhookEndpointWithSecret, error)
+	DeleteEndpoint(userID, endpointID uuid.UUID) error
+	ListDeliveries(userID, endpointID uuid.UUID, status string, limit, offset int) ([]models.WebhookDelivery, int64, error)
+	GetDelivery(userID, endpointID, deliveryID uuid.UUID) (*models.WebhookDelivery, error)
+	Redeliver(userID, endpointID, deliveryID uuid.UUID) (*models.WebhookDelivery, error)
+	Register(bus events.Bus)
+	Handle(event events.Event) error
+	ProcessDue() (int, error)
+	// Drain waits for first delivery attempts still in flight, or until ctx ends
+	Drain(ctx context.Context) error
+}
+
+type webhookService struct {
+	repo       repositories.WebhookRepository
+	httpClient *http.Client
+	logger     *zap.Logger
+	secret     string // Encrypts endpoint signing secrets at rest
+	// Key secrets stored before WEBHOOK_SECRET_KEY existed were encrypted with; each is
+	// re-encrypted with secret the first time it is read
+	legacySecret string
+	// Local development only: lets endpoints use plain http and private or loopback addresses
+	allowPrivate bool
+	attempts     inflight
+}
+
+/*
+	Endpoint URLs are user supplied, so deliveries must not reach our own network: hosts are
+	checked when an endpoint is saved and again on every connection (DNS can change in between),
+	redirects are not followed and no proxy is used.
+*/
+func NewWebhookService(repo repositories.WebhookRepository, logger *zap.Logger, secret, legacySecret string, allowPrivate bool) WebhookService {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return ErrWebhookURLNotPublic
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &webhookService{
+		repo: repo,
+		httpClient: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger:       logger,
+		secret:       secret,
+		legacySecret: legacySecret,
+		allowPrivate: allowPrivate,
+	}
+}
+
+func (s *webhookService) CreateEndpoint(userID uuid.UUID, req NewWebhookEndpoint) (*WebhookEndpointWithSecret, error) {
+	if err := s.validateURL(req.URL); err != nil {
+		return nil, err
+	}
+	if err := validateWebhookEventTypes(req.EventTypes); err != nil {
+		return nil, err
+	}
+	secret, encrypted, err := s.newSigningSecret()
+	if err != nil {
+		s.logger.Error("Failed to generate webhook secret", zap.Error(err))
+		return nil, err
+	}
+	endpoint := &models.WebhookEndpoint{
+		UserID:          userID,
+		URL:             req.URL,
+		Description:     req.Description,
+		EventTypes:      slices.Compact(slices.Sorted(slices.Values(req.EventTypes))),
+		EncryptedSecret: encrypted,
+		Status:          models.WebhookEndpointStatusActive,
+	}
+	if err := s.repo.CreateEndpoint(endpoint); err != nil {
+		s.logger.Error("Failed to create webhook endpoint", zap.Error(err))
+		return nil, err
+	}
+	return &WebhookEndpointWithSecret{WebhookEndpoint: *endpoint, Secret: secret}, nil
+}
+
+func (s *webhookService) ListEndpoints(userID uuid.UUID) ([]models.WebhookEndpoint, error) {
+	endpoints, err := s.repo.ListEndpoints(userID)
+	if err != nil {
+		s.logger.Error("Failed to list webhook endpoints", zap.Error(err))
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+func (s *webhookService) GetEndpoint(userID, endpointID uuid.UUID) (*models.WebhookEndpoint, error) {
+	endpoint, err := s.repo.GetEndpoint(endpointID, userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrWebhookEndpointNotFound
+		}
+		s.logger.Error("Failed to get webhook endpoint", zap.Error(err))
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+func (s *webhookService) UpdateEndpoint(userID, endpointID uuid.UUID, update WebhookEndpointUpdate) (*models.WebhookEndpoint, error) {
+	endpoint, err := s.GetEndpoint(userID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	if update.URL != nil {
+		if err := s.validateURL(*update.URL); err != nil {
+			return nil, err
+		}
+		endpoint.URL = *update.URL
+		columns = append(columns, "url")
+	}
+	if update.Description != nil {
+		endpoint.Description = *update.Description
+		columns = append(columns, "description")
+	}
+	if update.EventTypes != nil {
+		if err := validateWebhookEventTypes(update.EventTypes); err != nil {
+			return nil, err
+		}
+		endpoint.EventTypes = slices.Compact(slices.Sorted(slices.Values(update.EventTypes)))
+		columns = append(columns, "event_types")
+	}
+	if update.Enabled != nil {
+		now := time.Now()
+		switch {
+		case *update.Enabled && endpoint.Status != models.WebhookEndpointStatusActive:
+			endpoint.Status = models.WebhookEndpointStatusActive
+			endpoint.DisabledReason = ""
+			endpoint.DisabledAt = nil
+			endpoint.ConsecutiveFailures = 0
+			endpoint.FailingSince = nil
+			columns = append(columns, "status", "disabled_reason", "disabled_at", "consecutive_failures", "failing_since")
+		case !*update.Enabled && endpoint.Status == models.WebhookEndpointStatusActive:
+			endpoint.Status = models.WebhookEndpointStatusDisabled
+			endpoint.DisabledReason = "disabled by owner"
+			endpoint.DisabledAt = &now
+			columns = append(columns, "status", "disabled_reason", "disabled_at")
+		}
+	}
+	if len(columns) == 0 {
+		return endpoint, nil
+	}
+
+	if err := s.repo.UpdateEndpoint(endpoint, columns...); err != nil {
+		s.logger.Error("Failed to update webhook endpoint", zap.Error(err))
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// Replaces the signing secret. Deliveries sent from now on, retries included, use the new one.
+func (s *webhookService) RotateSecret(userID, endpointID uuid.UUID) (*WebhookEndpointWithSecret, error) {
+	endpoint, err := s.GetEndpoint(userID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	secret, encrypted, err := s.newSigningSecret()
+	if err != nil {
+		s.logger.Error("Failed to generate webhook secret", zap.Error(err))
+		return nil, err
+	}
+	endpoint.EncryptedSecret = encrypted
+	if err := s.repo.UpdateEndpoint(endpoint, "encrypted_secret"); err != nil {
+		s.logger.Error("Failed to rotate webhook secret", zap.Error(err))
+		return nil, err
+	}
+	return &WebhookEndpointWithSecret{WebhookEndpoint: *endpoint, Secret: secret}, nil
+}
+
+func (s *webhookService) DeleteEndpoint(userID, endpointID uuid.UUID) error {
+	if err := s.repo.DeleteEndpoint(endpointID, userID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrWebhookEndpointNotFound
+		}
+		s.logger.Error("Failed to delete webhook endpoint", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *webhookService) ListDeliveries(userID, endpointID uuid.UUID, status string, limit, offset int) ([]models.WebhookDelivery, int64, error) {
+	if _, err := s.GetEndpoint(userID, endpointID); err != nil {
+		return nil, 0, err
+	}
+	deliveries, count, err := s.repo.ListDeliveries(endpointID, status, limit, offset)
+	if err != nil {
+		s.logger.Error("Failed to list webhook deliveries", zap.Error(err))
+		return nil, 0, err
+	}
+	return deliveries, count, nil
+}
+
+// Includes every HTTP attempt made for the delivery
+func (s *webhookService) GetDelivery(userID, endpointID, deliveryID uuid.UUID) (*models.WebhookDelivery, error) {
+	if _, err := s.GetEndpoint(userID, endpointID); err != nil {
+		return nil, err
+	}
+	delivery, err := s.repo.GetDelivery(deliveryID, endpointID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		s.logger.Error("Failed to get webhook delivery", zap.Error(err))
+		return nil, err
+	}
+	return delivery, nil
+}
+
+/*
+	Sends a delivery again now, whatever its status, with a fresh set of retries. The payload
+	and event ID are unchanged, so a receiver that already processed it can drop the repeat.
+*/
+func (s *webhookService) Redeliver(userID, endpointID, deliveryID uuid.UUID) (*models.WebhookDelivery, error) {
+	endpoint, err := s.GetEndpoint(userID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Status != models.WebhookEndpointStatusActive {
+		return nil, ErrWebhookEndpointDisabled
+	}
+	delivery, err := s.GetDelivery(userID, endpointID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Status = models.DeliveryStatusPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	delivery.LastError = ""
+	if err := s.repo.UpdateDelivery(delivery, "status", "attempts", "next_attempt_at", "last_error"); err != nil {
+		s.logger.Error("Failed to queue webhook redelivery", zap.Error(err))
+		return nil, err
+	}
+	s.attempt(delivery)
+	return s.GetDelivery(userID, endpointID, deliveryID)
+}
+
+func (s *webhookService) Register(bus events.Bus) {
+	bus.Subscribe("webhooks", s.Handle, WebhookEventTypes...)
+}
+
+// Queues the event for each of the user's endpoints that subscribe to it and sends in the background
+func (s *webhookService) Handle(event events.Event) error {
+	endpoints, err := s.repo.ListSubscribedEndpoints(event.UserID, event.Type)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(WebhookPayload{
+		ID:         event.ID,
+		Type:       event.Type,
+		OccurredAt: event.OccurredAt,
+		Data:       event.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, endpoint := range endpoints {
+		delivery := &models.WebhookDelivery{
+			EndpointID:    endpoint.ID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       string(payload),
+			Status:        models.DeliveryStatusPending,
+			NextAttemptAt: now,
+		}
+		created, err := s.repo.CreateDelivery(delivery)
+		if err != nil {
+			s.logger.Error("Failed to queue webhook delivery", zap.String("endpoint_id", endpoint.ID.String()), zap.Error(err))
+			continue
+		}
+		if created {
+			s.attempts.Go(func() { s.attempt(delivery) })
+		}
+	}
+	return nil
+}
+
+// Sends deliveries that are due: retries and any whose first attempt was lost
+func (s *webhookService) ProcessDue() (int, error) {
+	now := time.Now()
+	deliveries, err := s.repo.ClaimDueDeliveries(now, now.Add(webhookLease), webhookBatchSize)
+	if err != nil {
+		s.logger.Error("Failed to claim due webhook deliveries", zap.Error(err))
+		return 0, err
+	}
+	for i := range deliveries {
+		s.send(&deliveries[i])
+	}
+	return len(deliveries), nil
+}
+
+func (s *webhookService) Drain(ctx context.Context) error {
+	return s.attempts.Wait(ctx)
+}
+
+// First attempt of a queued delivery. Claiming it keeps the worker from sending it at the same time.
+func (s *webhookService) attempt(delivery *models.WebhookDelivery) {
+	now := time.Now()
+	claimed, err := s.repo.ClaimDelivery(delivery.ID, now, now.Add(webhookLease))
+	if err != nil {
+		s.logger.Error("Failed to claim webhook delivery", zap.Error(err))
+		return
+	}
+	if !claimed {
+		return
+	}
+	delivery.Attempts++
+	s.send(delivery)
+}
+
+// Sends a claimed delivery, logs the attempt and schedules a retry or gives up
+func (s *webhookService) send(delivery *models.WebhookDelivery) {
+	endpoint, err := s.repo.GetEndpointByID(delivery.EndpointID)
+	if err != nil {
+		s.logger.Error("Failed to load webhook endpoint", zap.String("delivery_id", delivery.ID.String()), zap.Error(err))
+		return
+	}
+	if endpoint.Status != models.WebhookEndpointStatusActive {
+		delivery.Status = models.DeliveryStatusFailed
+		delivery.LastError = "endpoint is disabled"
+		if err := s.repo.UpdateDelivery(delivery, "status", "last_error"); err != nil {
+			s.logger.Error("Failed to record webhook delivery outcome", zap.Error(err))
+		}
+		return
+	}
+
+	attempt := s.post(endpoint, delivery)
+	attempt.DeliveryID = delivery.ID
+	if err := s.repo.CreateAttempt(attempt); err != nil {
+		s.logger.Error("Failed to record webhook attempt", zap.Error(err))
+	}
+
+	now := time.Now()
+	succeeded := attempt.Error == "" && attempt.ResponseStatus >= 200 && attempt.ResponseStatus < 300
+	switch {
+	case succeeded:
+		delivery.Status = models.DeliveryStatusSent
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	case delivery.Attempts >= maxWebhookAttempts:
+		delivery.Status = models.DeliveryStatusFailed
+		delivery.LastError = attemptError(attempt)
+	default:
+		delivery.NextAttemptAt = now.Add(webhookBackoff(delivery.Attempts))
+		delivery.LastError = attemptError(attempt)
+	}
+	if err := s.repo.UpdateDelivery(delivery, "status", "delivered_at", "last_error", "next_attempt_at"); err != nil {
+		s.logger.Error("Failed to record webhook delivery outcome", zap.Error(err))
+	}
+
+	if succeeded {
+		if err := s.repo.RecordEndpointSuccess(endpoint.ID, now); err != nil {
+			s.logger.Error("Failed to record webhook endpoint success", zap.Error(err))
+		}
+		return
+	}
+	s.logger.Warn("Webhook delivery failed",
+		zap.String("delivery_id", delivery.ID.String()),
+		zap.String("endpoint_id", endpoint.ID.String()),
+		zap.Int("attempts", delivery.Attempts),
+		zap.String("error", delivery.LastError))
+	if err := s.repo.RecordEndpointFailure(endpoint.ID, now); err != nil {
+		s.logger.Error("Failed to record webhook endpoint failure", zap.Error(err))
+		return
+	}
+	disabled, err := s.repo.DisableFailingEndpoint(endpoint.ID, webhookDisableAfterFailures, now.Add(-webhookDisableAfter),
+		fmt.Sprintf("failed %d times in a row over %s", webhookDisableAfterFailures, webhookDisableAfter))
+	if err != nil {
+		s.logger.Error("Failed to disable failing webhook endpoint", zap.Error(err))
+	} else if disabled {
+		s.logger.Warn("Webhook endpoint disabled after persistent failures", zap.String("endpoint_id", endpoint.ID.String()))
+	}
+}
+
+// Makes one signed POST and reports what happened; transport errors are recorded rather than returned
+func (s *webhookService) post(endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) *models.WebhookDeliveryAttempt {
+	attempt := &models.WebhookDeliveryAttempt{}
+	secret, err := s.signingSecret(endpoint)
+	if err != nil {
+		attempt.Error = "could not load signing secret"
+		s.logger.Error("Failed to decrypt webhook secret", zap.String("endpoint_id", endpoint.ID.String()), zap.Error(err))
+		return attempt
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PgPockets-Webhooks/1.0")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID.String())
+	req.Header.Set(WebhookSignatureHeader, "t="+timestamp+",v1="+SignWebhookPayload(secret, timestamp, body))
+
+	started := time.Now()
+	resp, err := s.httpClient.Do(req)
+	attempt.DurationMs = time.Since(started).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	attempt.ResponseStatus = resp.StatusCode
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	attempt.ResponseBody = string(responseBody)
+	return attempt
+}
+
+/*
+	Hex HMAC-SHA256 of "<timestamp>.<body>" with the endpoint's secret. Receivers recompute it
+	from the t= value and raw body in the signature header and should reject stale timestamps.
+*/
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Decrypts the endpoint's signing secret, moving secrets still under the legacy key over to the current one
+func (s *webhookService) signingSecret(endpoint *models.WebhookEndpoint) (string, error) {
+	secret, err := utils.DecryptString(endpoint.EncryptedSecret, s.secret)
+	if err == nil || s.legacySecret == "" || s.legacySecret == s.secret {
+		return secret, err
+	}
+	secret, legacyErr := utils.DecryptString(endpoint.EncryptedSecret, s.legacySecret)
+	if legacyErr != nil {
+		return "", err
+	}
+	encrypted, err := utils.EncryptString(secret, s.secret)
+	if err != nil {
+		s.logger.Error("Failed to re-encrypt webhook secret", zap.String("endpoint_id", endpoint.ID.String()), zap.Error(err))
+		return secret, nil
+	}
+	endpoint.EncryptedSecret = encrypted
+	if err := s.repo.UpdateEndpoint(endpoint, "encrypted_secret"); err != nil {
+		s.logger.Error("Failed to re-encrypt webhook secret", zap.String("endpoint_id", endpoint.ID.String()), zap.Error(err))
+	}
+	return secret, nil
+}
+
+// Returns the plain secret to show the user and its encrypted form to store
+func (s *webhookService) newSigningSecret() (string, string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	secret := "whsec_" + token
+	encrypted, err := utils.EncryptString(secret, s.secret)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, encrypted, nil
+}
+
+// Doubles from webhookBackoffBase after each failed attempt, up to webhookBackoffLimit
+func webhookBackoff(attempts int) time.Duration {
+	wait := webhookBackoffBase
+	for i := 1; i < attempts && wait < webhookBackoffLimit; i++ {
+		wait *= 2
+	}
+	return min(wait, webhookBackoffLimit)
+}
+
+func attemptError(attempt *models.WebhookDeliveryAttempt) string {
+	if attempt.Error != "" {
+		return attempt.Error
+	}
+	return fmt.Sprintf("endpoint responded with HTTP %d", attempt.ResponseStatus)
+}
+
+// Requires https to a host that resolves only to public addresses, unless private targets are allowed
+func (s *webhookService) validateURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	if s.allowPrivate {
+		if parsed.Scheme != "https" && parsed.Scheme != "http" {
+			return ErrInvalidWebhookURL
+		}
+		return nil
+	}
+	if parsed.Scheme != "https" {
+		return ErrInvalidWebhookURL
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookResolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ErrWebhookURLNotPublic
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return ErrWebhookURLNotPublic
+		}
+	}
+	return nil
+}
+
+// Carrier-grade NAT space, which IsPrivate does not cover
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if ip[0] == 0 || sharedAddressSpace.Contains(ip) {
+			return false
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+func validateWebhookEventTypes(eventTypes []string) error {
+	if len(eventTypes) == 0 {
+		return ErrUnknownWebhookEventType
+	}
+	for _, eventType := range eventTypes {
+		if !slices.Contains(WebhookEventTypes, eventType) {
+			return ErrUnknownWebhookEventType
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package sms
+
+import (
+	"errors"
+	"pgpockets/internal/config"
+
+	"go.uber.org/zap"
+)
+
+var ErrUnknownDriver = errors.New("unknown sms driver")
+
+// SMSSender delivers text messages to phone numbers. Implementations must be safe for concurrent use.
+type SMSSender interface {
+	Send(to, body string) error
+}
+
+// Builds the sender selected by SMS_DRIVER. Only the local log sender exists for now.
+func NewFromConfig(cfg config.Config, logger *zap.Logger) (SMSSender, error) {
+	switch cfg.SMSDriver {
+	case "log", "":
+		return NewLogSender(logger), nil
+	}
+	return nil, ErrUnknownDriver
+}
@@ -20,4 +20,28 @@ func PartiallyRedactDetails(item string) string {
 	
 	// Mask all but positions 4,5,6
 	return strings.Repeat("*", 4) + item[4:7] + strings.Repeat("*", n-7)
-}
\ No newline at end of file
+}
+
+/*
+	Masks each part of a person's name except its first letter, e.g. "Ada Obi" -> "A** O**".
+	Used when showing a recipient's name to someone who only knows their email or phone.
+*/
+func MaskName(name string) string {
+	parts := strings.Fields(name)
+	for i, part := range parts {
+		runes := []rune(part)
+		parts[i] = string(runes[0]) + strings.Repeat("*", len(runes)-1)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Strips spaces, dashes, dots and brackets from a phone number, keeping a leading +
+func NormalizePhoneNumber(phone string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		if (r >= '0' && r <= '9') || (r == '+' && i == 0) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -1,6 +1,11 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"time"
 	"errors"
 	"github.com/golang-jwt/jwt/v5"
@@ -9,6 +14,7 @@ import (
 
 var (
 	ErrSigningToken = errors.New("Error while signing token")
+	ErrInvalidToken = errors.New("invalid or expired token")
 )
 
 // Generates a JWT token
@@ -20,6 +26,8 @@ func GenerateJWTToken(
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id": userID.String(),
 		"exp":     expiryDate.Unix(),
+		// jti keeps tokens minted in the same second for the same user distinct
+		"jti": uuid.NewString(),
 	})
 	signedToken, err := token.SignedString([]byte(secret))
 	if err != nil {
@@ -29,3 +37,47 @@ func GenerateJWTToken(
 
 	return signedToken, err
 }
+
+// Verifies a JWT token's signature and expiry and returns the user ID it was issued for
+func ParseJWTToken(tokenString, secret string) (uuid.UUID, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return uuid.Nil, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return uuid.Nil, ErrInvalidToken
+	}
+	userIDStr, ok := claims["user_id"].(string)
+	if !ok {
+		return uuid.Nil, ErrInvalidToken
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return uuid.Nil, ErrInvalidToken
+	}
+	return userID, nil
+}
+
+// Generates a random URL-safe token for emailed links
+func GenerateOpaqueToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Signs an opaque token with the app secret; only this digest is stored, so a leaked
+// database row cannot be turned back into a working link without the secret.
+func SignOpaqueToken(token, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
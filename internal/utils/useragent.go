@@ -0,0 +1,66 @@
+package utils
+
+import "strings"
+
+type DeviceInfo struct {
+	Browser    string `json:"browser"`
+	OS         string `json:"os"`
+	DeviceType string `json:"device_type"`
+}
+
+/*
+	Best-effort User-Agent parsing for showing sessions to users.
+	Order matters here since most browsers claim to be several others
+	(e.g. Edge and Chrome both advertise Safari).
+*/
+func ParseUserAgent(userAgent string) DeviceInfo {
+	ua := strings.ToLower(userAgent)
+	info := DeviceInfo{
+		Browser:    "Unknown",
+		OS:         "Unknown",
+		DeviceType: "desktop",
+	}
+
+	switch {
+	case strings.Contains(ua, "edg/"):
+		info.Browser = "Edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		info.Browser = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		info.Browser = "Firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+		info.Browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		info.Browser = "Safari"
+	case strings.Contains(ua, "postman"):
+		info.Browser = "Postman"
+	case strings.Contains(ua, "curl/"):
+		info.Browser = "curl"
+	case strings.Contains(ua, "okhttp") || strings.Contains(ua, "dart"):
+		info.Browser = "Mobile App"
+	}
+
+	switch {
+	case strings.Contains(ua, "android"):
+		info.OS = "Android"
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ios"):
+		info.OS = "iOS"
+	case strings.Contains(ua, "windows"):
+		info.OS = "Windows"
+	case strings.Contains(ua, "mac os") || strings.Contains(ua, "macintosh"):
+		info.OS = "macOS"
+	case strings.Contains(ua, "linux"):
+		info.OS = "Linux"
+	}
+
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		info.DeviceType = "tablet"
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "android"):
+		info.DeviceType = "mobile"
+	case info.Browser == "Unknown" || info.Browser == "curl" || info.Browser == "Postman":
+		info.DeviceType = "other"
+	}
+
+	return info
+}